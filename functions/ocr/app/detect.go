@@ -20,88 +20,207 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"path"
+	"sort"
+	"strings"
 
-	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	vision "cloud.google.com/go/vision/apiv1"
 	"cloud.google.com/go/vision/v2/apiv1/visionpb"
 	"golang.org/x/text/language"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
-// detectText detects the text in an image using the Google Vision API.
-func detectText(ctx context.Context, bucketName, fileName string) error {
-	log.Printf("Looking for text in image %v", fileName)
+// detectText detects the text in an image or document, and publishes it for
+// translation into each target language.
+func (p *pipeline) detectText(ctx context.Context, bucketName, fileName string) error {
+	log.Printf("Looking for text in %v", fileName)
+	pages, err := p.detector.DetectText(ctx, bucketName, fileName)
+	if err != nil {
+		return fmt.Errorf("DetectText: %w", err)
+	}
+	text := strings.Join(pages, pageSeparator)
+	if strings.TrimSpace(text) == "" {
+		log.Printf("No text detected in %q. Returning early.", fileName)
+		return nil
+	}
+	log.Printf("Extracted text %q from %d page(s) (%d chars).", text, len(pages), len(text))
+
+	srcLang, err := p.translator.DetectLanguage(ctx, pages[0])
+	if err != nil {
+		return fmt.Errorf("DetectLanguage: %w", err)
+	}
+	log.Printf("Detected language %q for text %q.", srcLang, text)
+
+	// Submit a message to the bus for each target language and range of
+	// pages, so that long documents fit in Pub/Sub messages.
+	ranges := pageRanges(pages)
+	for _, targetLang := range p.toLang {
+		topicName := p.translateTopic
+		if srcLang == targetLang || srcLang == language.Und { // detection returns "und" for undefined language
+			topicName = p.resultTopic
+		}
+		for _, r := range ranges {
+			msg := ocrMessage{
+				Text:     strings.Join(pages[r.first-1:r.last], pageSeparator),
+				FileName: fileName,
+				Lang:     targetLang,
+				SrcLang:  srcLang,
+				Bucket:   bucketName,
+				Pages:    len(pages),
+			}
+			if len(ranges) > 1 {
+				msg.FirstPage, msg.LastPage = r.first, r.last
+			}
+			message, err := json.Marshal(msg)
+			if err != nil {
+				return fmt.Errorf("json.Marshal: %w", err)
+			}
+			if err := p.bus.Publish(ctx, topicName, message); err != nil {
+				return fmt.Errorf("Publish: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// maxMessageText limits the text in each message, well below the 10 MB
+// Pub/Sub limit, so that translations longer than the original text fit too.
+const maxMessageText = 1 << 20
+
+// pageRange is a range of pages, numbered from 1.
+type pageRange struct {
+	first, last int
+}
+
+// pageRanges splits pages into ranges whose text is at most maxMessageText
+// bytes. A longer page is in a range of its own.
+func pageRanges(pages []string) []pageRange {
+	var ranges []pageRange
+	size := 0
+	for i, page := range pages {
+		n := len(page) + len(pageSeparator)
+		if len(ranges) == 0 || size+n > maxMessageText {
+			ranges = append(ranges, pageRange{first: i + 1})
+			size = 0
+		}
+		ranges[len(ranges)-1].last = i + 1
+		size += n
+	}
+	return ranges
+}
+
+// documentTypes are the MIME types of the files with pages, by extension.
+var documentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+}
+
+// visionDetector is a textDetector using the Vision API. Text in images is
+// detected directly; text in documents is detected asynchronously, with the
+// output written to outputBucket and deleted once read.
+type visionDetector struct {
+	vision       *vision.ImageAnnotatorClient
+	storage      *storage.Client
+	outputBucket string
+}
+
+func (d *visionDetector) DetectText(ctx context.Context, bucket, name string) ([]string, error) {
+	uri := fmt.Sprintf("gs://%s/%s", bucket, name)
+	if mimeType, ok := documentTypes[strings.ToLower(path.Ext(name))]; ok {
+		return d.detectDocumentText(ctx, uri, mimeType)
+	}
+
 	maxResults := 1
 	image := &visionpb.Image{
 		Source: &visionpb.ImageSource{
-			GcsImageUri: fmt.Sprintf("gs://%s/%s", bucketName, fileName),
+			GcsImageUri: uri,
 		},
 	}
-	annotations, err := visionClient.DetectTexts(ctx, image, &visionpb.ImageContext{}, maxResults)
+	annotations, err := d.vision.DetectTexts(ctx, image, &visionpb.ImageContext{}, maxResults)
 	if err != nil {
-		return fmt.Errorf("DetectTexts: %w", err)
-	}
-	text := ""
-	if len(annotations) > 0 {
-		text = annotations[0].Description
+		return nil, fmt.Errorf("DetectTexts: %w", err)
 	}
-	if len(annotations) == 0 || len(text) == 0 {
-		log.Printf("No text detected in image %q. Returning early.", fileName)
-		return nil
+	if len(annotations) == 0 || len(annotations[0].Description) == 0 {
+		return nil, nil
 	}
-	log.Printf("Extracted text %q from image (%d chars).", text, len(text))
+	return []string{annotations[0].Description}, nil
+}
 
-	detectResponse, err := translateClient.DetectLanguage(ctx, []string{text})
+func (d *visionDetector) detectDocumentText(ctx context.Context, uri, mimeType string) ([]string, error) {
+	prefix := "ocr-output/" + strings.TrimPrefix(uri, "gs://") + "/"
+	req := &visionpb.AsyncBatchAnnotateFilesRequest{
+		Requests: []*visionpb.AsyncAnnotateFileRequest{{
+			InputConfig: &visionpb.InputConfig{
+				GcsSource: &visionpb.GcsSource{Uri: uri},
+				MimeType:  mimeType,
+			},
+			Features: []*visionpb.Feature{{Type: visionpb.Feature_DOCUMENT_TEXT_DETECTION}},
+			OutputConfig: &visionpb.OutputConfig{
+				GcsDestination: &visionpb.GcsDestination{Uri: fmt.Sprintf("gs://%s/%s", d.outputBucket, prefix)},
+				BatchSize:      20,
+			},
+		}},
+	}
+	op, err := d.vision.AsyncBatchAnnotateFiles(ctx, req)
 	if err != nil {
-		return fmt.Errorf("DetectLanguage: %w", err)
+		return nil, fmt.Errorf("AsyncBatchAnnotateFiles: %w", err)
 	}
-	if len(detectResponse) == 0 || len(detectResponse[0]) == 0 {
-		return fmt.Errorf("DetectLanguage gave empty response")
+	if _, err := op.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Wait: %w", err)
 	}
-	srcLang := detectResponse[0][0].Language.String()
-	log.Printf("Detected language %q for text %q.", srcLang, text)
 
-	// Submit a message to the bus for each target language
-	for _, targetLang := range toLang {
-		topicName := translateTopic
-		if srcLang == targetLang || srcLang == "und" { // detection returns "und" for undefined language
-			topicName = resultTopic
+	// Each output file holds the responses for a batch of pages.
+	pages := make(map[int]string)
+	bucket := d.storage.Bucket(d.outputBucket)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
 		}
-		targetTag, err := language.Parse(targetLang)
 		if err != nil {
-			return fmt.Errorf("language.Parse: %w", err)
+			return nil, fmt.Errorf("Objects: %w", err)
 		}
-		srcTag, err := language.Parse(srcLang)
+		obj := bucket.Object(attrs.Name)
+		r, err := obj.NewReader(ctx)
 		if err != nil {
-			return fmt.Errorf("language.Parse: %w", err)
+			return nil, fmt.Errorf("NewReader: %w", err)
 		}
-		message, err := json.Marshal(ocrMessage{
-			Text:     text,
-			FileName: fileName,
-			Lang:     targetTag,
-			SrcLang:  srcTag,
-		})
+		data, err := ioutil.ReadAll(r)
+		r.Close()
 		if err != nil {
-			return fmt.Errorf("json.Marshal: %w", err)
+			return nil, fmt.Errorf("ReadAll: %w", err)
 		}
-		topic := pubsubClient.Topic(topicName)
-		ok, err := topic.Exists(ctx)
-		if err != nil {
-			return fmt.Errorf("Exists: %w", err)
+		var out visionpb.AnnotateFileResponse
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("protojson.Unmarshal(%s): %w", attrs.Name, err)
 		}
-		if !ok {
-			topic, err = pubsubClient.CreateTopic(ctx, topicName)
-			if err != nil {
-				return fmt.Errorf("CreateTopic: %w", err)
+		for _, resp := range out.Responses {
+			if resp.Error != nil {
+				return nil, fmt.Errorf("page %d: %s", resp.GetContext().GetPageNumber(), resp.Error.Message)
 			}
+			pages[int(resp.GetContext().GetPageNumber())] = resp.GetFullTextAnnotation().GetText()
 		}
-		msg := &pubsub.Message{
-			Data: []byte(message),
-		}
-		if _, err = topic.Publish(ctx, msg).Get(ctx); err != nil {
-			return fmt.Errorf("Get: %w", err)
+		if err := obj.Delete(ctx); err != nil {
+			return nil, fmt.Errorf("Delete: %w", err)
 		}
 	}
-	return nil
+
+	numbers := make([]int, 0, len(pages))
+	for n := range pages {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	texts := make([]string, len(numbers))
+	for i, n := range numbers {
+		texts[i] = pages[n]
+	}
+	return texts, nil
 }
 
 // [END functions_ocr_detect]
@@ -0,0 +1,137 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// The in-memory implementations below let the whole pipeline run in a
+// single process, without Google Cloud.
+
+// memoryDetector is a textDetector that returns the pages of known files.
+type memoryDetector struct {
+	// files maps "bucket/name" to the text of each page.
+	files map[string][]string
+}
+
+func (d *memoryDetector) DetectText(ctx context.Context, bucket, name string) ([]string, error) {
+	pages, ok := d.files[bucket+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("gs://%s/%s not found", bucket, name)
+	}
+	return pages, nil
+}
+
+// memoryTranslator is a translator that looks texts up in a dictionary, and
+// otherwise prefixes them with the target language, e.g. "[fr] Hello".
+type memoryTranslator struct {
+	// languages maps texts to their language. Others are undefined.
+	languages map[string]language.Tag
+	// dictionary maps a target language and text, such as "fr:Thanks", to
+	// a translation.
+	dictionary map[string]string
+}
+
+func (t *memoryTranslator) DetectLanguage(ctx context.Context, text string) (language.Tag, error) {
+	if lang, ok := t.languages[text]; ok {
+		return lang, nil
+	}
+	return language.Und, nil
+}
+
+func (t *memoryTranslator) Translate(ctx context.Context, texts []string, src, target language.Tag) ([]string, error) {
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		if tr, ok := t.dictionary[target.String()+":"+text]; ok {
+			out[i] = tr
+			continue
+		}
+		out[i] = fmt.Sprintf("[%s] %s", target, text)
+	}
+	return out, nil
+}
+
+// memoryBus is a messageBus that delivers each message to the subscriber of
+// its topic before Publish returns.
+type memoryBus struct {
+	mu          sync.Mutex
+	subscribers map[string]func(context.Context, PubSubMessage) error
+	published   map[string][][]byte
+}
+
+// Subscribe sets the function called with the messages published to topic.
+func (b *memoryBus) Subscribe(topic string, f func(context.Context, PubSubMessage) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[string]func(context.Context, PubSubMessage) error)
+	}
+	b.subscribers[topic] = f
+}
+
+func (b *memoryBus) Publish(ctx context.Context, topic string, data []byte) error {
+	b.mu.Lock()
+	if b.published == nil {
+		b.published = make(map[string][][]byte)
+	}
+	b.published[topic] = append(b.published[topic], data)
+	f := b.subscribers[topic]
+	b.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f(ctx, PubSubMessage{Data: data})
+}
+
+// memoryStore is a resultStore that keeps results in memory, by result
+// file name.
+type memoryStore struct {
+	mu      sync.Mutex
+	results map[string]*ocrMessage
+}
+
+func (s *memoryStore) Save(ctx context.Context, result *ocrMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.results == nil {
+		s.results = make(map[string]*ocrMessage)
+	}
+	s.results[resultName(result)] = result
+	return nil
+}
+
+// newMemoryPipeline returns a pipeline using in-memory services, with its
+// functions subscribed to the bus.
+func newMemoryPipeline(detector *memoryDetector, translator *memoryTranslator, toLang ...language.Tag) (*pipeline, *memoryBus, *memoryStore) {
+	bus := &memoryBus{}
+	store := &memoryStore{}
+	p := &pipeline{
+		detector:       detector,
+		translator:     translator,
+		bus:            bus,
+		store:          store,
+		toLang:         toLang,
+		translateTopic: "translate",
+		resultTopic:    "result",
+	}
+	bus.Subscribe(p.translateTopic, p.translateText)
+	bus.Subscribe(p.resultTopic, p.saveResult)
+	return p, bus, store
+}
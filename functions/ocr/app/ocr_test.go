@@ -25,10 +25,7 @@ import (
 	"strings"
 	"testing"
 
-	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
-	"cloud.google.com/go/translate"
-	vision "cloud.google.com/go/vision/apiv1"
 	"golang.org/x/text/language"
 )
 
@@ -39,11 +36,13 @@ const (
 
 var (
 	imageBucketName string
+	resultBucket    string
+	storageClient   *storage.Client
 )
 
 func setupTests(t *testing.T) {
 	ctx := context.Background()
-	projectID = os.Getenv("GOLANG_SAMPLES_PROJECT_ID")
+	projectID := os.Getenv("GOLANG_SAMPLES_PROJECT_ID")
 	if projectID == "" {
 		t.Skip("GOLANG_SAMPLES_PROJECT_ID is unset")
 	}
@@ -57,19 +56,9 @@ func setupTests(t *testing.T) {
 	imageBucketName = "cloud-samples-data/functions"
 
 	var err error // Prevent shadowing clients with :=.
-	visionClient, err = vision.NewImageAnnotatorClient(ctx)
+	defaultPipeline, err = newPipeline(ctx, configFromEnv())
 	if err != nil {
-		t.Fatalf("vision.NewImageAnnotatorClient: %v", err)
-	}
-
-	translateClient, err = translate.NewClient(ctx)
-	if err != nil {
-		t.Fatalf("translate.NewClient: %v", err)
-	}
-
-	pubsubClient, err = pubsub.NewClient(ctx, projectID)
-	if err != nil {
-		t.Fatalf("translate.NewClient: %v", err)
+		t.Fatalf("newPipeline: %v", err)
 	}
 
 	storageClient, err = storage.NewClient(ctx)
@@ -171,7 +160,7 @@ func TestDetectText(t *testing.T) {
 
 	buf := new(bytes.Buffer)
 	log.SetOutput(buf)
-	if err := detectText(ctx, imageBucketName, menuName); err != nil {
+	if err := defaultPipeline.detectText(ctx, imageBucketName, menuName); err != nil {
 		t.Errorf("TestDetectText: %v", err)
 	}
 	got := buf.String()
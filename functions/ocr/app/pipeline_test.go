@@ -0,0 +1,211 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestPipeline(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+
+	book := []string{strings.Repeat("a", 600<<10), strings.Repeat("b", 600<<10), "c"}
+	detector := &memoryDetector{files: map[string][]string{
+		"uploads/menu.jpg":  {"Merci"},
+		"uploads/blank.png": nil,
+		"uploads/menu.pdf":  {"Merci", "Bonjour"},
+		"uploads/sign.tiff": {"???"},
+		"uploads/book.pdf":  book,
+	}}
+	translator := &memoryTranslator{
+		languages: map[string]language.Tag{"Merci": language.French},
+		dictionary: map[string]string{
+			"en:Merci":   "Thanks",
+			"en:Bonjour": "Hello",
+		},
+	}
+
+	tests := []struct {
+		name         string
+		translations int // Messages published for translation.
+		want         map[string]*ocrMessage
+	}{
+		{
+			name:         "menu.jpg",
+			translations: 1,
+			want: map[string]*ocrMessage{
+				"menu.jpg_en.txt": {Text: "Thanks", FileName: "menu.jpg", Lang: language.English, SrcLang: language.French, Bucket: "uploads", Pages: 1},
+				"menu.jpg_fr.txt": {Text: "Merci", FileName: "menu.jpg", Lang: language.French, SrcLang: language.French, Bucket: "uploads", Pages: 1},
+			},
+		},
+		{
+			name: "blank.png",
+			want: map[string]*ocrMessage{},
+		},
+		{
+			name:         "menu.pdf",
+			translations: 1,
+			want: map[string]*ocrMessage{
+				"menu.pdf_en.txt": {Text: "Thanks\fHello", FileName: "menu.pdf", Lang: language.English, SrcLang: language.French, Bucket: "uploads", Pages: 2},
+				"menu.pdf_fr.txt": {Text: "Merci\fBonjour", FileName: "menu.pdf", Lang: language.French, SrcLang: language.French, Bucket: "uploads", Pages: 2},
+			},
+		},
+		{
+			// Text in an undefined language is saved untranslated.
+			name: "sign.tiff",
+			want: map[string]*ocrMessage{
+				"sign.tiff_en.txt": {Text: "???", FileName: "sign.tiff", Lang: language.English, SrcLang: language.Und, Bucket: "uploads", Pages: 1},
+				"sign.tiff_fr.txt": {Text: "???", FileName: "sign.tiff", Lang: language.French, SrcLang: language.Und, Bucket: "uploads", Pages: 1},
+			},
+		},
+		{
+			// Long documents are sent and saved in ranges of pages.
+			name: "book.pdf",
+			want: map[string]*ocrMessage{
+				"book.pdf_en_pages-1-1.txt": {Text: book[0], FileName: "book.pdf", Lang: language.English, SrcLang: language.Und, Bucket: "uploads", Pages: 3, FirstPage: 1, LastPage: 1},
+				"book.pdf_en_pages-2-3.txt": {Text: book[1] + "\f" + book[2], FileName: "book.pdf", Lang: language.English, SrcLang: language.Und, Bucket: "uploads", Pages: 3, FirstPage: 2, LastPage: 3},
+				"book.pdf_fr_pages-1-1.txt": {Text: book[0], FileName: "book.pdf", Lang: language.French, SrcLang: language.Und, Bucket: "uploads", Pages: 3, FirstPage: 1, LastPage: 1},
+				"book.pdf_fr_pages-2-3.txt": {Text: book[1] + "\f" + book[2], FileName: "book.pdf", Lang: language.French, SrcLang: language.Und, Bucket: "uploads", Pages: 3, FirstPage: 2, LastPage: 3},
+			},
+		},
+	}
+	for _, tc := range tests {
+		p, bus, store := newMemoryPipeline(detector, translator, language.English, language.French)
+		if err := p.processImage(ctx, GCSEvent{Bucket: "uploads", Name: tc.name}); err != nil {
+			t.Errorf("%s: processImage: %v", tc.name, err)
+			continue
+		}
+		got := store.results
+		if got == nil {
+			got = map[string]*ocrMessage{}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got results %v, want %v", tc.name, got, tc.want)
+		}
+		if got := len(bus.published["translate"]); got != tc.translations {
+			t.Errorf("%s: got %d translation requests, want %d", tc.name, got, tc.translations)
+		}
+	}
+
+	p, _, _ := newMemoryPipeline(detector, translator, language.English)
+	if err := p.processImage(ctx, GCSEvent{Bucket: "uploads", Name: "missing.jpg"}); err == nil {
+		t.Errorf("processImage(missing.jpg): got nil error, want not found")
+	}
+}
+
+func TestResultMetadata(t *testing.T) {
+	tests := []struct {
+		result *ocrMessage
+		want   map[string]string
+	}{
+		{
+			result: &ocrMessage{FileName: "menu.pdf", Bucket: "uploads", Lang: language.English, SrcLang: language.French, Pages: 3},
+			want: map[string]string{
+				"source":          "gs://uploads/menu.pdf",
+				"source-language": "fr",
+				"language":        "en",
+				"translated":      "true",
+				"pages":           "3",
+			},
+		},
+		{
+			result: &ocrMessage{FileName: "menu.jpg", Lang: language.French, SrcLang: language.French},
+			want: map[string]string{
+				"source":          "menu.jpg",
+				"source-language": "fr",
+				"language":        "fr",
+				"translated":      "false",
+			},
+		},
+	}
+	for _, tc := range tests {
+		if got := resultMetadata(tc.result); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("resultMetadata(%s): got %v, want %v", tc.result.FileName, got, tc.want)
+		}
+	}
+}
+
+func TestTranslateBatches(t *testing.T) {
+	many := make([]string, 300)
+	for i := range many {
+		many[i] = "x"
+	}
+	long := strings.Repeat("é", maxTranslateChars)
+	tests := []struct {
+		name  string
+		texts []string
+		want  []int // Size of each batch.
+	}{
+		{name: "none"},
+		{name: "few", texts: []string{"a", "b"}, want: []int{2}},
+		{name: "many", texts: many, want: []int{128, 128, 44}},
+		{name: "long", texts: []string{"a", long, "b", "c"}, want: []int{1, 1, 2}},
+	}
+	for _, tc := range tests {
+		var got []int
+		var joined []string
+		for _, b := range translateBatches(tc.texts) {
+			got = append(got, len(b))
+			joined = append(joined, b...)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("translateBatches(%s): got batches of %v, want %v", tc.name, got, tc.want)
+		}
+		if len(joined) != len(tc.texts) {
+			t.Errorf("translateBatches(%s): got %d texts, want %d", tc.name, len(joined), len(tc.texts))
+		}
+	}
+}
+
+func TestTranslateLongDocument(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+
+	pages := make([]string, 200)
+	for i := range pages {
+		pages[i] = fmt.Sprintf("page %d", i+1)
+	}
+	detector := &memoryDetector{files: map[string][]string{"uploads/long.pdf": pages}}
+	translator := &memoryTranslator{languages: map[string]language.Tag{"page 1": language.French}}
+	p, _, store := newMemoryPipeline(detector, translator, language.English)
+	if err := p.processImage(ctx, GCSEvent{Bucket: "uploads", Name: "long.pdf"}); err != nil {
+		t.Fatalf("processImage: %v", err)
+	}
+	res, ok := store.results["long.pdf_en.txt"]
+	if !ok {
+		t.Fatalf("got results %v, want long.pdf_en.txt", store.results)
+	}
+	got := strings.Split(res.Text, "\f")
+	if len(got) != len(pages) {
+		t.Fatalf("got %d pages, want %d", len(got), len(pages))
+	}
+	for i, page := range got {
+		if want := "[en] " + pages[i]; page != want {
+			t.Errorf("page %d: got %q, want %q", i+1, page, want)
+		}
+	}
+}
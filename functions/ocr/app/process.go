@@ -24,17 +24,22 @@ import (
 
 // ProcessImage is executed when a file is uploaded to the Cloud Storage bucket you
 // created for uploading images. It runs detectText, which processes the image for text.
+// PDF and TIFF files are processed page by page.
 func ProcessImage(ctx context.Context, event GCSEvent) error {
 	if err := setup(ctx); err != nil {
 		return fmt.Errorf("ProcessImage: %w", err)
 	}
+	return defaultPipeline.processImage(ctx, event)
+}
+
+func (p *pipeline) processImage(ctx context.Context, event GCSEvent) error {
 	if event.Bucket == "" {
 		return fmt.Errorf("empty file.Bucket")
 	}
 	if event.Name == "" {
 		return fmt.Errorf("empty file.Name")
 	}
-	if err := detectText(ctx, event.Bucket, event.Name); err != nil {
+	if err := p.detectText(ctx, event.Bucket, event.Name); err != nil {
 		return fmt.Errorf("detectText: %w", err)
 	}
 	log.Printf("File %s processed.", event.Name)
@@ -21,6 +21,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/text/language"
 )
 
 // SaveResult is executed when a message is published to the Cloud Pub/Sub topic
@@ -30,6 +34,10 @@ func SaveResult(ctx context.Context, event PubSubMessage) error {
 	if err := setup(ctx); err != nil {
 		return fmt.Errorf("ProcessImage: %w", err)
 	}
+	return defaultPipeline.saveResult(ctx, event)
+}
+
+func (p *pipeline) saveResult(ctx context.Context, event PubSubMessage) error {
 	var message ocrMessage
 	if event.Data == nil {
 		return fmt.Errorf("Empty data")
@@ -39,17 +47,62 @@ func SaveResult(ctx context.Context, event PubSubMessage) error {
 	}
 	log.Printf("Received request to save file %q.", message.FileName)
 
-	resultFilename := fmt.Sprintf("%s_%s.txt", message.FileName, message.Lang)
-	bucket := storageClient.Bucket(resultBucket)
+	if err := p.store.Save(ctx, &message); err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	log.Printf("File saved.")
+	return nil
+}
 
-	log.Printf("Saving result to %q in bucket %q.", resultFilename, resultBucket)
+// resultName is the name of the file holding the text of result, e.g.
+// "menu.pdf_en.txt", or "menu.pdf_en_pages-1-40.txt" for part of a long
+// document.
+func resultName(result *ocrMessage) string {
+	if result.FirstPage > 0 {
+		return fmt.Sprintf("%s_%s_pages-%d-%d.txt", result.FileName, result.Lang, result.FirstPage, result.LastPage)
+	}
+	return fmt.Sprintf("%s_%s.txt", result.FileName, result.Lang)
+}
 
-	w := bucket.Object(resultFilename).NewWriter(ctx)
-	defer w.Close()
-	fmt.Fprint(w, message.Text)
+// gcsStore is a resultStore writing one text file per file, language and
+// range of pages to a Cloud Storage bucket, with the details of the result in
+// its metadata.
+type gcsStore struct {
+	client *storage.Client
+	bucket string
+}
 
-	log.Printf("File saved.")
-	return nil
+func (s *gcsStore) Save(ctx context.Context, result *ocrMessage) error {
+	name := resultName(result)
+	w := s.client.Bucket(s.bucket).Object(name).NewWriter(ctx)
+	w.ContentType = "text/plain; charset=utf-8"
+	w.Metadata = resultMetadata(result)
+	if _, err := fmt.Fprint(w, result.Text); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// resultMetadata describes a result file.
+func resultMetadata(result *ocrMessage) map[string]string {
+	md := map[string]string{
+		"source":          result.FileName,
+		"source-language": result.SrcLang.String(),
+		"language":        result.Lang.String(),
+		"translated":      strconv.FormatBool(result.Lang != result.SrcLang && result.SrcLang != language.Und),
+	}
+	if result.Bucket != "" {
+		md["source"] = fmt.Sprintf("gs://%s/%s", result.Bucket, result.FileName)
+	}
+	if result.Pages > 0 {
+		md["pages"] = strconv.Itoa(result.Pages)
+	}
+	if result.FirstPage > 0 {
+		md["first-page"] = strconv.Itoa(result.FirstPage)
+		md["last-page"] = strconv.Itoa(result.LastPage)
+	}
+	return md
 }
 
 // [END functions_ocr_save]
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -37,8 +38,20 @@ type ocrMessage struct {
 	FileName string       `json:"fileName"`
 	Lang     language.Tag `json:"lang"`
 	SrcLang  language.Tag `json:"srcLang"`
+	// Bucket is the bucket of FileName.
+	Bucket string `json:"bucket,omitempty"`
+	// Pages is the number of pages of a PDF or TIFF file. Their text is
+	// separated by pageSeparator.
+	Pages int `json:"pages,omitempty"`
+	// FirstPage and LastPage are the range of pages in Text, numbered from
+	// 1, if the document is too long for one message. Otherwise they are 0.
+	FirstPage int `json:"firstPage,omitempty"`
+	LastPage  int `json:"lastPage,omitempty"`
 }
 
+// pageSeparator separates the text of the pages of a document.
+const pageSeparator = "\f"
+
 // GCSEvent is the payload of a GCS event.
 type GCSEvent struct {
 	Bucket         string    `json:"bucket"`
@@ -56,56 +69,160 @@ type PubSubMessage struct {
 	Data []byte `json:"data"`
 }
 
-var (
-	visionClient    *vision.ImageAnnotatorClient
-	translateClient *translate.Client
-	pubsubClient    *pubsub.Client
-	storageClient   *storage.Client
+// textDetector finds text in images and documents.
+type textDetector interface {
+	// DetectText returns the text of each page of gs://bucket/name, or
+	// nothing if there is no text.
+	DetectText(ctx context.Context, bucket, name string) ([]string, error)
+}
+
+// translator detects the language of text and translates it.
+type translator interface {
+	// DetectLanguage returns language.Und if the language is unknown.
+	DetectLanguage(ctx context.Context, text string) (language.Tag, error)
+	Translate(ctx context.Context, texts []string, src, target language.Tag) ([]string, error)
+}
+
+// messageBus passes messages between the functions.
+type messageBus interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+}
+
+// resultStore saves the text of a file in one language.
+type resultStore interface {
+	Save(ctx context.Context, result *ocrMessage) error
+}
+
+// pipeline holds the services used by the functions, and their
+// configuration.
+type pipeline struct {
+	detector   textDetector
+	translator translator
+	bus        messageBus
+	store      resultStore
+
+	toLang         []language.Tag
+	translateTopic string
+	resultTopic    string
+}
+
+// defaultPipeline is created by setup. Tests replace it.
+var defaultPipeline *pipeline
 
+// config configures the pipeline with Google Cloud services.
+type config struct {
 	projectID      string
 	resultBucket   string
 	resultTopic    string
 	toLang         []string
 	translateTopic string
-)
+}
 
-func setup(ctx context.Context) error {
-	projectID = os.Getenv("GCP_PROJECT")
-	resultBucket = os.Getenv("RESULT_BUCKET")
-	resultTopic = os.Getenv("RESULT_TOPIC")
-	toLang = strings.Split(os.Getenv("TO_LANG"), ",")
-	translateTopic = os.Getenv("TRANSLATE_TOPIC")
+func configFromEnv() config {
+	projectID := os.Getenv("GCP_PROJECT")
+	if projectID == "" {
+		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	return config{
+		projectID:      projectID,
+		resultBucket:   os.Getenv("RESULT_BUCKET"),
+		resultTopic:    os.Getenv("RESULT_TOPIC"),
+		toLang:         strings.Split(os.Getenv("TO_LANG"), ","),
+		translateTopic: os.Getenv("TRANSLATE_TOPIC"),
+	}
+}
 
-	var err error // Prevent shadowing clients with :=.
+func setup(ctx context.Context) error {
+	if defaultPipeline != nil {
+		return nil
+	}
+	p, err := newPipeline(ctx, configFromEnv())
+	if err != nil {
+		return err
+	}
+	defaultPipeline = p
+	return nil
+}
 
-	if visionClient == nil {
-		visionClient, err = vision.NewImageAnnotatorClient(ctx)
+// newPipeline creates a pipeline using the Vision, Translation, Pub/Sub and
+// Cloud Storage APIs.
+func newPipeline(ctx context.Context, cfg config) (*pipeline, error) {
+	p := &pipeline{
+		translateTopic: cfg.translateTopic,
+		resultTopic:    cfg.resultTopic,
+	}
+	for _, l := range cfg.toLang {
+		tag, err := language.Parse(l)
 		if err != nil {
-			return fmt.Errorf("vision.NewImageAnnotatorClient: %w", err)
+			return nil, fmt.Errorf("language.Parse: %w", err)
 		}
+		p.toLang = append(p.toLang, tag)
 	}
 
-	if translateClient == nil {
-		translateClient, err = translate.NewClient(ctx)
-		if err != nil {
-			return fmt.Errorf("translate.NewClient: %w", err)
-		}
+	visionClient, err := vision.NewImageAnnotatorClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vision.NewImageAnnotatorClient: %w", err)
+	}
+	translateClient, err := translate.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("translate.NewClient: %w", err)
+	}
+	pubsubClient, err := pubsub.NewClient(ctx, cfg.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
 	}
 
-	if pubsubClient == nil {
-		pubsubClient, err = pubsub.NewClient(ctx, projectID)
-		if err != nil {
-			return fmt.Errorf("translate.NewClient: %w", err)
-		}
+	p.detector = &visionDetector{vision: visionClient, storage: storageClient, outputBucket: cfg.resultBucket}
+	p.translator = &cloudTranslator{client: translateClient}
+	p.bus = &pubsubBus{client: pubsubClient}
+	p.store = &gcsStore{client: storageClient, bucket: cfg.resultBucket}
+	return p, nil
+}
+
+// pubsubBus is a messageBus using Pub/Sub. Topics are created if they don't
+// exist.
+type pubsubBus struct {
+	client *pubsub.Client
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic
+}
+
+func (b *pubsubBus) Publish(ctx context.Context, topicName string, data []byte) error {
+	topic, err := b.topic(ctx, topicName)
+	if err != nil {
+		return err
 	}
+	_, err = topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
 
-	if storageClient == nil {
-		storageClient, err = storage.NewClient(ctx)
+func (b *pubsubBus) topic(ctx context.Context, topicName string) (*pubsub.Topic, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if topic, ok := b.topics[topicName]; ok {
+		return topic, nil
+	}
+	topic := b.client.Topic(topicName)
+	ok, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Exists: %w", err)
+	}
+	if !ok {
+		topic, err = b.client.CreateTopic(ctx, topicName)
 		if err != nil {
-			return fmt.Errorf("storage.NewClient: %w", err)
+			return nil, fmt.Errorf("CreateTopic: %w", err)
 		}
 	}
-	return nil
+	if b.topics == nil {
+		b.topics = make(map[string]*pubsub.Topic)
+	}
+	b.topics[topicName] = topic
+	return topic, nil
 }
 
 // [END functions_ocr_setup]
@@ -21,9 +21,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"unicode/utf8"
 
-	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/translate"
+	"golang.org/x/text/language"
 )
 
 // TranslateText is executed when a message is published to the Cloud Pub/Sub
@@ -33,6 +35,10 @@ func TranslateText(ctx context.Context, event PubSubMessage) error {
 	if err := setup(ctx); err != nil {
 		return fmt.Errorf("setup: %w", err)
 	}
+	return defaultPipeline.translateText(ctx, event)
+}
+
+func (p *pipeline) translateText(ctx context.Context, event PubSubMessage) error {
 	if event.Data == nil {
 		return fmt.Errorf("empty data")
 	}
@@ -42,47 +48,88 @@ func TranslateText(ctx context.Context, event PubSubMessage) error {
 	}
 
 	log.Printf("Translating text into %s.", message.Lang.String())
-	opts := translate.Options{
-		Source: message.SrcLang,
+	// Pages are translated separately to keep them apart, in batches that
+	// fit in a request.
+	pages := strings.Split(message.Text, pageSeparator)
+	var translated []string
+	for _, batch := range translateBatches(pages) {
+		out, err := p.translator.Translate(ctx, batch, message.SrcLang, message.Lang)
+		if err != nil {
+			return fmt.Errorf("Translate: %w", err)
+		}
+		if len(out) != len(batch) {
+			return fmt.Errorf("Translate returned %d texts, want %d", len(out), len(batch))
+		}
+		translated = append(translated, out...)
 	}
-	translateResponse, err := translateClient.Translate(ctx, []string{message.Text}, message.Lang, &opts)
+	translatedText := strings.Join(translated, pageSeparator)
+
+	message.Text = translatedText
+	messageData, err := json.Marshal(message)
 	if err != nil {
-		return fmt.Errorf("Translate: %w", err)
+		return fmt.Errorf("json.Marshal: %w", err)
 	}
-	if len(translateResponse) == 0 {
-		return fmt.Errorf("Empty Translate response")
+	if err := p.bus.Publish(ctx, p.resultTopic, messageData); err != nil {
+		return fmt.Errorf("Publish: %w", err)
 	}
-	translatedText := translateResponse[0]
+	log.Printf("Sent translation: %q", translatedText)
+	return nil
+}
 
-	messageData, err := json.Marshal(ocrMessage{
-		Text:     translatedText.Text,
-		FileName: message.FileName,
-		Lang:     message.Lang,
-		SrcLang:  message.SrcLang,
-	})
-	if err != nil {
-		return fmt.Errorf("json.Marshal: %w", err)
+// Limits of a Translation API request.
+const (
+	maxTranslateSegments = 128
+	maxTranslateChars    = 30000
+)
+
+// translateBatches splits texts into batches within the limits of a
+// Translation API request. A text longer than maxTranslateChars is sent in a
+// batch of its own.
+func translateBatches(texts []string) [][]string {
+	var batches [][]string
+	var batch []string
+	chars := 0
+	for _, text := range texts {
+		n := utf8.RuneCountInString(text)
+		if len(batch) > 0 && (len(batch) == maxTranslateSegments || chars+n > maxTranslateChars) {
+			batches = append(batches, batch)
+			batch, chars = nil, 0
+		}
+		batch = append(batch, text)
+		chars += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
 	}
+	return batches
+}
+
+// cloudTranslator is a translator using the Translation API.
+type cloudTranslator struct {
+	client *translate.Client
+}
 
-	topic := pubsubClient.Topic(resultTopic)
-	ok, err := topic.Exists(ctx)
+func (t *cloudTranslator) DetectLanguage(ctx context.Context, text string) (language.Tag, error) {
+	detectResponse, err := t.client.DetectLanguage(ctx, []string{text})
 	if err != nil {
-		return fmt.Errorf("Exists: %w", err)
+		return language.Und, err
 	}
-	if !ok {
-		topic, err = pubsubClient.CreateTopic(ctx, resultTopic)
-		if err != nil {
-			return fmt.Errorf("CreateTopic: %w", err)
-		}
+	if len(detectResponse) == 0 || len(detectResponse[0]) == 0 {
+		return language.Und, fmt.Errorf("DetectLanguage gave empty response")
 	}
-	msg := &pubsub.Message{
-		Data: messageData,
+	return detectResponse[0][0].Language, nil
+}
+
+func (t *cloudTranslator) Translate(ctx context.Context, texts []string, src, target language.Tag) ([]string, error) {
+	translations, err := t.client.Translate(ctx, texts, target, &translate.Options{Source: src})
+	if err != nil {
+		return nil, err
 	}
-	if _, err = topic.Publish(ctx, msg).Get(ctx); err != nil {
-		return fmt.Errorf("Get: %w", err)
+	out := make([]string, len(translations))
+	for i, tr := range translations {
+		out[i] = tr.Text
 	}
-	log.Printf("Sent translation: %q", translatedText.Text)
-	return nil
+	return out, nil
 }
 
 // [END functions_ocr_translate]
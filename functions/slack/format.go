@@ -18,24 +18,74 @@ package slack
 
 import (
 	"fmt"
+	"strings"
 
 	"google.golang.org/api/kgsearch/v1"
 )
 
+// Response types of a Message.
+const (
+	inChannel = "in_channel"
+	ephemeral = "ephemeral"
+)
+
+// Message is a reply to a Slack command, formatted with Block Kit.
+// See https://api.slack.com/block-kit.
+type Message struct {
+	// ResponseType is "in_channel" to show the reply to the whole channel,
+	// or "ephemeral" to show it only to the user who ran the command.
+	ResponseType string `json:"response_type"`
+	// Text is shown in notifications, and instead of the blocks where they
+	// can't be shown.
+	Text   string  `json:"text"`
+	Blocks []block `json:"blocks,omitempty"`
+}
+
+// Ephemeral returns a plain text message shown only to the user who ran the
+// command.
+func Ephemeral(text string) *Message {
+	return &Message{ResponseType: ephemeral, Text: text}
+}
+
+type block struct {
+	Type      string        `json:"type"`
+	Text      *textObject   `json:"text,omitempty"`
+	Accessory *imageElement `json:"accessory,omitempty"`
+	Elements  []*textObject `json:"elements,omitempty"`
+}
+
+type textObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type imageElement struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+func markdown(text string) *textObject {
+	return &textObject{Type: "mrkdwn", Text: text}
+}
+
+// escape escapes the characters that are control characters in Slack
+// markdown.
+var escape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
 func formatSlackMessage(query string, response *kgsearch.SearchResponse) (*Message, error) {
 	if response == nil {
 		return nil, fmt.Errorf("empty response")
 	}
 
-	if response.ItemListElement == nil || len(response.ItemListElement) == 0 {
+	queryContext := block{Type: "context", Elements: []*textObject{markdown("Query: " + escape(query))}}
+	if len(response.ItemListElement) == 0 {
 		message := &Message{
-			ResponseType: "in_channel",
-			Text:         fmt.Sprintf("Query: %s", query),
-			Attachments: []attachment{
-				{
-					Color: "#d6334b",
-					Text:  "No results match your query.",
-				},
+			ResponseType: ephemeral,
+			Text:         fmt.Sprintf("No results match your query %q.", query),
+			Blocks: []block{
+				{Type: "section", Text: markdown("No results match your query.")},
+				queryContext,
 			},
 		}
 		return message, nil
@@ -50,32 +100,36 @@ func formatSlackMessage(query string, response *kgsearch.SearchResponse) (*Messa
 		return nil, fmt.Errorf("error formatting response result")
 	}
 
-	attach := attachment{Color: "#3367d6"}
-	if name, ok := result["name"].(string); ok {
-		if description, ok := result["description"].(string); ok {
-			attach.Title = fmt.Sprintf("%s: %s", name, description)
-		} else {
-			attach.Title = name
-		}
-	}
+	name, _ := result["name"].(string)
+	title := escape(name)
 	if detailedDesc, ok := result["detailedDescription"].(map[string]interface{}); ok {
 		if url, ok := detailedDesc["url"].(string); ok {
-			attach.TitleLink = url
-		}
-		if article, ok := detailedDesc["articleBody"].(string); ok {
-			attach.Text = article
+			title = fmt.Sprintf("<%s|%s>", url, title)
 		}
 	}
+	title = "*" + title + "*"
+	if description, ok := result["description"].(string); ok {
+		title += ": " + escape(description)
+	}
+
+	summary := block{Type: "section", Text: markdown(title)}
 	if image, ok := result["image"].(map[string]interface{}); ok {
 		if imageURL, ok := image["contentUrl"].(string); ok {
-			attach.ImageURL = imageURL
+			summary.Accessory = &imageElement{Type: "image", ImageURL: imageURL, AltText: name}
+		}
+	}
+	blocks := []block{summary}
+	if detailedDesc, ok := result["detailedDescription"].(map[string]interface{}); ok {
+		if article, ok := detailedDesc["articleBody"].(string); ok {
+			blocks = append(blocks, block{Type: "section", Text: markdown(escape(article))})
 		}
 	}
+	blocks = append(blocks, queryContext)
 
 	message := &Message{
-		ResponseType: "in_channel",
+		ResponseType: inChannel,
 		Text:         fmt.Sprintf("Query: %s", query),
-		Attachments:  []attachment{attach},
+		Blocks:       blocks,
 	}
 	return message, nil
 }
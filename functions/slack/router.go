@@ -0,0 +1,201 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// [START functions_slack_router]
+
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Command is a slash command invocation.
+// See https://api.slack.com/interactivity/slash-commands.
+type Command struct {
+	// Name is the command, including the slash, e.g. "/kg".
+	Name string
+	// Text is the text after the command name.
+	Text        string
+	UserID      string
+	ChannelID   string
+	TeamID      string
+	ResponseURL string
+}
+
+// Handler answers a slash command. An error is logged and the user is told
+// that the command failed.
+type Handler func(ctx context.Context, cmd *Command) (*Message, error)
+
+// Router dispatches slash commands to their handlers. Requests must be
+// verified before they reach it; see verified.
+//
+// Slack requires a reply within 3 seconds. If a handler takes longer than
+// AckTimeout, the Router acknowledges the command and posts the handler's
+// reply to the command's response URL when it is ready. The follow-up runs
+// after the function has returned, so deploy with CPU allocated outside of
+// requests if commands are often slow.
+type Router struct {
+	// Default handles commands without a handler of their own. If nil, the
+	// user is told the command is unknown.
+	Default Handler
+	// AckTimeout defaults to 2.5 seconds.
+	AckTimeout time.Duration
+	// Timeout limits each handler, including follow-ups. It defaults to
+	// 1 minute.
+	Timeout time.Duration
+	// Client posts follow-ups. It defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu       sync.Mutex
+	commands map[string]Handler
+}
+
+// Handle registers the handler for the named command, e.g. "/kg".
+func (rt *Router) Handle(name string, h Handler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.commands == nil {
+		rt.commands = make(map[string]Handler)
+	}
+	rt.commands[name] = h
+}
+
+func (rt *Router) handler(name string) Handler {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if h, ok := rt.commands[name]; ok {
+		return h
+	}
+	if rt.Default != nil {
+		return rt.Default
+	}
+	return func(ctx context.Context, cmd *Command) (*Message, error) {
+		return Ephemeral(fmt.Sprintf("Unknown command %s.", cmd.Name)), nil
+	}
+}
+
+type handlerResult struct {
+	msg *Message
+	err error
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("ParseForm: %v", err)
+		http.Error(w, "Couldn't parse form", http.StatusBadRequest)
+		return
+	}
+	cmd := &Command{
+		Name:        r.PostForm.Get("command"),
+		Text:        r.PostForm.Get("text"),
+		UserID:      r.PostForm.Get("user_id"),
+		ChannelID:   r.PostForm.Get("channel_id"),
+		TeamID:      r.PostForm.Get("team_id"),
+		ResponseURL: r.PostForm.Get("response_url"),
+	}
+	h := rt.handler(cmd.Name)
+
+	// The handler outlives the request if it is slow, so its context is not
+	// the request's.
+	ctx, cancel := context.WithTimeout(context.Background(), durationOr(rt.Timeout, time.Minute))
+	done := make(chan handlerResult, 1)
+	go func() {
+		msg, err := h(ctx, cmd)
+		done <- handlerResult{msg, err}
+	}()
+
+	select {
+	case res := <-done:
+		cancel()
+		writeMessage(w, reply(cmd, res))
+	case <-time.After(durationOr(rt.AckTimeout, 2500*time.Millisecond)):
+		if cmd.ResponseURL == "" {
+			cancel()
+			log.Printf("%s: no response URL to follow up on", cmd.Name)
+			writeMessage(w, Ephemeral("Sorry, that took too long."))
+			return
+		}
+		writeMessage(w, Ephemeral("Working on it..."))
+		go func() {
+			defer cancel()
+			res := <-done
+			if err := rt.followUp(ctx, cmd.ResponseURL, reply(cmd, res)); err != nil {
+				log.Printf("%s: follow-up: %v", cmd.Name, err)
+			}
+		}()
+	}
+}
+
+// reply returns the message to send for a handler's result.
+func reply(cmd *Command, res handlerResult) *Message {
+	if res.err != nil {
+		log.Printf("%s %q: %v", cmd.Name, cmd.Text, res.err)
+		return Ephemeral("Sorry, something went wrong. Please try again later.")
+	}
+	if res.msg == nil {
+		return Ephemeral("Done.")
+	}
+	return res.msg
+}
+
+// followUp posts msg to a command's response URL.
+func (rt *Router) followUp(ctx context.Context, url string, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := rt.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("got status %s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+func writeMessage(w http.ResponseWriter, msg *Message) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// [END functions_slack_router]
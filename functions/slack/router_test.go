@@ -0,0 +1,195 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slack
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/kgsearch/v1"
+)
+
+const testSecret = "talesfromthecrypt"
+
+// fakeSearcher returns a result named after the query, no results for
+// "nothing", and an error for "fail". It sleeps for delay first.
+type fakeSearcher struct {
+	delay time.Duration
+}
+
+func (f *fakeSearcher) Search(ctx context.Context, query string) (*kgsearch.SearchResponse, error) {
+	time.Sleep(f.delay)
+	switch query {
+	case "fail":
+		return nil, errors.New("quota exceeded")
+	case "nothing":
+		return &kgsearch.SearchResponse{}, nil
+	}
+	return &kgsearch.SearchResponse{
+		ItemListElement: []interface{}{
+			map[string]interface{}{
+				"result": map[string]interface{}{
+					"name":        query,
+					"description": "A <thing>",
+					"image":       map[string]interface{}{"contentUrl": "https://example.com/img.png"},
+					"detailedDescription": map[string]interface{}{
+						"url":         "https://example.com/" + query,
+						"articleBody": query + " is a thing.",
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func signedRequest(method string, form url.Values, secret string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	body := form.Encode()
+	base := fmt.Sprintf("%s:%s:%s", version, ts, body)
+	req := httptest.NewRequest(method, "https://example.com/", strings.NewReader(body))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add(slackRequestTimestampHeader, ts)
+	req.Header.Add(slackSignatureHeader, fmt.Sprintf("%s=%s", version, hex.EncodeToString(getSignature([]byte(base), []byte(secret)))))
+	return req
+}
+
+func TestRouter(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	h := verified(testSecret, newRouter(&fakeSearcher{}))
+	tests := []struct {
+		name         string
+		method       string
+		secret       string
+		text         string
+		wantStatus   int
+		wantType     string
+		wantContains string
+	}{
+		{name: "result", text: "Gopher", wantType: inChannel, wantContains: "*<https://example.com/Gopher|Gopher>*: A &lt;thing&gt;"},
+		{name: "no results", text: "nothing", wantType: ephemeral, wantContains: "No results"},
+		{name: "empty text", text: " ", wantType: ephemeral, wantContains: "Usage: /kg <query>"},
+		{name: "search error", text: "fail", wantType: ephemeral, wantContains: "something went wrong"},
+		{name: "bad signature", secret: "wrong", text: "Gopher", wantStatus: http.StatusUnauthorized},
+		{name: "GET", method: http.MethodGet, text: "Gopher", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range tests {
+		method, secret, wantStatus := http.MethodPost, testSecret, http.StatusOK
+		if tc.method != "" {
+			method = tc.method
+		}
+		if tc.secret != "" {
+			secret = tc.secret
+		}
+		if tc.wantStatus != 0 {
+			wantStatus = tc.wantStatus
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, signedRequest(method, url.Values{"command": {"/kg"}, "text": {tc.text}}, secret))
+		if w.Code != wantStatus {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, wantStatus)
+			continue
+		}
+		if wantStatus != http.StatusOK {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Errorf("%s: json.Unmarshal: %v", tc.name, err)
+			continue
+		}
+		if msg.ResponseType != tc.wantType {
+			t.Errorf("%s: got response type %q, want %q", tc.name, msg.ResponseType, tc.wantType)
+		}
+		if got := msg.Text + "\n" + messageText(&msg); !strings.Contains(got, tc.wantContains) {
+			t.Errorf("%s: got %q, want it to contain %q", tc.name, got, tc.wantContains)
+		}
+	}
+}
+
+func TestRouterCommands(t *testing.T) {
+	rt := &Router{}
+	rt.Handle("/hello", func(ctx context.Context, cmd *Command) (*Message, error) {
+		return Ephemeral("Hello, " + cmd.UserID), nil
+	})
+	for _, tc := range []struct {
+		command, want string
+	}{
+		{"/hello", "Hello, U123"},
+		{"/bye", "Unknown command /bye."},
+	} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, signedRequest(http.MethodPost, url.Values{"command": {tc.command}, "user_id": {"U123"}}, testSecret))
+		var msg Message
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatalf("%s: json.Unmarshal: %v", tc.command, err)
+		}
+		if msg.Text != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.command, msg.Text, tc.want)
+		}
+	}
+}
+
+func TestRouterFollowUp(t *testing.T) {
+	followUps := make(chan Message, 1)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("follow-up: %v", err)
+		}
+		followUps <- msg
+	}))
+	defer slack.Close()
+
+	rt := newRouter(&fakeSearcher{delay: 50 * time.Millisecond})
+	rt.AckTimeout = 10 * time.Millisecond
+	rt.Client = slack.Client()
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, signedRequest(http.MethodPost, url.Values{
+		"command":      {"/kg"},
+		"text":         {"Gopher"},
+		"response_url": {slack.URL},
+	}, testSecret))
+
+	var ack Message
+	if err := json.Unmarshal(w.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if ack.ResponseType != ephemeral {
+		t.Errorf("ack: got response type %q, want %q", ack.ResponseType, ephemeral)
+	}
+	select {
+	case msg := <-followUps:
+		if msg.ResponseType != inChannel || !strings.Contains(messageText(&msg), "Gopher is a thing.") {
+			t.Errorf("follow-up: got %+v, want the search result", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("follow-up: not received")
+	}
+}
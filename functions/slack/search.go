@@ -20,10 +20,10 @@ package slack
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -31,6 +31,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"google.golang.org/api/kgsearch/v1"
 )
 
 type oldTimeStampError struct {
@@ -47,71 +49,55 @@ const (
 	slackSignatureHeader        = "X-Slack-Signature"
 )
 
-type attachment struct {
-	Color     string `json:"color"`
-	Title     string `json:"title"`
-	TitleLink string `json:"title_link"`
-	Text      string `json:"text"`
-	ImageURL  string `json:"image_url"`
-}
-
-// Message is the a Slack message event.
-// see https://api.slack.com/docs/message-formatting
-type Message struct {
-	ResponseType string       `json:"response_type"`
-	Text         string       `json:"text"`
-	Attachments  []attachment `json:"attachments"`
-}
-
 // KGSearch uses the Knowledge Graph API to search for a query provided
 // by a Slack command.
 func KGSearch(w http.ResponseWriter, r *http.Request) {
-	setup(r.Context())
-
-	bodyBytes, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatalf("Couldn't read request body: %v", err)
-	}
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	if r.Method != "POST" {
-		http.Error(w, "Only POST requests are accepted", 405)
-	}
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Couldn't parse form", 400)
-		log.Fatalf("ParseForm: %v", err)
-	}
-
-	// Reset r.Body as ParseForm depletes it by reading the io.ReadCloser.
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-	result, err := verifyWebHook(r, slackSecret)
-	if err != nil {
-		log.Fatalf("verifyWebhook: %v", err)
-	}
-	if !result {
-		log.Fatalf("signatures did not match.")
+	if err := setup(r.Context()); err != nil {
+		log.Printf("setup: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
 	}
+	handler.ServeHTTP(w, r)
+}
 
-	if len(r.Form["text"]) == 0 {
-		log.Fatalf("empty text in form")
-	}
-	kgSearchResponse, err := makeSearchRequest(r.Form["text"][0])
-	if err != nil {
-		log.Fatalf("makeSearchRequest: %v", err)
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err = json.NewEncoder(w).Encode(kgSearchResponse); err != nil {
-		log.Fatalf("json.Marshal: %v", err)
+// newRouter returns a Router that answers every command with a Knowledge
+// Graph search, so the function works whatever the command is named in the
+// Slack app.
+func newRouter(kg searcher) *Router {
+	search := func(ctx context.Context, cmd *Command) (*Message, error) {
+		query := strings.TrimSpace(cmd.Text)
+		if query == "" {
+			return Ephemeral(fmt.Sprintf("Usage: %s <query>", cmd.Name)), nil
+		}
+		return makeSearchRequest(ctx, kg, query)
 	}
+	rt := &Router{Default: search}
+	rt.Handle("/kg", search)
+	return rt
 }
 
 // [END functions_slack_search]
 
 // [START functions_slack_request]
-func makeSearchRequest(query string) (*Message, error) {
-	res, err := entitiesService.Search().Query(query).Limit(1).Do()
+
+// searcher searches the Knowledge Graph. Tests replace the API with a fake.
+type searcher interface {
+	Search(ctx context.Context, query string) (*kgsearch.SearchResponse, error)
+}
+
+// kgSearcher is a searcher using the Knowledge Graph Search API.
+type kgSearcher struct {
+	entities *kgsearch.EntitiesService
+}
+
+func (s *kgSearcher) Search(ctx context.Context, query string) (*kgsearch.SearchResponse, error) {
+	return s.entities.Search().Query(query).Limit(1).Context(ctx).Do()
+}
+
+func makeSearchRequest(ctx context.Context, kg searcher, query string) (*Message, error) {
+	res, err := kg.Search(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("Do: %w", err)
+		return nil, fmt.Errorf("Search: %w", err)
 	}
 	return formatSlackMessage(query, res)
 }
@@ -120,6 +106,27 @@ func makeSearchRequest(query string) (*Message, error) {
 
 // [START functions_verify_webhook]
 
+// verified returns a handler that only passes POST requests signed with
+// secret to next.
+func verified(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+			return
+		}
+		ok, err := verifyWebHook(r, secret)
+		if err != nil {
+			log.Printf("verifyWebHook: %v", err)
+		}
+		if !ok {
+			http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // verifyWebHook verifies the request signature.
 // See https://api.slack.com/docs/verifying-requests-from-slack.
 func verifyWebHook(r *http.Request, slackSigningSecret string) (bool, error) {
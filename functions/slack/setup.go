@@ -18,30 +18,49 @@ package slack
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"sync"
 
 	"google.golang.org/api/kgsearch/v1"
 	"google.golang.org/api/option"
 )
 
 var (
-	entitiesService *kgsearch.EntitiesService
-	kgKey           string
-	slackSecret     string
-)
+	kg          searcher
+	kgKey       string
+	slackSecret string
 
-func setup(ctx context.Context) {
-	kgKey = os.Getenv("KG_API_KEY")
-	slackSecret = os.Getenv("SLACK_SECRET")
+	setupOnce sync.Once
+	setupErr  error
+	handler   http.Handler
+)
 
-	if entitiesService == nil {
-		kgService, err := kgsearch.NewService(ctx, option.WithAPIKey(kgKey))
-		if err != nil {
-			log.Fatalf("kgsearch.NewService: %v", err)
+// setup creates the handler on the first request. Tests may set kg and
+// slackSecret beforehand.
+func setup(ctx context.Context) error {
+	setupOnce.Do(func() {
+		if slackSecret == "" {
+			slackSecret = os.Getenv("SLACK_SECRET")
+		}
+		if slackSecret == "" {
+			setupErr = errors.New("SLACK_SECRET is not set")
+			return
+		}
+		if kg == nil {
+			kgKey = os.Getenv("KG_API_KEY")
+			kgService, err := kgsearch.NewService(ctx, option.WithAPIKey(kgKey))
+			if err != nil {
+				setupErr = fmt.Errorf("kgsearch.NewService: %w", err)
+				return
+			}
+			kg = &kgSearcher{entities: kgsearch.NewEntitiesService(kgService)}
 		}
-		entitiesService = kgsearch.NewEntitiesService(kgService)
-	}
+		handler = verified(slackSecret, newRouter(kg))
+	})
+	return setupErr
 }
 
 // [END functions_slack_setup]
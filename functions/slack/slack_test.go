@@ -39,25 +39,44 @@ func TestMain(m *testing.M) {
 	ctx := context.Background()
 	slackURL = os.Getenv("GOLANG_SAMPLES_SLACK_URL")
 	kgKey = os.Getenv("GOLANG_SAMPLES_KG_KEY")
-	if kgKey == "" {
-		log.Print("GOLANG_SAMPLES_KG_KEY is unset. Skipping.")
-		return
-	}
 	slackSecret = os.Getenv("GOLANG_SAMPLES_SLACK_SECRET")
-	if slackSecret == "" {
-		log.Print("GOLANG_SAMPLES_SLACK_SECRET is unset. Skipping.")
-		return
+	if kgKey == "" || slackSecret == "" {
+		log.Print("GOLANG_SAMPLES_KG_KEY or GOLANG_SAMPLES_SLACK_SECRET is unset. Skipping system tests.")
+		os.Exit(m.Run())
 	}
 	kgService, err := kgsearch.NewService(ctx, option.WithAPIKey(kgKey))
 	if err != nil {
 		log.Fatalf("kgsearch.NewClient: %v", err)
 	}
-	entitiesService = kgsearch.NewEntitiesService(kgService)
+	kg = &kgSearcher{entities: kgsearch.NewEntitiesService(kgService)}
 
 	os.Exit(m.Run())
 }
 
+func systemTest(t *testing.T) {
+	t.Helper()
+	if kg == nil {
+		t.Skip("GOLANG_SAMPLES_KG_KEY and GOLANG_SAMPLES_SLACK_SECRET must be set")
+	}
+}
+
+// messageText returns the text of all of msg's blocks.
+func messageText(msg *Message) string {
+	var texts []string
+	for _, b := range msg.Blocks {
+		if b.Text != nil {
+			texts = append(texts, b.Text.Text)
+		}
+		for _, e := range b.Elements {
+			texts = append(texts, e.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
 func TestFormatSlackMessage(t *testing.T) {
+	systemTest(t)
+	ctx := context.Background()
 	tests := []struct {
 		query string
 		want  string
@@ -72,15 +91,15 @@ func TestFormatSlackMessage(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		res, err := entitiesService.Search().Query(test.query).Limit(1).Do()
+		res, err := kg.Search(ctx, test.query)
 		if err != nil {
-			t.Errorf("Do: %v", err)
+			t.Errorf("Search: %v", err)
 		}
 		msg, err := formatSlackMessage(test.query, res)
 		if err != nil {
 			t.Errorf("formatSlackMessage: %v", err)
 		}
-		got := msg.Attachments[0].Text
+		got := messageText(msg)
 		if !strings.Contains(got, test.want) {
 			t.Errorf("formatSlackMessage(%q) got %q, want %q", test.query, got, test.want)
 		}
@@ -88,9 +107,10 @@ func TestFormatSlackMessage(t *testing.T) {
 }
 
 func TestMakeSearchRequest(t *testing.T) {
+	systemTest(t)
 	query := "Google"
 	want := "Google"
-	msg, err := makeSearchRequest(query)
+	msg, err := makeSearchRequest(context.Background(), kg, query)
 	if err != nil {
 		t.Errorf("makeSearchRequest: %v", err)
 	}
@@ -101,19 +121,21 @@ func TestMakeSearchRequest(t *testing.T) {
 	if !strings.Contains(got, want) {
 		t.Errorf("makeSearchRequest(%q) got %q, want %q", query, got, want)
 	}
-	if len(msg.Attachments) == 0 {
-		t.Errorf("makeSearchRequest(%q) returned no attachments", query)
+	if len(msg.Blocks) == 0 {
+		t.Errorf("makeSearchRequest(%q) returned no blocks", query)
 	}
-	got = msg.Attachments[0].Text
+	got = messageText(msg)
 	if !strings.Contains(got, want) {
 		t.Errorf("makeSearchRequest(%q) got %q, want %q", query, got, want)
 	}
 }
 
 func TestKGSearch(t *testing.T) {
+	systemTest(t)
 	w := httptest.NewRecorder()
 	form := url.Values{
-		"text": []string{"Google"},
+		"command": []string{"/kg"},
+		"text":    []string{"Google"},
 	}
 
 	ts := strconv.FormatInt(time.Now().Unix(), 10)
@@ -3,12 +3,19 @@ module github.com/GoogleCloudPlatform/golang-samples
 go 1.19
 
 require (
+	cloud.google.com/go/artifactregistry v1.14.1
 	cloud.google.com/go/batch v0.7.0
 	cloud.google.com/go/bigquery v1.52.0
+	cloud.google.com/go/cloudbuild v1.10.1
 	cloud.google.com/go/compute v1.20.1
 	cloud.google.com/go/datastore v1.11.0
 	cloud.google.com/go/errorreporting v0.3.0
+	cloud.google.com/go/iam v1.1.0
+	cloud.google.com/go/kms v1.12.1
 	cloud.google.com/go/logging v1.7.0
+	cloud.google.com/go/pubsub v1.31.0
+	cloud.google.com/go/run v1.2.0
+	cloud.google.com/go/secretmanager v1.11.1
 	cloud.google.com/go/storage v1.30.1
 	cloud.google.com/go/vision v1.2.0
 	github.com/bmatcuk/doublestar/v2 v2.0.4
@@ -18,6 +25,7 @@ require (
 	golang.org/x/oauth2 v0.9.0
 	google.golang.org/api v0.128.0
 	google.golang.org/genproto v0.0.0-20230626202813-9b080da550b3
+	google.golang.org/grpc v1.56.3
 	google.golang.org/protobuf v1.31.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -25,7 +33,6 @@ require (
 require (
 	cloud.google.com/go v0.110.2 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
-	cloud.google.com/go/iam v1.1.0 // indirect
 	cloud.google.com/go/longrunning v0.5.0 // indirect
 	cloud.google.com/go/vision/v2 v2.7.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
@@ -43,7 +50,6 @@ require (
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20230530153820-e85fd2cbaebc // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230530153820-e85fd2cbaebc // indirect
-	google.golang.org/grpc v1.56.3 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
 
@@ -41,12 +41,15 @@
 //		resp, err := app.Get("/")
 //		...
 //	}
+//
+// To deploy several services and a dispatch.yaml together, use a Deployment.
 package aeintegrate
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -84,8 +87,19 @@ type App struct {
 	// Additional runtime environment variable overrides for the app.
 	Env map[string]string
 
+	// HealthPath is the path WaitReady polls. Defaults to "/".
+	HealthPath string
+
 	deployed bool // Whether the app has been deployed.
 
+	// newService is set if the service did not exist before Deploy, so that
+	// Cleanup deletes the whole service if the version is still its only one.
+	newService bool
+
+	// origSplit is the traffic split of the service before it was first
+	// changed by SplitTraffic or Migrate, restored by Cleanup.
+	origSplit *appengine.TrafficSplit
+
 	adminService *appengine.APIService // Used during clean up to delete the deployed version.
 
 	// A temporary configuration file that includes modifications (e.g. environment variables)
@@ -106,6 +120,59 @@ func (p *App) Get(path string) (*http.Response, error) {
 	return http.Get(url)
 }
 
+// NewRequest creates a request against the deployed version of the
+// application.
+func (p *App) NewRequest(method, path string, body io.Reader) (*http.Request, error) {
+	url, err := p.URL(path)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequest(method, url, body)
+}
+
+// Do sends a request, such as one created by NewRequest or NewServiceRequest,
+// with the given headers added.
+func (p *App) Do(req *http.Request, header http.Header) (*http.Response, error) {
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// WaitReady polls HealthPath on the deployed version every 10 seconds until
+// it responds with a 2xx status, or ctx is done.
+func (p *App) WaitReady(ctx context.Context) error {
+	if !p.deployed {
+		return errors.New("WaitReady called before Deploy")
+	}
+	path := p.HealthPath
+	if path == "" {
+		path = "/"
+	}
+	for {
+		req, err := p.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				log.Printf("(%s) Ready.", p.Name)
+				return nil
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("(%s) not ready at %s: %v", p.Name, path, err)
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
 // URL prepends the deployed application's base URL to the given path.
 // Returns an error if the application has not been deployed.
 func (p *App) URL(path string) (string, error) {
@@ -115,7 +182,7 @@ func (p *App) URL(path string) (string, error) {
 	return fmt.Sprintf("https://%s-dot-%s-dot-%s.appspot-preview.com%s", p.version(), p.Service, p.ProjectID, path), nil
 }
 
-// version returns the version that the app will be deployed to.
+// validate checks that the app can be deployed.
 func (p *App) validate() error {
 	if p.ProjectID == "" {
 		return errors.New("Project ID missing")
@@ -128,6 +195,12 @@ func (p *App) version() string {
 	return p.Name + "-" + runID
 }
 
+// Version returns the version ID that the app is deployed to, for use in
+// traffic splits.
+func (p *App) Version() string {
+	return p.version()
+}
+
 // Deploy deploys the application to App Engine. If the deployment fails, it tries to clean up the failed deployment.
 func (p *App) Deploy() error {
 	// Don't deploy unless we're certain everything is ready for deployment
@@ -141,6 +214,12 @@ func (p *App) Deploy() error {
 	if err := p.initAdminService(); err != nil {
 		return fmt.Errorf("could not setup admin service: %w", err)
 	}
+	if _, err := p.adminService.Apps.Services.Get(p.ProjectID, p.Service).Do(); err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("could not get service %s: %w", p.Service, err)
+		}
+		p.newService = true
+	}
 
 	log.Printf("(%s) Deploying...", p.Name)
 
@@ -239,12 +318,15 @@ func (p *App) envAppYaml() (string, error) {
 	return p.tempAppYaml, nil
 }
 
-func (p *App) deployCmd() (*exec.Cmd, error) {
-	gcloudBin := os.Getenv("GCLOUD_BIN")
-	if gcloudBin == "" {
-		gcloudBin = "gcloud"
+// gcloudBin returns the gcloud command to run.
+func gcloudBin() string {
+	if bin := os.Getenv("GCLOUD_BIN"); bin != "" {
+		return bin
 	}
+	return "gcloud"
+}
 
+func (p *App) deployCmd() (*exec.Cmd, error) {
 	appYaml, err := p.envAppYaml()
 	if err != nil {
 		return nil, err
@@ -253,7 +335,7 @@ func (p *App) deployCmd() (*exec.Cmd, error) {
 	// NOTE: if the "app" component is not available, and this is run in parallel,
 	// gcloud will attempt to install those components multiple
 	// times and will eventually fail on IO.
-	cmd := exec.Command(gcloudBin,
+	cmd := exec.Command(gcloudBin(),
 		"--quiet",
 		"app", "deploy", appYaml,
 		"--project", p.ProjectID,
@@ -307,7 +389,12 @@ func (p *App) initAdminService() error {
 	return err
 }
 
-// Cleanup deletes the created version from App Engine.
+// Cleanup deletes the created version from App Engine, after restoring the
+// traffic split it changed. If Deploy created the service, other runs may
+// have deployed to it since, so its traffic is moved to another version
+// first. The whole service is deleted instead if the created version is still
+// its only one, since the last version of a service cannot be, unless it is
+// the default service, which cannot be deleted either.
 func (p *App) Cleanup() error {
 	// NOTE: don't check whether p.deployed is set.
 	// We may want to attempt to clean up if deployment failed.
@@ -329,6 +416,24 @@ func (p *App) Cleanup() error {
 
 	log.Printf("(%s) Cleaning up.", p.Name)
 
+	if p.newService {
+		others, err := p.otherVersions(context.Background())
+		if err != nil {
+			return err
+		}
+		if len(others) == 0 && p.Service != "default" {
+			return p.deleteService()
+		}
+		if err := p.releaseTraffic(context.Background(), others); err != nil {
+			return fmt.Errorf("could not delete app module version %v/%v: %w", p.Service, p.version(), err)
+		}
+	}
+	if p.origSplit != nil {
+		if err := p.setSplit(context.Background(), p.origSplit, false); err != nil {
+			log.Printf("(%s) Could not restore traffic split: %v", p.Name, err)
+		}
+	}
+
 	var err error
 	for try := 0; try < 10; try++ {
 		_, err = p.adminService.Apps.Services.Versions.Delete(p.ProjectID, p.Service, p.version()).Do()
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import "testing"
+
+func TestValidateSplit(t *testing.T) {
+	tests := []struct {
+		allocations map[string]float64
+		wantErr     bool
+	}{
+		{allocations: map[string]float64{"v1": 1}},
+		{allocations: map[string]float64{"v1": 0.9, "v2": 0.1}},
+		{allocations: map[string]float64{"v1": 0.7, "v2": 0.2, "v3": 0.1}},
+		{allocations: nil, wantErr: true},
+		{allocations: map[string]float64{"v1": 0.5}, wantErr: true},
+		{allocations: map[string]float64{"v1": 1.5, "v2": -0.5}, wantErr: true},
+	}
+	for _, tc := range tests {
+		if err := validateSplit(tc.allocations); (err != nil) != tc.wantErr {
+			t.Errorf("validateSplit(%v): got err %v, want error: %v", tc.allocations, err, tc.wantErr)
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		service        string
+		wantURL        string
+		wantServiceURL string
+	}{
+		{
+			service:        "default",
+			wantURL:        "https://hw-" + runID + "-dot-default-dot-my-project.appspot-preview.com/ok",
+			wantServiceURL: "https://my-project.appspot.com/ok",
+		},
+		{
+			service:        "worker",
+			wantURL:        "https://hw-" + runID + "-dot-worker-dot-my-project.appspot-preview.com/ok",
+			wantServiceURL: "https://worker-dot-my-project.appspot.com/ok",
+		},
+	}
+	for _, tc := range tests {
+		app := &App{Name: "hw", ProjectID: "my-project", Service: tc.service}
+		if _, err := app.ServiceURL("/ok"); err == nil {
+			t.Errorf("ServiceURL before Deploy: got no error")
+		}
+		app.deployed = true
+		if got, _ := app.URL("/ok"); got != tc.wantURL {
+			t.Errorf("URL: got %q, want %q", got, tc.wantURL)
+		}
+		if got, _ := app.ServiceURL("/ok"); got != tc.wantServiceURL {
+			t.Errorf("ServiceURL: got %q, want %q", got, tc.wantServiceURL)
+		}
+	}
+}
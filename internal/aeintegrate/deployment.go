@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+// Deployment deploys several services of one application, and optionally
+// its dispatch rules, for tests that span services:
+//
+//	d := &aeintegrate.Deployment{
+//		ProjectID: tc.ProjectID,
+//		Apps: []*aeintegrate.App{
+//			{Name: "web", Dir: "default"},
+//			{Name: "worker", Dir: "worker"},
+//		},
+//		Dispatch: "dispatch.yaml",
+//		Promote:  true,
+//	}
+//	defer d.Cleanup()
+//	if err := d.Deploy(); err != nil { ... }
+//	if err := d.WaitReady(ctx); err != nil { ... }
+type Deployment struct {
+	// ProjectID is the project to deploy to. It is set on Apps without one.
+	ProjectID string
+
+	// Apps are deployed in order, so the default service should come first
+	// if it does not exist yet.
+	Apps []*App
+
+	// Dispatch is the path of a dispatch.yaml file deployed after the apps.
+	// Optional.
+	Dispatch string
+
+	// Promote migrates all traffic of each service to the deployed version,
+	// so that requests routed by Dispatch reach it.
+	Promote bool
+
+	adminService *appengine.APIService
+	// origDispatch holds the dispatch rules before Deploy, restored by
+	// Cleanup. It is nil until the dispatch file is deployed.
+	origDispatch []*appengine.UrlDispatchRule
+	dispatched   bool
+}
+
+// App returns the app with the given name, or nil.
+func (d *Deployment) App(name string) *App {
+	for _, a := range d.Apps {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Deploy deploys the apps, promotes them if requested, and then deploys the
+// dispatch rules. It stops at the first failure; call Cleanup to remove what
+// was deployed.
+func (d *Deployment) Deploy() error {
+	if len(d.Apps) == 0 {
+		return errors.New("no apps to deploy")
+	}
+	ctx := context.Background()
+	for _, a := range d.Apps {
+		if a.ProjectID == "" {
+			a.ProjectID = d.ProjectID
+		}
+		if err := a.Deploy(); err != nil {
+			return fmt.Errorf("could not deploy %s: %w", a.Name, err)
+		}
+		if d.Promote {
+			if err := a.Migrate(ctx); err != nil {
+				return fmt.Errorf("could not promote %s: %w", a.Name, err)
+			}
+		}
+	}
+	if d.Dispatch == "" {
+		return nil
+	}
+
+	d.adminService = d.Apps[0].adminService
+	app, err := d.adminService.Apps.Get(d.ProjectID).Do()
+	if err != nil {
+		return fmt.Errorf("could not get application: %w", err)
+	}
+	d.origDispatch = app.DispatchRules
+	if d.origDispatch == nil {
+		d.origDispatch = []*appengine.UrlDispatchRule{}
+	}
+
+	log.Printf("Deploying %s...", d.Dispatch)
+	cmd := exec.Command(gcloudBin(),
+		"--quiet",
+		"app", "deploy", filepath.Base(d.Dispatch),
+		"--project", d.ProjectID)
+	cmd.Dir = filepath.Dir(d.Dispatch)
+	d.dispatched = true
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Output from deploy:")
+		os.Stderr.Write(out)
+		return fmt.Errorf("could not deploy %s: %w", d.Dispatch, err)
+	}
+	return nil
+}
+
+// WaitReady waits for every app to be ready. See App.WaitReady.
+func (d *Deployment) WaitReady(ctx context.Context) error {
+	for _, a := range d.Apps {
+		if err := a.WaitReady(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup undoes Deploy in reverse order: it restores the dispatch rules,
+// then cleans up each app, last deployed first. It continues past failures
+// and returns the first.
+func (d *Deployment) Cleanup() error {
+	var first error
+	if d.dispatched {
+		log.Printf("Restoring dispatch rules.")
+		op, err := d.adminService.Apps.Patch(d.ProjectID, &appengine.Application{
+			DispatchRules:   d.origDispatch,
+			ForceSendFields: []string{"DispatchRules"},
+		}).UpdateMask("dispatch_rules").Do()
+		if err == nil {
+			err = waitForOperation(context.Background(), d.adminService, d.ProjectID, op)
+		}
+		if err != nil {
+			first = fmt.Errorf("could not restore dispatch rules: %w", err)
+			log.Print(first)
+		}
+	}
+	for i := len(d.Apps) - 1; i >= 0; i-- {
+		a := d.Apps[i]
+		if a.adminService == nil {
+			// Never deployed.
+			continue
+		}
+		if err := a.Cleanup(); err != nil {
+			log.Printf("(%s) %v", a.Name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
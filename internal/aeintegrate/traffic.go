@@ -0,0 +1,227 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+// Traffic sharding methods for SplitTraffic.
+const (
+	ShardByIP     = "IP"
+	ShardByCookie = "COOKIE"
+	ShardByRandom = "RANDOM"
+)
+
+// ServiceURL prepends the base URL of the deployed application's service to
+// the given path. Unlike URL, requests to it are routed by the service's
+// traffic split.
+func (p *App) ServiceURL(path string) (string, error) {
+	if !p.deployed {
+		return "", errors.New("ServiceURL called before Deploy")
+	}
+	if p.Service == "default" {
+		return fmt.Sprintf("https://%s.appspot.com%s", p.ProjectID, path), nil
+	}
+	return fmt.Sprintf("https://%s-dot-%s.appspot.com%s", p.Service, p.ProjectID, path), nil
+}
+
+// NewServiceRequest creates a request against the application's service,
+// routed by its traffic split.
+func (p *App) NewServiceRequest(method, path string, body io.Reader) (*http.Request, error) {
+	url, err := p.ServiceURL(path)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequest(method, url, body)
+}
+
+// Migrate routes all of the service's traffic to the deployed version. The
+// traffic is migrated gradually if the version supports it, that is, if it
+// runs in the standard environment with warmup requests enabled.
+func (p *App) Migrate(ctx context.Context) error {
+	if !p.deployed {
+		return errors.New("Migrate called before Deploy")
+	}
+	v, err := p.adminService.Apps.Services.Versions.Get(p.ProjectID, p.Service, p.version()).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("could not get version %s: %w", p.version(), err)
+	}
+	return p.SplitTraffic(ctx, map[string]float64{p.version(): 1}, "", canMigrate(v))
+}
+
+// canMigrate reports whether traffic can be migrated gradually to v.
+// Gradual migration is not supported in the flexible environment, and in the
+// standard environment it needs warmup requests.
+func canMigrate(v *appengine.Version) bool {
+	if v.Env != "" && v.Env != "standard" {
+		return false
+	}
+	for _, s := range v.InboundServices {
+		if s == "INBOUND_SERVICE_WARMUP" {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitTraffic sets the traffic split of the application's service.
+// allocations maps version IDs, such as the deployed Version, to fractions
+// of traffic that sum to 1. shardBy is one of ShardByIP, ShardByCookie or
+// ShardByRandom, or empty for the service's default. migrate requests a
+// gradual migration, which only standard environment versions with warmup
+// requests enabled support. The original split is restored by Cleanup.
+func (p *App) SplitTraffic(ctx context.Context, allocations map[string]float64, shardBy string, migrate bool) error {
+	if !p.deployed {
+		return errors.New("SplitTraffic called before Deploy")
+	}
+	if err := validateSplit(allocations); err != nil {
+		return err
+	}
+	if p.origSplit == nil && !p.newService {
+		svc, err := p.adminService.Apps.Services.Get(p.ProjectID, p.Service).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get service %s: %w", p.Service, err)
+		}
+		p.origSplit = svc.Split
+	}
+	log.Printf("(%s) Splitting traffic: %v", p.Name, allocations)
+	return p.setSplit(ctx, &appengine.TrafficSplit{Allocations: allocations, ShardBy: shardBy}, migrate)
+}
+
+// validateSplit checks that allocations are non-negative and sum to 1.
+func validateSplit(allocations map[string]float64) error {
+	if len(allocations) == 0 {
+		return errors.New("no traffic allocations")
+	}
+	var sum float64
+	for v, a := range allocations {
+		if a <= 0 || a > 1 {
+			return fmt.Errorf("allocation %v for version %q is not in (0, 1]", a, v)
+		}
+		sum += a
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		return fmt.Errorf("traffic allocations sum to %v, want 1", sum)
+	}
+	return nil
+}
+
+func (p *App) setSplit(ctx context.Context, split *appengine.TrafficSplit, migrate bool) error {
+	op, err := p.adminService.Apps.Services.Patch(p.ProjectID, p.Service, &appengine.Service{Split: split}).
+		UpdateMask("split").
+		MigrateTraffic(migrate).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("could not update traffic split of %s: %w", p.Service, err)
+	}
+	return waitForOperation(ctx, p.adminService, p.ProjectID, op)
+}
+
+// otherVersions lists the versions of the application's service other than
+// the deployed one.
+func (p *App) otherVersions(ctx context.Context) ([]*appengine.Version, error) {
+	resp, err := p.adminService.Apps.Services.Versions.List(p.ProjectID, p.Service).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("could not list versions of %s: %w", p.Service, err)
+	}
+	var others []*appengine.Version
+	for _, v := range resp.Versions {
+		if v.Id != p.version() {
+			others = append(others, v)
+		}
+	}
+	return others, nil
+}
+
+// releaseTraffic moves the traffic of a service created by Deploy off the
+// deployed version, so that Cleanup can delete it while others, such as the
+// versions of concurrent runs, remain. The traffic goes to the most recently
+// created of the others.
+func (p *App) releaseTraffic(ctx context.Context, others []*appengine.Version) error {
+	svc, err := p.adminService.Apps.Services.Get(p.ProjectID, p.Service).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("could not get service %s: %w", p.Service, err)
+	}
+	if svc.Split == nil || svc.Split.Allocations[p.version()] == 0 {
+		return nil
+	}
+	var latest *appengine.Version
+	for _, v := range others {
+		if latest == nil || v.CreateTime > latest.CreateTime {
+			latest = v
+		}
+	}
+	if latest == nil {
+		return fmt.Errorf("version %s serves all traffic of service %s and is its only version, so it cannot be deleted", p.version(), p.Service)
+	}
+	log.Printf("(%s) Moving traffic of %s to %s", p.Name, p.Service, latest.Id)
+	return p.setSplit(ctx, &appengine.TrafficSplit{Allocations: map[string]float64{latest.Id: 1}}, false)
+}
+
+// deleteService deletes the application's service and all its versions.
+func (p *App) deleteService() error {
+	op, err := p.adminService.Apps.Services.Delete(p.ProjectID, p.Service).Do()
+	if err == nil {
+		err = waitForOperation(context.Background(), p.adminService, p.ProjectID, op)
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete service %v: %v", p.Service, err)
+	}
+	log.Printf("(%s) Successfully cleaned up.", p.Name)
+	return nil
+}
+
+// waitForOperation polls op until it is done.
+func waitForOperation(ctx context.Context, gae *appengine.APIService, projectID string, op *appengine.Operation) error {
+	id := lastSegment(op.Name)
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+		var err error
+		if op, err = gae.Apps.Operations.Get(projectID, id).Context(ctx).Do(); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("%s (code %d)", op.Error.Message, op.Error.Code)
+	}
+	return nil
+}
+
+func lastSegment(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func isNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	appengine "google.golang.org/api/appengine/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeAdmin is a minimal App Engine Admin API serving one application's
+// services and versions.
+type fakeAdmin struct {
+	mu       sync.Mutex
+	services map[string]*appengine.Service
+	versions map[string][]*appengine.Version
+	patches  []patch
+	deleted  []string
+}
+
+// patch is a traffic split update.
+type patch struct {
+	service     string
+	allocations map[string]float64
+	migrate     bool
+}
+
+func newFakeAdmin(t *testing.T) (*fakeAdmin, *appengine.APIService) {
+	t.Helper()
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	f := &fakeAdmin{
+		services: make(map[string]*appengine.Service),
+		versions: make(map[string][]*appengine.Version),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	svc, err := appengine.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("appengine.NewService: %v", err)
+	}
+	return f, svc
+}
+
+// addVersion adds v to service, creating the service if needed.
+func (f *fakeAdmin) addVersion(service string, v *appengine.Version) {
+	if _, ok := f.services[service]; !ok {
+		f.services[service] = &appengine.Service{Id: service, Split: &appengine.TrafficSplit{Allocations: map[string]float64{v.Id: 1}}}
+	}
+	f.versions[service] = append(f.versions[service], v)
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// /v1/apps/{app}/services/{service}/versions/{version}
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(p) < 5 || p[0] != "v1" || p[1] != "apps" || p[3] != "services" {
+		http.NotFound(w, r)
+		return
+	}
+	service := p[4]
+	svc, ok := f.services[service]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	done := &appengine.Operation{Name: "apps/" + p[2] + "/operations/1", Done: true}
+	switch {
+	case len(p) == 5 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(svc)
+	case len(p) == 5 && r.Method == http.MethodPatch:
+		var body appengine.Service
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		migrate, _ := strconv.ParseBool(r.URL.Query().Get("migrateTraffic"))
+		svc.Split = body.Split
+		f.patches = append(f.patches, patch{service, body.Split.Allocations, migrate})
+		json.NewEncoder(w).Encode(done)
+	case len(p) == 5 && r.Method == http.MethodDelete:
+		delete(f.services, service)
+		f.deleted = append(f.deleted, service)
+		json.NewEncoder(w).Encode(done)
+	case len(p) == 6 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(&appengine.ListVersionsResponse{Versions: f.versions[service]})
+	case len(p) == 7:
+		for i, v := range f.versions[service] {
+			if v.Id != p[6] {
+				continue
+			}
+			switch r.Method {
+			case http.MethodGet:
+				json.NewEncoder(w).Encode(v)
+				return
+			case http.MethodDelete:
+				if svc.Split.Allocations[v.Id] > 0 {
+					http.Error(w, "version is serving traffic", http.StatusBadRequest)
+					return
+				}
+				f.versions[service] = append(f.versions[service][:i], f.versions[service][i+1:]...)
+				f.deleted = append(f.deleted, service+"/"+v.Id)
+				json.NewEncoder(w).Encode(done)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     *appengine.Version
+		wantMigrate bool
+	}{
+		{
+			name:        "standard with warmup",
+			version:     &appengine.Version{Env: "standard", InboundServices: []string{"INBOUND_SERVICE_MAIL", "INBOUND_SERVICE_WARMUP"}},
+			wantMigrate: true,
+		},
+		{
+			name:    "standard without warmup",
+			version: &appengine.Version{InboundServices: []string{"INBOUND_SERVICE_MAIL"}},
+		},
+		{
+			name:    "flexible",
+			version: &appengine.Version{Env: "flexible", InboundServices: []string{"INBOUND_SERVICE_WARMUP"}},
+		},
+	}
+	for _, tc := range tests {
+		f, svc := newFakeAdmin(t)
+		app := &App{Name: "hw", ProjectID: "my-project", Service: "worker", deployed: true, adminService: svc}
+		f.addVersion("worker", &appengine.Version{Id: "v0"})
+		tc.version.Id = app.Version()
+		f.addVersion("worker", tc.version)
+
+		if err := app.Migrate(context.Background()); err != nil {
+			t.Fatalf("%s: Migrate: %v", tc.name, err)
+		}
+		want := []patch{{"worker", map[string]float64{app.Version(): 1}, tc.wantMigrate}}
+		if !reflect.DeepEqual(f.patches, want) {
+			t.Errorf("%s: Migrate: got patches %+v, want %+v", tc.name, f.patches, want)
+		}
+	}
+}
+
+func TestSplitTrafficCleanup(t *testing.T) {
+	f, svc := newFakeAdmin(t)
+	app := &App{Name: "hw", ProjectID: "my-project", Service: "worker", deployed: true, adminService: svc}
+	f.addVersion("worker", &appengine.Version{Id: "v0"})
+	f.addVersion("worker", &appengine.Version{Id: app.Version()})
+
+	split := map[string]float64{"v0": 0.9, app.Version(): 0.1}
+	if err := app.SplitTraffic(context.Background(), split, ShardByCookie, false); err != nil {
+		t.Fatalf("SplitTraffic: %v", err)
+	}
+	if got := f.services["worker"].Split.ShardBy; got != ShardByCookie {
+		t.Errorf("SplitTraffic: got shardBy %q, want %q", got, ShardByCookie)
+	}
+	if err := app.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	wantPatches := []patch{
+		{"worker", split, false},
+		{"worker", map[string]float64{"v0": 1}, false},
+	}
+	if !reflect.DeepEqual(f.patches, wantPatches) {
+		t.Errorf("Cleanup: got patches %+v, want %+v", f.patches, wantPatches)
+	}
+	if want := []string{"worker/" + app.Version()}; !reflect.DeepEqual(f.deleted, want) {
+		t.Errorf("Cleanup: got deleted %v, want %v", f.deleted, want)
+	}
+}
+
+func TestCleanupNewService(t *testing.T) {
+	f, svc := newFakeAdmin(t)
+	app := &App{Name: "hw", ProjectID: "my-project", Service: "worker", deployed: true, newService: true, adminService: svc}
+	f.addVersion("worker", &appengine.Version{Id: app.Version()})
+
+	if err := app.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if want := []string{"worker"}; !reflect.DeepEqual(f.deleted, want) {
+		t.Errorf("Cleanup: got deleted %v, want %v", f.deleted, want)
+	}
+}
+
+func TestCleanupNewServiceShared(t *testing.T) {
+	// Another run deployed to the service after Deploy created it.
+	f, svc := newFakeAdmin(t)
+	app := &App{Name: "hw", ProjectID: "my-project", Service: "worker", deployed: true, newService: true, adminService: svc}
+	f.addVersion("worker", &appengine.Version{Id: app.Version()})
+	f.addVersion("worker", &appengine.Version{Id: "other-run"})
+
+	if err := app.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if got, want := f.services["worker"].Split.Allocations, map[string]float64{"other-run": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Cleanup: got split %v, want %v", got, want)
+	}
+	if want := []string{"worker/" + app.Version()}; !reflect.DeepEqual(f.deleted, want) {
+		t.Errorf("Cleanup: got deleted %v, want %v", f.deleted, want)
+	}
+}
+
+func TestCleanupNewDefaultService(t *testing.T) {
+	tests := []struct {
+		name        string
+		others      []*appengine.Version
+		wantDeleted bool
+	}{
+		{
+			name: "other versions",
+			others: []*appengine.Version{
+				{Id: "old", CreateTime: "2024-01-01T00:00:00Z"},
+				{Id: "newer", CreateTime: "2024-02-01T00:00:00Z"},
+			},
+			wantDeleted: true,
+		},
+		{name: "only version"},
+	}
+	for _, tc := range tests {
+		f, svc := newFakeAdmin(t)
+		app := &App{Name: "hw", ProjectID: "my-project", Service: "default", deployed: true, newService: true, adminService: svc}
+		f.addVersion("default", &appengine.Version{Id: app.Version(), InboundServices: []string{"INBOUND_SERVICE_WARMUP"}})
+		for _, v := range tc.others {
+			f.addVersion("default", v)
+		}
+		if err := app.Migrate(context.Background()); err != nil {
+			t.Fatalf("%s: Migrate: %v", tc.name, err)
+		}
+
+		err := app.Cleanup()
+		if !tc.wantDeleted {
+			if err == nil {
+				t.Errorf("%s: Cleanup: got no error deleting the only version", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Cleanup: %v", tc.name, err)
+		}
+		if got, want := f.services["default"].Split.Allocations, map[string]float64{"newer": 1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Cleanup: got split %v, want %v", tc.name, got, want)
+		}
+		if want := []string{"default/" + app.Version()}; !reflect.DeepEqual(f.deleted, want) {
+			t.Errorf("%s: Cleanup: got deleted %v, want %v", tc.name, f.deleted, want)
+		}
+	}
+}
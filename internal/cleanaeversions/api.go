@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+// versionsAPI is the part of the App Engine Admin API used to clean
+// versions. It is satisfied by apiService, and faked in tests.
+type versionsAPI interface {
+	ListServices(ctx context.Context, project string) ([]*appengine.Service, error)
+	ListVersions(ctx context.Context, project, service string) ([]*appengine.Version, error)
+	DeleteVersion(ctx context.Context, project, service, version string) (*appengine.Operation, error)
+	GetOperation(ctx context.Context, project, id string) (*appengine.Operation, error)
+}
+
+// apiService implements versionsAPI with an appengine.APIService.
+type apiService struct {
+	gae *appengine.APIService
+}
+
+func (a *apiService) ListServices(ctx context.Context, project string) ([]*appengine.Service, error) {
+	var services []*appengine.Service
+	err := a.gae.Apps.Services.List(project).Pages(ctx, func(lsr *appengine.ListServicesResponse) error {
+		services = append(services, lsr.Services...)
+		return nil
+	})
+	return services, err
+}
+
+func (a *apiService) ListVersions(ctx context.Context, project, service string) ([]*appengine.Version, error) {
+	var versions []*appengine.Version
+	err := a.gae.Apps.Services.Versions.List(project, service).Pages(ctx, func(lvr *appengine.ListVersionsResponse) error {
+		versions = append(versions, lvr.Versions...)
+		return nil
+	})
+	return versions, err
+}
+
+func (a *apiService) DeleteVersion(ctx context.Context, project, service, version string) (*appengine.Operation, error) {
+	return a.gae.Apps.Services.Versions.Delete(project, service, version).Context(ctx).Do()
+}
+
+func (a *apiService) GetOperation(ctx context.Context, project, id string) (*appengine.Operation, error) {
+	return a.gae.Apps.Operations.Get(project, id).Context(ctx).Do()
+}
+
+// operationID returns the ID of an operation from its resource name.
+func operationID(name string) string {
+	parts := strings.Split(name, "/")
+	return parts[len(parts)-1]
+}
@@ -14,29 +14,46 @@
 
 // Command cleaneversions deletes App Engine versions for a given project, service and/or version ID filter.
 //
+// Versions matching the filter are deleted unless a retention policy keeps
+// them: the newest versions of each service, versions serving traffic,
+// versions younger than a minimum age, and versions of services with an
+// excluded label.
+//
 //	Usage of cleanaeversions:
 //	  -async
 //	      Don't wait for successful deletion.
+//	  -concurrency n
+//	      Maximum number of deletions in flight. (default 10)
+//	  -exclude-label list
+//	      Comma-separated list of key=value service labels whose versions are kept. An empty value matches any value.
 //	  -filter regexp
 //	      Filter regexp for version IDs. If empty, attempts to clean all versions.
+//	  -json
+//	      Write a JSON report of what was or would be deleted to stdout.
+//	  -keep n
+//	      Number of newest matching versions to keep per service.
+//	  -keep-serving
+//	      Keep versions with a traffic allocation. (default true)
+//	  -min-age duration
+//	      Keep versions younger than duration.
 //	  -n  Dry run.
 //	  -project Project ID
 //	      Project ID to clean.
+//	  -rate n
+//	      Maximum number of deletions started per second. (default 5)
 //	  -service Service/module ID
 //	      Service/module ID to clean. If omitted, cleans all services.
 package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"regexp"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2/google"
@@ -45,19 +62,25 @@ import (
 )
 
 var (
-	proj    = flag.String("project", "", "`Project ID` to clean.")
-	service = flag.String("service", "", "`Service/module ID` to clean. If omitted, cleans all services.")
-	filter  = flag.String("filter", "", "Filter `regexp` for version IDs. If empty, attempts to clean all versions.")
-	async   = flag.Bool("async", false, "Don't wait for successful deletion.")
-	dryRun  = flag.Bool("n", false, "Dry run.")
+	proj         = flag.String("project", "", "`Project ID` to clean.")
+	service      = flag.String("service", "", "`Service/module ID` to clean. If omitted, cleans all services.")
+	filter       = flag.String("filter", "", "Filter `regexp` for version IDs. If empty, attempts to clean all versions.")
+	async        = flag.Bool("async", false, "Don't wait for successful deletion.")
+	dryRun       = flag.Bool("n", false, "Dry run.")
+	keep         = flag.Int("keep", 0, "Number of newest matching versions to keep per service.")
+	keepServing  = flag.Bool("keep-serving", true, "Keep versions with a traffic allocation.")
+	minAge       = flag.Duration("min-age", 0, "Keep versions younger than `duration`.")
+	excludeLabel = flag.String("exclude-label", "", "Comma-separated `list` of key=value service labels whose versions are kept. An empty value matches any value.")
+	jsonReport   = flag.Bool("json", false, "Write a JSON report of what was or would be deleted to stdout.")
+	concurrency  = flag.Int("concurrency", 10, "Maximum `n`umber of deletions in flight.")
+	rate         = flag.Float64("rate", 5, "Maximum `n`umber of deletions started per second.")
 )
 
-var gae *appengine.APIService
-
-type pendingDelete struct {
-	service string
-	version string
-	op      *appengine.Operation
+// report is the result of a run, written with -json.
+type report struct {
+	Project  string           `json:"project"`
+	DryRun   bool             `json:"dryRun"`
+	Versions []*versionResult `json:"versions"`
 }
 
 func main() {
@@ -73,7 +96,19 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Filter is not a valid regexp: %v", err)
 		os.Exit(2)
 	}
-	_ = filterRE
+	p := policy{
+		filter:      filterRE,
+		keepNewest:  *keep,
+		keepServing: *keepServing,
+		minAge:      *minAge,
+	}
+	if *excludeLabel != "" {
+		p.excludeLabels = make(map[string]string)
+		for _, l := range strings.Split(*excludeLabel, ",") {
+			k, v, _ := strings.Cut(l, "=")
+			p.excludeLabels[k] = v
+		}
+	}
 
 	ctx := context.Background()
 	hc, err := google.DefaultClient(ctx, appengine.CloudPlatformScope)
@@ -81,101 +116,86 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Could not create DefaultClient: %v", err)
 		os.Exit(1)
 	}
-	gae, err = appengine.New(hc)
+	gae, err := appengine.New(hc)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not create App Engine service: %v", err)
 		os.Exit(1)
 	}
+	api := &apiService{gae: gae}
 
-	var services []string
-	if *service != "" {
-		services = append(services, *service)
-	} else {
-		if err := gae.Apps.Services.List(*proj).Pages(ctx, func(lsr *appengine.ListServicesResponse) error {
-			for _, s := range lsr.Services {
-				services = append(services, s.Id)
-			}
-			return nil
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Could not list App Engine services: %v", err)
-			os.Exit(1)
-		}
+	rep, err := plan(ctx, api, *proj, *service, p, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	rep.DryRun = *dryRun
 
-	var pending []pendingDelete
-
-	for _, service := range services {
-		if err := gae.Apps.Services.Versions.List(*proj, service).Pages(ctx, func(lvr *appengine.ListVersionsResponse) error {
-			for _, v := range lvr.Versions {
-				if !filterRE.MatchString(v.Id) {
-					continue
-				}
-
-				log.Printf("Deleting %s/%s", service, v.Id)
-				if *dryRun {
-					continue
-				}
-
-				op, err := gae.Apps.Services.Versions.Delete(*proj, service, v.Id).Do()
-				if err != nil {
-					log.Printf("Could not delete version %s/%s: %v\n", service, v.Id, err)
-				} else {
-					pending = append(pending, pendingDelete{service: service, version: v.Id, op: op})
-				}
+	if *dryRun {
+		for _, r := range rep.Versions {
+			if r.Action == actionDelete {
+				log.Printf("Would delete %s/%s", r.Service, r.Version)
+				r.Status = "dry-run"
 			}
-			return nil
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Could not list versions for %q: %v\n", service, err)
-			os.Exit(1)
 		}
+	} else {
+		if !*async {
+			log.Printf("Waiting for operations to complete.")
+		}
+		d := &deleter{
+			api:          api,
+			project:      *proj,
+			concurrency:  *concurrency,
+			wait:         !*async,
+			pollInterval: 5 * time.Second,
+		}
+		if *rate > 0 {
+			d.interval = time.Duration(float64(time.Second) / *rate)
+		}
+		d.run(ctx, rep.Versions)
 	}
 
-	if *async {
-		log.Printf("Not waiting for operations to complete. Exiting.")
-		os.Exit(0)
-	}
-
-	log.Printf("Waiting for operations to complete.")
-
-	var failed int64
-	var wg sync.WaitGroup
-	wg.Add(len(pending))
-	for _, pd := range pending {
-		pd := pd
-		go func() {
-			if err := waitForCompletion(pd); err != nil {
-				log.Printf("FAILED %v/%v/%v: %v", *proj, pd.service, pd.version, err)
-				atomic.AddInt64(&failed, 1)
-			} else {
-				log.Printf("Deleted %v/%v/%v", *proj, pd.service, pd.version)
-			}
-			wg.Done()
-		}()
+	if *jsonReport {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write report: %v", err)
+			os.Exit(1)
+		}
 	}
-	wg.Wait()
 
-	if failed != 0 {
+	if failed := rep.failed(); failed != 0 {
 		log.Printf("FAILED (%d)", failed)
 		os.Exit(1)
 	}
 }
 
-func waitForCompletion(pd pendingDelete) error {
-	parts := strings.Split(pd.op.Name, "/")
-	id := parts[len(parts)-1]
-	for {
-		op, err := gae.Apps.Operations.Get(*proj, id).Do()
-		if err != nil {
-			return err
-		}
-		if !op.Done {
-			// 5 to 10 second sleep.
-			time.Sleep(time.Duration(5+rand.Float64()*5) * time.Second)
+// plan lists the versions of service, or of all services if service is
+// empty, and decides what to do with them.
+func plan(ctx context.Context, api versionsAPI, project, service string, p policy, now time.Time) (*report, error) {
+	services, err := api.ListServices(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list App Engine services: %v", err)
+	}
+	rep := &report{Project: project}
+	for _, svc := range services {
+		if service != "" && svc.Id != service {
 			continue
 		}
-		if op.Error == nil {
-			return nil
+		versions, err := api.ListVersions(ctx, project, svc.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list versions for %q: %v", svc.Id, err)
+		}
+		rep.Versions = append(rep.Versions, p.evaluate(svc, versions, now)...)
+	}
+	return rep, nil
+}
+
+func (r *report) failed() int {
+	n := 0
+	for _, v := range r.Versions {
+		if v.Status == "failed" {
+			n++
 		}
-		return fmt.Errorf("%s (code %d)", op.Error.Message, op.Error.Code)
 	}
+	return n
 }
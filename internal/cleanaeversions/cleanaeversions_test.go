@@ -0,0 +1,219 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func ago(d time.Duration) string {
+	return now.Add(-d).Format(time.RFC3339)
+}
+
+type fakeAPI struct {
+	services []*appengine.Service
+	versions map[string][]*appengine.Version
+
+	mu        sync.Mutex
+	deleted   []string
+	inFlight  int
+	maxFlight int
+	failOn    string
+}
+
+func (f *fakeAPI) ListServices(ctx context.Context, project string) ([]*appengine.Service, error) {
+	return f.services, nil
+}
+
+func (f *fakeAPI) ListVersions(ctx context.Context, project, service string) ([]*appengine.Version, error) {
+	return f.versions[service], nil
+}
+
+func (f *fakeAPI) DeleteVersion(ctx context.Context, project, service, version string) (*appengine.Operation, error) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxFlight {
+		f.maxFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	if service+"/"+version == f.failOn {
+		f.done()
+		return nil, errors.New("permission denied")
+	}
+	return &appengine.Operation{Name: "apps/" + project + "/operations/" + service + "." + version}, nil
+}
+
+func (f *fakeAPI) GetOperation(ctx context.Context, project, id string) (*appengine.Operation, error) {
+	time.Sleep(time.Millisecond)
+	f.mu.Lock()
+	f.deleted = append(f.deleted, id)
+	f.mu.Unlock()
+	f.done()
+	return &appengine.Operation{Name: id, Done: true}, nil
+}
+
+func (f *fakeAPI) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		services: []*appengine.Service{
+			{
+				Id:    "default",
+				Split: &appengine.TrafficSplit{Allocations: map[string]float64{"v-serving": 1}},
+			},
+			{Id: "pinned", Labels: map[string]string{"keep": "true"}},
+		},
+		versions: map[string][]*appengine.Version{
+			"default": {
+				{Id: "v-old", CreateTime: ago(72 * time.Hour)},
+				{Id: "v-serving", CreateTime: ago(96 * time.Hour)},
+				{Id: "v-newest", CreateTime: ago(time.Hour)},
+				{Id: "v-recent", CreateTime: ago(2 * time.Hour)},
+				{Id: "v-older", CreateTime: ago(48 * time.Hour)},
+				{Id: "prod", CreateTime: ago(100 * time.Hour)},
+			},
+			"pinned": {
+				{Id: "v-pinned", CreateTime: ago(72 * time.Hour)},
+			},
+		},
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		service string
+		p       policy
+		want    map[string]string // version to action
+	}{
+		{
+			name: "filter only",
+			p:    policy{filter: regexp.MustCompile("^v-")},
+			want: map[string]string{
+				"v-old": actionDelete, "v-serving": actionDelete, "v-newest": actionDelete,
+				"v-recent": actionDelete, "v-older": actionDelete, "v-pinned": actionDelete,
+			},
+		},
+		{
+			name:    "all policies",
+			service: "",
+			p: policy{
+				filter:        regexp.MustCompile("^v-"),
+				keepNewest:    1,
+				keepServing:   true,
+				minAge:        24 * time.Hour,
+				excludeLabels: map[string]string{"keep": ""},
+			},
+			want: map[string]string{
+				"v-newest": actionKeep, "v-recent": actionKeep, "v-serving": actionKeep,
+				"v-old": actionDelete, "v-older": actionDelete, "v-pinned": actionKeep,
+			},
+		},
+		{
+			name:    "keep newest in one service",
+			service: "default",
+			p:       policy{keepNewest: 3},
+			want: map[string]string{
+				"v-newest": actionKeep, "v-recent": actionKeep, "v-older": actionKeep,
+				"v-old": actionDelete, "v-serving": actionDelete, "prod": actionDelete,
+			},
+		},
+	}
+	for _, tc := range tests {
+		rep, err := plan(ctx, newFakeAPI(), "my-project", tc.service, tc.p, now)
+		if err != nil {
+			t.Fatalf("%s: plan: %v", tc.name, err)
+		}
+		got := make(map[string]string)
+		for _, r := range rep.Versions {
+			got[r.Version] = r.Action
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %d versions %v, want %d", tc.name, len(got), got, len(tc.want))
+		}
+		for v, want := range tc.want {
+			if got[v] != want {
+				t.Errorf("%s: %s: got action %q, want %q", tc.name, v, got[v], want)
+			}
+		}
+	}
+}
+
+func TestDeleter(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+
+	api := newFakeAPI()
+	api.failOn = "default/v-old"
+	rep, err := plan(ctx, api, "my-project", "", policy{filter: regexp.MustCompile("^v-")}, now)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	d := &deleter{api: api, project: "my-project", concurrency: 2, wait: true, pollInterval: time.Millisecond}
+	d.run(ctx, rep.Versions)
+
+	if api.maxFlight > 2 {
+		t.Errorf("deleter: got %d deletions in flight, want at most 2", api.maxFlight)
+	}
+	if got, want := len(api.deleted), 5; got != want {
+		t.Errorf("deleter: deleted %d versions, want %d", got, want)
+	}
+	if got := rep.failed(); got != 1 {
+		t.Errorf("failed: got %d, want 1", got)
+	}
+	for _, r := range rep.Versions {
+		want := "deleted"
+		if r.Version == "v-old" {
+			want = "failed"
+		}
+		if r.Status != want {
+			t.Errorf("%s/%s: got status %q, want %q", r.Service, r.Version, r.Status, want)
+		}
+	}
+
+	// With a rate limit, deletions are spread out.
+	api = newFakeAPI()
+	rep = &report{}
+	for _, v := range []string{"a", "b", "c"} {
+		rep.Versions = append(rep.Versions, &versionResult{Service: "default", Version: v, Action: actionDelete})
+	}
+	d = &deleter{api: api, project: "my-project", concurrency: 10, interval: 20 * time.Millisecond}
+	start := time.Now()
+	d.run(ctx, rep.Versions)
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("rate limited deleter: took %v for 3 deletions, want at least 40ms", elapsed)
+	}
+	if rep.Versions[0].Status != "pending" {
+		t.Errorf("async deleter: got status %q, want pending", rep.Versions[0].Status)
+	}
+}
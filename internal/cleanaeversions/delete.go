@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// deleter deletes versions with bounded concurrency, starting at most one
+// deletion per interval.
+type deleter struct {
+	api         versionsAPI
+	project     string
+	concurrency int
+	interval    time.Duration
+	// wait waits for each deletion operation to complete.
+	wait bool
+	// pollInterval is the minimum time between polls of an operation. Each
+	// poll waits up to twice as long, to spread out requests.
+	pollInterval time.Duration
+}
+
+// run deletes the versions whose action is actionDelete, and records the
+// outcome in their Status.
+func (d *deleter) run(ctx context.Context, results []*versionResult) {
+	concurrency := d.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var limit <-chan time.Time
+	if d.interval > 0 {
+		t := time.NewTicker(d.interval)
+		defer t.Stop()
+		limit = t.C
+	}
+
+	var wg sync.WaitGroup
+	first := true
+	for _, r := range results {
+		if r.Action != actionDelete {
+			continue
+		}
+		if !first && limit != nil {
+			<-limit
+		}
+		first = false
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(r *versionResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.delete(ctx, r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (d *deleter) delete(ctx context.Context, r *versionResult) {
+	log.Printf("Deleting %s/%s", r.Service, r.Version)
+	op, err := d.api.DeleteVersion(ctx, d.project, r.Service, r.Version)
+	if err != nil {
+		log.Printf("Could not delete version %s/%s: %v", r.Service, r.Version, err)
+		r.Status, r.Error = "failed", err.Error()
+		return
+	}
+	if !d.wait {
+		r.Status = "pending"
+		return
+	}
+	if err := d.waitForCompletion(ctx, op.Name); err != nil {
+		log.Printf("FAILED %v/%v/%v: %v", d.project, r.Service, r.Version, err)
+		r.Status, r.Error = "failed", err.Error()
+		return
+	}
+	log.Printf("Deleted %v/%v/%v", d.project, r.Service, r.Version)
+	r.Status = "deleted"
+}
+
+func (d *deleter) waitForCompletion(ctx context.Context, opName string) error {
+	id := operationID(opName)
+	for {
+		op, err := d.api.GetOperation(ctx, d.project, id)
+		if err != nil {
+			return err
+		}
+		if op.Done {
+			if op.Error == nil {
+				return nil
+			}
+			return fmt.Errorf("%s (code %d)", op.Error.Message, op.Error.Code)
+		}
+		select {
+		case <-time.After(time.Duration((1 + rand.Float64()) * float64(d.pollInterval))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+// Actions taken on a version.
+const (
+	actionKeep   = "keep"
+	actionDelete = "delete"
+)
+
+// policy decides which versions of a service to keep.
+type policy struct {
+	// filter selects the versions that may be deleted, by ID.
+	filter *regexp.Regexp
+	// keepNewest is the number of newest matching versions to keep per
+	// service.
+	keepNewest int
+	// keepServing keeps versions with a traffic allocation.
+	keepServing bool
+	// minAge keeps versions created more recently.
+	minAge time.Duration
+	// excludeLabels keeps the versions of services with any of the labels.
+	// App Engine versions have no labels of their own. An empty value
+	// matches any value.
+	excludeLabels map[string]string
+}
+
+// versionResult is the decision for one version, and the outcome of
+// deleting it.
+type versionResult struct {
+	Service string    `json:"service"`
+	Version string    `json:"version"`
+	Created time.Time `json:"created"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+	// Status is the outcome of a deletion: "dry-run", "pending", "deleted"
+	// or "failed".
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// evaluate decides what to do with each version of svc whose ID matches the
+// filter. Results are ordered newest first.
+func (p policy) evaluate(svc *appengine.Service, versions []*appengine.Version, now time.Time) []*versionResult {
+	var results []*versionResult
+	for _, v := range versions {
+		if p.filter != nil && !p.filter.MatchString(v.Id) {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339, v.CreateTime)
+		results = append(results, &versionResult{Service: svc.Id, Version: v.Id, Created: created})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Created.After(results[j].Created)
+	})
+
+	var traffic map[string]float64
+	if svc.Split != nil {
+		traffic = svc.Split.Allocations
+	}
+	excluded := p.excludedBy(svc)
+	for i, r := range results {
+		r.Action = actionKeep
+		switch {
+		case excluded != "":
+			r.Reason = "service has label " + excluded
+		case p.keepServing && traffic[r.Version] > 0:
+			r.Reason = fmt.Sprintf("serving %g of traffic", traffic[r.Version])
+		case i < p.keepNewest:
+			r.Reason = fmt.Sprintf("one of the %d newest versions", p.keepNewest)
+		case p.minAge > 0 && r.Created.IsZero():
+			r.Reason = "unknown age"
+		case p.minAge > 0 && now.Sub(r.Created) < p.minAge:
+			r.Reason = fmt.Sprintf("younger than %v", p.minAge)
+		default:
+			r.Action = actionDelete
+		}
+	}
+	return results
+}
+
+// excludedBy returns the first excluded label svc has, as key=value, or "".
+func (p policy) excludedBy(svc *appengine.Service) string {
+	keys := make([]string, 0, len(p.excludeLabels))
+	for k := range p.excludeLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		got, ok := svc.Labels[k]
+		if ok && (p.excludeLabels[k] == "" || got == p.excludeLabels[k]) {
+			return k + "=" + got
+		}
+	}
+	return ""
+}
@@ -14,13 +14,17 @@
 
 // Package cloudrunci facilitates end-to-end testing against the production Cloud Run.
 //
-// This is a specialized tool that could be used in addition to unit tests. It
-// calls the `gcloud beta run` command directly.
+// This is a specialized tool that could be used in addition to unit tests. By
+// default it calls the `gcloud beta run` command directly.
 //
 // gcloud (https://cloud.google.com/sdk) must be installed. You must be authorized via
 // the gcloud command-line tool (`gcloud auth login`).
 //
 // You may specify the location of gcloud via the GCLOUD_BIN environment variable.
+//
+// Set the CLOUDRUNCI_DEPLOYER environment variable to "api" to use the Cloud Run
+// Admin API instead of gcloud, or to "fake" to run container images locally
+// with Docker. See Deployer.
 package cloudrunci
 
 import (
@@ -29,13 +33,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
 	"path"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/logging/logadmin"
-	"google.golang.org/api/iterator"
+	"cloud.google.com/go/logging"
 )
 
 // labels are used in operation-related logs.
@@ -77,6 +81,14 @@ type Service struct {
 	// Strictly HTTP/2 serving
 	HTTP2 bool
 
+	// Deployer performs the build and deploy operations. If nil, the Deployer
+	// selected by the CLOUDRUNCI_DEPLOYER environment variable is used.
+	Deployer Deployer
+
+	// LogSource is used by WaitForLogs and LogEntries. If nil, the Cloud
+	// Logging API is used.
+	LogSource LogSource
+
 	deployed bool     // Whether the service has been deployed.
 	built    bool     // Whether the container image has been built.
 	url      *url.URL // The url of the deployed service.
@@ -85,17 +97,41 @@ type Service struct {
 // runID is an identifier that changes between runs.
 var runID = time.Now().Format("20060102-150405")
 
+// platformEnvVar selects the default Platform of NewService. Set it to
+// "local" to run services with LocalPlatform.
+const platformEnvVar = "CLOUDRUNCI_PLATFORM"
+
 // NewService creates a new Service based on the name and projectID provided.
 // It will default to the ManagedPlatform in region us-central1,
 // and build a container image as needed  for deployment.
+// If the CLOUDRUNCI_PLATFORM environment variable is "local", it will
+// default to a LocalPlatform instead.
 func NewService(name, projectID string) *Service {
+	var p Platform = ManagedPlatform{Region: "us-central1"}
+	if os.Getenv(platformEnvVar) == "local" {
+		p = &LocalPlatform{}
+	}
 	return &Service{
 		Name:      name,
 		ProjectID: projectID,
-		Platform:  ManagedPlatform{Region: "us-central1"},
+		Platform:  p,
 	}
 }
 
+// deployer returns the Deployer used by the service. Platforms that deploy
+// services themselves, such as LocalPlatform, take precedence over the
+// default Deployer.
+func (s *Service) deployer() Deployer {
+	if s.Deployer == nil {
+		if d, ok := s.Platform.(Deployer); ok {
+			s.Deployer = d
+		} else {
+			s.Deployer = defaultDeployer()
+		}
+	}
+	return s.Deployer
+}
+
 // Deployed reports whether the service has been deployed.
 func (s *Service) Deployed() bool {
 	return s.deployed
@@ -187,6 +223,9 @@ func (s *Service) NewRequest(method, path string) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("service.URL: %w", err)
 	}
+	if r, ok := s.deployer().(requester); ok {
+		return r.NewRequest(method, url)
+	}
 	return s.Platform.NewRequest(method, url)
 }
 
@@ -210,6 +249,9 @@ func (s *Service) Host() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("service.ParsedURL: %w", err)
 	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
 	return u.Host + ":443", nil
 }
 
@@ -220,12 +262,11 @@ func (s *Service) ParsedURL() (*url.URL, error) {
 		return nil, errors.New("URL called before Deploy")
 	}
 	if s.url == nil {
-		out, err := gcloud(s.operationLabel(labelOperationGetURL), s.urlCmd())
+		sURL, err := s.deployer().ServiceURL(context.Background(), s)
 		if err != nil {
-			return nil, fmt.Errorf("gcloud: %s: %q", s.Name, err)
+			return nil, err
 		}
 
-		sURL := string(out)
 		u, err := url.Parse(sURL)
 		if err != nil {
 			return nil, fmt.Errorf("url.Parse: %w", err)
@@ -238,12 +279,16 @@ func (s *Service) ParsedURL() (*url.URL, error) {
 
 // validate confirms all required service properties are present.
 func (s *Service) validate() error {
-	if s.ProjectID == "" {
-		return errors.New("Project ID missing")
-	}
 	if s.Platform == nil {
+		if s.ProjectID == "" {
+			return errors.New("Project ID missing")
+		}
 		return errors.New("Platform configuration missing")
 	}
+	// Local services are not deployed to a project.
+	if _, local := s.Platform.(*LocalPlatform); s.ProjectID == "" && !local {
+		return errors.New("Project ID missing")
+	}
 	if err := s.Platform.Validate(); err != nil {
 		return err
 	}
@@ -276,11 +321,13 @@ func (s *Service) Deploy() error {
 		}
 	}
 
-	if _, err := gcloud(s.operationLabel(labelOperationDeploy), s.deployCmd()); err != nil {
-		return fmt.Errorf("gcloud: %s: %q", s.version(), err)
+	if err := s.deployer().DeployService(context.Background(), s); err != nil {
+		return err
 	}
 
 	s.deployed = true
+	// A redeployment may change the URL, e.g. on the LocalPlatform.
+	s.url = nil
 	return nil
 }
 
@@ -300,9 +347,8 @@ func (s *Service) Build() error {
 		s.Image = fmt.Sprintf("gcr.io/%s/%s:%s", s.ProjectID, s.Name, runID)
 	}
 
-	if out, err := gcloud(s.operationLabel(labelOperationBuild), s.buildCmd()); err != nil {
-		fmt.Printf(string(out))
-		return fmt.Errorf("gcloud: %s: %q", s.Image, err)
+	if err := s.deployer().BuildService(context.Background(), s); err != nil {
+		return err
 	}
 	s.built = true
 
@@ -318,16 +364,16 @@ func (s *Service) Clean() error {
 		return err
 	}
 
-	if _, err := gcloud(s.operationLabel(labelOperationDeleteService), s.deleteServiceCmd()); err != nil {
-		return fmt.Errorf("gcloud: %v: %q", s.version(), err)
+	if err := s.deployer().DeleteService(context.Background(), s); err != nil {
+		return err
 	}
 	s.deployed = false
+	s.url = nil
 
 	// If s.built is false no image was created or is not managed by cloudrun-ci.
 	if s.built {
-		_, err := gcloud(s.operationLabel("delete container image"), s.deleteImageCmd())
-		if err != nil {
-			return fmt.Errorf("gcloud: %v: %q", s.version(), err)
+		if err := s.deployer().DeleteServiceImage(context.Background(), s); err != nil {
+			return err
 		}
 		s.built = false
 	}
@@ -453,41 +499,35 @@ func (s *Service) urlCmd() *exec.Cmd {
 	return cmd
 }
 
+// logFilter returns a Cloud Logging filter for the service's logs, narrowed
+// by filter.
+func (s *Service) logFilter(filter string) string {
+	return strings.TrimSpace(fmt.Sprintf(`resource.type="cloud_run_revision" resource.labels.service_name="%s" %s`, s.version(), filter))
+}
+
+// WaitForLogs polls the service's logs, narrowed by filter, until at least
+// want entries match all matchers or ctx is done. It returns all matched
+// entries, including those found before ctx was done.
+func (s *Service) WaitForLogs(ctx context.Context, filter string, want int, matchers ...LogMatcher) ([]*logging.Entry, error) {
+	w, release, err := logWatcher(ctx, s.LogSource, s.ProjectID, s.logFilter(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return w.WaitFor(ctx, want, matchers...)
+}
+
+// LogEntries reports whether a log entry containing find is written within
+// about 3 minutes plus maxAttempts polls of 15 seconds.
+//
+// New tests should use WaitForLogs, which supports structured matchers and
+// context deadlines.
 func (s *Service) LogEntries(filter string, find string, maxAttempts int) (bool, error) {
 	ctx := context.Background()
-	client, err := logadmin.NewClient(ctx, s.ProjectID)
+	w, release, err := logWatcher(ctx, s.LogSource, s.ProjectID, s.logFilter(filter))
 	if err != nil {
-		return false, fmt.Errorf("logadmin.NewClient: %w", err)
-	}
-	defer client.Close()
-
-	preparedFilter := fmt.Sprintf(`resource.type="cloud_run_revision" resource.labels.service_name="%s" %s`, s.version(), filter)
-	fmt.Printf("Using log filter: %s\n", preparedFilter)
-
-	fmt.Println("Waiting for logs...")
-	time.Sleep(3 * time.Minute)
-
-	for i := 1; i < maxAttempts; i++ {
-		fmt.Printf("Attempt #%d\n", i)
-		it := client.Entries(ctx, logadmin.Filter(preparedFilter))
-		for {
-			entry, err := it.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return false, fmt.Errorf("it.Next: %w", err)
-			}
-			payload := fmt.Sprintf("%v", entry.Payload)
-			if len(payload) > 0 {
-				fmt.Printf("entry.Payload: %v\n", entry.Payload)
-			}
-			if strings.Contains(payload, find) {
-				fmt.Printf("%q log entry found.\n", find)
-				return true, nil
-			}
-		}
-		time.Sleep(15 * time.Second)
+		return false, err
 	}
-	return false, nil
+	defer release()
+	return legacyLogEntries(ctx, w, find, maxAttempts, 3*time.Minute, 15*time.Second)
 }
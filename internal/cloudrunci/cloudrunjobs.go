@@ -25,22 +25,24 @@ package cloudrunci
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/logging/logadmin"
-	"google.golang.org/api/iterator"
+	"cloud.google.com/go/logging"
 )
 
 // Job describes a Cloud Run Job
 // The typical usage flow of a Job is to call the following methods, which
 // call the corresponding "gcloud run jobs" commands:
 // Build(), Create(), Run().
-// Note: The LogEntries() method cannot differentiate between executions at this
-// time, so it is not recommended to call Run() multiple times on a single Job
+// Note: The LogEntries() method cannot differentiate between executions, so
+// use Execution.WaitForLogs when calling Run() multiple times on a single Job
 // object.
 type Job struct {
 	// Name is an ID, used for logging and to generate a unique version to this run.
@@ -70,6 +72,14 @@ type Job struct {
 	// Build this Image as a BuildPack, without using a Dockerfile
 	AsBuildpack bool
 
+	// Deployer performs the build and run operations. If nil, the Deployer
+	// selected by the CLOUDRUNCI_DEPLOYER environment variable is used.
+	Deployer Deployer
+
+	// LogSource is used by WaitForLogs and LogEntries. If nil, the Cloud
+	// Logging API is used.
+	LogSource LogSource
+
 	built   bool // True if container image has been built.
 	created bool // True if job has been created.
 	started bool // true if the Job has been started.
@@ -94,6 +104,14 @@ func (j *Job) CommonGCloudFlags() []string {
 
 }
 
+// deployer returns the Deployer used by the job.
+func (j *Job) deployer() Deployer {
+	if j.Deployer == nil {
+		j.Deployer = defaultDeployer()
+	}
+	return j.Deployer
+}
+
 // validate confirms all required job properties are present.
 func (j *Job) validate() error {
 	if j.ProjectID == "" {
@@ -130,8 +148,8 @@ func (j *Job) Create() error {
 		}
 	}
 
-	if _, err := gcloud(fmt.Sprintf("%s: Creating Cloud Run Job", j.version()), j.createCmd()); err != nil {
-		return fmt.Errorf("gcloud: %s: %q", j.version(), err)
+	if err := j.deployer().CreateJob(context.Background(), j); err != nil {
+		return err
 	}
 
 	j.created = true
@@ -152,30 +170,48 @@ func (j *Job) Build() error {
 		j.Image = fmt.Sprintf("gcr.io/%s/%s:%s", j.ProjectID, j.Name, runID)
 	}
 
-	if _, err := gcloud(fmt.Sprintf("%s: Building image %s", j.version(), j.Image), j.buildCmd()); err != nil {
-		return fmt.Errorf("gcloud: %s: %q", j.Image, err)
+	if err := j.deployer().BuildJob(context.Background(), j); err != nil {
+		return err
 	}
 	j.built = true
 
 	return nil
 }
 
-// Run starts the Job in Cloud Run Jobs.
-// This method will call Build and Create if necessary.
-func (j *Job) Run() error {
+// Run executes the Job in Cloud Run Jobs and waits for the execution to
+// complete. This method will call Build and Create if necessary.
+// The execution is returned even if a task failed.
+func (j *Job) Run() (*Execution, error) {
+	ctx := context.Background()
+	e, err := j.Start(ctx, ExecutionOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return e, e.Wait(ctx)
+}
+
+// Start starts an execution of the Job with opts applied, without waiting
+// for it to complete. This method will call Build and Create if necessary.
+func (j *Job) Start(ctx context.Context, opts ExecutionOptions) (*Execution, error) {
 	if err := j.validate(); err != nil {
-		return err
+		return nil, err
+	}
+	if err := opts.Env.Validate(); err != nil {
+		return nil, err
 	}
 	// Create() checks that the image was built
 	if !j.created {
 		if err := j.Create(); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	if _, err := gcloud(fmt.Sprintf("%s: Running cloud run job", j.version()), j.runCmd()); err != nil {
-		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
+	e, err := j.deployer().RunJob(ctx, j, opts)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	e.Job = j
+	j.started = true
+	return e, nil
 }
 
 // Clean deletes the created Cloud Run service.
@@ -187,16 +223,15 @@ func (j *Job) Clean() error {
 		return err
 	}
 
-	if _, err := gcloud(fmt.Sprintf("%s: Deleting cloud run job", j.version()), j.deleteJobCmd()); err != nil {
-		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
+	if err := j.deployer().DeleteJob(context.Background(), j); err != nil {
+		return err
 	}
 	j.created = false
 
 	// If built is false, no image was created or is not managed by cloudrun-ci.
 	if j.built {
-		_, err := gcloud(fmt.Sprintf("%s: Deleting Image %s", j.version(), j.Image), j.deleteImageCmd())
-		if err != nil {
-			return fmt.Errorf("gcloud: %v: %q", j.version(), err)
+		if err := j.deployer().DeleteJobImage(context.Background(), j); err != nil {
+			return err
 		}
 		j.built = false
 	}
@@ -248,8 +283,9 @@ func (j *Job) buildCmd() *exec.Cmd {
 	return cmd
 }
 
-// runCmd returns the gcloud command needed to start this RunJob
-func (j *Job) runCmd() *exec.Cmd {
+// runCmd returns the gcloud command needed to start an execution of this
+// Job. The command prints the execution name.
+func (j *Job) runCmd(opts ExecutionOptions) *exec.Cmd {
 	args := append([]string{
 		"--quiet",
 		"alpha",
@@ -257,7 +293,53 @@ func (j *Job) runCmd() *exec.Cmd {
 		"jobs",
 		"execute",
 		j.version(),
-		"--wait", // Waits for job to complete before returning.
+		"--async",
+		"--format=value(metadata.name)",
+	}, j.CommonGCloudFlags()...)
+
+	if opts.TaskCount > 0 {
+		args = append(args, "--tasks", strconv.Itoa(opts.TaskCount))
+	}
+	if len(opts.Env) > 0 {
+		args = append(args, "--update-env-vars", opts.Env.String())
+	}
+
+	cmd := exec.Command(gcloudBin, args...)
+	cmd.Dir = j.Dir
+	return cmd
+}
+
+// updateParallelismCmd returns the gcloud command to change the Job's
+// parallelism.
+func (j *Job) updateParallelismCmd(parallelism int) *exec.Cmd {
+	args := append([]string{
+		"--quiet",
+		"alpha",
+		"run",
+		"jobs",
+		"update",
+		j.version(),
+		"--parallelism",
+		strconv.Itoa(parallelism),
+	}, j.CommonGCloudFlags()...)
+
+	cmd := exec.Command(gcloudBin, args...)
+	cmd.Dir = j.Dir
+	return cmd
+}
+
+// describeExecutionCmd returns the gcloud command to describe an execution
+// as JSON.
+func (j *Job) describeExecutionCmd(name string) *exec.Cmd {
+	args := append([]string{
+		"--quiet",
+		"alpha",
+		"run",
+		"jobs",
+		"executions",
+		"describe",
+		name,
+		"--format=json",
 	}, j.CommonGCloudFlags()...)
 
 	cmd := exec.Command(gcloudBin, args...)
@@ -265,6 +347,103 @@ func (j *Job) runCmd() *exec.Cmd {
 	return cmd
 }
 
+// listTasksCmd returns the gcloud command to list the tasks of an execution
+// as JSON.
+func (j *Job) listTasksCmd(name string) *exec.Cmd {
+	args := append([]string{
+		"--quiet",
+		"alpha",
+		"run",
+		"jobs",
+		"executions",
+		"tasks",
+		"list",
+		"--execution",
+		name,
+		"--format=json",
+	}, j.CommonGCloudFlags()...)
+
+	cmd := exec.Command(gcloudBin, args...)
+	cmd.Dir = j.Dir
+	return cmd
+}
+
+// gcloudCondition is a condition of a Cloud Run Admin API v1 resource.
+type gcloudCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// completed returns the status of the "Completed" condition.
+func completed(conditions []gcloudCondition) string {
+	for _, c := range conditions {
+		if c.Type == "Completed" {
+			return c.Status
+		}
+	}
+	return ""
+}
+
+// parseGCloudExecution converts the JSON output of describeExecutionCmd and
+// listTasksCmd, which use the Cloud Run Admin API v1 format, to an Execution.
+func parseGCloudExecution(execJSON, tasksJSON []byte) (*Execution, error) {
+	var ge struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			TaskCount   int `json:"taskCount"`
+			Parallelism int `json:"parallelism"`
+		} `json:"spec"`
+		Status struct {
+			StartTime      time.Time `json:"startTime"`
+			CompletionTime time.Time `json:"completionTime"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(execJSON, &ge); err != nil {
+		return nil, fmt.Errorf("execution: %w", err)
+	}
+	var gts []struct {
+		Status struct {
+			Index             int               `json:"index"`
+			Retried           int               `json:"retried"`
+			StartTime         time.Time         `json:"startTime"`
+			CompletionTime    time.Time         `json:"completionTime"`
+			Conditions        []gcloudCondition `json:"conditions"`
+			LastAttemptResult struct {
+				ExitCode int `json:"exitCode"`
+			} `json:"lastAttemptResult"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(tasksJSON, &gts); err != nil {
+		return nil, fmt.Errorf("tasks: %w", err)
+	}
+
+	e := &Execution{
+		Name:           ge.Metadata.Name,
+		TaskCount:      ge.Spec.TaskCount,
+		Parallelism:    ge.Spec.Parallelism,
+		StartTime:      ge.Status.StartTime,
+		CompletionTime: ge.Status.CompletionTime,
+	}
+	for _, gt := range gts {
+		st := gt.Status
+		t := TaskResult{
+			Index:          st.Index,
+			Status:         taskStatus(completed(st.Conditions), !st.StartTime.IsZero()),
+			ExitCode:       st.LastAttemptResult.ExitCode,
+			StartTime:      st.StartTime,
+			CompletionTime: st.CompletionTime,
+		}
+		if !st.StartTime.IsZero() {
+			t.Attempts = st.Retried + 1
+		}
+		e.Tasks = append(e.Tasks, t)
+	}
+	sort.Slice(e.Tasks, func(a, b int) bool { return e.Tasks[a].Index < e.Tasks[b].Index })
+	return e, nil
+}
+
 func (j *Job) deleteImageCmd() *exec.Cmd {
 	args := []string{
 		"--quiet",
@@ -295,38 +474,35 @@ func (j *Job) deleteJobCmd() *exec.Cmd {
 	return cmd
 }
 
+// logFilter returns a Cloud Logging filter for the job's logs, narrowed by
+// filter.
+func (j *Job) logFilter(filter string) string {
+	return strings.TrimSpace(fmt.Sprintf(`resource.type="cloud_run_job" resource.labels.job_name="%s" %s`, j.version(), filter))
+}
+
+// WaitForLogs polls the job's logs, narrowed by filter, until at least want
+// entries match all matchers or ctx is done. It returns all matched entries,
+// including those found before ctx was done.
+func (j *Job) WaitForLogs(ctx context.Context, filter string, want int, matchers ...LogMatcher) ([]*logging.Entry, error) {
+	w, release, err := logWatcher(ctx, j.LogSource, j.ProjectID, j.logFilter(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return w.WaitFor(ctx, want, matchers...)
+}
+
+// LogEntries reports whether a log entry containing find is written within
+// maxAttempts polls of 30 seconds.
+//
+// New tests should use WaitForLogs, which supports structured matchers and
+// context deadlines.
 func (j *Job) LogEntries(filter string, find string, maxAttempts int) (bool, error) {
 	ctx := context.Background()
-	client, err := logadmin.NewClient(ctx, j.ProjectID)
+	w, release, err := logWatcher(ctx, j.LogSource, j.ProjectID, j.logFilter(filter))
 	if err != nil {
-		return false, fmt.Errorf("logadmin.NewClient: %w", err)
-	}
-	defer client.Close()
-
-	preparedFilter := fmt.Sprintf(`resource.type="cloud_run_job" resource.labels.job_name="%s" %s`, j.version(), filter)
-	fmt.Printf("Using log filter: %s\n", preparedFilter)
-
-	for i := 1; i < maxAttempts; i++ {
-		fmt.Printf("Attempt #%d\n", i)
-		it := client.Entries(ctx, logadmin.Filter(preparedFilter))
-		for {
-			entry, err := it.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return false, fmt.Errorf("it.Next: %w", err)
-			}
-			payload := fmt.Sprintf("%v", entry.Payload)
-			if len(payload) > 0 {
-				fmt.Printf("entry.Payload: %v\n", entry.Payload)
-			}
-			if strings.Contains(payload, find) {
-				fmt.Printf("%q log entry found.\n", find)
-				return true, nil
-			}
-		}
-		time.Sleep(30 * time.Second)
+		return false, err
 	}
-	return false, nil
+	defer release()
+	return legacyLogEntries(ctx, w, find, maxAttempts, 0, 30*time.Second)
 }
@@ -0,0 +1,206 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Deployer performs the build, deploy and cleanup operations for Services
+// and Jobs.
+//
+// GCloudDeployer shells out to gcloud, APIDeployer calls the Cloud Run,
+// Cloud Build and Artifact Registry APIs directly, and FakeDeployer runs
+// container images locally with Docker.
+type Deployer interface {
+	// BuildService builds and pushes s.Image from s.Dir.
+	BuildService(ctx context.Context, s *Service) error
+	// DeployService creates or updates the service s.version() running s.Image.
+	DeployService(ctx context.Context, s *Service) error
+	// ServiceURL returns the base URL of the deployed service.
+	ServiceURL(ctx context.Context, s *Service) (string, error)
+	// DeleteService deletes the deployed service.
+	DeleteService(ctx context.Context, s *Service) error
+	// DeleteServiceImage deletes s.Image.
+	DeleteServiceImage(ctx context.Context, s *Service) error
+
+	// BuildJob builds and pushes j.Image from j.Dir.
+	BuildJob(ctx context.Context, j *Job) error
+	// CreateJob creates the job j.version() running j.Image.
+	CreateJob(ctx context.Context, j *Job) error
+	// RunJob starts an execution of the job with opts applied, and returns
+	// without waiting for it to finish.
+	RunJob(ctx context.Context, j *Job, opts ExecutionOptions) (*Execution, error)
+	// GetExecution returns the current state of the named execution of the
+	// job.
+	GetExecution(ctx context.Context, j *Job, name string) (*Execution, error)
+	// DeleteJob deletes the job.
+	DeleteJob(ctx context.Context, j *Job) error
+	// DeleteJobImage deletes j.Image.
+	DeleteJobImage(ctx context.Context, j *Job) error
+}
+
+// requester is implemented by Deployers whose services need requests built
+// differently from the Service's Platform, e.g. without an ID token.
+type requester interface {
+	NewRequest(method, url string) (*http.Request, error)
+}
+
+// deployerEnvVar selects the Deployer used when a Service or Job does not set
+// one: "gcloud" (the default), "api" or "fake".
+const deployerEnvVar = "CLOUDRUNCI_DEPLOYER"
+
+var (
+	defaultDeployerOnce sync.Once
+	defaultDeployerVal  Deployer
+)
+
+// defaultDeployer returns the Deployer selected by the CLOUDRUNCI_DEPLOYER
+// environment variable. The same Deployer is shared by all Services and Jobs.
+func defaultDeployer() Deployer {
+	defaultDeployerOnce.Do(func() {
+		switch v := os.Getenv(deployerEnvVar); v {
+		case "api":
+			defaultDeployerVal = &APIDeployer{}
+		case "fake":
+			defaultDeployerVal = &FakeDeployer{}
+		case "", "gcloud":
+			defaultDeployerVal = GCloudDeployer{}
+		default:
+			log.Printf("%s: unknown deployer %q, using gcloud", deployerEnvVar, v)
+			defaultDeployerVal = GCloudDeployer{}
+		}
+	})
+	return defaultDeployerVal
+}
+
+// GCloudDeployer is a Deployer that runs gcloud commands.
+//
+// gcloud (https://cloud.google.com/sdk) must be installed and authorized.
+// You may specify the location of gcloud via the GCLOUD_BIN environment variable.
+type GCloudDeployer struct{}
+
+// BuildService runs `gcloud builds submit` for the service.
+func (GCloudDeployer) BuildService(ctx context.Context, s *Service) error {
+	if out, err := gcloudContext(ctx, s.operationLabel(labelOperationBuild), s.buildCmd()); err != nil {
+		fmt.Print(string(out))
+		return fmt.Errorf("gcloud: %s: %q", s.Image, err)
+	}
+	return nil
+}
+
+// DeployService runs `gcloud run deploy` for the service.
+func (GCloudDeployer) DeployService(ctx context.Context, s *Service) error {
+	if _, err := gcloudContext(ctx, s.operationLabel(labelOperationDeploy), s.deployCmd()); err != nil {
+		return fmt.Errorf("gcloud: %s: %q", s.version(), err)
+	}
+	return nil
+}
+
+// ServiceURL runs `gcloud run services describe` for the service.
+func (GCloudDeployer) ServiceURL(ctx context.Context, s *Service) (string, error) {
+	out, err := gcloudContext(ctx, s.operationLabel(labelOperationGetURL), s.urlCmd())
+	if err != nil {
+		return "", fmt.Errorf("gcloud: %s: %q", s.Name, err)
+	}
+	return string(out), nil
+}
+
+// DeleteService runs `gcloud run services delete` for the service.
+func (GCloudDeployer) DeleteService(ctx context.Context, s *Service) error {
+	if _, err := gcloudContext(ctx, s.operationLabel(labelOperationDeleteService), s.deleteServiceCmd()); err != nil {
+		return fmt.Errorf("gcloud: %v: %q", s.version(), err)
+	}
+	return nil
+}
+
+// DeleteServiceImage runs `gcloud container images delete` for the service image.
+func (GCloudDeployer) DeleteServiceImage(ctx context.Context, s *Service) error {
+	if _, err := gcloudContext(ctx, s.operationLabel(labelOperationDeleteImage), s.deleteImageCmd()); err != nil {
+		return fmt.Errorf("gcloud: %v: %q", s.version(), err)
+	}
+	return nil
+}
+
+// BuildJob runs `gcloud builds submit` for the job.
+func (GCloudDeployer) BuildJob(ctx context.Context, j *Job) error {
+	if _, err := gcloudContext(ctx, fmt.Sprintf("%s: Building image %s", j.version(), j.Image), j.buildCmd()); err != nil {
+		return fmt.Errorf("gcloud: %s: %q", j.Image, err)
+	}
+	return nil
+}
+
+// CreateJob runs `gcloud run jobs create` for the job.
+func (GCloudDeployer) CreateJob(ctx context.Context, j *Job) error {
+	if _, err := gcloudContext(ctx, fmt.Sprintf("%s: Creating Cloud Run Job", j.version()), j.createCmd()); err != nil {
+		return fmt.Errorf("gcloud: %s: %q", j.version(), err)
+	}
+	return nil
+}
+
+// RunJob runs `gcloud run jobs execute --async` for the job. If
+// opts.Parallelism is set, the job is updated first with `gcloud run jobs
+// update`.
+func (GCloudDeployer) RunJob(ctx context.Context, j *Job, opts ExecutionOptions) (*Execution, error) {
+	if opts.Parallelism > 0 {
+		if _, err := gcloudContext(ctx, fmt.Sprintf("%s: Updating cloud run job", j.version()), j.updateParallelismCmd(opts.Parallelism)); err != nil {
+			return nil, fmt.Errorf("gcloud: %v: %q", j.version(), err)
+		}
+	}
+	out, err := gcloudContext(ctx, fmt.Sprintf("%s: Running cloud run job", j.version()), j.runCmd(opts))
+	if err != nil {
+		return nil, fmt.Errorf("gcloud: %v: %q", j.version(), err)
+	}
+	name := string(out)
+	if name == "" {
+		return nil, fmt.Errorf("gcloud: %v: no execution name in output", j.version())
+	}
+	return &Execution{Name: name, Job: j, TaskCount: opts.TaskCount, Parallelism: opts.Parallelism}, nil
+}
+
+// GetExecution runs `gcloud run jobs executions describe` and `gcloud run
+// jobs executions tasks list` for the execution.
+func (GCloudDeployer) GetExecution(ctx context.Context, j *Job, name string) (*Execution, error) {
+	out, err := gcloudContext(ctx, fmt.Sprintf("%s: Describing execution %s", j.version(), name), j.describeExecutionCmd(name))
+	if err != nil {
+		return nil, fmt.Errorf("gcloud: %v: %q", name, err)
+	}
+	tasks, err := gcloudContext(ctx, fmt.Sprintf("%s: Listing tasks of execution %s", j.version(), name), j.listTasksCmd(name))
+	if err != nil {
+		return nil, fmt.Errorf("gcloud: %v: %q", name, err)
+	}
+	return parseGCloudExecution(out, tasks)
+}
+
+// DeleteJob runs `gcloud run jobs delete` for the job.
+func (GCloudDeployer) DeleteJob(ctx context.Context, j *Job) error {
+	if _, err := gcloudContext(ctx, fmt.Sprintf("%s: Deleting cloud run job", j.version()), j.deleteJobCmd()); err != nil {
+		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
+	}
+	return nil
+}
+
+// DeleteJobImage runs `gcloud container images delete` for the job image.
+func (GCloudDeployer) DeleteJobImage(ctx context.Context, j *Job) error {
+	if _, err := gcloudContext(ctx, fmt.Sprintf("%s: Deleting Image %s", j.version(), j.Image), j.deleteImageCmd()); err != nil {
+		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
+	}
+	return nil
+}
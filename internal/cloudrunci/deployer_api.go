@@ -0,0 +1,669 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	artifactregistry "cloud.google.com/go/artifactregistry/apiv1"
+	"cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
+	cloudbuild "cloud.google.com/go/cloudbuild/apiv1/v2"
+	"cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	run "cloud.google.com/go/run/apiv2"
+	"cloud.google.com/go/run/apiv2/runpb"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// APIDeployer is a Deployer that calls the Cloud Run Admin API (v2), Cloud
+// Build and Artifact Registry directly, so gcloud does not need to be
+// installed. Credentials are found with Application Default Credentials.
+//
+// Only the ManagedPlatform is supported for services. Job.ExtraCreateFlags
+// are gcloud flags and are not supported.
+type APIDeployer struct {
+	// ClientOptions are passed to every API client.
+	ClientOptions []option.ClientOption
+
+	initOnce  sync.Once
+	initErr   error
+	services  *run.ServicesClient
+	jobs      *run.JobsClient
+	execs     *run.ExecutionsClient
+	tasks     *run.TasksClient
+	builds    *cloudbuild.Client
+	artifacts *artifactregistry.Client
+	storage   *storage.Client
+}
+
+// init creates the API clients on first use.
+func (d *APIDeployer) init(ctx context.Context) error {
+	d.initOnce.Do(func() {
+		// Clients outlive the context of the operation that created them.
+		ctx := context.Background()
+		var err error
+		if d.services, err = run.NewServicesClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("run.NewServicesClient: %w", err)
+			return
+		}
+		if d.jobs, err = run.NewJobsClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("run.NewJobsClient: %w", err)
+			return
+		}
+		if d.execs, err = run.NewExecutionsClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("run.NewExecutionsClient: %w", err)
+			return
+		}
+		if d.tasks, err = run.NewTasksClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("run.NewTasksClient: %w", err)
+			return
+		}
+		if d.builds, err = cloudbuild.NewClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("cloudbuild.NewClient: %w", err)
+			return
+		}
+		if d.artifacts, err = artifactregistry.NewClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("artifactregistry.NewClient: %w", err)
+			return
+		}
+		if d.storage, err = storage.NewClient(ctx, d.ClientOptions...); err != nil {
+			d.initErr = fmt.Errorf("storage.NewClient: %w", err)
+			return
+		}
+	})
+	return d.initErr
+}
+
+// Close closes the API clients. The first error encountered is returned.
+func (d *APIDeployer) Close() error {
+	var closers []io.Closer
+	if d.services != nil {
+		closers = append(closers, d.services)
+	}
+	if d.jobs != nil {
+		closers = append(closers, d.jobs)
+	}
+	if d.execs != nil {
+		closers = append(closers, d.execs)
+	}
+	if d.tasks != nil {
+		closers = append(closers, d.tasks)
+	}
+	if d.builds != nil {
+		closers = append(closers, d.builds)
+	}
+	if d.artifacts != nil {
+		closers = append(closers, d.artifacts)
+	}
+	if d.storage != nil {
+		closers = append(closers, d.storage)
+	}
+	var firstErr error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// serviceName returns the full resource name of the service.
+func serviceName(s *Service) (string, error) {
+	p, ok := s.Platform.(ManagedPlatform)
+	if !ok {
+		return "", fmt.Errorf("APIDeployer: unsupported platform %q", s.Platform.Name())
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/services/%s", s.ProjectID, p.Region, s.version()), nil
+}
+
+// jobName returns the full resource name of the job.
+func jobName(j *Job) string {
+	return fmt.Sprintf("projects/%s/locations/%s/jobs/%s", j.ProjectID, j.Region, j.version())
+}
+
+// runEnv converts environment variables to the Cloud Run API representation.
+func runEnv(env EnvVars) []*runpb.EnvVar {
+	var vars []*runpb.EnvVar
+	for _, k := range strings.Split(env.KeyString(), ",") {
+		if k == "" {
+			continue
+		}
+		vars = append(vars, &runpb.EnvVar{
+			Name:   strings.TrimSpace(k),
+			Values: &runpb.EnvVar_Value{Value: strings.TrimSpace(env[k])},
+		})
+	}
+	return vars
+}
+
+// BuildService builds s.Image with Cloud Build.
+func (d *APIDeployer) BuildService(ctx context.Context, s *Service) error {
+	return d.build(ctx, s.ProjectID, s.Dir, s.Image, s.version(), s.AsBuildpack)
+}
+
+// DeployService creates the service, or updates it if it already exists.
+func (d *APIDeployer) DeployService(ctx context.Context, s *Service) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	name, err := serviceName(s)
+	if err != nil {
+		return err
+	}
+	container := &runpb.Container{
+		Image: s.Image,
+		Env:   runEnv(s.Env),
+	}
+	if s.HTTP2 {
+		container.Ports = []*runpb.ContainerPort{{Name: "h2c", ContainerPort: 8080}}
+	}
+
+	log.Printf("Running: %s...", s.operationLabel(labelOperationDeploy))
+	op, err := d.services.UpdateService(ctx, &runpb.UpdateServiceRequest{
+		Service: &runpb.Service{
+			Name: name,
+			Template: &runpb.RevisionTemplate{
+				Containers: []*runpb.Container{container},
+			},
+		},
+		AllowMissing: true,
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateService: %s: %w", name, err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("UpdateService: %s: %w", name, err)
+	}
+
+	if s.AllowUnauthenticated {
+		if err := d.allowUnauthenticated(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowUnauthenticated grants roles/run.invoker to allUsers on the service,
+// keeping its existing bindings. The policy's etag makes SetIamPolicy fail
+// with Aborted if the policy changed since it was read, in which case it is
+// read again.
+func (d *APIDeployer) allowUnauthenticated(ctx context.Context, name string) error {
+	for attempt := 1; ; attempt++ {
+		policy, err := d.services.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{Resource: name})
+		if err != nil {
+			return fmt.Errorf("GetIamPolicy: %s: %w", name, err)
+		}
+		if !addBinding(policy, "roles/run.invoker", "allUsers") {
+			return nil
+		}
+		_, err = d.services.SetIamPolicy(ctx, &iampb.SetIamPolicyRequest{Resource: name, Policy: policy})
+		if status.Code(err) == codes.Aborted && attempt < 5 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("SetIamPolicy: %s: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// addBinding adds member to the binding for role in policy. It reports
+// whether the policy changed.
+func addBinding(policy *iampb.Policy, role, member string) bool {
+	for _, b := range policy.Bindings {
+		if b.Role != role || b.Condition != nil {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				return false
+			}
+		}
+		b.Members = append(b.Members, member)
+		return true
+	}
+	policy.Bindings = append(policy.Bindings, &iampb.Binding{Role: role, Members: []string{member}})
+	return true
+}
+
+// ServiceURL returns the URI of the deployed service.
+func (d *APIDeployer) ServiceURL(ctx context.Context, s *Service) (string, error) {
+	if err := d.init(ctx); err != nil {
+		return "", err
+	}
+	name, err := serviceName(s)
+	if err != nil {
+		return "", err
+	}
+	svc, err := d.services.GetService(ctx, &runpb.GetServiceRequest{Name: name})
+	if err != nil {
+		return "", fmt.Errorf("GetService: %s: %w", name, err)
+	}
+	if svc.GetUri() == "" {
+		return "", fmt.Errorf("GetService: %s: no URI", name)
+	}
+	return svc.GetUri(), nil
+}
+
+// DeleteService deletes the service.
+func (d *APIDeployer) DeleteService(ctx context.Context, s *Service) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	name, err := serviceName(s)
+	if err != nil {
+		return err
+	}
+	log.Printf("Running: %s...", s.operationLabel(labelOperationDeleteService))
+	op, err := d.services.DeleteService(ctx, &runpb.DeleteServiceRequest{Name: name})
+	if err != nil {
+		return fmt.Errorf("DeleteService: %s: %w", name, err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("DeleteService: %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteServiceImage deletes s.Image from Artifact Registry.
+func (d *APIDeployer) DeleteServiceImage(ctx context.Context, s *Service) error {
+	return d.deleteImage(ctx, s.Image)
+}
+
+// BuildJob builds j.Image with Cloud Build.
+func (d *APIDeployer) BuildJob(ctx context.Context, j *Job) error {
+	return d.build(ctx, j.ProjectID, j.Dir, j.Image, j.version(), j.AsBuildpack)
+}
+
+// CreateJob creates the job.
+func (d *APIDeployer) CreateJob(ctx context.Context, j *Job) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	if len(j.ExtraCreateFlags) > 0 {
+		return fmt.Errorf("APIDeployer: ExtraCreateFlags are not supported: %v", j.ExtraCreateFlags)
+	}
+	log.Printf("Running: %s: Creating Cloud Run Job...", j.version())
+	op, err := d.jobs.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/%s", j.ProjectID, j.Region),
+		JobId:  j.version(),
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Template: &runpb.TaskTemplate{
+					Containers: []*runpb.Container{{
+						Image: j.Image,
+						Env:   runEnv(j.Env),
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("CreateJob: %s: %w", j.version(), err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("CreateJob: %s: %w", j.version(), err)
+	}
+	return nil
+}
+
+// RunJob starts an execution of the job. The Run Admin API used here cannot
+// override an execution, so opts are applied by updating the job first.
+func (d *APIDeployer) RunJob(ctx context.Context, j *Job, opts ExecutionOptions) (*Execution, error) {
+	if err := d.init(ctx); err != nil {
+		return nil, err
+	}
+	if opts.TaskCount > 0 || opts.Parallelism > 0 || len(opts.Env) > 0 {
+		if err := d.updateJob(ctx, j, opts); err != nil {
+			return nil, fmt.Errorf("UpdateJob: %s: %w", j.version(), err)
+		}
+	}
+	log.Printf("Running: %s: Running cloud run job...", j.version())
+	op, err := d.jobs.RunJob(ctx, &runpb.RunJobRequest{Name: jobName(j)})
+	if err != nil {
+		return nil, fmt.Errorf("RunJob: %s: %w", j.version(), err)
+	}
+	md, err := op.Metadata()
+	if err != nil || md == nil {
+		return nil, fmt.Errorf("RunJob: %s: no execution in operation metadata: %v", j.version(), err)
+	}
+	return apiExecution(md, nil), nil
+}
+
+// updateJob applies opts to the job's execution template.
+func (d *APIDeployer) updateJob(ctx context.Context, j *Job, opts ExecutionOptions) error {
+	job, err := d.jobs.GetJob(ctx, &runpb.GetJobRequest{Name: jobName(j)})
+	if err != nil {
+		return err
+	}
+	t := job.GetTemplate()
+	if opts.TaskCount > 0 {
+		t.TaskCount = int32(opts.TaskCount)
+	}
+	if opts.Parallelism > 0 {
+		t.Parallelism = int32(opts.Parallelism)
+	}
+	if len(opts.Env) > 0 {
+		for _, c := range t.GetTemplate().GetContainers() {
+			c.Env = runEnv(opts.env(j))
+		}
+	}
+	op, err := d.jobs.UpdateJob(ctx, &runpb.UpdateJobRequest{Job: job})
+	if err != nil {
+		return err
+	}
+	_, err = op.Wait(ctx)
+	return err
+}
+
+// GetExecution gets the execution and lists its tasks.
+func (d *APIDeployer) GetExecution(ctx context.Context, j *Job, name string) (*Execution, error) {
+	if err := d.init(ctx); err != nil {
+		return nil, err
+	}
+	full := jobName(j) + "/executions/" + name
+	exec, err := d.execs.GetExecution(ctx, &runpb.GetExecutionRequest{Name: full})
+	if err != nil {
+		return nil, fmt.Errorf("GetExecution: %s: %w", name, err)
+	}
+	var tasks []*runpb.Task
+	it := d.tasks.ListTasks(ctx, &runpb.ListTasksRequest{Parent: full})
+	for {
+		t, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListTasks: %s: %w", name, err)
+		}
+		tasks = append(tasks, t)
+	}
+	return apiExecution(exec, tasks), nil
+}
+
+// apiExecution converts an execution and its tasks from the Run Admin API.
+func apiExecution(exec *runpb.Execution, tasks []*runpb.Task) *Execution {
+	e := &Execution{
+		Name:        path.Base(exec.GetName()),
+		TaskCount:   int(exec.GetTaskCount()),
+		Parallelism: int(exec.GetParallelism()),
+	}
+	if exec.GetStartTime() != nil {
+		e.StartTime = exec.GetStartTime().AsTime()
+	}
+	if exec.GetCompletionTime() != nil {
+		e.CompletionTime = exec.GetCompletionTime().AsTime()
+	}
+	for _, t := range tasks {
+		r := TaskResult{
+			Index:    int(t.GetIndex()),
+			ExitCode: int(t.GetLastAttemptResult().GetExitCode()),
+		}
+		if t.GetStartTime() != nil {
+			r.StartTime = t.GetStartTime().AsTime()
+			r.Attempts = int(t.GetRetried()) + 1
+		}
+		if t.GetCompletionTime() != nil {
+			r.CompletionTime = t.GetCompletionTime().AsTime()
+		}
+		var status string
+		for _, c := range t.GetConditions() {
+			if c.GetType() != "Completed" {
+				continue
+			}
+			switch c.GetState() {
+			case runpb.Condition_CONDITION_SUCCEEDED:
+				status = "True"
+			case runpb.Condition_CONDITION_FAILED:
+				status = "False"
+			}
+		}
+		r.Status = taskStatus(status, !r.StartTime.IsZero())
+		e.Tasks = append(e.Tasks, r)
+	}
+	sort.Slice(e.Tasks, func(a, b int) bool { return e.Tasks[a].Index < e.Tasks[b].Index })
+	return e
+}
+
+// DeleteJob deletes the job.
+func (d *APIDeployer) DeleteJob(ctx context.Context, j *Job) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	log.Printf("Running: %s: Deleting cloud run job...", j.version())
+	op, err := d.jobs.DeleteJob(ctx, &runpb.DeleteJobRequest{Name: jobName(j)})
+	if err != nil {
+		return fmt.Errorf("DeleteJob: %s: %w", j.version(), err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("DeleteJob: %s: %w", j.version(), err)
+	}
+	return nil
+}
+
+// DeleteJobImage deletes j.Image from Artifact Registry.
+func (d *APIDeployer) DeleteJobImage(ctx context.Context, j *Job) error {
+	return d.deleteImage(ctx, j.Image)
+}
+
+// build uploads dir to the project's Cloud Build bucket and builds image from
+// it, either with its Dockerfile or with Google Cloud buildpacks.
+func (d *APIDeployer) build(ctx context.Context, projectID, dir, image, label string, asBuildpack bool) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	log.Printf("Running: %s: Building image %s...", label, image)
+
+	bucket := projectID + "_cloudbuild"
+	object := fmt.Sprintf("source/%s-%d.tgz", label, time.Now().UnixNano())
+	if err := d.uploadSource(ctx, bucket, object, projectID, dir); err != nil {
+		return fmt.Errorf("upload source: %w", err)
+	}
+
+	step := &cloudbuildpb.BuildStep{
+		Name: "gcr.io/cloud-builders/docker",
+		Args: []string{"build", "--tag", image, "."},
+	}
+	if asBuildpack {
+		step = &cloudbuildpb.BuildStep{
+			Name:       "gcr.io/k8s-skaffold/pack",
+			Entrypoint: "pack",
+			Args:       []string{"build", image, "--builder", "gcr.io/buildpacks/builder:v1", "--network", "cloudbuild"},
+		}
+	}
+	op, err := d.builds.CreateBuild(ctx, &cloudbuildpb.CreateBuildRequest{
+		ProjectId: projectID,
+		Build: &cloudbuildpb.Build{
+			Source: &cloudbuildpb.Source{
+				Source: &cloudbuildpb.Source_StorageSource{
+					StorageSource: &cloudbuildpb.StorageSource{Bucket: bucket, Object: object},
+				},
+			},
+			Steps:  []*cloudbuildpb.BuildStep{step},
+			Images: []string{image},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("CreateBuild: %s: %w", image, err)
+	}
+	b, err := op.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("CreateBuild: %s: %w", image, err)
+	}
+	if b.GetStatus() != cloudbuildpb.Build_SUCCESS {
+		return fmt.Errorf("CreateBuild: %s: build %s finished with status %s; logs: %s", image, b.GetId(), b.GetStatus(), b.GetLogUrl())
+	}
+	return nil
+}
+
+// uploadSource writes dir as a gzipped tarball to gs://bucket/object,
+// creating the bucket if needed.
+func (d *APIDeployer) uploadSource(ctx context.Context, bucket, object, projectID, dir string) error {
+	b := d.storage.Bucket(bucket)
+	if _, err := b.Attrs(ctx); errors.Is(err, storage.ErrBucketNotExist) {
+		if err := b.Create(ctx, projectID, nil); err != nil {
+			return fmt.Errorf("Bucket(%q).Create: %w", bucket, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("Bucket(%q).Attrs: %w", bucket, err)
+	}
+
+	w := b.Object(object).NewWriter(ctx)
+	if err := writeSourceArchive(w, dir); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writeSourceArchive writes the regular files under dir to w as a gzipped
+// tarball. Version control directories are skipped.
+func writeSourceArchive(w io.Writer, dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// deleteImage deletes the Artifact Registry version referenced by image.
+func (d *APIDeployer) deleteImage(ctx context.Context, image string) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	name, err := artifactName(image)
+	if err != nil {
+		return err
+	}
+	log.Printf("Running: delete container image %s...", image)
+	version := name
+	if strings.Contains(name, "/tags/") {
+		tag, err := d.artifacts.GetTag(ctx, &artifactregistrypb.GetTagRequest{Name: name})
+		if err != nil {
+			return fmt.Errorf("GetTag: %s: %w", name, err)
+		}
+		version = tag.GetVersion()
+	}
+	op, err := d.artifacts.DeleteVersion(ctx, &artifactregistrypb.DeleteVersionRequest{Name: version, Force: true})
+	if err != nil {
+		return fmt.Errorf("DeleteVersion: %s: %w", version, err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("DeleteVersion: %s: %w", version, err)
+	}
+	return nil
+}
+
+// gcrLocations maps Container Registry hosts to the location of their
+// Artifact Registry repository.
+var gcrLocations = map[string]string{
+	"gcr.io":      "us",
+	"us.gcr.io":   "us",
+	"eu.gcr.io":   "europe",
+	"asia.gcr.io": "asia",
+}
+
+// artifactName converts a container image reference to the Artifact Registry
+// resource name of its tag, or of its version if the image has a digest.
+//
+// Both gcr.io (HOST/PROJECT/IMAGE) and pkg.dev
+// (LOCATION-docker.pkg.dev/PROJECT/REPOSITORY/IMAGE) references are supported.
+func artifactName(image string) (string, error) {
+	ref, digest, hasDigest := strings.Cut(image, "@")
+	tag := "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, tag = ref[:i], ref[i+1:]
+	}
+	parts := strings.Split(ref, "/")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("unsupported image reference %q", image)
+	}
+	host := parts[0]
+
+	var location, project, repo string
+	var pkg []string
+	if loc, ok := gcrLocations[host]; ok {
+		location, project, repo, pkg = loc, parts[1], host, parts[2:]
+	} else if strings.HasSuffix(host, "-docker.pkg.dev") && len(parts) >= 4 {
+		location = strings.TrimSuffix(host, "-docker.pkg.dev")
+		project, repo, pkg = parts[1], parts[2], parts[3:]
+	} else {
+		return "", fmt.Errorf("unsupported image reference %q", image)
+	}
+
+	name := fmt.Sprintf("projects/%s/locations/%s/repositories/%s/packages/%s",
+		project, location, repo, strings.Join(pkg, "%2F"))
+	if hasDigest {
+		return name + "/versions/" + digest, nil
+	}
+	return name + "/tags/" + tag, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// FakeDeployer is a Deployer that runs container images locally with Docker
+// instead of deploying them to Cloud Run. Services are published on a random
+// port of 127.0.0.1, and job tasks run as containers in sequence.
+//
+// Images are built with `docker build`, or with `pack build` when
+// AsBuildpack is set. Nothing is pushed to a registry.
+type FakeDeployer struct {
+	// Docker is the path to the docker executable. If empty, the DOCKER_BIN
+	// environment variable is used, or "docker" if that is unset.
+	Docker string
+	// Pack is the path to the pack executable, used for buildpack builds.
+	// If empty, "pack" is used.
+	Pack string
+
+	mu    sync.Mutex
+	urls  map[string]string // service version to URL.
+	execs localExecutions
+}
+
+func (d *FakeDeployer) docker() string {
+	if d.Docker != "" {
+		return d.Docker
+	}
+	if bin := os.Getenv("DOCKER_BIN"); bin != "" {
+		return bin
+	}
+	return "docker"
+}
+
+func (d *FakeDeployer) pack() string {
+	if d.Pack != "" {
+		return d.Pack
+	}
+	return "pack"
+}
+
+// run executes a local command and returns its trimmed output.
+func (d *FakeDeployer) run(ctx context.Context, dir, bin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = dir
+	log.Printf("Executing: %s %s", bin, strings.Join(args, " "))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", bin, args[0], err, bytes.TrimSpace(out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+func (d *FakeDeployer) build(ctx context.Context, dir, image string, asBuildpack bool) error {
+	if dir == "" {
+		dir = "."
+	}
+	if asBuildpack {
+		_, err := d.run(ctx, dir, d.pack(), "build", image, "--builder", "gcr.io/buildpacks/builder:v1", "--path", ".")
+		return err
+	}
+	_, err := d.run(ctx, dir, d.docker(), "build", "--tag", image, ".")
+	return err
+}
+
+// envArgs converts environment variables to docker run flags.
+func envArgs(env EnvVars) []string {
+	var args []string
+	for _, k := range strings.Split(env.KeyString(), ",") {
+		if k == "" {
+			continue
+		}
+		args = append(args, "--env", env.Variable(k))
+	}
+	return args
+}
+
+// BuildService builds s.Image locally.
+func (d *FakeDeployer) BuildService(ctx context.Context, s *Service) error {
+	return d.build(ctx, s.Dir, s.Image, s.AsBuildpack)
+}
+
+// DeployService (re)starts a container named s.version() running s.Image,
+// with the container's port 8080 published on 127.0.0.1.
+func (d *FakeDeployer) DeployService(ctx context.Context, s *Service) error {
+	name := s.version()
+	// Replace any previous deployment, as Cloud Run would.
+	d.run(ctx, "", d.docker(), "rm", "--force", name)
+
+	args := []string{"run", "--detach", "--name", name, "--publish", "127.0.0.1::8080", "--env", "PORT=8080"}
+	args = append(args, envArgs(s.Env)...)
+	args = append(args, s.Image)
+	if _, err := d.run(ctx, "", d.docker(), args...); err != nil {
+		return err
+	}
+	addr, err := d.run(ctx, "", d.docker(), "port", name, "8080/tcp")
+	if err != nil {
+		return err
+	}
+	// docker port may list several bindings, one per line.
+	addr = strings.SplitN(addr, "\n", 2)[0]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.urls == nil {
+		d.urls = make(map[string]string)
+	}
+	d.urls[name] = "http://" + addr
+	return nil
+}
+
+// ServiceURL returns the local URL of the service container.
+func (d *FakeDeployer) ServiceURL(ctx context.Context, s *Service) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.urls[s.version()]
+	if !ok {
+		return "", fmt.Errorf("FakeDeployer: service %s is not running", s.version())
+	}
+	return u, nil
+}
+
+// DeleteService removes the service container.
+func (d *FakeDeployer) DeleteService(ctx context.Context, s *Service) error {
+	d.mu.Lock()
+	delete(d.urls, s.version())
+	d.mu.Unlock()
+	_, err := d.run(ctx, "", d.docker(), "rm", "--force", s.version())
+	return err
+}
+
+// DeleteServiceImage removes s.Image from the local image store.
+func (d *FakeDeployer) DeleteServiceImage(ctx context.Context, s *Service) error {
+	_, err := d.run(ctx, "", d.docker(), "rmi", "--force", s.Image)
+	return err
+}
+
+// NewRequest creates a request without an ID token, since local containers
+// are not behind Cloud Run's authentication.
+func (d *FakeDeployer) NewRequest(method, url string) (*http.Request, error) {
+	return http.NewRequest(method, url, nil)
+}
+
+// BuildJob builds j.Image locally.
+func (d *FakeDeployer) BuildJob(ctx context.Context, j *Job) error {
+	return d.build(ctx, j.Dir, j.Image, j.AsBuildpack)
+}
+
+// CreateJob checks that j.Image is available locally.
+func (d *FakeDeployer) CreateJob(ctx context.Context, j *Job) error {
+	_, err := d.run(ctx, "", d.docker(), "image", "inspect", j.Image)
+	return err
+}
+
+// RunJob starts an execution that runs each task of the job as a container,
+// with the environment variables Cloud Run Jobs sets for each task.
+func (d *FakeDeployer) RunJob(ctx context.Context, j *Job, opts ExecutionOptions) (*Execution, error) {
+	return d.execs.start(j, opts, func(ctx context.Context, env EnvVars) error {
+		args := append([]string{"run", "--rm"}, envArgs(env)...)
+		args = append(args, j.Image)
+		_, err := d.run(ctx, j.Dir, d.docker(), args...)
+		return err
+	}), nil
+}
+
+// GetExecution returns the state of an execution started by RunJob.
+func (d *FakeDeployer) GetExecution(ctx context.Context, j *Job, name string) (*Execution, error) {
+	return d.execs.get(name)
+}
+
+// DeleteJob is a no-op: job containers are removed when they exit.
+func (d *FakeDeployer) DeleteJob(ctx context.Context, j *Job) error {
+	return nil
+}
+
+// DeleteJobImage removes j.Image from the local image store.
+func (d *FakeDeployer) DeleteJobImage(ctx context.Context, j *Job) error {
+	_, err := d.run(ctx, "", d.docker(), "rmi", "--force", j.Image)
+	return err
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestArtifactName(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{
+			image: "gcr.io/my-project/my-service:20200101-000000",
+			want:  "projects/my-project/locations/us/repositories/gcr.io/packages/my-service/tags/20200101-000000",
+		},
+		{
+			image: "eu.gcr.io/my-project/nested/image",
+			want:  "projects/my-project/locations/europe/repositories/eu.gcr.io/packages/nested%2Fimage/tags/latest",
+		},
+		{
+			image: "us-central1-docker.pkg.dev/my-project/repo/img@sha256:abc",
+			want:  "projects/my-project/locations/us-central1/repositories/repo/packages/img/versions/sha256:abc",
+		},
+	}
+	for _, test := range tests {
+		got, err := artifactName(test.image)
+		if err != nil {
+			t.Errorf("artifactName(%q): %v", test.image, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("artifactName(%q): got %q, want %q", test.image, got, test.want)
+		}
+	}
+
+	for _, image := range []string{"my-service", "docker.io/library/golang:1.21"} {
+		if _, err := artifactName(image); err == nil {
+			t.Errorf("artifactName(%q): expected error, got success", image)
+		}
+	}
+}
+
+func TestWriteSourceArchive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "sub/file.txt", ".git/HEAD"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeSourceArchive(&buf, dir); err != nil {
+		t.Fatalf("writeSourceArchive: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var got []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar.Next: %v", err)
+		}
+		got = append(got, hdr.Name)
+	}
+	sort.Strings(got)
+	if want := "main.go,sub/file.txt"; strings.Join(got, ",") != want {
+		t.Errorf("archive contents: got %v, want %s", got, want)
+	}
+}
+
+// fakeDocker writes a docker stand-in that logs its arguments and prints a
+// port for `docker port`.
+func fakeDocker(t *testing.T) (bin, logFile string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	bin = filepath.Join(dir, "docker")
+	logFile = filepath.Join(dir, "docker.log")
+	script := `#!/bin/sh
+echo "$@" >> ` + logFile + `
+if [ "$1" = "port" ]; then echo 127.0.0.1:49153; fi
+`
+	if err := ioutil.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, logFile
+}
+
+func TestFakeDeployerService(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	docker, logFile := fakeDocker(t)
+	service := NewService("my-service", "my-project")
+	service.Dir = t.TempDir()
+	service.Env = EnvVars{"NAME": "value"}
+	service.Deployer = &FakeDeployer{Docker: docker}
+
+	if err := service.Deploy(); err != nil {
+		t.Fatalf("service.Deploy: %v", err)
+	}
+	u, err := service.URL("/hello")
+	if err != nil {
+		t.Fatalf("service.URL: %v", err)
+	}
+	if want := "http://127.0.0.1:49153/hello"; u != want {
+		t.Errorf("service.URL: got %q, want %q", u, want)
+	}
+	host, err := service.Host()
+	if err != nil {
+		t.Fatalf("service.Host: %v", err)
+	}
+	if want := "127.0.0.1:49153"; host != want {
+		t.Errorf("service.Host: got %q, want %q", host, want)
+	}
+	req, err := service.NewRequest("GET", "/")
+	if err != nil {
+		t.Fatalf("service.NewRequest: %v", err)
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Errorf("service.NewRequest: unexpected Authorization header %q", got)
+	}
+	if err := service.Clean(); err != nil {
+		t.Fatalf("service.Clean: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := string(out)
+	for _, want := range []string{
+		"build --tag " + service.Image + " .",
+		"run --detach --name " + service.version(),
+		"--env NAME=value " + service.Image,
+		"rm --force " + service.version(),
+		"rmi --force " + service.Image,
+	} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("docker calls missing %q:\n%s", want, calls)
+		}
+	}
+}
+
+func TestFakeDeployerJob(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	docker, logFile := fakeDocker(t)
+	job := NewJob("my-job", "my-project")
+	job.Image = "gcr.io/my-project/my-job"
+	job.Deployer = &FakeDeployer{Docker: docker}
+
+	if _, err := job.Run(); err != nil {
+		t.Fatalf("job.Run: %v", err)
+	}
+	if err := job.Clean(); err != nil {
+		t.Fatalf("job.Clean: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "CLOUD_RUN_TASK_INDEX=0"; !strings.Contains(string(out), want) {
+		t.Errorf("docker calls missing %q:\n%s", want, out)
+	}
+	if strings.Contains(string(out), "rmi") {
+		t.Errorf("job.Clean removed an image it did not build:\n%s", out)
+	}
+}
+
+func TestAPIDeployerUnsupported(t *testing.T) {
+	service := NewService("my-service", "my-project")
+	service.Platform = GKEPlatform{Cluster: "c", ClusterLocation: "l"}
+	if _, err := serviceName(service); err == nil {
+		t.Errorf("serviceName: expected error for GKE platform, got success")
+	}
+}
+
+func TestAddBinding(t *testing.T) {
+	policy := &iampb.Policy{
+		Etag: []byte("etag"),
+		Bindings: []*iampb.Binding{
+			{Role: "roles/run.admin", Members: []string{"user:a@example.com"}},
+			{Role: "roles/run.invoker", Members: []string{"serviceAccount:b@example.com"}},
+		},
+	}
+	if !addBinding(policy, "roles/run.invoker", "allUsers") {
+		t.Errorf("addBinding: got no change, want allUsers added")
+	}
+	if addBinding(policy, "roles/run.invoker", "allUsers") {
+		t.Errorf("addBinding: got a change, want allUsers already present")
+	}
+	want := &iampb.Policy{
+		Etag: []byte("etag"),
+		Bindings: []*iampb.Binding{
+			{Role: "roles/run.admin", Members: []string{"user:a@example.com"}},
+			{Role: "roles/run.invoker", Members: []string{"serviceAccount:b@example.com", "allUsers"}},
+		},
+	}
+	if !proto.Equal(policy, want) {
+		t.Errorf("addBinding: got %v, want %v", policy, want)
+	}
+
+	empty := &iampb.Policy{}
+	addBinding(empty, "roles/run.invoker", "allUsers")
+	if len(empty.Bindings) != 1 || empty.Bindings[0].Role != "roles/run.invoker" {
+		t.Errorf("addBinding to empty policy: got %v", empty)
+	}
+}
@@ -87,5 +87,15 @@ Configure the service for deploying to a Cloud Run for Anthos on VMWare cluster:
 		ProjectID: os.Getenv("GOOGLE_CLOUD_PROJECT"),
 		Platform:  cloudrunci.KubernetesPlatform{Kubeconfig: "~/.kubeconfig", Context: "my-cluster"},
 	}
+
+Deploy with the Cloud Run Admin API instead of gcloud:
+
+	myService := cloudrunci.NewService("my-service", os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	myService.Deployer = &cloudrunci.APIDeployer{}
+
+Run the service's container image locally with Docker:
+
+	myService := cloudrunci.NewService("my-service", os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	myService.Deployer = &cloudrunci.FakeDeployer{}
 */
 package cloudrunci
@@ -0,0 +1,332 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// TaskStatus is the state of a task in a job execution.
+type TaskStatus string
+
+// Task states.
+const (
+	TaskPending   TaskStatus = "pending"
+	TaskRunning   TaskStatus = "running"
+	TaskSucceeded TaskStatus = "succeeded"
+	TaskFailed    TaskStatus = "failed"
+)
+
+// TaskResult describes one task of a job execution.
+type TaskResult struct {
+	Index  int
+	Status TaskStatus
+	// Attempts is the number of times the task was started, including
+	// retries.
+	Attempts int
+	// ExitCode is the exit code of the last attempt, if known.
+	ExitCode int
+
+	StartTime      time.Time
+	CompletionTime time.Time
+}
+
+// Duration returns how long the task ran, or zero if it has not completed.
+func (t TaskResult) Duration() time.Duration {
+	if t.StartTime.IsZero() || t.CompletionTime.IsZero() {
+		return 0
+	}
+	return t.CompletionTime.Sub(t.StartTime)
+}
+
+// ExecutionOptions override the job's configuration for one execution.
+// Zero values keep the job's configuration.
+//
+// Cloud Run cannot override parallelism per execution, so deployers that
+// use Cloud Run update the job instead, which also affects later executions.
+type ExecutionOptions struct {
+	TaskCount   int
+	Parallelism int
+	// Env is merged into Job.Env.
+	Env EnvVars
+}
+
+// env returns the job's environment with the overrides applied.
+func (o ExecutionOptions) env(j *Job) EnvVars {
+	env := make(EnvVars, len(j.Env)+len(o.Env))
+	for k, v := range j.Env {
+		env[k] = v
+	}
+	for k, v := range o.Env {
+		env[k] = v
+	}
+	return env
+}
+
+// Execution is a run of a Cloud Run job. It is a snapshot: call Refresh or
+// Wait to update it.
+type Execution struct {
+	// Name is the short name of the execution, e.g. "my-job-abc12".
+	Name string
+	Job  *Job
+
+	TaskCount   int
+	Parallelism int
+
+	StartTime      time.Time
+	CompletionTime time.Time
+
+	// Tasks are ordered by index. Tasks that have not been scheduled may be
+	// missing.
+	Tasks []TaskResult
+
+	pollInterval time.Duration
+}
+
+// Done reports whether the execution has completed.
+func (e *Execution) Done() bool {
+	return !e.CompletionTime.IsZero()
+}
+
+// Duration returns how long the execution ran, or zero if it has not
+// completed.
+func (e *Execution) Duration() time.Duration {
+	if e.StartTime.IsZero() || e.CompletionTime.IsZero() {
+		return 0
+	}
+	return e.CompletionTime.Sub(e.StartTime)
+}
+
+// Count returns the number of tasks with the given status.
+func (e *Execution) Count(status TaskStatus) int {
+	n := 0
+	for _, t := range e.Tasks {
+		if t.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Retried returns the number of tasks that needed more than one attempt.
+func (e *Execution) Retried() int {
+	n := 0
+	for _, t := range e.Tasks {
+		if t.Attempts > 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns an error if any task failed.
+func (e *Execution) Err() error {
+	if n := e.Count(TaskFailed); n > 0 {
+		return fmt.Errorf("execution %s: %d of %d tasks failed", e.Name, n, e.TaskCount)
+	}
+	return nil
+}
+
+// Refresh updates the execution from its job's Deployer.
+func (e *Execution) Refresh(ctx context.Context) error {
+	got, err := e.Job.deployer().GetExecution(ctx, e.Job, e.Name)
+	if err != nil {
+		return err
+	}
+	got.Job = e.Job
+	if got.pollInterval == 0 {
+		got.pollInterval = e.pollInterval
+	}
+	*e = *got
+	return nil
+}
+
+// Wait refreshes the execution until it completes or ctx is done. It returns
+// an error if any task failed.
+func (e *Execution) Wait(ctx context.Context) error {
+	interval := e.pollInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	for !e.Done() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("execution %s: %w", e.Name, ctx.Err())
+		case <-time.After(interval):
+		}
+		if err := e.Refresh(ctx); err != nil {
+			return err
+		}
+	}
+	return e.Err()
+}
+
+// WaitForLogs is like Job.WaitForLogs, but only considers this execution's
+// logs.
+func (e *Execution) WaitForLogs(ctx context.Context, filter string, want int, matchers ...LogMatcher) ([]*logging.Entry, error) {
+	filter = fmt.Sprintf(`labels."run.googleapis.com/execution_name"="%s" %s`, e.Name, filter)
+	return e.Job.WaitForLogs(ctx, filter, want, matchers...)
+}
+
+// taskStatus derives a task status from its "Completed" condition, which is
+// "True", "False" or empty while the task is not finished.
+func taskStatus(completed string, started bool) TaskStatus {
+	switch {
+	case completed == "True":
+		return TaskSucceeded
+	case completed == "False":
+		return TaskFailed
+	case started:
+		return TaskRunning
+	}
+	return TaskPending
+}
+
+// localExecutions runs job executions on this machine for FakeDeployer and
+// LocalPlatform, one goroutine per task.
+type localExecutions struct {
+	mu    sync.Mutex
+	n     int
+	execs map[string]*Execution
+}
+
+// runTaskFunc runs one attempt of a task with the given environment, which
+// includes the variables Cloud Run Jobs sets for each task.
+type runTaskFunc func(ctx context.Context, env EnvVars) error
+
+// start begins an execution of j in the background. Tasks are not retried.
+func (l *localExecutions) start(j *Job, opts ExecutionOptions, run runTaskFunc) *Execution {
+	taskCount := opts.TaskCount
+	if taskCount == 0 {
+		taskCount = 1
+	}
+	parallelism := opts.Parallelism
+	if parallelism == 0 || parallelism > taskCount {
+		parallelism = taskCount
+	}
+
+	l.mu.Lock()
+	l.n++
+	e := &Execution{
+		Name:         fmt.Sprintf("%s-local-%d", j.version(), l.n),
+		TaskCount:    taskCount,
+		Parallelism:  parallelism,
+		StartTime:    time.Now(),
+		Tasks:        make([]TaskResult, taskCount),
+		pollInterval: 50 * time.Millisecond,
+	}
+	for i := range e.Tasks {
+		e.Tasks[i] = TaskResult{Index: i, Status: TaskPending}
+	}
+	if l.execs == nil {
+		l.execs = make(map[string]*Execution)
+	}
+	l.execs[e.Name] = e
+	l.mu.Unlock()
+
+	env := opts.env(j)
+	go func() {
+		sem := make(chan struct{}, parallelism)
+		var wg sync.WaitGroup
+		for i := 0; i < taskCount; i++ {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer func() { <-sem; wg.Done() }()
+				l.runTask(e, j.version(), i, env, run)
+			}(i)
+		}
+		wg.Wait()
+		l.mu.Lock()
+		e.CompletionTime = time.Now()
+		l.mu.Unlock()
+	}()
+	return l.snapshot(e)
+}
+
+// taskExitError is returned by a runTaskFunc for a task that exited with a
+// non-zero code.
+type taskExitError int
+
+func (e taskExitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// runTask runs task i of e and records its result.
+func (l *localExecutions) runTask(e *Execution, job string, i int, jobEnv EnvVars, run runTaskFunc) {
+	env := make(EnvVars, len(jobEnv)+5)
+	for k, v := range jobEnv {
+		env[k] = v
+	}
+	env["CLOUD_RUN_JOB"] = job
+	env["CLOUD_RUN_EXECUTION"] = e.Name
+	env["CLOUD_RUN_TASK_INDEX"] = strconv.Itoa(i)
+	env["CLOUD_RUN_TASK_COUNT"] = strconv.Itoa(e.TaskCount)
+	env["CLOUD_RUN_TASK_ATTEMPT"] = "0"
+
+	l.mu.Lock()
+	e.Tasks[i].Status = TaskRunning
+	e.Tasks[i].Attempts = 1
+	e.Tasks[i].StartTime = time.Now()
+	l.mu.Unlock()
+
+	err := run(context.Background(), env)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Tasks[i].CompletionTime = time.Now()
+	e.Tasks[i].Status = TaskSucceeded
+	if err != nil {
+		e.Tasks[i].Status = TaskFailed
+		e.Tasks[i].ExitCode = -1
+		var ee *exec.ExitError
+		var te taskExitError
+		if errors.As(err, &ee) {
+			e.Tasks[i].ExitCode = ee.ExitCode()
+		} else if errors.As(err, &te) {
+			e.Tasks[i].ExitCode = int(te)
+		}
+	}
+}
+
+// get returns a snapshot of the named execution.
+func (l *localExecutions) get(name string) (*Execution, error) {
+	l.mu.Lock()
+	e, ok := l.execs[name]
+	l.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("execution %s not found", name)
+	}
+	return l.snapshot(e), nil
+}
+
+// snapshot copies e so callers can read it without holding l.mu.
+func (l *localExecutions) snapshot(e *Execution) *Execution {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := *e
+	c.Tasks = append([]TaskResult(nil), e.Tasks...)
+	sort.Slice(c.Tasks, func(a, b int) bool { return c.Tasks[a].Index < c.Tasks[b].Index })
+	return &c
+}
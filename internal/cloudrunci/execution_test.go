@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGCloudExecution(t *testing.T) {
+	execJSON := `{
+  "metadata": {"name": "my-job-abc12"},
+  "spec": {"parallelism": 2, "taskCount": 3},
+  "status": {
+    "startTime": "2024-01-02T03:04:05Z",
+    "completionTime": "2024-01-02T03:05:05Z",
+    "succeededCount": 2,
+    "failedCount": 1
+  }
+}`
+	tasksJSON := `[
+  {"status": {"index": 2, "startTime": "2024-01-02T03:04:06Z", "completionTime": "2024-01-02T03:04:16Z",
+    "conditions": [{"type": "Completed", "status": "True"}]}},
+  {"status": {"index": 0, "retried": 2, "startTime": "2024-01-02T03:04:06Z", "completionTime": "2024-01-02T03:05:00Z",
+    "conditions": [{"type": "Ready", "status": "True"}, {"type": "Completed", "status": "False"}],
+    "lastAttemptResult": {"exitCode": 1}}},
+  {"status": {"index": 1, "startTime": "2024-01-02T03:04:06Z",
+    "conditions": [{"type": "Completed", "status": "Unknown"}]}}
+]`
+	e, err := parseGCloudExecution([]byte(execJSON), []byte(tasksJSON))
+	if err != nil {
+		t.Fatalf("parseGCloudExecution: %v", err)
+	}
+	if e.Name != "my-job-abc12" || e.TaskCount != 3 || e.Parallelism != 2 {
+		t.Errorf("parseGCloudExecution: got %+v", e)
+	}
+	if !e.Done() || e.Duration() != time.Minute {
+		t.Errorf("Duration: got %v (done %v), want %v", e.Duration(), e.Done(), time.Minute)
+	}
+
+	want := []struct {
+		status   TaskStatus
+		attempts int
+		exitCode int
+	}{
+		{TaskFailed, 3, 1},
+		{TaskRunning, 1, 0},
+		{TaskSucceeded, 1, 0},
+	}
+	if len(e.Tasks) != len(want) {
+		t.Fatalf("Tasks: got %d, want %d", len(e.Tasks), len(want))
+	}
+	for i, w := range want {
+		got := e.Tasks[i]
+		if got.Index != i || got.Status != w.status || got.Attempts != w.attempts || got.ExitCode != w.exitCode {
+			t.Errorf("Tasks[%d]: got %+v, want %+v", i, got, w)
+		}
+	}
+	if e.Retried() != 1 {
+		t.Errorf("Retried: got %d, want 1", e.Retried())
+	}
+	if err := e.Err(); err == nil {
+		t.Errorf("Err: expected failure, got nil")
+	}
+}
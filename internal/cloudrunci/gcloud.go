@@ -16,6 +16,7 @@ package cloudrunci
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -39,6 +40,12 @@ func init() {
 // gcloud provides a common mechanism for executing gcloud commands.
 // It will attempt to retry failed commands. Use gcloudWithoutRetry() for no retry.
 func gcloud(label string, cmd *exec.Cmd) ([]byte, error) {
+	return gcloudContext(context.Background(), label, cmd)
+}
+
+// gcloudContext is like gcloud, but kills the command and stops retrying
+// when ctx is done.
+func gcloudContext(ctx context.Context, label string, cmd *exec.Cmd) ([]byte, error) {
 	var out []byte
 	var err error
 
@@ -49,13 +56,14 @@ func gcloud(label string, cmd *exec.Cmd) ([]byte, error) {
 
 	maxAttempts := 5
 	success := testutil.RetryWithoutTest(maxAttempts, delaySeconds, func(r *testutil.R) {
-		// exec.Cmd objects cannot be reused once started, so first make a copy.
-		cmdCopy := &exec.Cmd{
-			Path: cmd.Path,
-			Args: cmd.Args,
-			Env:  cmd.Env,
-			Dir:  cmd.Dir,
+		if ctx.Err() != nil {
+			return
 		}
+		// exec.Cmd objects cannot be reused once started, so first make a copy.
+		cmdCopy := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+		cmdCopy.Args = cmd.Args
+		cmdCopy.Env = cmd.Env
+		cmdCopy.Dir = cmd.Dir
 		out, err = gcloudExec(fmt.Sprintf("Attempt #%d: ", r.Attempt), label, cmdCopy)
 		if err != nil {
 			log.Printf("gcloudExec: %v", err)
@@ -66,6 +74,9 @@ func gcloud(label string, cmd *exec.Cmd) ([]byte, error) {
 		cmd.Stderr = nil
 	})
 
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("gcloudExec: %s: %w", label, ctx.Err())
+	}
 	if success {
 		return out, nil
 	}
@@ -79,23 +90,30 @@ func gcloudWithoutRetry(label string, cmd *exec.Cmd) ([]byte, error) {
 }
 
 // gcloudExec adds output prefixing to the execution of the provided command.
+// It returns the standard output of the command, which callers may parse:
+// gcloud writes progress messages and warnings to standard error, which is
+// only shown if the command fails.
 func gcloudExec(prefix string, label string, cmd *exec.Cmd) ([]byte, error) {
 	log.Printf("%sRunning: %s...", prefix, label)
 	log.Printf("%sExecuting: %s: %s: %s", prefix, label, cmd.Path, strings.Join(cmd.Args[1:], " "))
 	// TODO: add a flag for verbose output (e.g. when running with binary created with `go test -c`)
-	out, err := cmd.CombinedOutput()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
 	if err != nil {
 		os.Stderr.Write([]byte(fmt.Sprintf("%s%s: Error Output\n###\n", prefix, label)))
-		if len(out) > 0 {
-			os.Stderr.Write(out)
+		if stdout.Len()+stderr.Len() > 0 {
+			os.Stderr.Write(stdout.Bytes())
+			os.Stderr.Write(stderr.Bytes())
 		} else {
 			os.Stderr.Write([]byte("no output produced"))
 		}
 		os.Stderr.Write([]byte("\n###\n"))
-		return out, fmt.Errorf("%s%s: %q", prefix, label, err)
+		return stdout.Bytes(), fmt.Errorf("%s%s: %q", prefix, label, err)
 	}
 
-	return bytes.TrimSpace(out), err
+	return bytes.TrimSpace(stdout.Bytes()), nil
 }
 
 // CreateIDToken generates an ID token for requests to the fully managed platform.
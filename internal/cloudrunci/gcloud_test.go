@@ -16,11 +16,15 @@ package cloudrunci
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
 )
@@ -68,3 +72,34 @@ func TestGcloudRetry(t *testing.T) {
 		t.Errorf("exec.Cmd object was reused, producing an 'already started' error")
 	}
 }
+
+func TestGcloudContext(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := gcloudContext(ctx, "sleeping", exec.Command("sleep", "30"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("gcloudContext: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("gcloudContext: took %v, want the command killed and retries stopped", d)
+	}
+}
+
+func TestGcloudStdout(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// gcloud reports progress on stderr; only stdout is returned.
+	cmd := exec.Command("sh", "-c", "echo 'Creating execution...done' >&2; echo my-job-abc12; echo 'Updates are available' >&2")
+	out, err := gcloudWithoutRetry("stdout", cmd)
+	if err != nil {
+		t.Fatalf("gcloud: %v", err)
+	}
+	if got, want := string(out), "my-job-abc12"; got != want {
+		t.Errorf("gcloud: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+)
+
+// LocalPlatform builds a service's main package with `go build` and runs the
+// binary on this machine, so e2e tests can run without Cloud Run, Docker or
+// gcloud. URL, Request, Do and Clean behave as they do for deployed services.
+//
+// LocalPlatform is both the Platform and the Deployer of a service, so it
+// must be used as a pointer:
+//
+//	service.Platform = &cloudrunci.LocalPlatform{}
+type LocalPlatform struct {
+	platformBase
+
+	// ReadyPath, if set, is polled with GET requests until it returns a
+	// non-5xx response. Otherwise the service is ready when its port accepts
+	// connections.
+	ReadyPath string
+
+	// StartTimeout bounds the wait for readiness. Defaults to 30 seconds.
+	StartTimeout time.Duration
+
+	// Output receives the stdout and stderr of started binaries.
+	// Defaults to os.Stderr.
+	Output io.Writer
+
+	mu    sync.Mutex
+	procs map[string]*localProcess // keyed by service or job version.
+	execs localExecutions
+}
+
+// localProcess is a built binary and, for services, its running process.
+type localProcess struct {
+	runner *testutil.Runner
+	proc   *testutil.Process
+}
+
+// Name retrieves the ID for the local platform.
+func (p *LocalPlatform) Name() string {
+	return "local"
+}
+
+// Validate always succeeds: the local platform needs no configuration.
+func (p *LocalPlatform) Validate() error {
+	return nil
+}
+
+// CommandFlags returns no flags, as the local platform does not use gcloud.
+func (p *LocalPlatform) CommandFlags() []string {
+	return nil
+}
+
+func (p *LocalPlatform) output() io.Writer {
+	if p.Output != nil {
+		return p.Output
+	}
+	return os.Stderr
+}
+
+// build builds dir and records the binary under key.
+func (p *LocalPlatform) build(key, dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	log.Printf("Running: go build in %s for %s...", dir, key)
+	r, err := testutil.BuildMainDir(dir)
+	if err != nil {
+		if r != nil {
+			r.Cleanup()
+		}
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.procs == nil {
+		p.procs = make(map[string]*localProcess)
+	}
+	if old, ok := p.procs[key]; ok {
+		old.runner.Cleanup()
+	}
+	p.procs[key] = &localProcess{runner: r}
+	return nil
+}
+
+// proc returns the process recorded under key, building dir first if needed.
+func (p *LocalPlatform) proc(key, dir string) (*localProcess, error) {
+	p.mu.Lock()
+	lp, ok := p.procs[key]
+	p.mu.Unlock()
+	if ok {
+		return lp, nil
+	}
+	if err := p.build(key, dir); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.procs[key], nil
+}
+
+// env returns the environment of a process started from the built binary:
+// env plus the extra variables.
+func env(vars EnvVars, extra map[string]string) map[string]string {
+	m := make(map[string]string, len(vars)+len(extra))
+	for k, v := range vars {
+		m[k] = v
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+// BuildService builds the main package in s.Dir.
+func (p *LocalPlatform) BuildService(ctx context.Context, s *Service) error {
+	return p.build(s.version(), s.Dir)
+}
+
+// DeployService starts the built binary on a free port with PORT and s.Env
+// set, and waits until it is ready. A running instance is stopped first.
+func (p *LocalPlatform) DeployService(ctx context.Context, s *Service) error {
+	lp, err := p.proc(s.version(), s.Dir)
+	if err != nil {
+		return err
+	}
+	if lp.proc != nil {
+		stopProcess(lp)
+	}
+
+	timeout := p.StartTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("Running: starting %s...", s.version())
+	lp.runner.Output = p.output()
+	proc, err := lp.runner.StartServer(ctx, env(s.Env, map[string]string{
+		"K_SERVICE":       s.version(),
+		"K_REVISION":      s.version() + "-local",
+		"K_CONFIGURATION": s.version(),
+	}))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.version(), err)
+	}
+	lp.proc = proc
+	if p.ReadyPath != "" {
+		if err := proc.WaitForHTTP(ctx, proc.URL+p.ReadyPath); err != nil {
+			stopProcess(lp)
+			return fmt.Errorf("%s: %w", s.version(), err)
+		}
+	}
+	return nil
+}
+
+// ServiceURL returns the local URL of the running service.
+func (p *LocalPlatform) ServiceURL(ctx context.Context, s *Service) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lp, ok := p.procs[s.version()]
+	if !ok || lp.proc == nil {
+		return "", fmt.Errorf("LocalPlatform: service %s is not running", s.version())
+	}
+	return lp.proc.URL, nil
+}
+
+// DeleteService stops the running service and removes its binary, which may
+// have been built implicitly by DeployService.
+func (p *LocalPlatform) DeleteService(ctx context.Context, s *Service) error {
+	p.remove(s.version())
+	return nil
+}
+
+// DeleteServiceImage removes the built binary, if DeleteService has not.
+func (p *LocalPlatform) DeleteServiceImage(ctx context.Context, s *Service) error {
+	p.remove(s.version())
+	return nil
+}
+
+// BuildJob builds the main package in j.Dir.
+func (p *LocalPlatform) BuildJob(ctx context.Context, j *Job) error {
+	return p.build(j.version(), j.Dir)
+}
+
+// CreateJob builds the job binary if it has not been built yet.
+func (p *LocalPlatform) CreateJob(ctx context.Context, j *Job) error {
+	_, err := p.proc(j.version(), j.Dir)
+	return err
+}
+
+// RunJob starts an execution that runs each task of the job as a process,
+// with the environment variables Cloud Run Jobs sets for each task.
+func (p *LocalPlatform) RunJob(ctx context.Context, j *Job, opts ExecutionOptions) (*Execution, error) {
+	lp, err := p.proc(j.version(), j.Dir)
+	if err != nil {
+		return nil, err
+	}
+	lp.runner.Output = p.output()
+	return p.execs.start(j, opts, func(ctx context.Context, vars EnvVars) error {
+		proc, err := lp.runner.Start(env(vars, nil))
+		if err != nil {
+			return err
+		}
+		code, err := proc.Wait(ctx)
+		if err != nil {
+			proc.Terminate(10 * time.Second)
+			return err
+		}
+		if code != 0 {
+			return taskExitError(code)
+		}
+		return nil
+	}), nil
+}
+
+// GetExecution returns the state of an execution started by RunJob.
+func (p *LocalPlatform) GetExecution(ctx context.Context, j *Job, name string) (*Execution, error) {
+	return p.execs.get(name)
+}
+
+// DeleteJob removes the job binary, which may have been built implicitly by
+// CreateJob.
+func (p *LocalPlatform) DeleteJob(ctx context.Context, j *Job) error {
+	p.remove(j.version())
+	return nil
+}
+
+// DeleteJobImage removes the built binary, if DeleteJob has not.
+func (p *LocalPlatform) DeleteJobImage(ctx context.Context, j *Job) error {
+	p.remove(j.version())
+	return nil
+}
+
+// remove stops and deletes the process recorded under key.
+func (p *LocalPlatform) remove(key string) {
+	p.mu.Lock()
+	lp, ok := p.procs[key]
+	delete(p.procs, key)
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	if lp.proc != nil {
+		stopProcess(lp)
+	}
+	lp.runner.Cleanup()
+}
+
+// stopProcess sends SIGTERM, as Cloud Run does on shutdown, and kills the
+// process if it has not exited after 10 seconds.
+func stopProcess(lp *localProcess) {
+	if _, err := lp.proc.Terminate(10 * time.Second); err != nil {
+		log.Printf("LocalPlatform: %v", err)
+	}
+	lp.proc = nil
+}
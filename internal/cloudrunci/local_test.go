@@ -0,0 +1,169 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const localServiceMain = `package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+func main() {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello %s from %s!", os.Getenv("NAME"), os.Getenv("K_SERVICE"))
+	})
+	http.ListenAndServe(":"+os.Getenv("PORT"), nil)
+}
+`
+
+const localJobMain = `package main
+
+import "os"
+
+func main() {
+	if os.Getenv("CLOUD_RUN_TASK_COUNT") == "" {
+		os.Exit(1)
+	}
+	if os.Getenv("CLOUD_RUN_TASK_INDEX") == os.Getenv("FAIL_TASK") {
+		os.Exit(3)
+	}
+}
+`
+
+// writeMain writes a standalone main package to a temporary directory.
+func writeMain(t *testing.T, src string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module localtest\n\ngo 1.19\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLocalPlatformService(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	service := NewService("my-service", "")
+	service.Platform = &LocalPlatform{ReadyPath: "/"}
+	service.Dir = writeMain(t, localServiceMain)
+	service.Env = EnvVars{"NAME": "Gopher"}
+
+	if err := service.Deploy(); err != nil {
+		t.Fatalf("service.Deploy: %v", err)
+	}
+	defer service.Clean()
+
+	resp, err := service.Request("GET", "/")
+	if err != nil {
+		t.Fatalf("service.Request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+	want := "Hello Gopher from " + service.version() + "!"
+	if got := string(body); got != want {
+		t.Errorf("service.Request: got %q, want %q", got, want)
+	}
+
+	u, err := service.URL("/")
+	if err != nil {
+		t.Fatalf("service.URL: %v", err)
+	}
+	if err := service.Clean(); err != nil {
+		t.Fatalf("service.Clean: %v", err)
+	}
+	if _, err := http.Get(u); err == nil {
+		t.Errorf("http.Get(%s) succeeded after Clean", u)
+	}
+}
+
+func TestLocalPlatformBuildError(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	service := NewService("my-service", "")
+	service.Platform = &LocalPlatform{}
+	service.Dir = writeMain(t, "package main\n\nfunc main() { undefined() }\n")
+
+	err := service.Deploy()
+	if err == nil {
+		service.Clean()
+		t.Fatalf("service.Deploy: expected build error, got success")
+	}
+	if !strings.Contains(err.Error(), "go build") {
+		t.Errorf("service.Deploy: got %v, want go build error", err)
+	}
+}
+
+func TestLocalPlatformJob(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	job := NewJob("my-job", "my-project")
+	job.Dir = writeMain(t, localJobMain)
+	job.Deployer = &LocalPlatform{}
+	defer job.Clean()
+
+	e, err := job.Run()
+	if err != nil {
+		t.Fatalf("job.Run: %v", err)
+	}
+	if !e.Done() || e.TaskCount != 1 || e.Count(TaskSucceeded) != 1 {
+		t.Errorf("job.Run: got %+v, want 1 succeeded task", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	e, err = job.Start(ctx, ExecutionOptions{TaskCount: 3, Parallelism: 2, Env: EnvVars{"FAIL_TASK": "1"}})
+	if err != nil {
+		t.Fatalf("job.Start: %v", err)
+	}
+	if err := e.Wait(ctx); err == nil {
+		t.Errorf("Wait: expected task failure, got success")
+	}
+	if got := e.Count(TaskSucceeded); got != 2 {
+		t.Errorf("Count(TaskSucceeded): got %d, want 2", got)
+	}
+	if len(e.Tasks) != 3 {
+		t.Fatalf("Tasks: got %d, want 3", len(e.Tasks))
+	}
+	failed := e.Tasks[1]
+	if failed.Status != TaskFailed || failed.ExitCode != 3 || failed.Attempts != 1 {
+		t.Errorf("Tasks[1]: got %+v, want failed attempt with exit code 3", failed)
+	}
+	if e.Duration() <= 0 || failed.Duration() <= 0 {
+		t.Errorf("Duration: got execution %v, task %v, want positive", e.Duration(), failed.Duration())
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// LogSource lists log entries matching a Cloud Logging filter.
+type LogSource interface {
+	Entries(ctx context.Context, filter string) ([]*logging.Entry, error)
+}
+
+// LogadminSource is a LogSource backed by the Cloud Logging API.
+type LogadminSource struct {
+	Client *logadmin.Client
+}
+
+// Entries lists all entries matching filter.
+func (l LogadminSource) Entries(ctx context.Context, filter string) ([]*logging.Entry, error) {
+	var entries []*logging.Entry
+	it := l.Client.Entries(ctx, logadmin.Filter(filter))
+	for {
+		entry, err := it.Next()
+		if err == iterator.Done {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, fmt.Errorf("it.Next: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// FakeLogSource is an in-memory LogSource for tests. It ignores filters, but
+// records them for inspection.
+type FakeLogSource struct {
+	mu      sync.Mutex
+	entries []*logging.Entry
+	filters []string
+}
+
+// Add makes entries visible to subsequent calls to Entries.
+func (f *FakeLogSource) Add(entries ...*logging.Entry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, entries...)
+}
+
+// Entries returns all entries added so far.
+func (f *FakeLogSource) Entries(ctx context.Context, filter string) ([]*logging.Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filters = append(f.filters, filter)
+	return append([]*logging.Entry(nil), f.entries...), nil
+}
+
+// Filters returns the filters Entries was called with.
+func (f *FakeLogSource) Filters() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.filters...)
+}
+
+// LogMatcher reports whether a log entry is wanted.
+type LogMatcher func(*logging.Entry) bool
+
+// SeverityAtLeast matches entries with severity s or higher.
+func SeverityAtLeast(s logging.Severity) LogMatcher {
+	return func(e *logging.Entry) bool {
+		return e.Severity >= s
+	}
+}
+
+// PayloadContains matches entries whose payload contains substr.
+func PayloadContains(substr string) LogMatcher {
+	return func(e *logging.Entry) bool {
+		return strings.Contains(payloadString(e), substr)
+	}
+}
+
+// PayloadMatches matches entries whose payload matches re.
+func PayloadMatches(re *regexp.Regexp) LogMatcher {
+	return func(e *logging.Entry) bool {
+		return re.MatchString(payloadString(e))
+	}
+}
+
+// JSONFieldEquals matches entries with a JSON payload whose field at the
+// dot-separated path has the given value. Values are compared by their
+// default formatting, so JSON numbers equal Go integers of the same value.
+func JSONFieldEquals(path string, value interface{}) LogMatcher {
+	keys := strings.Split(path, ".")
+	want := fmt.Sprint(value)
+	return func(e *logging.Entry) bool {
+		var v interface{} = jsonPayload(e)
+		for _, k := range keys {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if v, ok = m[k]; !ok {
+				return false
+			}
+		}
+		return fmt.Sprint(v) == want
+	}
+}
+
+// LabelEquals matches entries with the given label.
+func LabelEquals(key, value string) LogMatcher {
+	return func(e *logging.Entry) bool {
+		return e.Labels[key] == value
+	}
+}
+
+// AllOf matches entries matched by every matcher.
+func AllOf(matchers ...LogMatcher) LogMatcher {
+	return func(e *logging.Entry) bool {
+		for _, m := range matchers {
+			if !m(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf matches entries matched by at least one matcher.
+func AnyOf(matchers ...LogMatcher) LogMatcher {
+	return func(e *logging.Entry) bool {
+		for _, m := range matchers {
+			if m(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// jsonPayload returns the payload of e as decoded JSON, or nil if it is not
+// a JSON payload.
+func jsonPayload(e *logging.Entry) map[string]interface{} {
+	switch p := e.Payload.(type) {
+	case *structpb.Struct:
+		return p.AsMap()
+	case map[string]interface{}:
+		return p
+	}
+	return nil
+}
+
+// payloadString formats the payload of e for text matching. JSON payloads
+// are formatted as JSON.
+func payloadString(e *logging.Entry) string {
+	switch p := e.Payload.(type) {
+	case string:
+		return p
+	case nil:
+		return ""
+	}
+	if m := jsonPayload(e); m != nil {
+		if b, err := json.Marshal(m); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", e.Payload)
+}
+
+// LogWatcher polls a LogSource until enough matching entries are found.
+type LogWatcher struct {
+	Source LogSource
+	// Filter is a Cloud Logging filter selecting the entries to consider.
+	Filter string
+	// PollInterval is the delay between polls. Defaults to 15 seconds.
+	PollInterval time.Duration
+}
+
+// WaitFor polls until at least want distinct entries match all matchers, and
+// returns every matched entry. If ctx is done first, the entries matched so
+// far are returned with an error wrapping ctx.Err().
+func (w *LogWatcher) WaitFor(ctx context.Context, want int, matchers ...LogMatcher) ([]*logging.Entry, error) {
+	interval := w.PollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	match := AllOf(matchers...)
+	seen := make(map[string]bool)
+	var matched []*logging.Entry
+
+	log.Printf("Using log filter: %s", w.Filter)
+	for attempt := 1; ; attempt++ {
+		entries, err := w.Source.Entries(ctx, w.Filter)
+		if err != nil && ctx.Err() == nil {
+			return matched, err
+		}
+		for _, e := range entries {
+			key := entryKey(e)
+			if seen[key] || !match(e) {
+				continue
+			}
+			seen[key] = true
+			matched = append(matched, e)
+		}
+		if len(matched) >= want {
+			return matched, nil
+		}
+		log.Printf("Attempt #%d: %d of %d log entries found", attempt, len(matched), want)
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return matched, fmt.Errorf("found %d of %d log entries: %w", len(matched), want, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// entryKey identifies an entry across polls.
+func entryKey(e *logging.Entry) string {
+	if e.InsertID != "" {
+		return e.LogName + "/" + e.InsertID
+	}
+	return fmt.Sprintf("%s/%d/%s", e.LogName, e.Timestamp.UnixNano(), payloadString(e))
+}
+
+// logWatcher returns a LogWatcher using source, or a LogadminSource for
+// projectID. The returned function releases the source.
+func logWatcher(ctx context.Context, source LogSource, projectID, filter string) (*LogWatcher, func(), error) {
+	if source != nil {
+		return &LogWatcher{Source: source, Filter: filter}, func() {}, nil
+	}
+	client, err := logadmin.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("logadmin.NewClient: %w", err)
+	}
+	return &LogWatcher{Source: LogadminSource{Client: client}, Filter: filter}, func() { client.Close() }, nil
+}
+
+// legacyLogEntries implements the LogEntries methods of Service and Job on
+// top of LogWatcher: it waits up to initialWait plus maxAttempts polls of
+// interval for an entry containing find.
+func legacyLogEntries(ctx context.Context, w *LogWatcher, find string, maxAttempts int, initialWait, interval time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, initialWait+time.Duration(maxAttempts)*interval)
+	defer cancel()
+	w.PollInterval = interval
+	entries, err := w.WaitFor(ctx, 1, PayloadContains(find))
+	if len(entries) > 0 {
+		fmt.Printf("%q log entry found.\n", find)
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	return false, err
+}
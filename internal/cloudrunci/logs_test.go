@@ -0,0 +1,155 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func jsonEntry(t *testing.T, id string, m map[string]interface{}) *logging.Entry {
+	t.Helper()
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatalf("structpb.NewStruct: %v", err)
+	}
+	return &logging.Entry{InsertID: id, Payload: s}
+}
+
+func TestLogMatchers(t *testing.T) {
+	text := &logging.Entry{Payload: "Completed Task 3", Severity: logging.Warning, Labels: map[string]string{"k": "v"}}
+	structured := jsonEntry(t, "1", map[string]interface{}{
+		"message":  "hello",
+		"httpInfo": map[string]interface{}{"status": 200},
+	})
+
+	tests := []struct {
+		name  string
+		m     LogMatcher
+		entry *logging.Entry
+		want  bool
+	}{
+		{"severity equal", SeverityAtLeast(logging.Warning), text, true},
+		{"severity lower", SeverityAtLeast(logging.Error), text, false},
+		{"contains", PayloadContains("Completed Task"), text, true},
+		{"contains json", PayloadContains(`"message":"hello"`), structured, true},
+		{"regexp", PayloadMatches(regexp.MustCompile(`Task \d+$`)), text, true},
+		{"json field", JSONFieldEquals("message", "hello"), structured, true},
+		{"json nested number", JSONFieldEquals("httpInfo.status", 200), structured, true},
+		{"json missing", JSONFieldEquals("httpInfo.latency", "1s"), structured, false},
+		{"json on text", JSONFieldEquals("message", "hello"), text, false},
+		{"label", LabelEquals("k", "v"), text, true},
+		{"all of", AllOf(PayloadContains("Task"), SeverityAtLeast(logging.Error)), text, false},
+		{"any of", AnyOf(PayloadContains("nope"), SeverityAtLeast(logging.Info)), text, true},
+	}
+	for _, test := range tests {
+		if got := test.m(test.entry); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLogWatcherWaitFor(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	src := &FakeLogSource{}
+	src.Add(
+		&logging.Entry{InsertID: "a", Payload: "starting"},
+		&logging.Entry{InsertID: "b", Payload: "Completed Task 0"},
+	)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		// Duplicate "b" must not count twice.
+		src.Add(
+			&logging.Entry{InsertID: "b", Payload: "Completed Task 0"},
+			&logging.Entry{InsertID: "c", Payload: "Completed Task 1"},
+		)
+	}()
+
+	w := &LogWatcher{Source: src, Filter: "f", PollInterval: 5 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := w.WaitFor(ctx, 2, PayloadContains("Completed Task"))
+	if err != nil {
+		t.Fatalf("WaitFor: %v", err)
+	}
+	var ids []string
+	for _, e := range got {
+		ids = append(ids, e.InsertID)
+	}
+	if strings.Join(ids, ",") != "b,c" {
+		t.Errorf("WaitFor: got entries %v, want [b c]", ids)
+	}
+	if filters := src.Filters(); len(filters) < 2 || filters[0] != "f" {
+		t.Errorf("Filters: got %q, want several calls with %q", filters, "f")
+	}
+}
+
+func TestLogWatcherDeadline(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	src := &FakeLogSource{}
+	src.Add(&logging.Entry{InsertID: "a", Payload: "Completed Task 0"})
+	w := &LogWatcher{Source: src, PollInterval: 5 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	got, err := w.WaitFor(ctx, 2, PayloadContains("Completed Task"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitFor: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(got) != 1 {
+		t.Errorf("WaitFor: got %d entries, want the 1 found before the deadline", len(got))
+	}
+}
+
+func TestServiceWaitForLogs(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	src := &FakeLogSource{}
+	src.Add(jsonEntry(t, "1", map[string]interface{}{"message": "shutting down", "severity": "INFO"}))
+	service := NewService("my-service", "my-project")
+	service.LogSource = src
+
+	got, err := service.WaitForLogs(context.Background(), `severity>=INFO`, 1, JSONFieldEquals("message", "shutting down"))
+	if err != nil {
+		t.Fatalf("WaitForLogs: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("WaitForLogs: got %d entries, want 1", len(got))
+	}
+	want := `resource.type="cloud_run_revision" resource.labels.service_name="` + service.version() + `" severity>=INFO`
+	if filters := src.Filters(); len(filters) == 0 || filters[0] != want {
+		t.Errorf("Filters: got %q, want %q", filters, want)
+	}
+
+	found, err := service.LogEntries("", "shutting down", 1)
+	if err != nil || !found {
+		t.Errorf("LogEntries: got (%v, %v), want (true, nil)", found, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command janitor deletes resources leaked by tests in a project.
+//
+// Resources are selected by name prefix or label, and must be older than
+// -age. Without -prefix or -label, resources labeled by janitor.Labels are
+// selected.
+//
+//	Usage of janitor:
+//	  -age duration
+//	      Minimum age of resources to delete. (default 24h0m0s)
+//	  -kinds list
+//	      Comma-separated list of kinds to sweep. If empty, sweeps all kinds.
+//	  -kms-location location
+//	      KMS location to sweep. (default "global")
+//	  -label list
+//	      Comma-separated list of key=value labels to match. An empty value matches any value.
+//	  -n  Dry run.
+//	  -prefix list
+//	      Comma-separated list of name prefixes to match.
+//	  -project Project ID
+//	      Project ID to clean.
+//	  -region region
+//	      Cloud Run region to sweep. (default "us-central1")
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/janitor"
+)
+
+var (
+	proj        = flag.String("project", "", "`Project ID` to clean.")
+	kinds       = flag.String("kinds", "", "Comma-separated `list` of kinds to sweep. If empty, sweeps all kinds.")
+	prefixes    = flag.String("prefix", "", "Comma-separated `list` of name prefixes to match.")
+	labels      = flag.String("label", "", "Comma-separated `list` of key=value labels to match. An empty value matches any value.")
+	age         = flag.Duration("age", 24*time.Hour, "Minimum age of resources to delete.")
+	region      = flag.String("region", "us-central1", "Cloud Run `region` to sweep.")
+	kmsLocation = flag.String("kms-location", "global", "KMS `location` to sweep.")
+	dryRun      = flag.Bool("n", false, "Dry run.")
+)
+
+func main() {
+	flag.Parse()
+	if *proj == "" {
+		fmt.Fprintln(os.Stderr, "-project flag is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	policy := janitor.Policy{
+		Prefixes: split(*prefixes),
+		MinAge:   *age,
+	}
+	for _, l := range split(*labels) {
+		if policy.Labels == nil {
+			policy.Labels = make(map[string]string)
+		}
+		k, v, _ := strings.Cut(l, "=")
+		policy.Labels[k] = v
+	}
+	if len(policy.Prefixes) == 0 && len(policy.Labels) == 0 {
+		policy.Labels = janitor.DefaultPolicy.Labels
+	}
+
+	ctx := context.Background()
+	sweepers, closeAll, err := janitor.NewSweepers(ctx, janitor.Config{
+		ProjectID:   *proj,
+		Region:      *region,
+		KMSLocation: *kmsLocation,
+	}, split(*kinds)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer closeAll()
+
+	j := &janitor.Janitor{Sweepers: sweepers, Policy: policy, DryRun: *dryRun}
+	rep, err := j.Sweep(ctx)
+	if *dryRun {
+		log.Printf("Would delete %d resources.", len(rep.Deleted))
+	} else {
+		log.Printf("Deleted %d resources.", len(rep.Deleted))
+	}
+	if err != nil {
+		log.Printf("%d failures.", len(rep.Failures))
+		closeAll()
+		os.Exit(1)
+	}
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regiontag
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Allowlist holds known problems that are not reported, such as tags shared
+// by the first and second generation versions of a sample. Each line is
+//
+//	<check> <file> <tag>
+//
+// where file is slash-separated and relative to the linted root. Blank lines
+// and lines starting with # are ignored.
+type Allowlist map[string]bool
+
+// ParseAllowlist reads an Allowlist.
+func ParseAllowlist(r io.Reader) (Allowlist, error) {
+	a := make(Allowlist)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("line %d: got %d fields, want check, file and tag", n, len(f))
+		}
+		a[strings.Join(f, " ")] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// key returns the Allowlist entry for p, found under root.
+func key(root string, p Problem) string {
+	file := p.File
+	if rel, err := filepath.Rel(root, p.File); err == nil {
+		file = rel
+	}
+	return fmt.Sprintf("%s %s %s", p.Check, filepath.ToSlash(file), p.Tag)
+}
+
+// Allows reports whether p, found under root, is in the allowlist.
+func (a Allowlist) Allows(root string, p Problem) bool {
+	return a[key(root, p)]
+}
+
+// Unused returns the entries that match none of problems, found under root,
+// sorted.
+func (a Allowlist) Unused(root string, problems []Problem) []string {
+	used := make(map[string]bool)
+	for _, p := range problems {
+		used[key(root, p)] = true
+	}
+	var unused []string
+	for k := range a {
+		if !used[k] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
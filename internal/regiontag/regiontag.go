@@ -0,0 +1,298 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package regiontag finds and validates the region tags that mark the parts
+// of samples included in documentation:
+//
+//	// [START <tag>]
+//	...
+//	// [END <tag>]
+//
+// Files are parsed directly, so the whole repository can be linted in a
+// second or two. Lint reports:
+//
+//   - START tags without a matching END, and END tags without a START
+//   - regions nested inside a region with the same tag
+//   - tags used in more than one file
+//   - tags in files with build constraints, which documentation tooling and
+//     some builds skip
+//   - tags in doc comments, which would appear in `go doc` output
+package regiontag
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Region is a tagged region of a file. A tag may mark several regions of
+// the same file.
+type Region struct {
+	Tag  string `json:"tag"`
+	File string `json:"file"`
+	// Start and End are the lines of the START and END tags.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Checks, reported in Problem.Check.
+const (
+	CheckParse      = "parse"
+	CheckPairing    = "pairing"
+	CheckDuplicate  = "duplicate"
+	CheckConstraint = "constraint"
+	CheckDoc        = "doc"
+)
+
+// Problem is a region tag error.
+type Problem struct {
+	File  string
+	Line  int
+	Check string
+	// Tag is the region tag the problem is about, or "" for CheckParse.
+	Tag string
+	Msg string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+}
+
+// Result is the result of linting a set of files.
+type Result struct {
+	// Regions and Problems are sorted by file and line.
+	Regions  []Region
+	Problems []Problem
+}
+
+// Index maps each tag to its regions.
+func (r *Result) Index() map[string][]Region {
+	idx := make(map[string][]Region)
+	for _, reg := range r.Regions {
+		idx[reg.Tag] = append(idx[reg.Tag], reg)
+	}
+	return idx
+}
+
+var tagRe = regexp.MustCompile(`\[(START|END) ([[:word:]-]+)\]`)
+
+type marker struct {
+	end  bool
+	tag  string
+	line int
+}
+
+// LintFile finds the regions of one file and the problems within it. src
+// is the content of the file; if nil, the file is read from path.
+func LintFile(path string, src []byte) ([]Region, []Problem, error) {
+	var in interface{}
+	if src != nil {
+		in = src
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, in, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		regions  []Region
+		problems []Problem
+		markers  []marker
+	)
+	report := func(line int, check, tag, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: path, Line: line, Check: check, Tag: tag, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	constrained := -1
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
+				constrained = fset.Position(c.Pos()).Line
+			}
+		}
+	}
+	docs := docComments(f)
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			for _, m := range tagRe.FindAllStringSubmatch(c.Text, -1) {
+				line := fset.Position(c.Pos()).Line
+				markers = append(markers, marker{end: m[1] == "END", tag: m[2], line: line})
+				if m[1] == "START" && docs[cg] {
+					report(line, CheckDoc, m[2], "region tag %s is in a doc comment", m[2])
+				}
+			}
+		}
+	}
+	if constrained > 0 && len(markers) > 0 {
+		report(markers[0].line, CheckConstraint, markers[0].tag, "region tags in a file with build constraints (line %d)", constrained)
+	}
+
+	// open holds the regions that have started but not ended. Regions with
+	// different tags may overlap, as when two samples share their imports.
+	var open []marker
+	for _, m := range markers {
+		if !m.end {
+			for _, o := range open {
+				if o.tag == m.tag {
+					report(m.line, CheckPairing, m.tag, "START %s inside region started on line %d", m.tag, o.line)
+				}
+			}
+			open = append(open, m)
+			continue
+		}
+		i := len(open) - 1
+		for i >= 0 && open[i].tag != m.tag {
+			i--
+		}
+		if i < 0 {
+			report(m.line, CheckPairing, m.tag, "END %s without START", m.tag)
+			continue
+		}
+		regions = append(regions, Region{Tag: m.tag, File: path, Start: open[i].line, End: m.line})
+		open = append(open[:i], open[i+1:]...)
+	}
+	for _, o := range open {
+		report(o.line, CheckPairing, o.tag, "START %s without END", o.tag)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+	return regions, problems, nil
+}
+
+// docComments returns the comment groups that go doc shows: the package
+// comment and the doc comments of exported declarations.
+func docComments(f *ast.File) map[*ast.CommentGroup]bool {
+	docs := make(map[*ast.CommentGroup]bool)
+	add := func(cg *ast.CommentGroup, name *ast.Ident) {
+		if cg != nil && (name == nil || name.IsExported()) {
+			docs[cg] = true
+		}
+	}
+	add(f.Doc, nil)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				add(d.Doc, d.Name)
+			}
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					add(d.Doc, s.Name)
+					add(s.Doc, s.Name)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						add(d.Doc, n)
+						add(s.Doc, n)
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+// Lint lints the Go files in and below each root, skipping testdata,
+// vendor and hidden directories. Files are parsed in parallel.
+func Lint(roots ...string) (*Result, error) {
+	var files []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".go") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type fileResult struct {
+		regions  []Region
+		problems []Problem
+	}
+	results := make([]fileResult, len(files))
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				regions, problems, err := LintFile(files[i], nil)
+				if err != nil {
+					problems = []Problem{{File: files[i], Line: 1, Check: CheckParse, Msg: fmt.Sprintf("could not parse: %v", err)}}
+				}
+				results[i] = fileResult{regions, problems}
+			}
+		}()
+	}
+	for i := range files {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	res := &Result{}
+	firstFile := make(map[string]Region)
+	for _, fr := range results {
+		for _, reg := range fr.regions {
+			if first, ok := firstFile[reg.Tag]; ok && first.File != reg.File {
+				res.Problems = append(res.Problems, Problem{
+					File:  reg.File,
+					Line:  reg.Start,
+					Check: CheckDuplicate,
+					Tag:   reg.Tag,
+					Msg:   fmt.Sprintf("tag %s is also used in %s:%d", reg.Tag, first.File, first.Start),
+				})
+			} else if !ok {
+				firstFile[reg.Tag] = reg
+			}
+		}
+		res.Regions = append(res.Regions, fr.regions...)
+		res.Problems = append(res.Problems, fr.problems...)
+	}
+	sort.SliceStable(res.Problems, func(i, j int) bool {
+		a, b := res.Problems[i], res.Problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return res, nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regiontag
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		regions []Region
+		checks  []string // Problem.Check of each problem
+		lines   []int    // Problem.Line of each problem
+	}{
+		{
+			name: "ok",
+			src: `package p
+
+// [START a]
+// [START b]
+import "fmt"
+// [END a]
+
+// [START a]
+func f() { fmt.Println() }
+// [END b]
+// [END a]
+`,
+			regions: []Region{
+				{Tag: "a", File: "f.go", Start: 3, End: 6},
+				{Tag: "b", File: "f.go", Start: 4, End: 10},
+				{Tag: "a", File: "f.go", Start: 8, End: 11},
+			},
+		},
+		{
+			name: "unpaired",
+			src: `package p
+
+// [START a]
+func f() {}
+
+// [END b]
+`,
+			checks: []string{CheckPairing, CheckPairing},
+			lines:  []int{6, 3},
+		},
+		{
+			name: "nested in itself",
+			src: `package p
+
+// [START a]
+func f() {
+	// [START a]
+}
+// [END a]
+`,
+			regions: []Region{{Tag: "a", File: "f.go", Start: 5, End: 7}},
+			checks:  []string{CheckPairing, CheckPairing},
+			lines:   []int{5, 3},
+		},
+		{
+			name: "build constraint",
+			src: `//go:build ignore
+
+package p
+
+// [START a]
+func f() {}
+
+// [END a]
+`,
+			regions: []Region{{Tag: "a", File: "f.go", Start: 5, End: 8}},
+			checks:  []string{CheckConstraint},
+			lines:   []int{5},
+		},
+		{
+			name: "doc comments",
+			src: `// [START a]
+package p
+
+// [START b]
+func F() {}
+
+// [START c]
+func f() {}
+
+// [END a]
+// [END b]
+// [END c]
+`,
+			regions: []Region{
+				{Tag: "a", File: "f.go", Start: 1, End: 10},
+				{Tag: "b", File: "f.go", Start: 4, End: 11},
+				{Tag: "c", File: "f.go", Start: 7, End: 12},
+			},
+			checks: []string{CheckDoc, CheckDoc},
+			lines:  []int{1, 4},
+		},
+	}
+	for _, tc := range tests {
+		regions, problems, err := LintFile("f.go", []byte(tc.src))
+		if err != nil {
+			t.Errorf("%s: LintFile: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(regions, tc.regions) {
+			t.Errorf("%s: got regions %+v, want %+v", tc.name, regions, tc.regions)
+		}
+		var checks []string
+		var lines []int
+		for _, p := range problems {
+			checks = append(checks, p.Check)
+			lines = append(lines, p.Line)
+		}
+		if !reflect.DeepEqual(checks, tc.checks) || !reflect.DeepEqual(lines, tc.lines) {
+			t.Errorf("%s: got problems %v, want checks %v on lines %v", tc.name, problems, tc.checks, tc.lines)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a/a.go":          "package a\n\n// [START dup]\nfunc a() {}\n\n// [END dup]\n",
+		"b/b.go":          "package b\n\n// [START dup]\nfunc b() {}\n\n// [END dup]\n",
+		"b/bad.go":        "package b\n\nfunc {\n",
+		"testdata/t.go":   "package t\n\n// [START skipped]\n",
+		".hidden/h.go":    "package h\n\n// [START skipped]\n",
+		"c/not_go.txt.md": "[START skipped]\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := Lint(dir)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	if got := len(res.Index()["dup"]); got != 2 {
+		t.Errorf("Index: got %d regions for dup, want 2", got)
+	}
+	var checks []string
+	for _, p := range res.Problems {
+		checks = append(checks, p.Check)
+	}
+	if want := []string{CheckDuplicate, CheckParse}; !reflect.DeepEqual(checks, want) {
+		t.Errorf("Lint: got problems %v, want checks %v", res.Problems, want)
+	}
+}
+
+// TestRepository lints the whole repository. Problems that predate the
+// linter are listed in allowlist.txt.
+func TestRepository(t *testing.T) {
+	const root = "../.."
+	f, err := os.Open("allowlist.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	allow, err := ParseAllowlist(f)
+	if err != nil {
+		t.Fatalf("ParseAllowlist: %v", err)
+	}
+
+	res, err := Lint(root)
+	if err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	for _, p := range res.Problems {
+		if !allow.Allows(root, p) {
+			t.Errorf("%v", p)
+		}
+	}
+	for _, k := range allow.Unused(root, res.Problems) {
+		t.Errorf("allowlist.txt: %q no longer matches a problem; remove it", k)
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	src := "# comment\n\nduplicate a/b.go imports\n  doc c.go intro  \n"
+	a, err := ParseAllowlist(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseAllowlist: %v", err)
+	}
+	tests := []struct {
+		p    Problem
+		want bool
+	}{
+		{p: Problem{File: "root/a/b.go", Check: CheckDuplicate, Tag: "imports"}, want: true},
+		{p: Problem{File: "root/c.go", Check: CheckDoc, Tag: "intro"}, want: true},
+		{p: Problem{File: "root/c.go", Check: CheckDuplicate, Tag: "intro"}},
+		{p: Problem{File: "root/a/b.go", Check: CheckDuplicate, Tag: "init"}},
+	}
+	for _, tc := range tests {
+		if got := a.Allows("root", tc.p); got != tc.want {
+			t.Errorf("Allows(%+v): got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if got, want := a.Unused("root", []Problem{tests[0].p}), []string{"doc c.go intro"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused: got %q, want %q", got, want)
+	}
+
+	if _, err := ParseAllowlist(strings.NewReader("duplicate a.go\n")); err == nil {
+		t.Errorf("ParseAllowlist with two fields: got no error")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command regiontaglint checks the region tags of the Go files in the given
+// directories, or the current directory, and their subdirectories. See
+// package regiontag for the checks. It exits with status 1 if there are
+// problems.
+//
+//	Usage of regiontaglint:
+//	  -allowlist file
+//	      Skip the known problems listed in file, with paths relative to each directory. See regiontag.Allowlist.
+//	  -index file
+//	      Write a JSON index mapping each tag to its regions to file, or - for stdout.
+//	  -skip list
+//	      Comma-separated list of checks to skip: pairing, duplicate, constraint, doc.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/golang-samples/internal/regiontag"
+)
+
+var (
+	allowlist = flag.String("allowlist", "", "Skip the known problems listed in `file`, with paths relative to each directory. See regiontag.Allowlist.")
+	index     = flag.String("index", "", "Write a JSON index mapping each tag to its regions to `file`, or - for stdout.")
+	skip      = flag.String("skip", "", "Comma-separated `list` of checks to skip: pairing, duplicate, constraint, doc.")
+)
+
+func main() {
+	flag.Parse()
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	skipped := make(map[string]bool)
+	if *skip != "" {
+		for _, c := range strings.Split(*skip, ",") {
+			skipped[c] = true
+		}
+	}
+
+	allow := regiontag.Allowlist{}
+	if *allowlist != "" {
+		f, err := os.Open(*allowlist)
+		if err != nil {
+			log.Fatal(err)
+		}
+		allow, err = regiontag.ParseAllowlist(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not read %s: %v", *allowlist, err)
+		}
+	}
+
+	res, err := regiontag.Lint(roots...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *index != "" {
+		out := os.Stdout
+		if *index != "-" {
+			if out, err = os.Create(*index); err != nil {
+				log.Fatal(err)
+			}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res.Index()); err != nil {
+			log.Fatalf("Could not write index: %v", err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatalf("Could not write index: %v", err)
+		}
+	}
+
+	failed := 0
+	for _, p := range res.Problems {
+		if skipped[p.Check] || allowed(allow, roots, p) {
+			continue
+		}
+		fmt.Fprintln(os.Stderr, p)
+		failed++
+	}
+	if failed != 0 {
+		log.Printf("FAILED (%d)", failed)
+		os.Exit(1)
+	}
+}
+
+// allowed reports whether p is in allow, relative to any of roots.
+func allowed(allow regiontag.Allowlist, roots []string, p regiontag.Problem) bool {
+	for _, root := range roots {
+		if allow.Allows(root, p) {
+			return true
+		}
+	}
+	return false
+}
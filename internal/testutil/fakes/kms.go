@@ -0,0 +1,514 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// KMS is an in-memory fake of the Cloud KMS API. It supports key rings,
+// symmetric encryption keys (GOOGLE_SYMMETRIC_ENCRYPTION) and EC signing keys
+// (EC_SIGN_P256_SHA256, EC_SIGN_P384_SHA384). Key material is generated
+// locally and ciphertexts are only meaningful to the same fake.
+type KMS struct {
+	*grpcFake
+	kmsServer
+}
+
+type kmsServer struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+
+	mu       sync.Mutex
+	keyRings map[string]*kmspb.KeyRing
+	keys     map[string]*fakeCryptoKey
+}
+
+type fakeCryptoKey struct {
+	key      *kmspb.CryptoKey
+	versions []*fakeKeyVersion // versions[i] has ID i+1.
+}
+
+type fakeKeyVersion struct {
+	version *kmspb.CryptoKeyVersion
+	aead    cipher.AEAD       // symmetric keys.
+	signer  *ecdsa.PrivateKey // signing keys.
+}
+
+// NewKMS starts a KMS fake that is stopped when the test ends.
+func NewKMS(t testing.TB) *KMS {
+	t.Helper()
+	k := &KMS{}
+	k.keyRings = make(map[string]*kmspb.KeyRing)
+	k.keys = make(map[string]*fakeCryptoKey)
+	k.grpcFake = newGRPCFake(t, func(s *grpc.Server) {
+		kmspb.RegisterKeyManagementServiceServer(s, &k.kmsServer)
+	})
+	return k
+}
+
+// checksum wraps the CRC32C checksum of b.
+func checksum(b []byte) *wrapperspb.Int64Value {
+	return wrapperspb.Int64(crc32c(b))
+}
+
+// verify checks an optional client-supplied checksum of b.
+func verify(b []byte, c *wrapperspb.Int64Value, field string) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	if c.GetValue() != crc32c(b) {
+		return false, status.Errorf(codes.InvalidArgument, "%s_crc32c does not match", field)
+	}
+	return true, nil
+}
+
+// newKeyVersion generates key material for a new version of k. The caller
+// must hold s.mu.
+func newKeyVersion(k *fakeCryptoKey) (*fakeKeyVersion, error) {
+	alg := k.key.GetVersionTemplate().GetAlgorithm()
+	v := &fakeKeyVersion{version: &kmspb.CryptoKeyVersion{
+		Name:            fmt.Sprintf("%s/cryptoKeyVersions/%d", k.key.Name, len(k.versions)+1),
+		State:           kmspb.CryptoKeyVersion_ENABLED,
+		ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+		Algorithm:       alg,
+		CreateTime:      timestamppb.Now(),
+		GenerateTime:    timestamppb.Now(),
+	}}
+	var err error
+	switch alg {
+	case kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION:
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if v.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	case kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
+		v.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
+		v.signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "fake: algorithm %s is not supported", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.versions = append(k.versions, v)
+	return v, nil
+}
+
+func (s *kmsServer) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest) (*kmspb.KeyRing, error) {
+	name := req.GetParent() + "/keyRings/" + req.GetKeyRingId()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keyRings[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "KeyRing %s already exists.", name)
+	}
+	kr := &kmspb.KeyRing{Name: name, CreateTime: timestamppb.Now()}
+	s.keyRings[name] = kr
+	return proto.Clone(kr).(*kmspb.KeyRing), nil
+}
+
+func (s *kmsServer) GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest) (*kmspb.KeyRing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kr, ok := s.keyRings[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "KeyRing %s not found.", req.GetName())
+	}
+	return proto.Clone(kr).(*kmspb.KeyRing), nil
+}
+
+func (s *kmsServer) ListKeyRings(ctx context.Context, req *kmspb.ListKeyRingsRequest) (*kmspb.ListKeyRingsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &kmspb.ListKeyRingsResponse{}
+	for name, kr := range s.keyRings {
+		if strings.HasPrefix(name, req.GetParent()+"/keyRings/") {
+			resp.KeyRings = append(resp.KeyRings, proto.Clone(kr).(*kmspb.KeyRing))
+		}
+	}
+	sort.Slice(resp.KeyRings, func(i, j int) bool { return resp.KeyRings[i].Name < resp.KeyRings[j].Name })
+	resp.TotalSize = int32(len(resp.KeyRings))
+	return resp, nil
+}
+
+// cryptoKey returns a copy of k with its primary version set.
+func cryptoKey(k *fakeCryptoKey) *kmspb.CryptoKey {
+	c := proto.Clone(k.key).(*kmspb.CryptoKey)
+	if c.Primary != nil {
+		for _, v := range k.versions {
+			if v.version.Name == c.Primary.Name {
+				c.Primary = proto.Clone(v.version).(*kmspb.CryptoKeyVersion)
+			}
+		}
+	}
+	return c
+}
+
+func (s *kmsServer) CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	name := req.GetParent() + "/cryptoKeys/" + req.GetCryptoKeyId()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keyRings[req.GetParent()]; !ok {
+		return nil, status.Errorf(codes.NotFound, "KeyRing %s not found.", req.GetParent())
+	}
+	if _, ok := s.keys[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "CryptoKey %s already exists.", name)
+	}
+	key := proto.Clone(req.GetCryptoKey()).(*kmspb.CryptoKey)
+	if key == nil {
+		key = &kmspb.CryptoKey{}
+	}
+	key.Name = name
+	key.CreateTime = timestamppb.Now()
+	if key.VersionTemplate == nil {
+		key.VersionTemplate = &kmspb.CryptoKeyVersionTemplate{}
+	}
+	if key.VersionTemplate.Algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED {
+		if key.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			return nil, status.Error(codes.InvalidArgument, "version_template.algorithm is required")
+		}
+		key.VersionTemplate.Algorithm = kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION
+	}
+	k := &fakeCryptoKey{key: key}
+	if !req.GetSkipInitialVersionCreation() {
+		v, err := newKeyVersion(k)
+		if err != nil {
+			return nil, err
+		}
+		if key.Purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			key.Primary = &kmspb.CryptoKeyVersion{Name: v.version.Name}
+		}
+	}
+	s.keys[name] = k
+	return cryptoKey(k), nil
+}
+
+func (s *kmsServer) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, err := s.key(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return cryptoKey(k), nil
+}
+
+func (s *kmsServer) ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest) (*kmspb.ListCryptoKeysResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &kmspb.ListCryptoKeysResponse{}
+	for name, k := range s.keys {
+		if strings.HasPrefix(name, req.GetParent()+"/cryptoKeys/") {
+			resp.CryptoKeys = append(resp.CryptoKeys, cryptoKey(k))
+		}
+	}
+	sort.Slice(resp.CryptoKeys, func(i, j int) bool { return resp.CryptoKeys[i].Name < resp.CryptoKeys[j].Name })
+	resp.TotalSize = int32(len(resp.CryptoKeys))
+	return resp, nil
+}
+
+// key returns the named key. The caller must hold s.mu.
+func (s *kmsServer) key(name string) (*fakeCryptoKey, error) {
+	k, ok := s.keys[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "CryptoKey %s not found.", name)
+	}
+	return k, nil
+}
+
+// keyVersion returns the named key version. The caller must hold s.mu.
+func (s *kmsServer) keyVersion(name string) (*fakeCryptoKey, *fakeKeyVersion, error) {
+	i := strings.LastIndex(name, "/cryptoKeyVersions/")
+	if i < 0 {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid key version name %q", name)
+	}
+	k, err := s.key(name[:i])
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := strconv.Atoi(name[i+len("/cryptoKeyVersions/"):])
+	if err != nil || n < 1 || n > len(k.versions) {
+		return nil, nil, status.Errorf(codes.NotFound, "CryptoKeyVersion %s not found.", name)
+	}
+	return k, k.versions[n-1], nil
+}
+
+// enabled returns an error unless v can be used.
+func enabled(v *fakeKeyVersion) error {
+	if v.version.State != kmspb.CryptoKeyVersion_ENABLED {
+		return status.Errorf(codes.FailedPrecondition, "%s is not enabled, current state is: %s.", v.version.Name, v.version.State)
+	}
+	return nil
+}
+
+func (s *kmsServer) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, err := s.key(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	v, err := newKeyVersion(k)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.version).(*kmspb.CryptoKeyVersion), nil
+}
+
+func (s *kmsServer) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.keyVersion(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.version).(*kmspb.CryptoKeyVersion), nil
+}
+
+func (s *kmsServer) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, err := s.key(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	resp := &kmspb.ListCryptoKeyVersionsResponse{TotalSize: int32(len(k.versions))}
+	for _, v := range k.versions {
+		resp.CryptoKeyVersions = append(resp.CryptoKeyVersions, proto.Clone(v.version).(*kmspb.CryptoKeyVersion))
+	}
+	return resp, nil
+}
+
+func (s *kmsServer) UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest) (*kmspb.CryptoKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, v, err := s.keyVersion(req.GetName() + "/cryptoKeyVersions/" + req.GetCryptoKeyVersionId())
+	if err != nil {
+		return nil, err
+	}
+	if k.key.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return nil, status.Error(codes.FailedPrecondition, "only ENCRYPT_DECRYPT keys have a primary version")
+	}
+	if err := enabled(v); err != nil {
+		return nil, err
+	}
+	k.key.Primary = &kmspb.CryptoKeyVersion{Name: v.version.Name}
+	return cryptoKey(k), nil
+}
+
+// DestroyCryptoKeyVersion schedules the version for destruction, which makes
+// it unusable immediately.
+func (s *kmsServer) DestroyCryptoKeyVersion(ctx context.Context, req *kmspb.DestroyCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.keyVersion(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	v.version.State = kmspb.CryptoKeyVersion_DESTROY_SCHEDULED
+	v.version.DestroyTime = timestamppb.Now()
+	return proto.Clone(v.version).(*kmspb.CryptoKeyVersion), nil
+}
+
+// Encrypt encrypts with the primary version of a key, or with the named
+// version. The ciphertext is the version number, a nonce and the AES-GCM
+// sealed plaintext.
+func (s *kmsServer) Encrypt(ctx context.Context, req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	verifiedPlaintext, err := verify(req.GetPlaintext(), req.GetPlaintextCrc32C(), "plaintext")
+	if err != nil {
+		return nil, err
+	}
+	verifiedAAD, err := verify(req.GetAdditionalAuthenticatedData(), req.GetAdditionalAuthenticatedDataCrc32C(), "additional_authenticated_data")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := req.GetName()
+	if !strings.Contains(name, "/cryptoKeyVersions/") {
+		k, err := s.key(name)
+		if err != nil {
+			return nil, err
+		}
+		if k.key.Primary == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s has no primary version", name)
+		}
+		name = k.key.Primary.Name
+	}
+	_, v, err := s.keyVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := enabled(v); err != nil {
+		return nil, err
+	}
+	if v.aead == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not a symmetric encryption key", name)
+	}
+
+	n, _ := strconv.Atoi(name[strings.LastIndex(name, "/")+1:])
+	ciphertext := binary.BigEndian.AppendUint32(nil, uint32(n))
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = v.aead.Seal(ciphertext, nonce, req.GetPlaintext(), req.GetAdditionalAuthenticatedData())
+	return &kmspb.EncryptResponse{
+		Name:                    name,
+		Ciphertext:              ciphertext,
+		CiphertextCrc32C:        checksum(ciphertext),
+		VerifiedPlaintextCrc32C: verifiedPlaintext,
+		VerifiedAdditionalAuthenticatedDataCrc32C: verifiedAAD,
+		ProtectionLevel: v.version.ProtectionLevel,
+	}, nil
+}
+
+func (s *kmsServer) Decrypt(ctx context.Context, req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	ciphertext := req.GetCiphertext()
+	if _, err := verify(ciphertext, req.GetCiphertextCrc32C(), "ciphertext"); err != nil {
+		return nil, err
+	}
+	if _, err := verify(req.GetAdditionalAuthenticatedData(), req.GetAdditionalAuthenticatedDataCrc32C(), "additional_authenticated_data"); err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < 4 {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, err := s.key(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s/cryptoKeyVersions/%d", req.GetName(), binary.BigEndian.Uint32(ciphertext))
+	_, v, err := s.keyVersion(name)
+	if err != nil || v.aead == nil {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	if err := enabled(v); err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[4:]
+	if len(ciphertext) < v.aead.NonceSize() {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	nonce, sealed := ciphertext[:v.aead.NonceSize()], ciphertext[v.aead.NonceSize():]
+	plaintext, err := v.aead.Open(nil, nonce, sealed, req.GetAdditionalAuthenticatedData())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	return &kmspb.DecryptResponse{
+		Plaintext:       plaintext,
+		PlaintextCrc32C: checksum(plaintext),
+		UsedPrimary:     k.key.Primary != nil && k.key.Primary.Name == name,
+		ProtectionLevel: v.version.ProtectionLevel,
+	}, nil
+}
+
+func (s *kmsServer) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.keyVersion(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if v.signer == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not an asymmetric key", req.GetName())
+	}
+	der, err := x509.MarshalPKIXPublicKey(&v.signer.PublicKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	p := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return &kmspb.PublicKey{
+		Pem:             p,
+		PemCrc32C:       checksum([]byte(p)),
+		Algorithm:       v.version.Algorithm,
+		Name:            v.version.Name,
+		ProtectionLevel: v.version.ProtectionLevel,
+	}, nil
+}
+
+// AsymmetricSign signs a digest with an EC key, returning an ASN.1 DER
+// encoded signature.
+func (s *kmsServer) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	digest := req.GetDigest().GetSha256()
+	if d := req.GetDigest().GetSha384(); d != nil {
+		digest = d
+	}
+	if len(digest) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "fake: a SHA-256 or SHA-384 digest is required")
+	}
+	verified, err := verify(digest, req.GetDigestCrc32C(), "digest")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.keyVersion(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if err := enabled(v); err != nil {
+		return nil, err
+	}
+	if v.signer == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not a signing key", req.GetName())
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, v.signer, digest)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &kmspb.AsymmetricSignResponse{
+		Signature:            sig,
+		SignatureCrc32C:      checksum(sig),
+		VerifiedDigestCrc32C: verified,
+		Name:                 v.version.Name,
+		ProtectionLevel:      v.version.ProtectionLevel,
+	}, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newKMSClient(t *testing.T) (*KMS, *kms.KeyManagementClient, string) {
+	t.Helper()
+	ctx := context.Background()
+	fake := NewKMS(t)
+	client, err := kms.NewKeyManagementClient(ctx, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("kms.NewKeyManagementClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	kr, err := client.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
+		Parent:    "projects/my-project/locations/global",
+		KeyRingId: "my-ring",
+	})
+	if err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	return fake, client, kr.Name
+}
+
+func TestKMSEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	fake, client, keyRing := newKMSClient(t)
+
+	key, err := client.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+		Parent:      keyRing,
+		CryptoKeyId: "my-key",
+		CryptoKey:   &kmspb.CryptoKey{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT},
+	})
+	if err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+	if key.GetPrimary().GetState() != kmspb.CryptoKeyVersion_ENABLED {
+		t.Errorf("CreateCryptoKey: got primary %v, want enabled version", key.GetPrimary())
+	}
+
+	plaintext := []byte("attack at dawn")
+	enc, err := client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:            key.Name,
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(crc32c(plaintext)),
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !enc.VerifiedPlaintextCrc32C || enc.GetCiphertextCrc32C().GetValue() != crc32c(enc.Ciphertext) {
+		t.Errorf("Encrypt: checksums not verified or wrong: %v", enc)
+	}
+
+	// Rotating the primary version must not break old ciphertexts.
+	v2, err := client.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{Parent: key.Name})
+	if err != nil {
+		t.Fatalf("CreateCryptoKeyVersion: %v", err)
+	}
+	if _, err := client.UpdateCryptoKeyPrimaryVersion(ctx, &kmspb.UpdateCryptoKeyPrimaryVersionRequest{Name: key.Name, CryptoKeyVersionId: "2"}); err != nil {
+		t.Fatalf("UpdateCryptoKeyPrimaryVersion: %v", err)
+	}
+	dec, err := client.Decrypt(ctx, &kmspb.DecryptRequest{Name: key.Name, Ciphertext: enc.Ciphertext})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(dec.Plaintext) != string(plaintext) || dec.UsedPrimary {
+		t.Errorf("Decrypt: got (%q, used primary %v), want (%q, false)", dec.Plaintext, dec.UsedPrimary, plaintext)
+	}
+
+	if _, err := client.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{Name: enc.Name}); err != nil {
+		t.Fatalf("DestroyCryptoKeyVersion: %v", err)
+	}
+	_, err = client.Decrypt(ctx, &kmspb.DecryptRequest{Name: key.Name, Ciphertext: enc.Ciphertext})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Decrypt(destroyed): got %v, want %v", err, codes.FailedPrecondition)
+	}
+	enc2, err := client.Encrypt(ctx, &kmspb.EncryptRequest{Name: key.Name, Plaintext: plaintext})
+	if err != nil || enc2.Name != v2.Name {
+		t.Errorf("Encrypt after rotation: got (%v, %v), want version %s", enc2.GetName(), err, v2.Name)
+	}
+
+	if n := fake.Count("Encrypt"); n != 2 {
+		t.Errorf("Count(Encrypt): got %d, want 2", n)
+	}
+}
+
+func TestKMSAsymmetricSign(t *testing.T) {
+	ctx := context.Background()
+	_, client, keyRing := newKMSClient(t)
+
+	key, err := client.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+		Parent:      keyRing,
+		CryptoKeyId: "signer",
+		CryptoKey: &kmspb.CryptoKey{
+			Purpose:         kmspb.CryptoKey_ASYMMETRIC_SIGN,
+			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+	version := key.Name + "/cryptoKeyVersions/1"
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+		Name:   version,
+		Digest: &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest[:]}},
+	})
+	if err != nil {
+		t.Fatalf("AsymmetricSign: %v", err)
+	}
+	pub, err := client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: version})
+	if err != nil {
+		t.Fatalf("GetPublicKey: %v", err)
+	}
+	block, _ := pem.Decode([]byte(pub.Pem))
+	if block == nil {
+		t.Fatalf("GetPublicKey: invalid PEM %q", pub.Pem)
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("x509.ParsePKIXPublicKey: %v", err)
+	}
+	if !ecdsa.VerifyASN1(k.(*ecdsa.PublicKey), digest[:], sig.Signature) {
+		t.Errorf("AsymmetricSign: signature does not verify")
+	}
+
+	_, err = client.Encrypt(ctx, &kmspb.EncryptRequest{Name: version, Plaintext: []byte("x")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Encrypt with signing key: got %v, want %v", err, codes.FailedPrecondition)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc"
+)
+
+// PubSub is a fake of the Pub/Sub API, backed by pstest.
+type PubSub struct {
+	*grpcFake
+	// Server is the underlying pstest server. Use it to publish messages or
+	// inspect published ones directly.
+	Server *pstest.Server
+}
+
+// NewPubSub starts a Pub/Sub fake that is stopped when the test ends.
+func NewPubSub(t testing.TB) *PubSub {
+	t.Helper()
+	ps := pstest.NewServer()
+	t.Cleanup(func() { ps.Close() })
+	f := newGRPCFake(t, func(s *grpc.Server) {
+		pubsubpb.RegisterPublisherServer(s, &ps.GServer)
+		pubsubpb.RegisterSubscriberServer(s, &ps.GServer)
+		pubsubpb.RegisterSchemaServiceServer(s, &ps.GServer)
+	})
+	return &PubSub{grpcFake: f, Server: ps}
+}
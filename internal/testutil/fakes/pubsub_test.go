@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub(t *testing.T) {
+	ctx := context.Background()
+	fake := NewPubSub(t)
+	client, err := pubsub.NewClient(ctx, "my-project", fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("pubsub.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	topic, err := client.CreateTopic(ctx, "my-topic")
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	defer topic.Stop()
+	sub, err := client.CreateSubscription(ctx, "my-sub", pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatalf("CreateSubscription: %v", err)
+	}
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: []byte("hello")}).Get(ctx); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var got string
+	err = sub.Receive(cctx, func(ctx context.Context, m *pubsub.Message) {
+		got = string(m.Data)
+		m.Ack()
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if got != "hello" {
+		t.Errorf("Receive: got %q, want %q", got, "hello")
+	}
+
+	if msgs := fake.Server.Messages(); len(msgs) != 1 {
+		t.Errorf("Messages: got %d, want 1", len(msgs))
+	}
+	if n := fake.Count("CreateTopic"); n != 1 {
+		t.Errorf("Count(CreateTopic): got %d, want 1", n)
+	}
+	if n := fake.Count("StreamingPull"); n == 0 {
+		t.Errorf("Count(StreamingPull): got 0, want streaming calls to be recorded")
+	}
+}
@@ -0,0 +1,742 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3 is an in-memory fake of the Amazon S3 REST API, as far as the Cloud
+// Storage XML API implements it for interoperability. It supports path-style
+// requests to list, create and delete buckets, to put, get, copy and delete
+// objects, multipart uploads and multi-object delete. Requests must be
+// signed with AWS Signature Version 4, in a header or a presigned URL, with
+// the fake's HMAC credentials.
+//
+// With GCS set, the fake rejects what the Cloud Storage XML API does not
+// implement: multi-object delete and copying into an upload part.
+type S3 struct {
+	Recorder
+
+	// AccessKeyID and SecretKey are the credentials requests are signed
+	// with. If AccessKeyID is empty, requests need not be signed.
+	AccessKeyID string
+	SecretKey   string
+	// GCS makes the fake behave like the Cloud Storage XML API where it
+	// differs from S3.
+	GCS bool
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*s3Bucket
+	uploads map[string]*s3Upload
+	nextID  int
+}
+
+type s3Bucket struct {
+	created time.Time
+	objects map[string]*s3Object
+}
+
+type s3Object struct {
+	data        []byte
+	etag        string
+	contentType string
+	meta        http.Header
+	modified    time.Time
+}
+
+// s3Upload is a multipart upload in progress.
+type s3Upload struct {
+	bucket, key string
+	contentType string
+	meta        http.Header
+	parts       map[int]*s3Object
+}
+
+// s3MinPartSize is the minimum size of all but the last part of a multipart
+// upload.
+const s3MinPartSize = 5 << 20
+
+// NewS3 starts an S3 fake that is stopped when the test ends.
+func NewS3(t testing.TB) *S3 {
+	t.Helper()
+	s := &S3{
+		AccessKeyID: "GOOGFAKEACCESSKEY",
+		SecretKey:   "fake-secret-key",
+		buckets:     make(map[string]*s3Bucket),
+		uploads:     make(map[string]*s3Upload),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL returns the endpoint of the fake.
+func (s *S3) URL() string {
+	return s.srv.URL
+}
+
+// AddObject stores an object for test setup, creating its bucket if needed.
+func (s *S3) AddObject(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = &s3Bucket{created: time.Now(), objects: make(map[string]*s3Object)}
+		s.buckets[bucket] = b
+	}
+	b.objects[key] = newS3Object(data, "application/octet-stream", nil)
+}
+
+// Object returns the contents of an object, and whether it exists.
+func (s *S3) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	o, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.data...), true
+}
+
+func newS3Object(data []byte, contentType string, meta http.Header) *s3Object {
+	sum := md5.Sum(data)
+	return &s3Object{
+		data:        data,
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		contentType: contentType,
+		meta:        meta,
+		modified:    time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// s3Error is an error in the S3 XML error format.
+type s3Error struct {
+	status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string { return e.Code + ": " + e.Message }
+
+func s3Errorf(status int, code, format string, args ...interface{}) error {
+	return &s3Error{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// writeXML writes v with status, or err in the S3 error format.
+func writeXML(w http.ResponseWriter, status int, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/xml")
+	if err != nil {
+		e, ok := err.(*s3Error)
+		if !ok {
+			e = &s3Error{status: http.StatusInternalServerError, Code: "InternalError", Message: err.Error()}
+		}
+		w.WriteHeader(e.status)
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"Error"`
+			Code    string
+			Message string
+		}{Code: e.Code, Message: e.Message})
+		return
+	}
+	w.WriteHeader(status)
+	if v != nil {
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(v)
+	}
+}
+
+func (s *S3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.record(Call{Method: r.Method + " " + r.URL.Path, Query: r.URL.Query()})
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeXML(w, 0, nil, s3Errorf(http.StatusBadRequest, "IncompleteBody", "%v", err))
+		return
+	}
+	if err := s.authenticate(r, body); err != nil {
+		writeXML(w, 0, nil, err)
+		return
+	}
+
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	q := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		s.listBuckets(w)
+	case bucket == "":
+		writeXML(w, 0, nil, s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s /", r.Method))
+	case key == "":
+		s.serveBucket(w, r, bucket, q, body)
+	default:
+		s.serveObject(w, r, bucket, key, q, body)
+	}
+}
+
+func (s *S3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, q url.Values, body []byte) {
+	switch r.Method {
+	case http.MethodPut:
+		if _, ok := s.buckets[bucket]; ok {
+			writeXML(w, 0, nil, s3Errorf(http.StatusConflict, "BucketAlreadyOwnedByYou", "bucket %s already exists", bucket))
+			return
+		}
+		s.buckets[bucket] = &s3Bucket{created: time.Now(), objects: make(map[string]*s3Object)}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	b, err := s.bucket(bucket)
+	if err != nil {
+		writeXML(w, 0, nil, err)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet:
+		v, err := b.list(bucket, q)
+		writeXML(w, http.StatusOK, v, err)
+	case r.Method == http.MethodPost && q.Has("delete"):
+		if s.GCS {
+			writeXML(w, 0, nil, s3Errorf(http.StatusNotImplemented, "NotImplemented", "multi-object delete is not implemented"))
+			return
+		}
+		v, err := b.deleteMultiple(body)
+		writeXML(w, http.StatusOK, v, err)
+	case r.Method == http.MethodDelete:
+		if len(b.objects) > 0 {
+			writeXML(w, 0, nil, s3Errorf(http.StatusConflict, "BucketNotEmpty", "bucket %s is not empty", bucket))
+			return
+		}
+		delete(s.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeXML(w, 0, nil, s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s on a bucket", r.Method))
+	}
+}
+
+func (s *S3) bucket(name string) (*s3Bucket, error) {
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, s3Errorf(http.StatusNotFound, "NoSuchBucket", "bucket %s does not exist", name)
+	}
+	return b, nil
+}
+
+func (s *S3) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string
+		CreationDate time.Time
+	}
+	var v struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}
+	for name, b := range s.buckets {
+		v.Buckets = append(v.Buckets, bucket{Name: name, CreationDate: b.created})
+	}
+	sort.Slice(v.Buckets, func(i, j int) bool { return v.Buckets[i].Name < v.Buckets[j].Name })
+	writeXML(w, http.StatusOK, &v, nil)
+}
+
+type s3Contents struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	KeyCount              int    `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []s3Contents
+	CommonPrefixes        []string `xml:"CommonPrefixes>Prefix"`
+}
+
+// list implements ListObjects and, with list-type=2, ListObjectsV2.
+func (b *s3Bucket) list(name string, q url.Values) (*s3ListResult, error) {
+	v2 := q.Get("list-type") == "2"
+	res := &s3ListResult{Name: name, Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter"), MaxKeys: 1000}
+	if m := q.Get("max-keys"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 0 {
+			return nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid max-keys %q", m)
+		}
+		res.MaxKeys = n
+	}
+	after := q.Get("marker")
+	if v2 {
+		res.StartAfter, res.ContinuationToken = q.Get("start-after"), q.Get("continuation-token")
+		after = res.StartAfter
+		if res.ContinuationToken != "" {
+			after = res.ContinuationToken
+		}
+	} else {
+		res.Marker = after
+	}
+
+	keys := make([]string, 0, len(b.objects))
+	for k := range b.objects {
+		if !strings.HasPrefix(k, res.Prefix) || k <= after {
+			continue
+		}
+		// A marker that is a common prefix continues after all its keys.
+		if res.Delimiter != "" && strings.HasSuffix(after, res.Delimiter) && strings.HasPrefix(k, after) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var last string
+	for _, k := range keys {
+		if p := commonPrefix(k, res.Prefix, res.Delimiter); p != "" {
+			if n := len(res.CommonPrefixes); n > 0 && res.CommonPrefixes[n-1] == p {
+				continue
+			}
+		}
+		if len(res.Contents)+len(res.CommonPrefixes) == res.MaxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if p := commonPrefix(k, res.Prefix, res.Delimiter); p != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, p)
+			last = p
+			continue
+		}
+		o := b.objects[k]
+		res.Contents = append(res.Contents, s3Contents{Key: k, LastModified: o.modified, ETag: o.etag, Size: int64(len(o.data)), StorageClass: "STANDARD"})
+		last = k
+	}
+	if res.IsTruncated {
+		if v2 {
+			res.NextContinuationToken = last
+		} else if res.Delimiter != "" {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+	}
+	return res, nil
+}
+
+// commonPrefix returns the prefix key is rolled up into, or "".
+func commonPrefix(key, prefix, delim string) string {
+	if delim == "" {
+		return ""
+	}
+	if i := strings.Index(key[len(prefix):], delim); i >= 0 {
+		return key[:len(prefix)+i+len(delim)]
+	}
+	return ""
+}
+
+func (b *s3Bucket) deleteMultiple(body []byte) (interface{}, error) {
+	var req struct {
+		Quiet   bool
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return nil, s3Errorf(http.StatusBadRequest, "MalformedXML", "%v", err)
+	}
+	if len(req.Objects) > 1000 {
+		return nil, s3Errorf(http.StatusBadRequest, "MalformedXML", "more than 1000 objects")
+	}
+	type deleted struct{ Key string }
+	var res struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}
+	for _, o := range req.Objects {
+		delete(b.objects, o.Key)
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, deleted{Key: o.Key})
+		}
+	}
+	return &res, nil
+}
+
+func (s *S3) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string, q url.Values, body []byte) {
+	b, err := s.bucket(bucket)
+	if err != nil {
+		writeXML(w, 0, nil, err)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = &s3Upload{bucket: bucket, key: key, contentType: r.Header.Get("Content-Type"), meta: userMetadata(r.Header), parts: make(map[int]*s3Object)}
+		writeXML(w, http.StatusOK, &struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id}, nil)
+	case q.Has("uploadId"):
+		s.serveUpload(w, r, b, bucket, key, q, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, err := s.copySource(r.Header.Get("X-Amz-Copy-Source"))
+		if err != nil {
+			writeXML(w, 0, nil, err)
+			return
+		}
+		o := newS3Object(src.data, src.contentType, src.meta)
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			o.contentType, o.meta = r.Header.Get("Content-Type"), userMetadata(r.Header)
+		}
+		b.objects[key] = o
+		writeXML(w, http.StatusOK, &struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			LastModified time.Time
+			ETag         string
+		}{LastModified: o.modified, ETag: o.etag}, nil)
+	case r.Method == http.MethodPut:
+		o := newS3Object(body, r.Header.Get("Content-Type"), userMetadata(r.Header))
+		b.objects[key] = o
+		w.Header().Set("ETag", o.etag)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		o, ok := b.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeXML(w, 0, nil, s3Errorf(http.StatusNotFound, "NoSuchKey", "object %s does not exist", key))
+			return
+		}
+		serveS3Object(w, r, o)
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeXML(w, 0, nil, s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s on an object", r.Method))
+	}
+}
+
+// copySource returns the object named by an x-amz-copy-source header.
+func (s *S3) copySource(h string) (*s3Object, error) {
+	src, err := url.PathUnescape(strings.TrimPrefix(h, "/"))
+	if err != nil {
+		return nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid copy source %q", h)
+	}
+	i := strings.Index(src, "/")
+	if i < 0 {
+		return nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid copy source %q", h)
+	}
+	b, err := s.bucket(src[:i])
+	if err != nil {
+		return nil, err
+	}
+	o, ok := b.objects[src[i+1:]]
+	if !ok {
+		return nil, s3Errorf(http.StatusNotFound, "NoSuchKey", "object %s does not exist", src[i+1:])
+	}
+	return o, nil
+}
+
+// userMetadata returns the x-amz-meta- headers of h.
+func userMetadata(h http.Header) http.Header {
+	meta := make(http.Header)
+	for k, v := range h {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+var rangeRE = regexp.MustCompile(`^bytes=(\d+)-(\d*)$`)
+
+func serveS3Object(w http.ResponseWriter, r *http.Request, o *s3Object) {
+	for k, v := range o.meta {
+		w.Header()[k] = v
+	}
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Content-Type", o.contentType)
+	w.Header().Set("Last-Modified", o.modified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	data, status := o.data, http.StatusOK
+	if h := r.Header.Get("Range"); h != "" {
+		m := rangeRE.FindStringSubmatch(h)
+		if m == nil {
+			writeXML(w, 0, nil, s3Errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "unsupported range %q", h))
+			return
+		}
+		start, _ := strconv.Atoi(m[1])
+		end := len(data) - 1
+		if m[2] != "" {
+			if e, _ := strconv.Atoi(m[2]); e < end {
+				end = e
+			}
+		}
+		if start > end {
+			writeXML(w, 0, nil, s3Errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "range %q of %d bytes", h, len(data)))
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data, status = data[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// serveUpload handles the requests on a multipart upload.
+func (s *S3) serveUpload(w http.ResponseWriter, r *http.Request, b *s3Bucket, bucket, key string, q url.Values, body []byte) {
+	id := q.Get("uploadId")
+	u, ok := s.uploads[id]
+	if !ok || u.bucket != bucket || u.key != key {
+		writeXML(w, 0, nil, s3Errorf(http.StatusNotFound, "NoSuchUpload", "upload %s does not exist", id))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if err != nil || n < 1 || n > 10000 {
+			writeXML(w, 0, nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid part number %q", q.Get("partNumber")))
+			return
+		}
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			if s.GCS {
+				writeXML(w, 0, nil, s3Errorf(http.StatusNotImplemented, "NotImplemented", "copying into an upload part is not implemented"))
+				return
+			}
+			src, err := s.copySource(r.Header.Get("X-Amz-Copy-Source"))
+			if err != nil {
+				writeXML(w, 0, nil, err)
+				return
+			}
+			body = src.data
+		}
+		p := newS3Object(body, "", nil)
+		u.parts[n] = p
+		w.Header().Set("ETag", p.etag)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(s.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		o, err := u.complete(body)
+		if err != nil {
+			writeXML(w, 0, nil, err)
+			return
+		}
+		delete(s.uploads, id)
+		b.objects[key] = o
+		writeXML(w, http.StatusOK, &struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: o.etag}, nil)
+	default:
+		writeXML(w, 0, nil, s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "%s on an upload", r.Method))
+	}
+}
+
+// complete assembles the parts listed in a CompleteMultipartUpload request.
+// The ETag of the object is the MD5 of the part MD5s and the part count.
+func (u *s3Upload) complete(body []byte) (*s3Object, error) {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		return nil, s3Errorf(http.StatusBadRequest, "MalformedXML", "invalid part list")
+	}
+	for i := 1; i < len(req.Parts); i++ {
+		if req.Parts[i].PartNumber <= req.Parts[i-1].PartNumber {
+			return nil, s3Errorf(http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+		}
+	}
+	var data, sums []byte
+	for i, rp := range req.Parts {
+		p, ok := u.parts[rp.PartNumber]
+		if !ok || strings.Trim(rp.ETag, `"`) != strings.Trim(p.etag, `"`) {
+			return nil, s3Errorf(http.StatusBadRequest, "InvalidPart", "part %d not found", rp.PartNumber)
+		}
+		if i < len(req.Parts)-1 && len(p.data) < s3MinPartSize {
+			return nil, s3Errorf(http.StatusBadRequest, "EntityTooSmall", "part %d is smaller than 5 MiB", rp.PartNumber)
+		}
+		data = append(data, p.data...)
+		sum, _ := hex.DecodeString(strings.Trim(p.etag, `"`))
+		sums = append(sums, sum...)
+	}
+	o := newS3Object(data, u.contentType, u.meta)
+	sum := md5.Sum(sums)
+	o.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	return o, nil
+}
+
+// authenticate checks the AWS Signature Version 4 of r, from its
+// Authorization header or, for presigned URLs, its query.
+func (s *S3) authenticate(r *http.Request, body []byte) error {
+	if s.AccessKeyID == "" {
+		return nil
+	}
+	q := r.URL.Query()
+	var credential, signedHeaders, signature, date, payloadHash string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+			return s3Errorf(http.StatusBadRequest, "InvalidRequest", "unsupported authorization %q", auth)
+		}
+		for _, f := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(f), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				signature = v
+			}
+		}
+		date = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return s3Errorf(http.StatusBadRequest, "InvalidRequest", "missing x-amz-content-sha256")
+		}
+		if payloadHash != "UNSIGNED-PAYLOAD" {
+			if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != payloadHash {
+				return s3Errorf(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash mismatch")
+			}
+		}
+	} else if q.Get("X-Amz-Algorithm") == "AWS4-HMAC-SHA256" {
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		date, payloadHash = q.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+		t, err := time.Parse("20060102T150405Z", date)
+		expires, err2 := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || err2 != nil || expires > 7*24*60*60 {
+			return s3Errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "invalid X-Amz-Date or X-Amz-Expires")
+		}
+		if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+			return s3Errorf(http.StatusForbidden, "AccessDenied", "Request has expired")
+		}
+		q.Del("X-Amz-Signature")
+	} else {
+		return s3Errorf(http.StatusForbidden, "AccessDenied", "anonymous access is not allowed")
+	}
+
+	// Credential is ACCESS_KEY/DATE/REGION/SERVICE/aws4_request.
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[4] != "aws4_request" {
+		return s3Errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "invalid credential %q", credential)
+	}
+	if scope[0] != s.AccessKeyID {
+		return s3Errorf(http.StatusForbidden, "InvalidAccessKeyId", "unknown access key %s", scope[0])
+	}
+
+	var headers []string
+	for _, h := range strings.Split(signedHeaders, ";") {
+		var v string
+		switch h {
+		case "host":
+			v = r.Host
+		case "content-length":
+			v = strconv.FormatInt(r.ContentLength, 10)
+		case "expect":
+			// The server consumes Expect: 100-continue.
+			v = "100-continue"
+		default:
+			vals := r.Header.Values(h)
+			for i := range vals {
+				vals[i] = strings.TrimSpace(vals[i])
+			}
+			v = strings.Join(strings.Fields(strings.Join(vals, ",")), " ")
+		}
+		headers = append(headers, h+":"+v)
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		awsEscapePath(r.URL.Path),
+		strings.Replace(q.Encode(), "+", "%20", -1),
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, strings.Join(scope[1:], "/"), hex.EncodeToString(sum[:])}, "\n")
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range scope[1:] {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, toSign)); !hmac.Equal([]byte(want), []byte(signature)) {
+		return s3Errorf(http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match")
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscapePath escapes p as in a canonical request: every byte but
+// unreserved characters and "/" is percent-encoded.
+func awsEscapePath(p string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// s3Do makes an unsigned request to the fake and returns the status and
+// body of the response.
+func s3Do(t *testing.T, s *S3, method, path string, header http.Header, body []byte) (int, http.Header, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL()+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp.StatusCode, resp.Header, string(b)
+}
+
+func TestS3(t *testing.T) {
+	s := NewS3(t)
+	if code, _, body := s3Do(t, s, "GET", "/", nil, nil); code != http.StatusForbidden || !strings.Contains(body, "AccessDenied") {
+		t.Errorf("unsigned request: got %d %s, want 403 AccessDenied", code, body)
+	}
+	h := http.Header{"Authorization": {"AWS4-HMAC-SHA256 Credential=OTHER/20240101/auto/s3/aws4_request, SignedHeaders=host, Signature=00"}, "X-Amz-Content-Sha256": {"UNSIGNED-PAYLOAD"}}
+	if code, _, body := s3Do(t, s, "GET", "/", h, nil); code != http.StatusForbidden || !strings.Contains(body, "InvalidAccessKeyId") {
+		t.Errorf("unknown key: got %d %s, want 403 InvalidAccessKeyId", code, body)
+	}
+
+	s.AccessKeyID = ""
+	if code, _, body := s3Do(t, s, "PUT", "/b", nil, nil); code != http.StatusOK {
+		t.Fatalf("create bucket: got %d %s", code, body)
+	}
+	for _, k := range []string{"a", "d/1", "d/2", "e/1", "f"} {
+		if code, _, body := s3Do(t, s, "PUT", "/b/"+k, http.Header{"Content-Type": {"text/plain"}, "X-Amz-Meta-Color": {"blue"}}, []byte("data "+k)); code != http.StatusOK {
+			t.Fatalf("put %s: got %d %s", k, code, body)
+		}
+	}
+
+	code, hdr, body := s3Do(t, s, "GET", "/b/d/1", http.Header{"Range": {"bytes=2-"}}, nil)
+	if code != http.StatusPartialContent || body != "ta d/1" || hdr.Get("X-Amz-Meta-Color") != "blue" {
+		t.Errorf("get range: got %d %q %v", code, body, hdr)
+	}
+	if code, _, body := s3Do(t, s, "GET", "/b/missing", nil, nil); code != http.StatusNotFound || !strings.Contains(body, "NoSuchKey") {
+		t.Errorf("get missing: got %d %s, want 404 NoSuchKey", code, body)
+	}
+
+	// Page through a delimited listing, one entry at a time.
+	var got []string
+	marker := ""
+	for i := 0; i < 10; i++ {
+		_, _, body := s3Do(t, s, "GET", "/b?delimiter=/&max-keys=2&list-type=2&continuation-token="+marker, nil, nil)
+		var res s3ListResult
+		if err := xml.Unmarshal([]byte(body), &res); err != nil {
+			t.Fatalf("list: %v in %s", err, body)
+		}
+		for _, c := range res.Contents {
+			got = append(got, c.Key)
+		}
+		got = append(got, res.CommonPrefixes...)
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextContinuationToken
+	}
+	sort.Strings(got)
+	if want := "a d/ e/ f"; strings.Join(got, " ") != want {
+		t.Errorf("list: got %q, want %q", got, want)
+	}
+
+	code, _, body = s3Do(t, s, "PUT", "/b/copy", http.Header{"X-Amz-Copy-Source": {"/b/d%2F2"}}, nil)
+	if data, _ := s.Object("b", "copy"); code != http.StatusOK || string(data) != "data d/2" {
+		t.Errorf("copy: got %d %s, object %q", code, body, data)
+	}
+
+	del := []byte(`<Delete><Object><Key>a</Key></Object><Object><Key>f</Key></Object></Delete>`)
+	if code, _, body := s3Do(t, s, "POST", "/b?delete", nil, del); code != http.StatusOK || strings.Count(body, "<Deleted>") != 2 {
+		t.Errorf("delete multiple: got %d %s", code, body)
+	}
+	if _, ok := s.Object("b", "a"); ok {
+		t.Errorf("delete multiple: object a still exists")
+	}
+	s.GCS = true
+	if code, _, body := s3Do(t, s, "POST", "/b?delete", nil, del); code != http.StatusNotImplemented {
+		t.Errorf("delete multiple like GCS: got %d %s, want 501", code, body)
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	s := NewS3(t)
+	s.AccessKeyID = ""
+	s.AddObject("b", "seed", nil)
+
+	_, _, body := s3Do(t, s, "POST", "/b/big?uploads", nil, nil)
+	var init struct{ UploadId string }
+	if err := xml.Unmarshal([]byte(body), &init); err != nil || init.UploadId == "" {
+		t.Fatalf("initiate: %v in %s", err, body)
+	}
+	parts := [][]byte{bytes.Repeat([]byte("a"), s3MinPartSize), []byte("tail")}
+	var etags []string
+	for i, p := range parts {
+		code, hdr, body := s3Do(t, s, "PUT", fmt.Sprintf("/b/big?partNumber=%d&uploadId=%s", i+1, init.UploadId), nil, p)
+		if code != http.StatusOK {
+			t.Fatalf("upload part %d: got %d %s", i+1, code, body)
+		}
+		etags = append(etags, hdr.Get("ETag"))
+	}
+	complete := func(list ...int) (int, string) {
+		var b strings.Builder
+		b.WriteString("<CompleteMultipartUpload>")
+		for _, n := range list {
+			fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", n, etags[n-1])
+		}
+		b.WriteString("</CompleteMultipartUpload>")
+		code, _, body := s3Do(t, s, "POST", "/b/big?uploadId="+init.UploadId, nil, []byte(b.String()))
+		return code, body
+	}
+	if code, body := complete(2, 1); code != http.StatusBadRequest || !strings.Contains(body, "InvalidPartOrder") {
+		t.Errorf("complete out of order: got %d %s", code, body)
+	}
+	if code, body := complete(1, 2); code != http.StatusOK || !strings.Contains(body, "-2&#34;</ETag>") {
+		t.Errorf("complete: got %d %s, want a two-part ETag", code, body)
+	}
+	if data, _ := s.Object("b", "big"); len(data) != s3MinPartSize+4 {
+		t.Errorf("completed object has %d bytes, want %d", len(data), s3MinPartSize+4)
+	}
+	if code, _ := complete(1, 2); code != http.StatusNotFound {
+		t.Errorf("complete again: got %d, want 404", code)
+	}
+}
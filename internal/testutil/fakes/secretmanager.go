@@ -0,0 +1,297 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SecretManager is an in-memory fake of the Secret Manager API. It supports
+// secrets and their versions; IAM and replication settings are accepted but
+// have no effect.
+type SecretManager struct {
+	*grpcFake
+	secretManagerServer
+}
+
+type secretManagerServer struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+
+	mu      sync.Mutex
+	secrets map[string]*fakeSecret // keyed by resource name.
+}
+
+type fakeSecret struct {
+	secret   *secretmanagerpb.Secret
+	versions []*fakeSecretVersion // versions[i] has ID i+1.
+}
+
+type fakeSecretVersion struct {
+	version *secretmanagerpb.SecretVersion
+	data    []byte
+}
+
+// NewSecretManager starts a Secret Manager fake that is stopped when the test
+// ends.
+func NewSecretManager(t testing.TB) *SecretManager {
+	t.Helper()
+	sm := &SecretManager{}
+	sm.secrets = make(map[string]*fakeSecret)
+	sm.grpcFake = newGRPCFake(t, func(s *grpc.Server) {
+		secretmanagerpb.RegisterSecretManagerServiceServer(s, &sm.secretManagerServer)
+	})
+	return sm
+}
+
+// secret returns the named secret. The caller must hold s.mu.
+func (s *secretManagerServer) secret(name string) (*fakeSecret, error) {
+	sec, ok := s.secrets[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%s] not found or has no versions.", name)
+	}
+	return sec, nil
+}
+
+// version returns the named version, resolving the "latest" alias to the
+// newest version that is not destroyed. The caller must hold s.mu.
+func (s *secretManagerServer) version(name string) (*fakeSecret, *fakeSecretVersion, error) {
+	i := strings.LastIndex(name, "/versions/")
+	if i < 0 {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid secret version name %q", name)
+	}
+	sec, err := s.secret(name[:i])
+	if err != nil {
+		return nil, nil, err
+	}
+	id := name[i+len("/versions/"):]
+	if id == "latest" {
+		for j := len(sec.versions) - 1; j >= 0; j-- {
+			if sec.versions[j].version.State != secretmanagerpb.SecretVersion_DESTROYED {
+				return sec, sec.versions[j], nil
+			}
+		}
+		return nil, nil, status.Errorf(codes.NotFound, "Secret [%s] not found or has no versions.", name[:i])
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 || n > len(sec.versions) {
+		return nil, nil, status.Errorf(codes.NotFound, "Secret Version [%s] not found.", name)
+	}
+	return sec, sec.versions[n-1], nil
+}
+
+func (s *secretManagerServer) CreateSecret(ctx context.Context, req *secretmanagerpb.CreateSecretRequest) (*secretmanagerpb.Secret, error) {
+	if req.GetSecretId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "secret_id is required")
+	}
+	name := req.GetParent() + "/secrets/" + req.GetSecretId()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "Secret [%s] already exists.", name)
+	}
+	sec := proto.Clone(req.GetSecret()).(*secretmanagerpb.Secret)
+	if sec == nil {
+		sec = &secretmanagerpb.Secret{}
+	}
+	sec.Name = name
+	sec.CreateTime = timestamppb.Now()
+	s.secrets[name] = &fakeSecret{secret: sec}
+	return proto.Clone(sec).(*secretmanagerpb.Secret), nil
+}
+
+func (s *secretManagerServer) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.secret(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(sec.secret).(*secretmanagerpb.Secret), nil
+}
+
+func (s *secretManagerServer) ListSecrets(ctx context.Context, req *secretmanagerpb.ListSecretsRequest) (*secretmanagerpb.ListSecretsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &secretmanagerpb.ListSecretsResponse{}
+	prefix := req.GetParent() + "/secrets/"
+	for name, sec := range s.secrets {
+		if strings.HasPrefix(name, prefix) {
+			resp.Secrets = append(resp.Secrets, proto.Clone(sec.secret).(*secretmanagerpb.Secret))
+		}
+	}
+	sort.Slice(resp.Secrets, func(i, j int) bool { return resp.Secrets[i].Name < resp.Secrets[j].Name })
+	resp.TotalSize = int32(len(resp.Secrets))
+	return resp, nil
+}
+
+// UpdateSecret supports the labels field.
+func (s *secretManagerServer) UpdateSecret(ctx context.Context, req *secretmanagerpb.UpdateSecretRequest) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.secret(req.GetSecret().GetName())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range req.GetUpdateMask().GetPaths() {
+		switch p {
+		case "labels":
+			sec.secret.Labels = req.GetSecret().GetLabels()
+		default:
+			return nil, status.Errorf(codes.Unimplemented, "fake: updating %q is not supported", p)
+		}
+	}
+	return proto.Clone(sec.secret).(*secretmanagerpb.Secret), nil
+}
+
+func (s *secretManagerServer) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.secret(req.GetName()); err != nil {
+		return nil, err
+	}
+	delete(s.secrets, req.GetName())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *secretManagerServer) AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	data := req.GetPayload().GetData()
+	if c := req.GetPayload().DataCrc32C; c != nil && *c != crc32c(data) {
+		return nil, status.Error(codes.InvalidArgument, "data_crc32c does not match payload")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.secret(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	v := &secretmanagerpb.SecretVersion{
+		Name:                           fmt.Sprintf("%s/versions/%d", req.GetParent(), len(sec.versions)+1),
+		CreateTime:                     timestamppb.Now(),
+		State:                          secretmanagerpb.SecretVersion_ENABLED,
+		ClientSpecifiedPayloadChecksum: req.GetPayload().DataCrc32C != nil,
+	}
+	sec.versions = append(sec.versions, &fakeSecretVersion{version: v, data: append([]byte(nil), data...)})
+	return proto.Clone(v).(*secretmanagerpb.SecretVersion), nil
+}
+
+func (s *secretManagerServer) GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.version(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.version).(*secretmanagerpb.SecretVersion), nil
+}
+
+func (s *secretManagerServer) ListSecretVersions(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest) (*secretmanagerpb.ListSecretVersionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.secret(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	resp := &secretmanagerpb.ListSecretVersionsResponse{TotalSize: int32(len(sec.versions))}
+	// Newest first, as the API does.
+	for i := len(sec.versions) - 1; i >= 0; i-- {
+		resp.Versions = append(resp.Versions, proto.Clone(sec.versions[i].version).(*secretmanagerpb.SecretVersion))
+	}
+	return resp, nil
+}
+
+func (s *secretManagerServer) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.version(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if v.version.State != secretmanagerpb.SecretVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "Secret Version [%s] is in %s state.", v.version.Name, v.version.State)
+	}
+	checksum := crc32c(v.data)
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name: v.version.Name,
+		Payload: &secretmanagerpb.SecretPayload{
+			Data:       append([]byte(nil), v.data...),
+			DataCrc32C: &checksum,
+		},
+	}, nil
+}
+
+// setState changes the state of a version. Destroyed versions cannot change.
+func (s *secretManagerServer) setState(name string, state secretmanagerpb.SecretVersion_State) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, v, err := s.version(name)
+	if err != nil {
+		return nil, err
+	}
+	if v.version.State == secretmanagerpb.SecretVersion_DESTROYED {
+		return nil, status.Errorf(codes.FailedPrecondition, "Secret Version [%s] is destroyed.", v.version.Name)
+	}
+	v.version.State = state
+	if state == secretmanagerpb.SecretVersion_DESTROYED {
+		v.version.DestroyTime = timestamppb.Now()
+		v.data = nil
+	}
+	return proto.Clone(v.version).(*secretmanagerpb.SecretVersion), nil
+}
+
+func (s *secretManagerServer) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.GetName(), secretmanagerpb.SecretVersion_DISABLED)
+}
+
+func (s *secretManagerServer) EnableSecretVersion(ctx context.Context, req *secretmanagerpb.EnableSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.GetName(), secretmanagerpb.SecretVersion_ENABLED)
+}
+
+func (s *secretManagerServer) DestroySecretVersion(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.GetName(), secretmanagerpb.SecretVersion_DESTROYED)
+}
+
+// AddSecret creates a secret with one version holding data, for test setup.
+// name is the full resource name, e.g. "projects/p/secrets/s".
+func (sm *SecretManager) AddSecret(name string, data []byte) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sec, ok := sm.secrets[name]
+	if !ok {
+		sec = &fakeSecret{secret: &secretmanagerpb.Secret{Name: name, CreateTime: timestamppb.Now()}}
+		sm.secrets[name] = sec
+	}
+	sec.versions = append(sec.versions, &fakeSecretVersion{
+		version: &secretmanagerpb.SecretVersion{
+			Name:       fmt.Sprintf("%s/versions/%d", name, len(sec.versions)+1),
+			CreateTime: timestamppb.Now(),
+			State:      secretmanagerpb.SecretVersion_ENABLED,
+		},
+		data: append([]byte(nil), data...),
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"context"
+	"testing"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSecretManager(t *testing.T) {
+	ctx := context.Background()
+	fake := NewSecretManager(t)
+	client, err := secretmanager.NewClient(ctx, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("secretmanager.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	secret, err := client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+		Parent:   "projects/my-project",
+		SecretId: "my-secret",
+		Secret: &secretmanagerpb.Secret{
+			Replication: &secretmanagerpb.Replication{
+				Replication: &secretmanagerpb.Replication_Automatic_{Automatic: &secretmanagerpb.Replication_Automatic{}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+	for _, data := range []string{"v1", "v2"} {
+		if _, err := client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+			Parent:  secret.Name,
+			Payload: &secretmanagerpb.SecretPayload{Data: []byte(data)},
+		}); err != nil {
+			t.Fatalf("AddSecretVersion: %v", err)
+		}
+	}
+
+	resp, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: secret.Name + "/versions/latest"})
+	if err != nil {
+		t.Fatalf("AccessSecretVersion: %v", err)
+	}
+	if got := string(resp.Payload.Data); got != "v2" {
+		t.Errorf("AccessSecretVersion: got %q, want %q", got, "v2")
+	}
+	if resp.Payload.GetDataCrc32C() != crc32c([]byte("v2")) {
+		t.Errorf("AccessSecretVersion: wrong checksum %d", resp.Payload.GetDataCrc32C())
+	}
+
+	if _, err := client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: secret.Name + "/versions/1"}); err != nil {
+		t.Fatalf("DisableSecretVersion: %v", err)
+	}
+	_, err = client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: secret.Name + "/versions/1"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("AccessSecretVersion(disabled): got %v, want %v", err, codes.FailedPrecondition)
+	}
+
+	_, err = client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{Parent: "projects/my-project", SecretId: "my-secret"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateSecret(existing): got %v, want %v", err, codes.AlreadyExists)
+	}
+	if err := client.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: secret.Name}); err != nil {
+		t.Fatalf("DeleteSecret: %v", err)
+	}
+	_, err = client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: secret.Name})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetSecret(deleted): got %v, want %v", err, codes.NotFound)
+	}
+
+	if n := fake.Count("AddSecretVersion"); n != 2 {
+		t.Errorf("Count(AddSecretVersion): got %d, want 2", n)
+	}
+	calls := fake.Calls()
+	if req, ok := calls[0].Request.(*secretmanagerpb.CreateSecretRequest); !ok || req.SecretId != "my-secret" {
+		t.Errorf("Calls()[0]: got %v, want CreateSecret request", calls[0])
+	}
+}
+
+func TestSecretManagerAddSecret(t *testing.T) {
+	ctx := context.Background()
+	fake := NewSecretManager(t)
+	fake.AddSecret("projects/p/secrets/s", []byte("seeded"))
+	client, err := secretmanager.NewClient(ctx, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("secretmanager.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	resp, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: "projects/p/secrets/s/versions/1"})
+	if err != nil || string(resp.GetPayload().GetData()) != "seeded" {
+		t.Errorf("AccessSecretVersion: got (%v, %v), want seeded payload", resp, err)
+	}
+}
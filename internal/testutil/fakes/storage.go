@@ -0,0 +1,985 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// Storage is an in-memory fake of the Cloud Storage JSON API, including
+// media uploads and XML API downloads as used by the storage client library.
+// It supports buckets and objects with generations, generation
+// preconditions, listing with prefixes, delimiters and offsets, rewrite
+// and compose. Buckets may be patched, have IAM policies and lock their
+// retention policies. Objects may be encrypted with customer-supplied keys, which
+// must then be given to read or rewrite them, or with a KMS key on rewrite;
+// the data is stored as is. Object versioning, ACLs and IAM are not
+// supported.
+type Storage struct {
+	Recorder
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	uploads map[string]*fakeUpload
+	nextGen int64
+}
+
+type fakeBucket struct {
+	bucket  *raw.Bucket
+	policy  *raw.Policy
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	object *raw.Object
+	data   []byte
+}
+
+// fakeUpload is a resumable upload in progress.
+type fakeUpload struct {
+	bucket string
+	object *raw.Object
+	query  url.Values
+	data   []byte
+}
+
+// NewStorage starts a Storage fake that is stopped when the test ends.
+func NewStorage(t testing.TB) *Storage {
+	t.Helper()
+	s := &Storage{
+		buckets: make(map[string]*fakeBucket),
+		uploads: make(map[string]*fakeUpload),
+		nextGen: time.Now().UnixNano() / 1000,
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL returns the base URL of the fake.
+func (s *Storage) URL() string {
+	return s.srv.URL
+}
+
+// ClientOptions returns options that connect a storage.Client to the fake
+// without authentication.
+func (s *Storage) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.srv.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// AddObject stores an object for test setup, creating its bucket if needed.
+func (s *Storage) AddObject(bucket, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = s.newBucket(&raw.Bucket{Name: bucket})
+	}
+	s.putObject(bucket, &raw.Object{Name: name}, data)
+}
+
+// Object returns the contents of an object, and whether it exists.
+func (s *Storage) Object(bucket, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	o, ok := b.objects[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.data...), true
+}
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// writeJSON writes v, or err in the JSON API error format.
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err != nil {
+		code := http.StatusInternalServerError
+		if he, ok := err.(*httpError); ok {
+			code = he.code
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{"code": code, "message": err.Error()},
+		})
+		return
+	}
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// pathSegments splits the escaped request path and unescapes each segment,
+// so object names containing "/" are kept whole.
+func pathSegments(r *http.Request) []string {
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		if u, err := url.PathUnescape(p); err == nil {
+			parts[i] = u
+		}
+	}
+	return parts
+}
+
+func (s *Storage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.record(Call{Method: r.Method + " " + r.URL.Path, Query: r.URL.Query()})
+	p := pathSegments(r)
+	switch {
+	case len(p) >= 3 && p[0] == "upload" && p[1] == "storage":
+		s.serveUpload(w, r, p[3:])
+	case len(p) >= 2 && p[0] == "storage" && p[1] == "v1":
+		s.serveJSON(w, r, p[2:])
+	case len(p) >= 4 && p[0] == "download" && p[1] == "storage":
+		// /download/storage/v1/b/BUCKET/o/OBJECT
+		s.serveMedia(w, r, p[4], strings.Join(p[6:], "/"))
+	case len(p) >= 2:
+		// XML API: /BUCKET/OBJECT
+		s.serveMedia(w, r, p[0], strings.Join(p[1:], "/"))
+	default:
+		writeJSON(w, nil, errorf(http.StatusNotFound, "fake: unsupported path %s", r.URL.Path))
+	}
+}
+
+// serveJSON handles /storage/v1/... with the version prefix removed from p.
+func (s *Storage) serveJSON(w http.ResponseWriter, r *http.Request, p []string) {
+	q := r.URL.Query()
+	switch {
+	case len(p) == 1 && p[0] == "b":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, s.listBuckets(q.Get("project"), q.Get("prefix")), nil)
+		case http.MethodPost:
+			var b raw.Bucket
+			if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+				writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+				return
+			}
+			v, err := s.insertBucket(q.Get("project"), &b)
+			writeJSON(w, v, err)
+		default:
+			writeJSON(w, nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		}
+	case len(p) == 2 && p[0] == "b":
+		switch r.Method {
+		case http.MethodGet:
+			v, err := s.getBucket(p[1])
+			writeJSON(w, v, err)
+		case http.MethodPatch, http.MethodPut:
+			var patch map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+				return
+			}
+			v, err := s.patchBucket(p[1], patch, q)
+			writeJSON(w, v, err)
+		case http.MethodDelete:
+			writeJSON(w, nil, s.deleteBucket(p[1]))
+		default:
+			writeJSON(w, nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		}
+	case len(p) == 3 && p[0] == "b" && p[2] == "iam":
+		switch r.Method {
+		case http.MethodGet:
+			v, err := s.getBucketPolicy(p[1])
+			writeJSON(w, v, err)
+		case http.MethodPut:
+			var policy raw.Policy
+			if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+				writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+				return
+			}
+			v, err := s.setBucketPolicy(p[1], &policy)
+			writeJSON(w, v, err)
+		default:
+			writeJSON(w, nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		}
+	case len(p) == 3 && p[0] == "b" && p[2] == "lockRetentionPolicy" && r.Method == http.MethodPost:
+		v, err := s.lockRetentionPolicy(p[1], q)
+		writeJSON(w, v, err)
+	case len(p) == 3 && p[0] == "b" && p[2] == "o" && r.Method == http.MethodGet:
+		v, err := s.listObjects(p[1], q)
+		writeJSON(w, v, err)
+	case len(p) >= 4 && p[0] == "b" && p[2] == "o":
+		s.serveObject(w, r, p[1], p[3:])
+	default:
+		writeJSON(w, nil, errorf(http.StatusNotFound, "fake: unsupported path %s", r.URL.Path))
+	}
+}
+
+// serveObject handles /b/BUCKET/o/OBJECT[/...] requests. Because the client
+// escapes "/" in object names, rest has one element unless it names a
+// rewrite or compose.
+func (s *Storage) serveObject(w http.ResponseWriter, r *http.Request, bucket string, rest []string) {
+	name := rest[0]
+	q := r.URL.Query()
+	switch {
+	case len(rest) == 6 && rest[1] == "rewriteTo":
+		v, err := s.rewrite(bucket, name, rest[3], rest[5], r, q)
+		writeJSON(w, v, err)
+	case len(rest) == 2 && rest[1] == "compose":
+		var req raw.ComposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+			return
+		}
+		v, err := s.compose(bucket, name, &req, q)
+		writeJSON(w, v, err)
+	case len(rest) != 1:
+		writeJSON(w, nil, errorf(http.StatusNotFound, "fake: unsupported path %s", r.URL.Path))
+	case r.Method == http.MethodGet && q.Get("alt") == "media":
+		s.serveMedia(w, r, bucket, name)
+	case r.Method == http.MethodGet:
+		v, err := s.getObject(bucket, name, q)
+		writeJSON(w, v, err)
+	case r.Method == http.MethodPatch:
+		var o raw.Object
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+			return
+		}
+		v, err := s.patchObject(bucket, name, &o, q)
+		writeJSON(w, v, err)
+	case r.Method == http.MethodDelete:
+		writeJSON(w, nil, s.deleteObject(bucket, name, q))
+	default:
+		writeJSON(w, nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+	}
+}
+
+func (s *Storage) newBucket(b *raw.Bucket) *fakeBucket {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	b.Kind = "storage#bucket"
+	b.Id = b.Name
+	b.TimeCreated = now
+	b.Updated = now
+	b.Metageneration = 1
+	b.ProjectNumber = 1234567890
+	if b.Location == "" {
+		b.Location = "US"
+	}
+	if b.StorageClass == "" {
+		b.StorageClass = "STANDARD"
+	}
+	return &fakeBucket{bucket: b, objects: make(map[string]*fakeObject)}
+}
+
+func (s *Storage) listBuckets(project, prefix string) *raw.Buckets {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &raw.Buckets{Kind: "storage#buckets"}
+	for name, b := range s.buckets {
+		if strings.HasPrefix(name, prefix) {
+			resp.Items = append(resp.Items, b.bucket)
+		}
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].Name < resp.Items[j].Name })
+	return resp
+}
+
+func (s *Storage) insertBucket(project string, b *raw.Bucket) (*raw.Bucket, error) {
+	if b.Name == "" {
+		return nil, errorf(http.StatusBadRequest, "Required parameter: bucket name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[b.Name]; ok {
+		return nil, errorf(http.StatusConflict, "Your previous request to create the named bucket succeeded and you already own it.")
+	}
+	fb := s.newBucket(b)
+	s.buckets[b.Name] = fb
+	return fb.bucket, nil
+}
+
+// bucket returns the named bucket. The caller must hold s.mu.
+func (s *Storage) bucket(name string) (*fakeBucket, error) {
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "The specified bucket does not exist.")
+	}
+	return b, nil
+}
+
+func (s *Storage) getBucket(name string) (*raw.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.bucket, nil
+}
+
+// patchBucket applies patch to the bucket as a JSON merge patch: fields
+// set to null are removed and nested objects are merged, except lifecycle,
+// which is replaced. A locked retention policy cannot be removed or
+// shortened.
+func (s *Storage) patchBucket(name string, patch map[string]interface{}, q url.Values) (*raw.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBucketPreconditions(b.bucket, q); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(b.bucket)
+	if err != nil {
+		return nil, err
+	}
+	var cur map[string]interface{}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return nil, err
+	}
+	if lc, ok := patch["lifecycle"]; ok {
+		cur["lifecycle"] = lc
+		delete(patch, "lifecycle")
+	}
+	mergePatch(cur, patch)
+	if data, err = json.Marshal(cur); err != nil {
+		return nil, err
+	}
+	var nb raw.Bucket
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, errorf(http.StatusBadRequest, "%v", err)
+	}
+
+	if old := b.bucket.RetentionPolicy; old != nil && old.IsLocked {
+		if nb.RetentionPolicy == nil || nb.RetentionPolicy.RetentionPeriod < old.RetentionPeriod {
+			return nil, errorf(http.StatusForbidden, "Cannot reduce retention duration of a locked Retention Policy for bucket '%s'.", name)
+		}
+		nb.RetentionPolicy.IsLocked = true
+	}
+	if rp := nb.RetentionPolicy; rp != nil && rp.EffectiveTime == "" {
+		rp.EffectiveTime = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	nb.Name, nb.Id, nb.Kind = b.bucket.Name, b.bucket.Id, b.bucket.Kind
+	nb.Metageneration = b.bucket.Metageneration + 1
+	nb.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	b.bucket = &nb
+	return b.bucket, nil
+}
+
+// mergePatch applies the JSON merge patch (RFC 7396) patch to dst.
+func mergePatch(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = make(map[string]interface{})
+			dst[k] = dm
+		}
+		mergePatch(dm, pm)
+	}
+}
+
+// checkBucketPreconditions applies the ifMetagenerationMatch parameter in q.
+func checkBucketPreconditions(b *raw.Bucket, q url.Values) error {
+	if v := q.Get("ifMetagenerationMatch"); v != "" && v != strconv.FormatInt(b.Metageneration, 10) {
+		return errorf(http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+	}
+	return nil
+}
+
+func (s *Storage) lockRetentionPolicy(name string, q url.Values) (*raw.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if q.Get("ifMetagenerationMatch") == "" {
+		return nil, errorf(http.StatusBadRequest, "Required parameter: ifMetagenerationMatch")
+	}
+	if err := checkBucketPreconditions(b.bucket, q); err != nil {
+		return nil, err
+	}
+	if b.bucket.RetentionPolicy == nil {
+		return nil, errorf(http.StatusBadRequest, "Bucket '%s' does not have a retention policy.", name)
+	}
+	b.bucket.RetentionPolicy.IsLocked = true
+	b.bucket.Metageneration++
+	return b.bucket, nil
+}
+
+func (s *Storage) getBucketPolicy(name string) (*raw.Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if b.policy == nil {
+		b.policy = &raw.Policy{Kind: "storage#policy", ResourceId: "projects/_/buckets/" + name, Version: 1, Etag: "CAE="}
+	}
+	return b.policy, nil
+}
+
+// setBucketPolicy replaces the IAM policy of a bucket if policy has the
+// current etag, or none.
+func (s *Storage) setBucketPolicy(name string, policy *raw.Policy) (*raw.Policy, error) {
+	cur, err := s.getBucketPolicy(name)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if policy.Etag != "" && policy.Etag != cur.Etag {
+		return nil, errorf(http.StatusPreconditionFailed, "Precondition Failed: etag does not match")
+	}
+	s.nextGen++
+	policy.Kind, policy.ResourceId = cur.Kind, cur.ResourceId
+	policy.Etag = base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(s.nextGen, 10)))
+	if policy.Version == 0 {
+		policy.Version = 1
+	}
+	s.buckets[name].policy = policy
+	return policy, nil
+}
+
+func (s *Storage) deleteBucket(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(name)
+	if err != nil {
+		return err
+	}
+	if len(b.objects) > 0 {
+		return errorf(http.StatusConflict, "The bucket you tried to delete is not empty.")
+	}
+	delete(s.buckets, name)
+	return nil
+}
+
+// listObjects lists the objects matching the prefix, delimiter, startOffset
+// and endOffset parameters in q.
+func (s *Storage) listObjects(bucket string, q url.Values) (*raw.Objects, error) {
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	start, end := q.Get("startOffset"), q.Get("endOffset")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	resp := &raw.Objects{Kind: "storage#objects"}
+	prefixes := make(map[string]bool)
+	for name, o := range b.objects {
+		if !strings.HasPrefix(name, prefix) || name < start || (end != "" && name >= end) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				prefixes[name[:len(prefix)+i+len(delimiter)]] = true
+				continue
+			}
+		}
+		resp.Items = append(resp.Items, o.object)
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].Name < resp.Items[j].Name })
+	for p := range prefixes {
+		resp.Prefixes = append(resp.Prefixes, p)
+	}
+	sort.Strings(resp.Prefixes)
+	return resp, nil
+}
+
+// checkPreconditions applies the ifGenerationMatch and ifMetagenerationMatch
+// parameters to o, which is nil if the object does not exist.
+func checkPreconditions(o *fakeObject, q url.Values) error {
+	if v := q.Get("ifGenerationMatch"); v != "" {
+		want, _ := strconv.ParseInt(v, 10, 64)
+		var got int64
+		if o != nil {
+			got = o.object.Generation
+		}
+		if got != want {
+			return errorf(http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+		}
+	}
+	if v := q.Get("ifMetagenerationMatch"); v != "" {
+		want, _ := strconv.ParseInt(v, 10, 64)
+		if o == nil || o.object.Metageneration != want {
+			return errorf(http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+		}
+	}
+	return nil
+}
+
+// object returns the named object, which must match the generation in q if
+// one is given. The caller must hold s.mu.
+func (s *Storage) object(bucket, name string, q url.Values) (*fakeObject, error) {
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := b.objects[name]
+	if ok {
+		if g := q.Get("generation"); g != "" && g != strconv.FormatInt(o.object.Generation, 10) {
+			ok = false
+		}
+	}
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "No such object: %s/%s", bucket, name)
+	}
+	return o, nil
+}
+
+func (s *Storage) getObject(bucket, name string, q url.Values) (*raw.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.object(bucket, name, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions(o, q); err != nil {
+		return nil, err
+	}
+	return o.object, nil
+}
+
+// patchObject updates content type, cache control and custom metadata.
+func (s *Storage) patchObject(bucket, name string, patch *raw.Object, q url.Values) (*raw.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.object(bucket, name, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions(o, q); err != nil {
+		return nil, err
+	}
+	if patch.ContentType != "" {
+		o.object.ContentType = patch.ContentType
+	}
+	if patch.CacheControl != "" {
+		o.object.CacheControl = patch.CacheControl
+	}
+	if patch.Metadata != nil {
+		if o.object.Metadata == nil {
+			o.object.Metadata = make(map[string]string)
+		}
+		for k, v := range patch.Metadata {
+			o.object.Metadata[k] = v
+		}
+	}
+	o.object.Metageneration++
+	o.object.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	return o.object, nil
+}
+
+func (s *Storage) deleteObject(bucket, name string, q url.Values) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.object(bucket, name, q)
+	if err != nil {
+		return err
+	}
+	if err := checkPreconditions(o, q); err != nil {
+		return err
+	}
+	delete(s.buckets[bucket].objects, name)
+	return nil
+}
+
+// putObject stores a new generation of an object. The caller must hold s.mu
+// and have checked that the bucket exists.
+func (s *Storage) putObject(bucket string, meta *raw.Object, data []byte) *raw.Object {
+	s.nextGen++
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	sum := md5.Sum(data)
+	o := &raw.Object{
+		Kind:               "storage#object",
+		Id:                 fmt.Sprintf("%s/%s/%d", bucket, meta.Name, s.nextGen),
+		Bucket:             bucket,
+		Name:               meta.Name,
+		Generation:         s.nextGen,
+		Metageneration:     1,
+		Size:               uint64(len(data)),
+		Md5Hash:            base64.StdEncoding.EncodeToString(sum[:]),
+		Crc32c:             encodeCRC32C(data),
+		Etag:               fmt.Sprintf("%x", sum),
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		CacheControl:       meta.CacheControl,
+		Metadata:           meta.Metadata,
+		CustomerEncryption: meta.CustomerEncryption,
+		KmsKeyName:         meta.KmsKeyName,
+		StorageClass:       s.buckets[bucket].bucket.StorageClass,
+		TimeCreated:        now,
+		Updated:            now,
+		MediaLink:          fmt.Sprintf("%s/download/storage/v1/b/%s/o/%s?generation=%d&alt=media", s.srv.URL, bucket, url.PathEscape(meta.Name), s.nextGen),
+	}
+	if o.ContentType == "" {
+		o.ContentType = "application/octet-stream"
+	}
+	s.buckets[bucket].objects[meta.Name] = &fakeObject{object: o, data: append([]byte(nil), data...)}
+	return o
+}
+
+// insertObject checks the bucket and preconditions, then stores the object.
+func (s *Storage) insertObject(bucket string, meta *raw.Object, data []byte, q url.Values) (*raw.Object, error) {
+	if meta.Name == "" {
+		return nil, errorf(http.StatusBadRequest, "Required parameter: object name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions(b.objects[meta.Name], q); err != nil {
+		return nil, err
+	}
+	if want := meta.Crc32c; want != "" && want != encodeCRC32C(data) {
+		return nil, errorf(http.StatusBadRequest, "Provided CRC32C %q doesn't match calculated CRC32C %q.", want, encodeCRC32C(data))
+	}
+	return s.putObject(bucket, meta, data), nil
+}
+
+func (s *Storage) rewrite(srcBucket, srcName, dstBucket, dstName string, r *http.Request, q url.Values) (*raw.RewriteResponse, error) {
+	var meta raw.Object
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil && err != io.EOF {
+			return nil, errorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	s.mu.Lock()
+	src, err := s.object(srcBucket, srcName, url.Values{"generation": {q.Get("sourceGeneration")}})
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err := checkKey(src, r.Header, "copy-source-"); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	data := src.data
+	if meta.ContentType == "" {
+		meta.ContentType = src.object.ContentType
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = src.object.Metadata
+	}
+	s.mu.Unlock()
+
+	meta.Name = dstName
+	meta.CustomerEncryption = customerEncryption(r.Header, "")
+	meta.KmsKeyName = q.Get("destinationKmsKeyName")
+	if meta.CustomerEncryption != nil && meta.KmsKeyName != "" {
+		return nil, errorf(http.StatusBadRequest, "Cannot use both a customer-supplied and a KMS encryption key.")
+	}
+	o, err := s.insertObject(dstBucket, &meta, data, q)
+	if err != nil {
+		return nil, err
+	}
+	return &raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		Done:                true,
+		ObjectSize:          int64(len(data)),
+		TotalBytesRewritten: int64(len(data)),
+		Resource:            o,
+	}, nil
+}
+
+func (s *Storage) compose(bucket, name string, req *raw.ComposeRequest, q url.Values) (*raw.Object, error) {
+	s.mu.Lock()
+	var data []byte
+	for _, src := range req.SourceObjects {
+		sq := url.Values{}
+		if src.Generation != 0 {
+			sq.Set("generation", strconv.FormatInt(src.Generation, 10))
+		}
+		o, err := s.object(bucket, src.Name, sq)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		data = append(data, o.data...)
+	}
+	s.mu.Unlock()
+
+	meta := req.Destination
+	if meta == nil {
+		meta = &raw.Object{}
+	}
+	meta.Name = name
+	return s.insertObject(bucket, meta, data, q)
+}
+
+// serveUpload handles /upload/storage/v1/b/BUCKET/o.
+func (s *Storage) serveUpload(w http.ResponseWriter, r *http.Request, p []string) {
+	if len(p) != 3 || p[0] != "b" || p[2] != "o" {
+		writeJSON(w, nil, errorf(http.StatusNotFound, "fake: unsupported path %s", r.URL.Path))
+		return
+	}
+	bucket := p[1]
+	q := r.URL.Query()
+	switch q.Get("uploadType") {
+	case "media":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+			return
+		}
+		meta := &raw.Object{Name: q.Get("name"), ContentType: r.Header.Get("Content-Type")}
+		meta.CustomerEncryption = customerEncryption(r.Header, "")
+		v, err := s.insertObject(bucket, meta, data, q)
+		writeJSON(w, v, err)
+	case "multipart":
+		meta, data, err := readMultipart(r)
+		if err != nil {
+			writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+			return
+		}
+		if meta.Name == "" {
+			meta.Name = q.Get("name")
+		}
+		meta.CustomerEncryption = customerEncryption(r.Header, "")
+		v, err := s.insertObject(bucket, meta, data, q)
+		writeJSON(w, v, err)
+	case "resumable":
+		s.serveResumable(w, r, bucket, q)
+	default:
+		writeJSON(w, nil, errorf(http.StatusBadRequest, "fake: unsupported uploadType %q", q.Get("uploadType")))
+	}
+}
+
+// readMultipart reads the metadata and media parts of a multipart upload.
+func readMultipart(r *http.Request) (*raw.Object, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	var meta raw.Object
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		return nil, nil, err
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = part.Header.Get("Content-Type")
+	}
+	return &meta, data, nil
+}
+
+// serveResumable starts a resumable upload, or receives a chunk of one if
+// upload_id is set. Chunks must arrive in order.
+func (s *Storage) serveResumable(w http.ResponseWriter, r *http.Request, bucket string, q url.Values) {
+	if q.Get("upload_id") == "" {
+		var meta raw.Object
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil && err != io.EOF {
+			writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+			return
+		}
+		if meta.Name == "" {
+			meta.Name = q.Get("name")
+		}
+		meta.CustomerEncryption = customerEncryption(r.Header, "")
+		s.mu.Lock()
+		s.nextGen++
+		id := strconv.FormatInt(s.nextGen, 10)
+		s.uploads[id] = &fakeUpload{bucket: bucket, object: &meta, query: q}
+		s.mu.Unlock()
+
+		loc := *r.URL
+		lq := loc.Query()
+		lq.Set("upload_id", id)
+		loc.RawQuery = lq.Encode()
+		w.Header().Set("Location", s.srv.URL+loc.RequestURI())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := q.Get("upload_id")
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, nil, errorf(http.StatusNotFound, "No such upload: %s", id))
+		return
+	}
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, nil, errorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+	// Content-Range is "bytes FIRST-LAST/TOTAL", "bytes */TOTAL" or has "*"
+	// as TOTAL while the size is unknown.
+	total := "*"
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			total = cr[i+1:]
+		}
+	}
+	s.mu.Lock()
+	u.data = append(u.data, chunk...)
+	size := len(u.data)
+	s.mu.Unlock()
+
+	if total == "*" || strconv.Itoa(size) != total {
+		if size > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+		}
+		// Clients that cannot handle 308 responses ask for a 200 with an
+		// override header instead.
+		if r.Header.Get("X-GUploader-No-308") == "yes" {
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	v, err := s.insertObject(u.bucket, u.object, u.data, u.query)
+	writeJSON(w, v, err)
+}
+
+// serveMedia writes object contents, honoring a single Range header, with
+// the headers the XML API sets.
+func (s *Storage) serveMedia(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	s.mu.Lock()
+	o, err := s.object(bucket, name, r.URL.Query())
+	if err == nil {
+		err = checkPreconditions(o, r.URL.Query())
+	}
+	if err == nil {
+		err = checkKey(o, r.Header, "")
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, nil, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", o.object.ContentType)
+	h.Set("X-Goog-Generation", strconv.FormatInt(o.object.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(o.object.Metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(o.data)))
+	if t, err := time.Parse(time.RFC3339Nano, o.object.Updated); err == nil {
+		h.Set("Last-Modified", t.Format(http.TimeFormat))
+	}
+	h.Set("Etag", o.object.Etag)
+	if o.object.ContentEncoding != "" {
+		h.Set("X-Goog-Stored-Content-Encoding", o.object.ContentEncoding)
+	}
+	// http.ServeContent handles Range requests; the hash only describes the
+	// full content.
+	if r.Header.Get("Range") == "" {
+		h.Set("X-Goog-Hash", "crc32c="+o.object.Crc32c+",md5="+o.object.Md5Hash)
+	}
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(o.data)))
+}
+
+// customerEncryption returns the customer-supplied encryption key described
+// by the x-goog-<prefix>encryption-* headers, or nil.
+func customerEncryption(h http.Header, prefix string) *raw.ObjectCustomerEncryption {
+	sha := h.Get("X-Goog-" + prefix + "Encryption-Key-Sha256")
+	if sha == "" {
+		return nil
+	}
+	return &raw.ObjectCustomerEncryption{
+		EncryptionAlgorithm: h.Get("X-Goog-" + prefix + "Encryption-Algorithm"),
+		KeySha256:           sha,
+	}
+}
+
+// checkKey checks that the headers supply the customer-supplied key of o,
+// if it has one.
+func checkKey(o *fakeObject, h http.Header, prefix string) error {
+	want := o.object.CustomerEncryption
+	got := customerEncryption(h, prefix)
+	switch {
+	case want == nil && got == nil:
+		return nil
+	case want == nil:
+		return errorf(http.StatusBadRequest, "The target object is not encrypted by a customer-supplied encryption key.")
+	case got == nil:
+		return errorf(http.StatusBadRequest, "The target object is encrypted by a customer-supplied encryption key.")
+	case got.KeySha256 != want.KeySha256:
+		return errorf(http.StatusBadRequest, "The provided encryption key is incorrect.")
+	}
+	return nil
+}
+
+// encodeCRC32C returns the base64 big-endian CRC32C checksum of data, as in
+// object metadata.
+func encodeCRC32C(data []byte) string {
+	return base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, uint32(crc32c(data))))
+}
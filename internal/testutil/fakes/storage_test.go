@@ -0,0 +1,291 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+)
+
+func newStorageClient(t *testing.T) (*Storage, *storage.Client) {
+	t.Helper()
+	fake := NewStorage(t)
+	client, err := storage.NewClient(context.Background(), fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("storage.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return fake, client
+}
+
+func writeObject(ctx context.Context, o *storage.ObjectHandle, data []byte, chunkSize int) error {
+	w := o.NewWriter(ctx)
+	w.ChunkSize = chunkSize
+	w.ContentType = "text/plain"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func readObject(ctx context.Context, o *storage.ObjectHandle, offset, length int64) (string, error) {
+	r, err := o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func TestStorageObjects(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newStorageClient(t)
+
+	bkt := client.Bucket("my-bucket")
+	if err := bkt.Create(ctx, "my-project", &storage.BucketAttrs{Labels: map[string]string{"env": "test"}}); err != nil {
+		t.Fatalf("Bucket.Create: %v", err)
+	}
+	if err := bkt.Create(ctx, "my-project", nil); err == nil {
+		t.Errorf("Bucket.Create: expected conflict for existing bucket, got success")
+	}
+
+	// A small object uses a multipart upload, a large one a resumable
+	// upload in several chunks.
+	big := bytes.Repeat([]byte("0123456789"), 100*1024)
+	if err := writeObject(ctx, bkt.Object("dir/small.txt"), []byte("hello world"), 0); err != nil {
+		t.Fatalf("write small: %v", err)
+	}
+	if err := writeObject(ctx, bkt.Object("dir/sub/big.txt"), big, 256*1024); err != nil {
+		t.Fatalf("write big: %v", err)
+	}
+	if got, ok := fake.Object("my-bucket", "dir/sub/big.txt"); !ok || !bytes.Equal(got, big) {
+		t.Errorf("resumable upload: stored %d bytes, want %d", len(got), len(big))
+	}
+
+	if got, err := readObject(ctx, bkt.Object("dir/small.txt"), 0, -1); err != nil || got != "hello world" {
+		t.Errorf("read: got (%q, %v), want %q", got, err, "hello world")
+	}
+	if got, err := readObject(ctx, bkt.Object("dir/small.txt"), 6, 3); err != nil || got != "wor" {
+		t.Errorf("range read: got (%q, %v), want %q", got, err, "wor")
+	}
+
+	attrs, err := bkt.Object("dir/small.txt").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs: %v", err)
+	}
+	if attrs.Size != 11 || attrs.ContentType != "text/plain" || attrs.Generation == 0 {
+		t.Errorf("Attrs: got size %d, type %q, generation %d", attrs.Size, attrs.ContentType, attrs.Generation)
+	}
+
+	// Writing an existing object with DoesNotExist fails the precondition.
+	err = writeObject(ctx, bkt.Object("dir/small.txt").If(storage.Conditions{DoesNotExist: true}), []byte("x"), 0)
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusPreconditionFailed {
+		t.Errorf("conditional write: got %v, want 412", err)
+	}
+
+	if _, err := bkt.Object("copy.txt").CopierFrom(bkt.Object("dir/small.txt")).Run(ctx); err != nil {
+		t.Fatalf("Copier.Run: %v", err)
+	}
+	if _, err := bkt.Object("composed.txt").ComposerFrom(bkt.Object("copy.txt"), bkt.Object("dir/small.txt")).Run(ctx); err != nil {
+		t.Fatalf("Composer.Run: %v", err)
+	}
+	if got, err := readObject(ctx, bkt.Object("composed.txt"), 0, -1); err != nil || got != "hello worldhello world" {
+		t.Errorf("read composed: got (%q, %v)", got, err)
+	}
+
+	var names []string
+	it := bkt.Objects(ctx, &storage.Query{Delimiter: "/"})
+	for {
+		a, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Objects: %v", err)
+		}
+		names = append(names, a.Name+a.Prefix)
+	}
+	if got, want := strings.Join(names, ","), "composed.txt,copy.txt,dir/"; got != want {
+		t.Errorf("Objects: got %s, want %s", got, want)
+	}
+
+	if err := bkt.Delete(ctx); err == nil {
+		t.Errorf("Bucket.Delete: expected error for non-empty bucket, got success")
+	}
+	for _, name := range []string{"composed.txt", "copy.txt", "dir/small.txt", "dir/sub/big.txt"} {
+		if err := bkt.Object(name).Delete(ctx); err != nil {
+			t.Errorf("Delete(%s): %v", name, err)
+		}
+	}
+	if _, err := bkt.Object("copy.txt").Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("Attrs after delete: got %v, want %v", err, storage.ErrObjectNotExist)
+	}
+	if err := bkt.Delete(ctx); err != nil {
+		t.Errorf("Bucket.Delete: %v", err)
+	}
+	if _, err := bkt.Attrs(ctx); err != storage.ErrBucketNotExist {
+		t.Errorf("Bucket.Attrs after delete: got %v, want %v", err, storage.ErrBucketNotExist)
+	}
+
+	if n := fake.Count("DELETE /storage/v1/b/my-bucket"); n != 2 {
+		t.Errorf("Count(bucket delete): got %d, want 2", n)
+	}
+}
+
+func TestStorageAddObject(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newStorageClient(t)
+	fake.AddObject("seeded", "a/b c.txt", []byte("seed"))
+
+	got, err := readObject(ctx, client.Bucket("seeded").Object("a/b c.txt"), 0, -1)
+	if err != nil || got != "seed" {
+		t.Errorf("read: got (%q, %v), want %q", got, err, "seed")
+	}
+	if _, err := client.Bucket("seeded").Object("missing").NewReader(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("NewReader(missing): got %v, want %v", err, storage.ErrObjectNotExist)
+	}
+}
+
+func TestStorageEncryption(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newStorageClient(t)
+	fake.AddObject("b", "plain", []byte("plain"))
+	key := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+	o := client.Bucket("b").Object("secret")
+	if err := writeObject(ctx, o.Key(key), []byte("secret"), 0); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs: %v", err)
+	}
+	if attrs.CustomerKeySHA256 == "" {
+		t.Errorf("Attrs: got no CustomerKeySHA256, want one")
+	}
+	for _, h := range []*storage.ObjectHandle{o, o.Key(other)} {
+		if _, err := readObject(ctx, h, 0, -1); err == nil {
+			t.Errorf("read without the key: got success, want error")
+		}
+	}
+	if got, err := readObject(ctx, o.Key(key), 0, -1); err != nil || got != "secret" {
+		t.Errorf("read: got (%q, %v), want %q", got, err, "secret")
+	}
+
+	c := o.CopierFrom(o.Key(key))
+	c.DestinationKMSKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	attrs, err = c.Run(ctx)
+	if err != nil {
+		t.Fatalf("Copier.Run: %v", err)
+	}
+	if attrs.CustomerKeySHA256 != "" || attrs.KMSKeyName != c.DestinationKMSKeyName {
+		t.Errorf("Copier.Run: got key %q and KMS key %q, want KMS key %q", attrs.CustomerKeySHA256, attrs.KMSKeyName, c.DestinationKMSKeyName)
+	}
+	if got, err := readObject(ctx, o, 0, -1); err != nil || got != "secret" {
+		t.Errorf("read after rewrite: got (%q, %v), want %q", got, err, "secret")
+	}
+
+	it := client.Bucket("b").Objects(ctx, &storage.Query{StartOffset: "q"})
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Objects: %v", err)
+		}
+		names = append(names, attrs.Name)
+	}
+	if len(names) != 1 || names[0] != "secret" {
+		t.Errorf("Objects(StartOffset): got %v, want [secret]", names)
+	}
+}
+
+func TestStorageBucketUpdate(t *testing.T) {
+	ctx := context.Background()
+	_, client := newStorageClient(t)
+	bkt := client.Bucket("b")
+	if err := bkt.Create(ctx, "p", &storage.BucketAttrs{
+		Labels:                   map[string]string{"a": "1", "b": "2"},
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: true},
+		Lifecycle: storage.Lifecycle{Rules: []storage.LifecycleRule{{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 30},
+		}}},
+	}); err != nil {
+		t.Fatalf("Bucket.Create: %v", err)
+	}
+
+	ua := storage.BucketAttrsToUpdate{
+		PublicAccessPrevention: storage.PublicAccessPreventionEnforced,
+		Lifecycle:              &storage.Lifecycle{},
+		RetentionPolicy:        &storage.RetentionPolicy{RetentionPeriod: time.Hour},
+	}
+	ua.SetLabel("c", "3")
+	ua.DeleteLabel("a")
+	attrs, err := bkt.Update(ctx, ua)
+	if err != nil {
+		t.Fatalf("Bucket.Update: %v", err)
+	}
+	if want := map[string]string{"b": "2", "c": "3"}; !reflect.DeepEqual(attrs.Labels, want) {
+		t.Errorf("Update: got labels %v, want %v", attrs.Labels, want)
+	}
+	if !attrs.UniformBucketLevelAccess.Enabled || attrs.PublicAccessPrevention != storage.PublicAccessPreventionEnforced {
+		t.Errorf("Update: got UBLA %v and PAP %v, want both set", attrs.UniformBucketLevelAccess.Enabled, attrs.PublicAccessPrevention)
+	}
+	if len(attrs.Lifecycle.Rules) != 0 {
+		t.Errorf("Update: got lifecycle rules %v, want none", attrs.Lifecycle.Rules)
+	}
+
+	if err := bkt.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).LockRetentionPolicy(ctx); err != nil {
+		t.Fatalf("LockRetentionPolicy: %v", err)
+	}
+	if _, err := bkt.Update(ctx, storage.BucketAttrsToUpdate{RetentionPolicy: &storage.RetentionPolicy{}}); err == nil {
+		t.Errorf("Update: removed a locked retention policy, want error")
+	}
+
+	policy, err := bkt.IAM().V3().Policy(ctx)
+	if err != nil {
+		t.Fatalf("Policy: %v", err)
+	}
+	policy.Bindings = append(policy.Bindings, &iampb.Binding{Role: "roles/storage.objectViewer", Members: []string{"allUsers"}})
+	if err := bkt.IAM().V3().SetPolicy(ctx, policy); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	if err := bkt.IAM().V3().SetPolicy(ctx, policy); err == nil {
+		t.Errorf("SetPolicy with a stale etag: got success, want error")
+	}
+	got, err := bkt.IAM().V3().Policy(ctx)
+	if err != nil || len(got.Bindings) != 1 {
+		t.Errorf("Policy: got (%v, %v), want one binding", got, err)
+	}
+}
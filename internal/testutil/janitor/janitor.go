@@ -0,0 +1,245 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package janitor deletes cloud resources leaked by tests that failed to
+// clean up after themselves.
+//
+// A Sweeper lists and deletes one kind of resource. A Janitor runs sweepers
+// and deletes the resources its Policy matches: those created by tests,
+// identified by name prefix or label, that are older than a minimum age.
+//
+// Tests should label the resources they create with Labels, so that sweepers
+// can tell their age even when the API doesn't report a creation time:
+//
+//	topic, err := client.CreateTopicWithConfig(ctx, id, &pubsub.TopicConfig{
+//		Labels: janitor.Labels(),
+//	})
+//
+// The janitor can run from TestMain, to clean up after earlier runs:
+//
+//	func TestMain(m *testing.M) {
+//		if tc, ok := testutil.ContextMain(m); ok {
+//			janitor.SweepProject(ctx, janitor.Config{ProjectID: tc.ProjectID}, janitor.DefaultPolicy, janitor.KindTopic)
+//		}
+//		os.Exit(m.Run())
+//	}
+//
+// or as the internal/janitor command.
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resource kinds.
+const (
+	KindBucket       = "bucket"
+	KindSubscription = "subscription"
+	KindTopic        = "topic"
+	KindSecret       = "secret"
+	KindRunService   = "run-service"
+	KindKeyVersion   = "kms-key-version"
+)
+
+// AllKinds lists every kind of resource the janitor can sweep, in the order
+// they are swept. Subscriptions are swept before their topics.
+var AllKinds = []string{KindBucket, KindSubscription, KindTopic, KindSecret, KindRunService, KindKeyVersion}
+
+// TestLabel marks a resource as created by a test. CreatedLabel records when,
+// in Unix seconds.
+const (
+	TestLabel    = "golang-samples-test"
+	CreatedLabel = "golang-samples-created"
+)
+
+// Labels returns the labels tests should set on the resources they create.
+func Labels() map[string]string {
+	return map[string]string{
+		TestLabel:    "true",
+		CreatedLabel: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
+
+// Resource is a resource found by a Sweeper.
+type Resource struct {
+	Kind string
+	// Name is the full resource name, e.g. "projects/p/topics/t".
+	Name string
+	// ID is the name the test chose, which policies match prefixes against,
+	// e.g. "t". For KMS key versions it is the crypto key ID.
+	ID      string
+	Created time.Time
+	Labels  map[string]string
+}
+
+// created returns the creation time of r, falling back to CreatedLabel.
+func (r Resource) created() time.Time {
+	if !r.Created.IsZero() {
+		return r.Created
+	}
+	if v, ok := r.Labels[CreatedLabel]; ok {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// Sweeper lists and deletes one kind of resource.
+type Sweeper interface {
+	// Kind returns the kind of resource the sweeper handles.
+	Kind() string
+	// List returns all resources of the sweeper's kind.
+	List(ctx context.Context) ([]Resource, error)
+	// Delete deletes r, which was returned by List.
+	Delete(ctx context.Context, r Resource) error
+}
+
+// Policy selects the resources to delete.
+type Policy struct {
+	// Prefixes matches resources whose ID starts with any of the prefixes.
+	Prefixes []string
+	// Labels matches resources that have all of the labels. An empty value
+	// matches any value.
+	Labels map[string]string
+	// MinAge is how old a resource must be before it is deleted, so that
+	// resources of tests that are still running are left alone. Resources
+	// of unknown age are only deleted if MinAge is zero.
+	MinAge time.Duration
+}
+
+// DefaultPolicy deletes resources labeled by Labels after a day. Tests that
+// name resources with testutil.UniqueBucketName should add their prefix.
+var DefaultPolicy = Policy{
+	Labels: map[string]string{TestLabel: ""},
+	MinAge: 24 * time.Hour,
+}
+
+// Match reports whether p selects r at time now. A policy without prefixes
+// or labels matches nothing.
+func (p Policy) Match(r Resource, now time.Time) bool {
+	if !p.matchName(r) && !p.matchLabels(r) {
+		return false
+	}
+	if p.MinAge == 0 {
+		return true
+	}
+	created := r.created()
+	return !created.IsZero() && now.Sub(created) >= p.MinAge
+}
+
+func (p Policy) matchName(r Resource) bool {
+	for _, prefix := range p.Prefixes {
+		if prefix != "" && strings.HasPrefix(r.ID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) matchLabels(r Resource) bool {
+	if len(p.Labels) == 0 {
+		return false
+	}
+	for k, want := range p.Labels {
+		got, ok := r.Labels[k]
+		if !ok || (want != "" && got != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// Janitor deletes the resources matched by Policy.
+type Janitor struct {
+	Sweepers []Sweeper
+	Policy   Policy
+	// DryRun logs the resources that would be deleted without deleting them.
+	DryRun bool
+	// Logf logs progress. It defaults to log.Printf.
+	Logf func(format string, args ...interface{})
+
+	now func() time.Time
+}
+
+// Failure is a resource that could not be listed or deleted.
+type Failure struct {
+	Kind     string
+	Resource *Resource // nil if listing failed
+	Err      error
+}
+
+// Report describes the result of a sweep.
+type Report struct {
+	// Deleted lists the deleted resources, or the resources that would be
+	// deleted in a dry run.
+	Deleted  []Resource
+	Failures []Failure
+}
+
+// Sweep runs each sweeper in turn and deletes the resources matched by the
+// policy. It continues past failures, and returns an error if there were
+// any.
+func (j *Janitor) Sweep(ctx context.Context) (*Report, error) {
+	logf := j.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	now := time.Now
+	if j.now != nil {
+		now = j.now
+	}
+
+	rep := &Report{}
+	for _, s := range j.Sweepers {
+		resources, err := s.List(ctx)
+		if err != nil {
+			logf("janitor: could not list %ss: %v", s.Kind(), err)
+			rep.Failures = append(rep.Failures, Failure{Kind: s.Kind(), Err: err})
+			continue
+		}
+		for _, r := range resources {
+			if !j.Policy.Match(r, now()) {
+				continue
+			}
+			if j.DryRun {
+				logf("janitor: would delete %s %s", r.Kind, r.Name)
+				rep.Deleted = append(rep.Deleted, r)
+				continue
+			}
+			logf("janitor: deleting %s %s", r.Kind, r.Name)
+			if err := s.Delete(ctx, r); err != nil {
+				logf("janitor: could not delete %s %s: %v", r.Kind, r.Name, err)
+				r := r
+				rep.Failures = append(rep.Failures, Failure{Kind: s.Kind(), Resource: &r, Err: err})
+				continue
+			}
+			rep.Deleted = append(rep.Deleted, r)
+		}
+	}
+	if n := len(rep.Failures); n > 0 {
+		return rep, fmt.Errorf("janitor: %d failures, first: %w", n, rep.Failures[0].Err)
+	}
+	return rep, nil
+}
+
+// shortName returns the last segment of a resource name.
+func shortName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
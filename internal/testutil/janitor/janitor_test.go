@@ -0,0 +1,255 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package janitor
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"cloud.google.com/go/pubsub"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+)
+
+func TestPolicyMatch(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	policy := Policy{
+		Prefixes: []string{"test-"},
+		Labels:   map[string]string{TestLabel: ""},
+		MinAge:   24 * time.Hour,
+	}
+	tests := []struct {
+		name string
+		p    Policy
+		r    Resource
+		want bool
+	}{
+		{"old prefix", policy, Resource{ID: "test-abc", Created: old}, true},
+		{"new prefix", policy, Resource{ID: "test-abc", Created: now.Add(-time.Hour)}, false},
+		{"other name", policy, Resource{ID: "prod-abc", Created: old}, false},
+		{"old label", policy, Resource{ID: "x", Created: old, Labels: map[string]string{TestLabel: "true"}}, true},
+		{"created label", policy, Resource{ID: "x", Labels: map[string]string{
+			TestLabel:    "true",
+			CreatedLabel: strconv.FormatInt(old.Unix(), 10),
+		}}, true},
+		{"unknown age", policy, Resource{ID: "test-abc"}, false},
+		{"unknown age, no MinAge", Policy{Prefixes: []string{"test-"}}, Resource{ID: "test-abc"}, true},
+		{"label value", Policy{Labels: map[string]string{"env": "ci"}}, Resource{Labels: map[string]string{"env": "prod"}}, false},
+		{"empty policy", Policy{}, Resource{ID: "test-abc", Created: old}, false},
+	}
+	for _, tc := range tests {
+		if got := tc.p.Match(tc.r, now); got != tc.want {
+			t.Errorf("%s: Match: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+type fakeSweeper struct {
+	resources []Resource
+	listErr   error
+	deleteErr map[string]error
+	deleted   []string
+}
+
+func (s *fakeSweeper) Kind() string { return "fake" }
+
+func (s *fakeSweeper) List(ctx context.Context) ([]Resource, error) {
+	return s.resources, s.listErr
+}
+
+func (s *fakeSweeper) Delete(ctx context.Context, r Resource) error {
+	if err := s.deleteErr[r.Name]; err != nil {
+		return err
+	}
+	s.deleted = append(s.deleted, r.Name)
+	return nil
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	quiet := func(string, ...interface{}) {}
+	newSweeper := func() *fakeSweeper {
+		return &fakeSweeper{
+			resources: []Resource{
+				{Kind: "fake", Name: "a", ID: "test-a"},
+				{Kind: "fake", Name: "b", ID: "test-b"},
+				{Kind: "fake", Name: "c", ID: "keep-c"},
+			},
+			deleteErr: map[string]error{"b": errors.New("permission denied")},
+		}
+	}
+	policy := Policy{Prefixes: []string{"test-"}}
+
+	s := newSweeper()
+	j := &Janitor{Sweepers: []Sweeper{s}, Policy: policy, DryRun: true, Logf: quiet}
+	rep, err := j.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("dry run: Sweep: %v", err)
+	}
+	if len(rep.Deleted) != 2 || len(s.deleted) != 0 {
+		t.Errorf("dry run: got %d reported and %d deleted, want 2 and 0", len(rep.Deleted), len(s.deleted))
+	}
+
+	failing := &fakeSweeper{listErr: errors.New("unavailable")}
+	s = newSweeper()
+	j = &Janitor{Sweepers: []Sweeper{failing, s}, Policy: policy, Logf: quiet}
+	rep, err = j.Sweep(ctx)
+	if err == nil {
+		t.Errorf("Sweep: got no error, want one")
+	}
+	if got, want := s.deleted, []string{"a"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Sweep: deleted %v, want %v", got, want)
+	}
+	if len(rep.Failures) != 2 || rep.Failures[0].Resource != nil || rep.Failures[1].Resource.Name != "b" {
+		t.Errorf("Sweep: got failures %+v, want a list failure and a delete failure of b", rep.Failures)
+	}
+}
+
+func sweepNames(t *testing.T, s Sweeper, p Policy) []string {
+	t.Helper()
+	j := &Janitor{Sweepers: []Sweeper{s}, Policy: p, Logf: func(string, ...interface{}) {}}
+	rep, err := j.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("%s: Sweep: %v", s.Kind(), err)
+	}
+	var names []string
+	for _, r := range rep.Deleted {
+		names = append(names, r.ID)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSweepers(t *testing.T) {
+	ctx := context.Background()
+	const project = "my-project"
+	p := Policy{Prefixes: []string{"test-"}, Labels: map[string]string{TestLabel: ""}}
+
+	t.Run("buckets", func(t *testing.T) {
+		fake := fakes.NewStorage(t)
+		client, err := storage.NewClient(ctx, fake.ClientOptions()...)
+		if err != nil {
+			t.Fatalf("storage.NewClient: %v", err)
+		}
+		defer client.Close()
+		for _, b := range []string{"test-bucket", "prod-bucket"} {
+			if err := client.Bucket(b).Create(ctx, project, nil); err != nil {
+				t.Fatalf("Create(%q): %v", b, err)
+			}
+		}
+		if err := client.Bucket("labeled").Create(ctx, project, &storage.BucketAttrs{Labels: Labels()}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		s := &BucketSweeper{Client: client, ProjectID: project}
+		if got := sweepNames(t, s, p); len(got) != 2 || got[0] != "labeled" || got[1] != "test-bucket" {
+			t.Errorf("swept %v, want [labeled test-bucket]", got)
+		}
+		if _, err := client.Bucket("prod-bucket").Attrs(ctx); err != nil {
+			t.Errorf("prod-bucket: %v", err)
+		}
+	})
+
+	t.Run("pubsub", func(t *testing.T) {
+		fake := fakes.NewPubSub(t)
+		client, err := pubsub.NewClient(ctx, project, fake.ClientOptions()...)
+		if err != nil {
+			t.Fatalf("pubsub.NewClient: %v", err)
+		}
+		defer client.Close()
+		topic, err := client.CreateTopicWithConfig(ctx, "labeled-topic", &pubsub.TopicConfig{Labels: Labels()})
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+		if _, err := client.CreateTopic(ctx, "prod-topic"); err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+		if _, err := client.CreateSubscription(ctx, "test-sub", pubsub.SubscriptionConfig{Topic: topic}); err != nil {
+			t.Fatalf("CreateSubscription: %v", err)
+		}
+		if got := sweepNames(t, &SubscriptionSweeper{Client: client}, p); len(got) != 1 || got[0] != "test-sub" {
+			t.Errorf("subscriptions: swept %v, want [test-sub]", got)
+		}
+		if got := sweepNames(t, &TopicSweeper{Client: client}, p); len(got) != 1 || got[0] != "labeled-topic" {
+			t.Errorf("topics: swept %v, want [labeled-topic]", got)
+		}
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		fake := fakes.NewSecretManager(t)
+		client, err := secretmanager.NewClient(ctx, fake.ClientOptions()...)
+		if err != nil {
+			t.Fatalf("secretmanager.NewClient: %v", err)
+		}
+		defer client.Close()
+		for _, id := range []string{"test-secret", "prod-secret"} {
+			if _, err := client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+				Parent:   "projects/" + project,
+				SecretId: id,
+				Secret: &secretmanagerpb.Secret{
+					Replication: &secretmanagerpb.Replication{
+						Replication: &secretmanagerpb.Replication_Automatic_{},
+					},
+				},
+			}); err != nil {
+				t.Fatalf("CreateSecret: %v", err)
+			}
+		}
+		if got := sweepNames(t, &SecretSweeper{Client: client, ProjectID: project}, p); len(got) != 1 || got[0] != "test-secret" {
+			t.Errorf("swept %v, want [test-secret]", got)
+		}
+	})
+
+	t.Run("key versions", func(t *testing.T) {
+		fake := fakes.NewKMS(t)
+		client, err := kms.NewKeyManagementClient(ctx, fake.ClientOptions()...)
+		if err != nil {
+			t.Fatalf("kms.NewKeyManagementClient: %v", err)
+		}
+		defer client.Close()
+		kr, err := client.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
+			Parent:    "projects/" + project + "/locations/global",
+			KeyRingId: "ring",
+		})
+		if err != nil {
+			t.Fatalf("CreateKeyRing: %v", err)
+		}
+		for _, id := range []string{"test-key", "prod-key"} {
+			if _, err := client.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+				Parent:      kr.Name,
+				CryptoKeyId: id,
+				CryptoKey:   &kmspb.CryptoKey{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT},
+			}); err != nil {
+				t.Fatalf("CreateCryptoKey: %v", err)
+			}
+		}
+		s := &KeyVersionSweeper{Client: client, ProjectID: project, Location: "global"}
+		if got := sweepNames(t, s, p); len(got) != 1 || got[0] != "test-key" {
+			t.Errorf("swept %v, want [test-key]", got)
+		}
+		// Destroyed versions are not swept again.
+		if got := sweepNames(t, s, p); len(got) != 0 {
+			t.Errorf("second sweep: swept %v, want none", got)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/pubsub"
+	run "cloud.google.com/go/run/apiv2"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/storage"
+)
+
+// Config describes the project NewSweepers sweeps.
+type Config struct {
+	ProjectID string
+	// Region is the Cloud Run region. It defaults to us-central1.
+	Region string
+	// KMSLocation is the KMS location. It defaults to global.
+	KMSLocation string
+}
+
+// NewSweepers returns sweepers for kinds, in the order of AllKinds, using
+// Application Default Credentials. If kinds is empty, it returns sweepers for
+// all kinds. Call the returned function to close the clients.
+func NewSweepers(ctx context.Context, cfg Config, kinds ...string) ([]Sweeper, func(), error) {
+	if cfg.ProjectID == "" {
+		return nil, nil, fmt.Errorf("janitor: no project ID")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-central1"
+	}
+	if cfg.KMSLocation == "" {
+		cfg.KMSLocation = "global"
+	}
+	want := make(map[string]bool)
+	for _, k := range kinds {
+		want[k] = true
+	}
+	for k := range want {
+		if !contains(AllKinds, k) {
+			return nil, nil, fmt.Errorf("janitor: unknown kind %q", k)
+		}
+	}
+
+	var (
+		sweepers []Sweeper
+		closers  []func() error
+		ps       *pubsub.Client
+	)
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	for _, kind := range AllKinds {
+		if len(want) > 0 && !want[kind] {
+			continue
+		}
+		var err error
+		switch kind {
+		case KindBucket:
+			var c *storage.Client
+			if c, err = storage.NewClient(ctx); err == nil {
+				closers = append(closers, c.Close)
+				sweepers = append(sweepers, &BucketSweeper{Client: c, ProjectID: cfg.ProjectID})
+			}
+		case KindSubscription, KindTopic:
+			if ps == nil {
+				if ps, err = pubsub.NewClient(ctx, cfg.ProjectID); err != nil {
+					break
+				}
+				closers = append(closers, ps.Close)
+			}
+			if kind == KindTopic {
+				sweepers = append(sweepers, &TopicSweeper{Client: ps})
+			} else {
+				sweepers = append(sweepers, &SubscriptionSweeper{Client: ps})
+			}
+		case KindSecret:
+			var c *secretmanager.Client
+			if c, err = secretmanager.NewClient(ctx); err == nil {
+				closers = append(closers, c.Close)
+				sweepers = append(sweepers, &SecretSweeper{Client: c, ProjectID: cfg.ProjectID})
+			}
+		case KindRunService:
+			var c *run.ServicesClient
+			if c, err = run.NewServicesClient(ctx); err == nil {
+				closers = append(closers, c.Close)
+				sweepers = append(sweepers, &RunServiceSweeper{Client: c, ProjectID: cfg.ProjectID, Region: cfg.Region})
+			}
+		case KindKeyVersion:
+			var c *kms.KeyManagementClient
+			if c, err = kms.NewKeyManagementClient(ctx); err == nil {
+				closers = append(closers, c.Close)
+				sweepers = append(sweepers, &KeyVersionSweeper{Client: c, ProjectID: cfg.ProjectID, Location: cfg.KMSLocation})
+			}
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("janitor: creating %s client: %w", kind, err)
+		}
+	}
+	return sweepers, closeAll, nil
+}
+
+// SweepProject deletes the resources of kinds in a project that p matches.
+// It is meant for TestMain, so it logs failures rather than returning them.
+func SweepProject(ctx context.Context, cfg Config, p Policy, kinds ...string) {
+	sweepers, closeAll, err := NewSweepers(ctx, cfg, kinds...)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	defer closeAll()
+	j := &Janitor{Sweepers: sweepers, Policy: p}
+	if rep, err := j.Sweep(ctx); err == nil {
+		log.Printf("janitor: deleted %d resources", len(rep.Deleted))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
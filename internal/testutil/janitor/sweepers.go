@@ -0,0 +1,298 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"cloud.google.com/go/pubsub"
+	run "cloud.google.com/go/run/apiv2"
+	"cloud.google.com/go/run/apiv2/runpb"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// createTime converts ts, returning the zero time rather than the Unix epoch
+// if it is unset.
+func createTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// BucketSweeper sweeps Cloud Storage buckets and their objects.
+type BucketSweeper struct {
+	Client    *storage.Client
+	ProjectID string
+}
+
+// Kind implements Sweeper.
+func (s *BucketSweeper) Kind() string { return KindBucket }
+
+// List implements Sweeper.
+func (s *BucketSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	it := s.Client.Buckets(ctx, s.ProjectID)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Buckets(%q).Next: %w", s.ProjectID, err)
+		}
+		rs = append(rs, Resource{
+			Kind:    KindBucket,
+			Name:    attrs.Name,
+			ID:      attrs.Name,
+			Created: attrs.Created,
+			Labels:  attrs.Labels,
+		})
+	}
+}
+
+// Delete implements Sweeper.
+func (s *BucketSweeper) Delete(ctx context.Context, r Resource) error {
+	return testutil.DeleteBucketIfExists(ctx, s.Client, r.Name)
+}
+
+// TopicSweeper sweeps Pub/Sub topics. The API doesn't report when topics
+// were created, so their age comes from CreatedLabel.
+type TopicSweeper struct {
+	Client *pubsub.Client
+}
+
+// Kind implements Sweeper.
+func (s *TopicSweeper) Kind() string { return KindTopic }
+
+// List implements Sweeper.
+func (s *TopicSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	it := s.Client.Topics(ctx)
+	for {
+		cfg, err := it.NextConfig()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Topics.NextConfig: %w", err)
+		}
+		rs = append(rs, Resource{
+			Kind:   KindTopic,
+			Name:   cfg.String(),
+			ID:     cfg.ID(),
+			Labels: cfg.Labels,
+		})
+	}
+}
+
+// Delete implements Sweeper.
+func (s *TopicSweeper) Delete(ctx context.Context, r Resource) error {
+	return s.Client.Topic(r.ID).Delete(ctx)
+}
+
+// SubscriptionSweeper sweeps Pub/Sub subscriptions. Like topics, their age
+// comes from CreatedLabel.
+type SubscriptionSweeper struct {
+	Client *pubsub.Client
+}
+
+// Kind implements Sweeper.
+func (s *SubscriptionSweeper) Kind() string { return KindSubscription }
+
+// List implements Sweeper.
+func (s *SubscriptionSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	it := s.Client.Subscriptions(ctx)
+	for {
+		cfg, err := it.NextConfig()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Subscriptions.NextConfig: %w", err)
+		}
+		rs = append(rs, Resource{
+			Kind:   KindSubscription,
+			Name:   cfg.String(),
+			ID:     cfg.ID(),
+			Labels: cfg.Labels,
+		})
+	}
+}
+
+// Delete implements Sweeper.
+func (s *SubscriptionSweeper) Delete(ctx context.Context, r Resource) error {
+	return s.Client.Subscription(r.ID).Delete(ctx)
+}
+
+// SecretSweeper sweeps Secret Manager secrets and all their versions.
+type SecretSweeper struct {
+	Client    *secretmanager.Client
+	ProjectID string
+}
+
+// Kind implements Sweeper.
+func (s *SecretSweeper) Kind() string { return KindSecret }
+
+// List implements Sweeper.
+func (s *SecretSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	it := s.Client.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{
+		Parent: "projects/" + s.ProjectID,
+	})
+	for {
+		sec, err := it.Next()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListSecrets: %w", err)
+		}
+		rs = append(rs, Resource{
+			Kind:    KindSecret,
+			Name:    sec.GetName(),
+			ID:      shortName(sec.GetName()),
+			Created: createTime(sec.GetCreateTime()),
+			Labels:  sec.GetLabels(),
+		})
+	}
+}
+
+// Delete implements Sweeper.
+func (s *SecretSweeper) Delete(ctx context.Context, r Resource) error {
+	return s.Client.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: r.Name})
+}
+
+// RunServiceSweeper sweeps Cloud Run services in one region.
+type RunServiceSweeper struct {
+	Client    *run.ServicesClient
+	ProjectID string
+	Region    string
+}
+
+// Kind implements Sweeper.
+func (s *RunServiceSweeper) Kind() string { return KindRunService }
+
+// List implements Sweeper.
+func (s *RunServiceSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	it := s.Client.ListServices(ctx, &runpb.ListServicesRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/%s", s.ProjectID, s.Region),
+	})
+	for {
+		svc, err := it.Next()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListServices: %w", err)
+		}
+		rs = append(rs, Resource{
+			Kind:    KindRunService,
+			Name:    svc.GetName(),
+			ID:      shortName(svc.GetName()),
+			Created: createTime(svc.GetCreateTime()),
+			Labels:  svc.GetLabels(),
+		})
+	}
+}
+
+// Delete implements Sweeper.
+func (s *RunServiceSweeper) Delete(ctx context.Context, r Resource) error {
+	op, err := s.Client.DeleteService(ctx, &runpb.DeleteServiceRequest{Name: r.Name})
+	if err != nil {
+		return err
+	}
+	_, err = op.Wait(ctx)
+	return err
+}
+
+// KeyVersionSweeper sweeps KMS crypto key versions in one location. Key
+// rings and keys cannot be deleted, so it schedules the destruction of their
+// enabled and disabled versions. Resources are matched by the ID and labels
+// of their crypto key.
+type KeyVersionSweeper struct {
+	Client    *kms.KeyManagementClient
+	ProjectID string
+	Location  string
+}
+
+// Kind implements Sweeper.
+func (s *KeyVersionSweeper) Kind() string { return KindKeyVersion }
+
+// List implements Sweeper.
+func (s *KeyVersionSweeper) List(ctx context.Context) ([]Resource, error) {
+	var rs []Resource
+	rings := s.Client.ListKeyRings(ctx, &kmspb.ListKeyRingsRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/%s", s.ProjectID, s.Location),
+	})
+	for {
+		ring, err := rings.Next()
+		if err == iterator.Done {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListKeyRings: %w", err)
+		}
+		keys := s.Client.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{Parent: ring.GetName()})
+		for {
+			key, err := keys.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ListCryptoKeys(%q): %w", ring.GetName(), err)
+			}
+			versions := s.Client.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{Parent: key.GetName()})
+			for {
+				v, err := versions.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("ListCryptoKeyVersions(%q): %w", key.GetName(), err)
+				}
+				switch v.GetState() {
+				case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+				default:
+					continue
+				}
+				rs = append(rs, Resource{
+					Kind:    KindKeyVersion,
+					Name:    v.GetName(),
+					ID:      shortName(key.GetName()),
+					Created: createTime(v.GetCreateTime()),
+					Labels:  key.GetLabels(),
+				})
+			}
+		}
+	}
+}
+
+// Delete implements Sweeper.
+func (s *KeyVersionSweeper) Delete(ctx context.Context, r Resource) error {
+	_, err := s.Client.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{Name: r.Name})
+	return err
+}
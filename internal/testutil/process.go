@@ -0,0 +1,296 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Process is a built binary running in the background, such as an HTTP
+// server under test. Its output is captured as it is written.
+type Process struct {
+	// URL is the base URL of a process started with StartServer, e.g.
+	// "http://localhost:41234".
+	URL string
+
+	cmd  *exec.Cmd
+	out  *output
+	done chan struct{}
+	err  error // result of cmd.Wait, valid once done is closed
+}
+
+// Start runs the built binary in the background. You can supply extra
+// arguments for the binary via args.
+// If the Runner was created by BuildMain, the process is killed when the
+// test ends, if it is still running.
+func (r *Runner) Start(env map[string]string, args ...string) (*Process, error) {
+	if !r.Built() {
+		return nil, fmt.Errorf("tried to run when binary not built")
+	}
+
+	p := &Process{out: newOutput(r.Output), done: make(chan struct{})}
+	p.cmd = exec.Command(r.bin, args...)
+	p.cmd.Env = r.environ(env)
+	p.cmd.Stdout = p.out.writer(&p.out.stdout)
+	p.cmd.Stderr = p.out.writer(&p.out.stderr)
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not execute binary: %w", err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.done)
+		p.out.notify()
+	}()
+	if r.t != nil {
+		r.t.Cleanup(p.kill)
+	}
+	return p, nil
+}
+
+// StartServer starts the built binary with PORT set to a free port and waits
+// until it accepts connections on that port, or ctx is done.
+func (r *Runner) StartServer(ctx context.Context, env map[string]string, args ...string) (*Process, error) {
+	port, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+	e := map[string]string{"PORT": strconv.Itoa(port)}
+	for k, v := range env {
+		e[k] = v
+	}
+	p, err := r.Start(e, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.WaitForPort(ctx, port); err != nil {
+		p.kill()
+		return nil, err
+	}
+	p.URL = "http://localhost:" + strconv.Itoa(port)
+	return p, nil
+}
+
+// FreePort returns a TCP port on localhost that is not in use.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, fmt.Errorf("net.Listen: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// WaitForPort waits until the process accepts TCP connections on port.
+// It fails if the process exits first or ctx is done.
+func (p *Process) WaitForPort(ctx context.Context, port int) error {
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("process exited before listening on %s: %v\n%s", addr, p.err, p.Stderr())
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", addr, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// WaitForHTTP waits until a GET request for url returns a status below 500.
+// It fails if the process exits first or ctx is done.
+func (p *Process) WaitForHTTP(ctx context.Context, url string) error {
+	client := &http.Client{Timeout: time.Second}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				return nil
+			}
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("process exited before %s was ready: %v\n%s", url, p.err, p.Stderr())
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", url, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// WaitForOutput waits until re matches the stdout or stderr of the process,
+// and returns the match. It fails if the process exits without a match or
+// ctx is done.
+func (p *Process) WaitForOutput(ctx context.Context, re *regexp.Regexp) (string, error) {
+	for {
+		changed := p.out.changed()
+		for _, b := range [][]byte{p.Stdout(), p.Stderr()} {
+			if m := re.Find(b); m != nil {
+				return string(m), nil
+			}
+		}
+		select {
+		case <-changed:
+		case <-p.done:
+			// Output is complete once the process has exited; check once more.
+			for _, b := range [][]byte{p.Stdout(), p.Stderr()} {
+				if m := re.Find(b); m != nil {
+					return string(m), nil
+				}
+			}
+			return "", fmt.Errorf("process exited without output matching %q: %v", re, p.err)
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for output matching %q: %w", re, ctx.Err())
+		}
+	}
+}
+
+// Stdout returns the standard output written so far.
+func (p *Process) Stdout() []byte {
+	return p.out.bytes(&p.out.stdout)
+}
+
+// Stderr returns the standard error written so far.
+func (p *Process) Stderr() []byte {
+	return p.out.bytes(&p.out.stderr)
+}
+
+// Signal sends sig to the process.
+func (p *Process) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// Wait waits for the process to exit and returns its exit code. The exit
+// code is -1 if the process was killed by a signal. An error is returned
+// only if ctx is done first or the process could not be waited for.
+func (p *Process) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return 0, fmt.Errorf("waiting for process to exit: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if p.err != nil && !errors.As(p.err, &exitErr) {
+		return 0, p.err
+	}
+	return p.cmd.ProcessState.ExitCode(), nil
+}
+
+// Terminate sends SIGTERM to the process and waits up to timeout for it to
+// shut down, returning its exit code. If the process doesn't exit in time it
+// is killed and an error is returned.
+func (p *Process) Terminate(timeout time.Duration) (int, error) {
+	select {
+	case <-p.done:
+		return p.Wait(context.Background())
+	default:
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return 0, fmt.Errorf("could not signal process: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	code, err := p.Wait(ctx)
+	if err != nil {
+		p.kill()
+		return 0, fmt.Errorf("process did not exit within %v of SIGTERM", timeout)
+	}
+	return code, nil
+}
+
+// kill stops the process if it is still running and waits for it to exit.
+func (p *Process) kill() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.cmd.Process.Kill()
+	<-p.done
+}
+
+// output captures the stdout and stderr of a process, and notifies waiters
+// when either changes.
+type output struct {
+	mu      sync.Mutex
+	stdout  bytes.Buffer
+	stderr  bytes.Buffer
+	tee     io.Writer // if set, also receives both streams
+	changes chan struct{}
+}
+
+func newOutput(tee io.Writer) *output {
+	return &output{tee: tee, changes: make(chan struct{})}
+}
+
+// changed returns a channel that is closed on the next write.
+func (o *output) changed() <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.changes
+}
+
+func (o *output) notify() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	close(o.changes)
+	o.changes = make(chan struct{})
+}
+
+func (o *output) bytes(b *bytes.Buffer) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]byte(nil), b.Bytes()...)
+}
+
+func (o *output) writer(b *bytes.Buffer) *outputWriter {
+	return &outputWriter{o: o, b: b}
+}
+
+type outputWriter struct {
+	o *output
+	b *bytes.Buffer
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	n, err := w.b.Write(p)
+	if w.o.tee != nil {
+		w.o.tee.Write(p)
+	}
+	w.o.mu.Unlock()
+	w.o.notify()
+	return n, err
+}
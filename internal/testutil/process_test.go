@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func buildServer(t *testing.T, opts ...BuildOption) *Runner {
+	t.Helper()
+	r, err := BuildMainDir(filepath.Join("testdata", "server"), opts...)
+	if r == nil {
+		t.Fatal(err)
+	}
+	r.t = t
+	t.Cleanup(r.Cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestStartServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	r := buildServer(t, WithCoverage())
+
+	tests := []struct {
+		env      map[string]string
+		wantCode int
+	}{
+		{wantCode: 0},
+		{env: map[string]string{"EXIT_CODE": "3"}, wantCode: 3},
+	}
+	for _, tc := range tests {
+		p, err := r.StartServer(ctx, tc.env)
+		if err != nil {
+			t.Fatalf("StartServer: %v", err)
+		}
+		if _, err := p.WaitForOutput(ctx, regexp.MustCompile(`listening on port \d+`)); err != nil {
+			t.Errorf("WaitForOutput: %v", err)
+		}
+
+		resp, err := http.Get(p.URL + "/hello")
+		if err != nil {
+			t.Fatalf("http.Get: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := string(body), "Hello World!\n"; got != want {
+			t.Errorf("response: got %q, want %q", got, want)
+		}
+		if _, err := p.WaitForOutput(ctx, regexp.MustCompile(`request /hello`)); err != nil {
+			t.Errorf("WaitForOutput: %v", err)
+		}
+
+		code, err := p.Terminate(10 * time.Second)
+		if err != nil {
+			t.Fatalf("Terminate: %v", err)
+		}
+		if code != tc.wantCode {
+			t.Errorf("Terminate: got exit code %d, want %d", code, tc.wantCode)
+		}
+		if got, want := string(p.Stderr()), "server exited"; !strings.Contains(got, want) {
+			t.Errorf("Stderr: got %q, want it to contain %q", got, want)
+		}
+	}
+
+	profile := filepath.Join(t.TempDir(), "cover.out")
+	if err := r.WriteCoverProfile(profile); err != nil {
+		t.Fatalf("WriteCoverProfile: %v", err)
+	}
+	b, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.HasPrefix(string(b), "mode: ") || !strings.Contains(string(b), "main.go") {
+		t.Errorf("cover profile: got %q, want a profile of main.go", b)
+	}
+}
+
+func TestWaitForOutputExited(t *testing.T) {
+	r := buildServer(t)
+	// An invalid port makes the server exit immediately.
+	p, err := r.Start(map[string]string{"PORT": "-1"})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := p.WaitForOutput(ctx, regexp.MustCompile(`never printed`)); err == nil {
+		t.Errorf("WaitForOutput: got no error after the process exited")
+	}
+	code, err := p.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if code != 1 {
+		t.Errorf("Wait: got exit code %d, want 1", code)
+	}
+}
+
+func TestWaitForHTTP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	r := buildServer(t)
+	var out bytes.Buffer
+	r.Output = &out
+
+	p, err := r.StartServer(ctx, nil)
+	if err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+	if err := p.WaitForHTTP(ctx, p.URL+"/hello"); err != nil {
+		t.Errorf("WaitForHTTP: %v", err)
+	}
+	if _, err := p.Terminate(10 * time.Second); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+	if got, want := out.String(), "server exited"; !strings.Contains(got, want) {
+		t.Errorf("Output: got %q, want it to contain %q", got, want)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := p.WaitForHTTP(short, p.URL+"/hello"); err == nil {
+		t.Errorf("WaitForHTTP after exit: got no error")
+	}
+}
+
+func TestCoverSupported(t *testing.T) {
+	tests := []struct {
+		version string
+		wantErr bool
+	}{
+		{version: "go1.19.13", wantErr: true},
+		{version: "go1.20"},
+		{version: "go1.21.5"},
+		{version: "devel go1.23-0123456 Mon Jan 1 00:00:00 2024 +0000"},
+		{version: "devel +0123456"},
+	}
+	for _, tc := range tests {
+		if err := coverSupported(tc.version); (err != nil) != tc.wantErr {
+			t.Errorf("coverSupported(%q): got %v, want error: %v", tc.version, err, tc.wantErr)
+		}
+	}
+}
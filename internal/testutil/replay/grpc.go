@@ -0,0 +1,228 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// GRPCClientOptions returns options for client libraries that use gRPC.
+// When replaying, clients are not authenticated and never connect.
+func (s *Session) GRPCClientOptions() []option.ClientOption {
+	opts := []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(s.UnaryClientInterceptor())),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(s.StreamClientInterceptor())),
+	}
+	if !s.recording {
+		opts = append(opts,
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	}
+	return opts
+}
+
+// UnaryClientInterceptor returns an interceptor that records unary calls, or
+// replays them without calling the server.
+func (s *Session) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		reqBody, err := s.protoBody(req)
+		if err != nil {
+			return status.Errorf(codes.Internal, "replay: %s: %v", method, err)
+		}
+
+		if !s.recording {
+			in, err := s.next(func(in *Interaction) bool {
+				return in.Kind == KindGRPC && in.Method == method
+			}, func(in *Interaction) bool {
+				return in.Request.equal(reqBody)
+			})
+			if err != nil {
+				return status.Errorf(codes.Unimplemented, "replay: %s: %v", method, err)
+			}
+			if in.Code != 0 {
+				return status.Error(codes.Code(in.Code), in.Message)
+			}
+			m, ok := reply.(proto.Message)
+			if !ok {
+				return status.Errorf(codes.Internal, "replay: %s: reply %T is not a proto.Message", method, reply)
+			}
+			if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(in.Response.data(), m); err != nil {
+				return status.Errorf(codes.Internal, "replay: %s: protojson.Unmarshal: %v", method, err)
+			}
+			return nil
+		}
+
+		callErr := invoker(ctx, method, req, reply, cc, opts...)
+		in := &Interaction{Kind: KindGRPC, Method: method, Request: reqBody}
+		if callErr != nil {
+			st := status.Convert(callErr)
+			in.Code = int(st.Code())
+			in.Message = s.scrub(st.Message())
+		} else if in.Response, err = s.protoBody(reply); err != nil {
+			return status.Errorf(codes.Internal, "replay: %s: %v", method, err)
+		}
+		s.add(in)
+		return callErr
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that records the messages
+// of streaming calls, or replays them without calling the server.
+//
+// Replayed streams are matched by method, in the order they were opened.
+// Messages the client sends are not compared with the recording, and the
+// recorded responses are returned whatever the client sends.
+func (s *Session) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !s.recording {
+			in, err := s.next(func(in *Interaction) bool {
+				return in.Kind == KindGRPCStream && in.Method == method
+			}, func(*Interaction) bool {
+				return true
+			})
+			if err != nil {
+				return nil, status.Errorf(codes.Unimplemented, "replay: %s: %v", method, err)
+			}
+			return &replayStream{ctx: ctx, method: method, in: in}, nil
+		}
+
+		in := &Interaction{Kind: KindGRPCStream, Method: method}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			st := status.Convert(err)
+			in.Code = int(st.Code())
+			in.Message = s.scrub(st.Message())
+		}
+		s.add(in)
+		if err != nil {
+			return nil, err
+		}
+		return &recordStream{ClientStream: cs, s: s, in: in}, nil
+	}
+}
+
+// recordStream records the messages sent and received on a stream, and the
+// status it ends with.
+type recordStream struct {
+	grpc.ClientStream
+	s  *Session
+	in *Interaction
+}
+
+func (rs *recordStream) SendMsg(m interface{}) error {
+	if err := rs.ClientStream.SendMsg(m); err != nil {
+		return err
+	}
+	b, err := rs.s.protoBody(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "replay: %s: %v", rs.in.Method, err)
+	}
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+	rs.in.Requests = append(rs.in.Requests, b)
+	return nil
+}
+
+func (rs *recordStream) RecvMsg(m interface{}) error {
+	recvErr := rs.ClientStream.RecvMsg(m)
+	var b *Body
+	var code int
+	var msg string
+	switch {
+	case recvErr == io.EOF:
+	case recvErr != nil:
+		st := status.Convert(recvErr)
+		code, msg = int(st.Code()), rs.s.scrub(st.Message())
+	default:
+		var err error
+		if b, err = rs.s.protoBody(m); err != nil {
+			return status.Errorf(codes.Internal, "replay: %s: %v", rs.in.Method, err)
+		}
+	}
+	rs.s.mu.Lock()
+	defer rs.s.mu.Unlock()
+	if recvErr == nil {
+		rs.in.Responses = append(rs.in.Responses, b)
+	} else {
+		rs.in.Code, rs.in.Message = code, msg
+	}
+	return recvErr
+}
+
+// replayStream returns the recorded responses of a stream in order, then the
+// status it ended with.
+type replayStream struct {
+	ctx    context.Context
+	method string
+	in     *Interaction
+	next   int
+}
+
+func (rs *replayStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (rs *replayStream) Trailer() metadata.MD         { return metadata.MD{} }
+func (rs *replayStream) CloseSend() error             { return nil }
+func (rs *replayStream) Context() context.Context     { return rs.ctx }
+func (rs *replayStream) SendMsg(m interface{}) error  { return nil }
+
+func (rs *replayStream) RecvMsg(m interface{}) error {
+	if rs.next >= len(rs.in.Responses) {
+		if rs.in.Code != 0 {
+			return status.Error(codes.Code(rs.in.Code), rs.in.Message)
+		}
+		return io.EOF
+	}
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "replay: %s: reply %T is not a proto.Message", rs.method, m)
+	}
+	data := rs.in.Responses[rs.next].data()
+	rs.next++
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, pm); err != nil {
+		return status.Errorf(codes.Internal, "replay: %s: protojson.Unmarshal: %v", rs.method, err)
+	}
+	return nil
+}
+
+// protoBody returns the scrubbed JSON form of m. protojson output varies in
+// whitespace between runs, so it is compacted first.
+func (s *Session) protoBody(m interface{}) (*Body, error) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", m)
+	}
+	b, err := protojson.Marshal(pm)
+	if err != nil {
+		return nil, fmt.Errorf("protojson.Marshal: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return nil, fmt.Errorf("json.Compact: %w", err)
+	}
+	return s.body(buf.Bytes()), nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+const (
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	storageURL         = "https://storage.googleapis.com"
+)
+
+// HTTPClientOptions returns options for client libraries that use HTTP, such
+// as Cloud Storage. When recording, requests are authenticated with
+// Application Default Credentials.
+func (s *Session) HTTPClientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var base http.RoundTripper
+	if s.recording {
+		var err error
+		base, err = htransport.NewTransport(ctx, http.DefaultTransport, option.WithScopes(cloudPlatformScope))
+		if err != nil {
+			return nil, fmt.Errorf("htransport.NewTransport: %w", err)
+		}
+	}
+	return []option.ClientOption{
+		option.WithHTTPClient(&http.Client{Transport: s.Transport(base)}),
+	}, nil
+}
+
+// SetStorageEmulatorHost points STORAGE_EMULATOR_HOST at a local server that
+// records or replays Cloud Storage requests, for samples that create their own
+// storage.Client. The server is stopped when the test ends.
+//
+// When recording, requests are sent to Cloud Storage with Application Default
+// Credentials or, if STORAGE_EMULATOR_HOST is already set, to that emulator.
+// A test running live without -record is left to use them directly.
+func (s *Session) SetStorageEmulatorHost(ctx context.Context) {
+	s.t.Helper()
+	if s.live {
+		return
+	}
+	upstream, base := storageURL, http.RoundTripper(nil)
+	if s.recording {
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			if !strings.Contains(host, "://") {
+				host = "http://" + host
+			}
+			upstream = strings.TrimSuffix(host, "/")
+			s.AddScrubber(regexp.MustCompile(regexp.QuoteMeta(upstream)), storageURL)
+		} else {
+			var err error
+			base, err = htransport.NewTransport(ctx, http.DefaultTransport, option.WithScopes(cloudPlatformScope))
+			if err != nil {
+				s.t.Fatalf("replay: htransport.NewTransport: %v", err)
+			}
+		}
+	}
+	srv := httptest.NewServer(s.proxy(upstream, base))
+	s.t.Cleanup(srv.Close)
+	s.t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+}
+
+// proxy returns a handler that sends the requests it receives to upstream
+// through s.Transport(base).
+func (s *Session) proxy(upstream string, base http.RoundTripper) http.Handler {
+	t := s.Transport(base)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := http.NewRequestWithContext(r.Context(), r.Method, upstream+r.URL.RequestURI(), r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		out.Header = r.Header.Clone()
+		out.ContentLength = r.ContentLength
+		resp, err := t.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, vs := range resp.Header {
+			if k == "Content-Length" {
+				continue
+			}
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+}
+
+// Transport returns an http.RoundTripper that records the requests it sends
+// through base, or replays them without using base.
+func (s *Session) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{s: s, base: base}
+}
+
+type transport struct {
+	s    *Session
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	url := t.s.scrub(req.URL.String())
+	reqBody := t.s.body(body)
+
+	if !t.s.recording {
+		in, err := t.s.next(func(in *Interaction) bool {
+			return in.Kind == KindHTTP && in.Method == req.Method && in.URL == url
+		}, func(in *Interaction) bool {
+			return in.Request.equal(reqBody)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("replay: %s %s: %w", req.Method, url, err)
+		}
+		data := in.Response.data()
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			ContentLength: int64(len(data)),
+			Request:       req,
+		}, nil
+	}
+
+	out := req.Clone(req.Context())
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	t.s.add(&Interaction{
+		Kind:     KindHTTP,
+		Method:   req.Method,
+		URL:      url,
+		Request:  reqBody,
+		Status:   resp.StatusCode,
+		Header:   t.s.header(resp.Header),
+		Response: t.s.body(data),
+	})
+	return resp, nil
+}
+
+// keptHeaders are the response headers saved in recordings, in addition to
+// X-Goog-* headers. Others, such as Set-Cookie and Date, are dropped.
+var keptHeaders = []string{
+	"Content-Range",
+	"Content-Type",
+	"Etag",
+	"Last-Modified",
+	"Location",
+	"Range",
+	"X-Guploader-Uploadid",
+	"X-Http-Status-Code-Override",
+}
+
+func (s *Session) header(h http.Header) http.Header {
+	out := http.Header{}
+	for k, vs := range h {
+		keep := strings.HasPrefix(k, "X-Goog-")
+		for _, kh := range keptHeaders {
+			keep = keep || k == kh
+		}
+		if !keep {
+			continue
+		}
+		for _, v := range vs {
+			out.Add(k, s.scrub(v))
+		}
+	}
+	return out
+}
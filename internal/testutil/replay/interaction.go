@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Interaction kinds.
+const (
+	KindHTTP = "http"
+	KindGRPC = "grpc"
+	// KindGRPCStream is a streaming gRPC call. Its messages are stored in
+	// Requests and Responses.
+	KindGRPCStream = "grpc-stream"
+)
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Kind string `json:"kind"`
+	// Method is the HTTP method, or the full gRPC method name.
+	Method string `json:"method"`
+	// URL is the scrubbed URL of an HTTP request.
+	URL     string `json:"url,omitempty"`
+	Request *Body  `json:"request,omitempty"`
+
+	// Status and Header are the HTTP response status and the headers that
+	// clients rely on.
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// Code and Message describe a gRPC error. Code is zero for a successful
+	// call.
+	Code     int    `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Response *Body  `json:"response,omitempty"`
+
+	// Requests and Responses are the messages sent and received on a stream.
+	Requests  []*Body `json:"requests,omitempty"`
+	Responses []*Body `json:"responses,omitempty"`
+}
+
+// Body is a request or response body. UTF-8 bodies are stored as text, so
+// recordings are readable and can be scrubbed; anything else is stored as
+// bytes.
+type Body struct {
+	Text  string `json:"text,omitempty"`
+	Bytes []byte `json:"bytes,omitempty"`
+}
+
+func (b *Body) data() []byte {
+	if b == nil {
+		return nil
+	}
+	if b.Bytes != nil {
+		return b.Bytes
+	}
+	return []byte(b.Text)
+}
+
+func (b *Body) equal(o *Body) bool {
+	return bytes.Equal(b.data(), o.data())
+}
+
+// body returns the scrubbed form of data, or nil if data is empty.
+func (s *Session) body(data []byte) *Body {
+	if len(data) == 0 {
+		return nil
+	}
+	if !utf8.Valid(data) {
+		return &Body{Bytes: data}
+	}
+	return &Body{Text: s.scrub(string(data))}
+}
+
+type scrubber struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// Timestamps are replaced with a fixed time rather than removed, so that
+// replayed responses still parse.
+const scrubbedTime = "2000-01-01T00:00:00Z"
+
+func defaultScrubbers() []scrubber {
+	return []scrubber{
+		{regexp.MustCompile(`ya29\.[0-9A-Za-z_.\-]+`), "TOKEN"},
+		{regexp.MustCompile(`("(?:access_token|id_token|refresh_token)"\s*:\s*)"[^"]*"`), `${1}"TOKEN"`},
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`), scrubbedTime},
+	}
+}
+
+func (s *Session) scrub(v string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.scrubbers {
+		v = sc.re.ReplaceAllString(v, sc.repl)
+	}
+	return v
+}
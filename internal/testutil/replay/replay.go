@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay records the API traffic of system tests and replays it, so
+// tests written with testutil.SystemTest can run offline in CI.
+//
+// Run a test once against a live project with -record to write its
+// interactions to testdata/replay/<TestName>.json:
+//
+//	GOLANG_SAMPLES_PROJECT_ID=my-project go test -run TestCreateKey -record
+//
+// Without -record, the test replays those interactions and never touches the
+// network, unless GOLANG_SAMPLES_PROJECT_ID is set: then it runs against that
+// project, as with testutil.SystemTest, and nothing is saved. A test opts in
+// by using SystemTest from this package and building its clients with the
+// Session's options:
+//
+//	tc, s := replay.SystemTest(t)
+//	client, err := kms.NewKeyManagementClient(ctx, s.GRPCClientOptions()...)
+//
+// Storage samples that create their own clients are recorded through
+// STORAGE_EMULATOR_HOST instead; see Session.SetStorageEmulatorHost.
+//
+// Project IDs, access tokens and timestamps are scrubbed before anything is
+// written to disk. Use AddScrubber for other values that vary between runs.
+package replay
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+)
+
+var record = flag.Bool("record", false, "run system tests against live APIs and record their interactions under testdata/replay")
+
+// ProjectID is the project ID tests see when replaying. Recorded project
+// IDs are replaced with it.
+const ProjectID = "replay-project"
+
+// Session records or replays the interactions of one test.
+type Session struct {
+	t         testing.TB
+	recording bool
+	live      bool // recording, but not saved
+	file      string
+	scrubbers []scrubber
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// SystemTest is like testutil.SystemTest, but also returns a Session.
+//
+// With -record, the test runs against GOLANG_SAMPLES_PROJECT_ID and its
+// interactions are saved when it ends, unless it failed. Without -record but
+// with GOLANG_SAMPLES_PROJECT_ID set, the test runs against that project and
+// nothing is saved. Otherwise, if the test has a recording, GOLANG_SAMPLES_PROJECT_ID is set to ProjectID for the
+// duration of the test and the recording is replayed. Tests without a
+// recording are skipped, as testutil.SystemTest does without a project.
+//
+// SystemTest sets environment variables, so it must be called before
+// t.Parallel.
+func SystemTest(t *testing.T) (testutil.Context, *Session) {
+	t.Helper()
+	file := goldenFile(t)
+	if *record || os.Getenv("GOLANG_SAMPLES_PROJECT_ID") != "" {
+		tc := testutil.SystemTest(t)
+		s := newSession(t, true, file)
+		if !*record {
+			s.live = true
+			return tc, s
+		}
+		s.AddScrubber(regexp.MustCompile(regexp.QuoteMeta(tc.ProjectID)), ProjectID)
+		t.Cleanup(func() {
+			if t.Failed() {
+				t.Logf("replay: test failed, not writing %s", file)
+				return
+			}
+			if err := s.save(); err != nil {
+				t.Errorf("replay: %v", err)
+			}
+		})
+		return tc, s
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Skipf("replay: no recording at %s; run with -record to create one", file)
+	}
+	t.Setenv("GOLANG_SAMPLES_PROJECT_ID", ProjectID)
+	tc := testutil.SystemTest(t)
+	s := newSession(t, false, file)
+	if err := s.load(); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return tc, s
+}
+
+func newSession(t testing.TB, recording bool, file string) *Session {
+	return &Session{
+		t:         t,
+		recording: recording,
+		file:      file,
+		scrubbers: defaultScrubbers(),
+	}
+}
+
+// goldenFile returns the recording path for t, relative to the package
+// directory.
+func goldenFile(t testing.TB) string {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	return filepath.Join("testdata", "replay", name+".json")
+}
+
+// Recording reports whether the session talks to live APIs.
+func (s *Session) Recording() bool {
+	return s.recording
+}
+
+// AddScrubber replaces every match of re in recorded URLs and bodies with
+// repl. Use it for values that change between runs, such as resource names
+// with a random suffix, so requests made while replaying match the recording.
+// Scrubbers apply to requests while replaying too, so add them before any
+// client is used.
+func (s *Session) AddScrubber(re *regexp.Regexp, repl string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scrubbers = append(s.scrubbers, scrubber{re, repl})
+}
+
+func (s *Session) add(in *Interaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interactions = append(s.interactions, in)
+}
+
+// next returns the first unused interaction for which match returns true,
+// preferring one for which exact also returns true.
+func (s *Session) next(match, exact func(*Interaction) bool) (*Interaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := -1
+	for i, in := range s.interactions {
+		if s.used[i] || !match(in) {
+			continue
+		}
+		if exact(in) {
+			found = i
+			break
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("no unused interaction in %s matches", s.file)
+	}
+	s.used[found] = true
+	return s.interactions[found], nil
+}
+
+type recording struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+func (s *Session) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.MarshalIndent(recording{Interactions: s.interactions}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	if err := os.WriteFile(s.file, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+func (s *Session) load() error {
+	b, err := os.ReadFile(s.file)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var r recording
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("json.Unmarshal(%s): %w", s.file, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interactions = r.Interactions
+	s.used = make([]bool, len(r.Interactions))
+	return nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func get(t *testing.T, c *http.Client, url string) string {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return fmt.Sprintf("%d %s", resp.StatusCode, b)
+}
+
+func TestHTTPRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprintf(w, `{"path":%q,"access_token":"ya29.abc-def","updated":"2024-05-06T07:08:09.123Z"}`, r.URL.Path)
+	}))
+	host := regexp.MustCompile(regexp.QuoteMeta(srv.URL))
+	file := filepath.Join(t.TempDir(), "recording.json")
+
+	rec := newSession(t, true, file)
+	rec.AddScrubber(regexp.MustCompile("my-real-project"), ProjectID)
+	rec.AddScrubber(host, "http://api.test")
+	c := &http.Client{Transport: rec.Transport(nil)}
+	if got, want := get(t, c, srv.URL+"/projects/my-real-project/things"), `"path":"/projects/my-real-project/things"`; !strings.Contains(got, want) {
+		t.Errorf("recording: got %q, want it to contain %q", got, want)
+	}
+	get(t, c, srv.URL+"/missing")
+	if err := rec.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	srv.Close()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, leak := range []string{"my-real-project", "ya29.abc", "2024-05-06", "session=secret", srv.URL} {
+		if strings.Contains(string(b), leak) {
+			t.Errorf("recording contains %q:\n%s", leak, b)
+		}
+	}
+
+	rep := newSession(t, false, file)
+	rep.AddScrubber(host, "http://api.test")
+	if err := rep.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	c = &http.Client{Transport: rep.Transport(nil)}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{
+			url:  "http://api.test/missing",
+			want: "404 404 page not found\n",
+		},
+		{
+			url:  "http://api.test/projects/" + ProjectID + "/things",
+			want: `200 {"path":"/projects/replay-project/things","access_token":"TOKEN","updated":"2000-01-01T00:00:00Z"}`,
+		},
+	}
+	for _, tc := range tests {
+		if got := get(t, c, tc.url); got != tc.want {
+			t.Errorf("replay %s: got %q, want %q", tc.url, got, tc.want)
+		}
+	}
+	if _, err := c.Get("http://api.test/missing"); err == nil {
+		t.Errorf("replay: got no error for an interaction that was already used")
+	}
+}
+
+func TestGRPCRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "recording.json")
+	parent := "projects/" + ProjectID + "/locations/global"
+
+	fake := fakes.NewKMS(t)
+	rec := newSession(t, true, file)
+	client, err := kms.NewKeyManagementClient(ctx, append(fake.ClientOptions(), rec.GRPCClientOptions()...)...)
+	if err != nil {
+		t.Fatalf("kms.NewKeyManagementClient: %v", err)
+	}
+	kr, err := client.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{Parent: parent, KeyRingId: "my-ring"})
+	if err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	_, err = client.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{Name: parent + "/keyRings/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("GetKeyRing: got %v, want NotFound", err)
+	}
+	client.Close()
+	if err := rec.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	rep := newSession(t, false, file)
+	if err := rep.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	client, err = kms.NewKeyManagementClient(ctx, rep.GRPCClientOptions()...)
+	if err != nil {
+		t.Fatalf("kms.NewKeyManagementClient: %v", err)
+	}
+	defer client.Close()
+	got, err := client.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{Parent: parent, KeyRingId: "my-ring"})
+	if err != nil {
+		t.Fatalf("replay CreateKeyRing: %v", err)
+	}
+	if got.Name != kr.Name {
+		t.Errorf("replay CreateKeyRing: got name %q, want %q", got.Name, kr.Name)
+	}
+	_, err = client.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{Name: parent + "/keyRings/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("replay GetKeyRing: got %v, want NotFound", err)
+	}
+	_, err = client.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{Name: kr.Name})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("replay unrecorded GetKeyRing: got %v, want Unimplemented", err)
+	}
+}
+
+// watchHealth opens a health Watch stream through the session's interceptor,
+// receives two statuses, then cancels the stream. If hs is not nil, it
+// changes the serving status after the first one is received.
+func watchHealth(t *testing.T, s *Session, addr string, hs *health.Server) []healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(s.StreamClientInterceptor()))
+	if err != nil {
+		t.Fatalf("grpc.Dial: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: "samples"})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	var got []healthpb.HealthCheckResponse_ServingStatus
+	for i := 0; i < 2; i++ {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		got = append(got, resp.GetStatus())
+		if hs != nil && i == 0 {
+			hs.SetServingStatus("samples", healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Recv after cancel: got %v, want Canceled", err)
+	}
+	return got
+}
+
+func TestGRPCStreamRecordReplay(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("samples", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	file := filepath.Join(t.TempDir(), "recording.json")
+
+	rec := newSession(t, true, file)
+	want := watchHealth(t, rec, lis.Addr().String(), hs)
+	srv.Stop()
+	if err := rec.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	rep := newSession(t, false, file)
+	if err := rep.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// Nothing listens on addr any more; the stream is replayed.
+	got := watchHealth(t, rep, lis.Addr().String(), nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replay Watch: got %v, want %v", got, want)
+	}
+}
+
+func TestSetStorageEmulatorHost(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "recording.json")
+	fake := fakes.NewStorage(t)
+	fake.AddObject("my-bucket", "hello.txt", []byte("hello"))
+	t.Setenv("STORAGE_EMULATOR_HOST", fake.URL())
+
+	read := func(t *testing.T, s *Session) string {
+		t.Helper()
+		s.SetStorageEmulatorHost(ctx)
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			t.Fatalf("storage.NewClient: %v", err)
+		}
+		defer client.Close()
+		r, err := client.Bucket("my-bucket").Object("hello.txt").NewReader(ctx)
+		if err != nil {
+			t.Fatalf("NewReader: %v", err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		return string(b)
+	}
+
+	t.Run("record", func(t *testing.T) {
+		rec := newSession(t, true, file)
+		if got, want := read(t, rec), "hello"; got != want {
+			t.Errorf("recording: got %q, want %q", got, want)
+		}
+		if err := rec.save(); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	})
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(b), fake.URL()) {
+		t.Errorf("recording contains the emulator URL %q:\n%s", fake.URL(), b)
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		rep := newSession(t, false, file)
+		if err := rep.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if got, want := read(t, rep), "hello"; got != want {
+			t.Errorf("replay: got %q, want %q", got, want)
+		}
+	})
+}
+
+// TestSystemTest replays testdata/replay/TestSystemTest.json.
+func TestSystemTest(t *testing.T) {
+	if *record {
+		t.Skip("recording would overwrite the hand-written testdata")
+	}
+	t.Setenv("GOLANG_SAMPLES_PROJECT_ID", "")
+	tc, s := SystemTest(t)
+	if tc.ProjectID != ProjectID {
+		t.Errorf("ProjectID: got %q, want %q", tc.ProjectID, ProjectID)
+	}
+	if got := os.Getenv("GOLANG_SAMPLES_PROJECT_ID"); got != ProjectID {
+		t.Errorf("GOLANG_SAMPLES_PROJECT_ID: got %q, want %q", got, ProjectID)
+	}
+	c := &http.Client{Transport: s.Transport(nil)}
+	url := "https://storage.googleapis.com/storage/v1/b?project=" + tc.ProjectID
+	if got, want := get(t, c, url), `200 {"kind":"storage#buckets"}`; got != want {
+		t.Errorf("Get(%q): got %q, want %q", url, got, want)
+	}
+}
+
+func TestSystemTestLive(t *testing.T) {
+	if *record {
+		t.Skip("-record always runs live")
+	}
+	t.Setenv("GOLANG_SAMPLES_PROJECT_ID", "my-project")
+	t.Setenv("STORAGE_EMULATOR_HOST", "localhost:9000")
+	t.Run("live", func(t *testing.T) {
+		tc, s := SystemTest(t)
+		if tc.ProjectID != "my-project" || !s.Recording() {
+			t.Errorf("SystemTest: got project %q, recording %v, want my-project live", tc.ProjectID, s.Recording())
+		}
+		s.SetStorageEmulatorHost(context.Background())
+		if got := os.Getenv("STORAGE_EMULATOR_HOST"); got != "localhost:9000" {
+			t.Errorf("STORAGE_EMULATOR_HOST: got %q, want it unchanged", got)
+		}
+	})
+	if _, err := os.Stat(goldenFile(t)); !os.IsNotExist(err) {
+		t.Errorf("live test saved a recording: %v", err)
+	}
+}
@@ -18,44 +18,126 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
+// BuildOption configures how BuildMain and BuildMainDir build a binary.
+type BuildOption func(*buildConfig)
+
+type buildConfig struct {
+	cover bool
+}
+
+// WithCoverage builds the binary with -cover. Each run writes coverage data
+// to Runner.CoverDir, which Runner.WriteCoverProfile converts to a profile.
+// It requires Go 1.20 or later; with older toolchains the build fails.
+func WithCoverage() BuildOption {
+	return func(c *buildConfig) { c.cover = true }
+}
+
 // BuildMain builds the main package in the current working directory.
 // If it doesn't build, t.Fatal is called.
 // Test methods calling BuildMain should run Runner.Cleanup.
-func BuildMain(t *testing.T) *Runner {
-	wd, err := os.Getwd()
-	if err != nil {
+func BuildMain(t *testing.T, opts ...BuildOption) *Runner {
+	r, err := BuildMainDir(".", opts...)
+	if r == nil {
 		t.Fatal(err)
 	}
-	tmp, err := os.MkdirTemp("", "runmain-"+filepath.Base(wd)+"-")
+	r.t = t
 	if err != nil {
-		t.Fatal(err)
+		t.Error(err)
 	}
+	return r
+}
 
-	r := &Runner{t: t, tmp: tmp}
+// BuildMainDir builds the main package in dir, for callers without a
+// *testing.T such as end-to-end harnesses.
+// If the temporary directory cannot be created, a nil Runner is returned.
+// If the package doesn't build, the returned Runner reports !Built() and
+// the error includes the build output.
+// Callers should run Runner.Cleanup.
+func BuildMainDir(dir string, opts ...BuildOption) (*Runner, error) {
+	var c buildConfig
+	for _, o := range opts {
+		o(&c)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "runmain-"+filepath.Base(abs)+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Runner{tmp: tmp}
 
 	bin := filepath.Join(tmp, "a.out")
-	cmd := exec.Command("go", "build", "-o", bin)
+	args := []string{"build", "-o", bin}
+	if c.cover {
+		if err := checkCoverSupport(); err != nil {
+			return r, err
+		}
+		r.coverDir = filepath.Join(tmp, "cover")
+		if err := os.Mkdir(r.coverDir, 0755); err != nil {
+			return r, err
+		}
+		args = append(args, "-cover")
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = abs
 	if out, err := cmd.CombinedOutput(); err != nil {
-		t.Errorf("go build: %v\n%s", err, out)
-		return r
+		return r, fmt.Errorf("go build: %v\n%s", err, out)
 	}
 
 	r.bin = bin
-	return r
+	return r, nil
+}
+
+// coverMinVersion is the first Go minor version with `go build -cover`.
+const coverMinVersion = 20
+
+var goVersionRE = regexp.MustCompile(`go1\.(\d+)`)
+
+// checkCoverSupport returns an error if the go command is older than Go 1.20.
+func checkCoverSupport() error {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return fmt.Errorf("go env GOVERSION: %v", err)
+	}
+	return coverSupported(strings.TrimSpace(string(out)))
+}
+
+func coverSupported(version string) error {
+	m := goVersionRE.FindStringSubmatch(version)
+	if m == nil {
+		// Development toolchains may not report a release version.
+		return nil
+	}
+	if minor, _ := strconv.Atoi(m[1]); minor < coverMinVersion {
+		return fmt.Errorf("WithCoverage requires Go 1.%d or later for go build -cover, have %s", coverMinVersion, version)
+	}
+	return nil
 }
 
 // Runner holds the result of `go build`
 type Runner struct {
-	t   *testing.T
-	tmp string
-	bin string
+	// Output, if set, also receives the stdout and stderr of processes
+	// started with Start or StartServer, as they are written.
+	Output io.Writer
+
+	t        *testing.T
+	tmp      string
+	bin      string
+	coverDir string
 }
 
 // Built reports whether the build was successful.
@@ -63,9 +145,46 @@ func (r *Runner) Built() bool {
 	return r.bin != ""
 }
 
+// Bin returns the path of the built binary, or "" if the build failed.
+func (r *Runner) Bin() string {
+	return r.bin
+}
+
+// CoverDir returns the directory runs of the binary write coverage data to,
+// or "" if it was not built WithCoverage.
+func (r *Runner) CoverDir() string {
+	return r.coverDir
+}
+
+// WriteCoverProfile converts the coverage data collected so far into a
+// profile at path, in the format of `go test -coverprofile`.
+func (r *Runner) WriteCoverProfile(path string) error {
+	if r.coverDir == "" {
+		return fmt.Errorf("binary not built with coverage")
+	}
+	out, err := exec.Command("go", "tool", "covdata", "textfmt", "-i="+r.coverDir, "-o="+path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go tool covdata: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// environ returns the environment of the binary: the current one plus env,
+// and GOCOVERDIR if the binary was built with coverage.
+func (r *Runner) environ(env map[string]string) []string {
+	environ := os.Environ()
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	if r.coverDir != "" {
+		environ = append(environ, "GOCOVERDIR="+r.coverDir)
+	}
+	return environ
+}
+
 // Cleanup removes the built binary.
 func (r *Runner) Cleanup() {
-	if err := os.RemoveAll(r.tmp); err != nil {
+	if err := os.RemoveAll(r.tmp); err != nil && r.t != nil {
 		r.t.Error(err)
 	}
 }
@@ -78,16 +197,11 @@ func (r *Runner) Run(env map[string]string, timeout time.Duration, args ...strin
 		return nil, nil, fmt.Errorf("tried to run when binary not built")
 	}
 
-	environ := os.Environ()
-	for k, v := range env {
-		environ = append(environ, k+"="+v)
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, r.bin, args...)
-	cmd.Env = environ
+	cmd.Env = r.environ(env)
 	var bufOut, bufErr bytes.Buffer
 	cmd.Stdout = &bufOut
 	cmd.Stderr = &bufErr
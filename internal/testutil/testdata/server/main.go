@@ -0,0 +1,56 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command server is an HTTP server that shuts down gracefully on SIGTERM,
+// used to test Runner.Start.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	srv := &http.Server{
+		Addr: ":" + os.Getenv("PORT"),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("request %s", r.URL.Path)
+			fmt.Fprint(w, "Hello World!\n")
+		}),
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM)
+	go func() {
+		log.Printf("listening on port %s", os.Getenv("PORT"))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-signalChan
+	log.Printf("%s signal caught", sig)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		log.Fatalf("server shutdown failed: %v", err)
+	}
+	log.Print("server exited")
+	if code := os.Getenv("EXIT_CODE"); code == "3" {
+		os.Exit(3)
+	}
+}
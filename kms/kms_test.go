@@ -34,6 +34,8 @@ import (
 	kms "cloud.google.com/go/kms/apiv1"
 	"cloud.google.com/go/kms/apiv1/kmspb"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	"google.golang.org/api/option"
 )
 
 var fixture *kmsFixture
@@ -41,8 +43,9 @@ var fixture *kmsFixture
 func TestMain(m *testing.M) {
 	tc, ok := testutil.ContextMain(m)
 	if !ok {
-		log.Print("skipping - unset GOLANG_SAMPLES_PROJECT_ID?")
-		return
+		// Only tests that use a fake can run.
+		log.Print("skipping system tests - unset GOLANG_SAMPLES_PROJECT_ID?")
+		os.Exit(m.Run())
 	}
 
 	var err error
@@ -60,6 +63,32 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
+// testSymmetricKey returns a symmetric key to run a test with, and options
+// for the clients of the test and its samples. Without
+// GOLANG_SAMPLES_PROJECT_ID set, the key is created in a fake, which the
+// options connect to.
+func testSymmetricKey(t *testing.T) (string, []option.ClientOption) {
+	if fixture != nil {
+		return fixture.SymmetricKeyName, nil
+	}
+	opts := fakes.NewKMS(t).ClientOptions()
+	client, err := kms.NewKeyManagementClient(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	k := &kmsFixture{client: client, ProjectID: "fake-project"}
+	k.LocationName = fmt.Sprintf("projects/%s/locations/us-east1", k.ProjectID)
+	if k.KeyRingName, err = k.CreateKeyRing(k.LocationName); err != nil {
+		t.Fatalf("failed to create key ring: %v", err)
+	}
+	name, err := k.CreateSymmetricKey(k.KeyRingName)
+	if err != nil {
+		t.Fatalf("failed to create symmetric key: %v", err)
+	}
+	return name, opts
+}
+
 func TestCreateKeyAsymmetricDecrypt(t *testing.T) {
 	testutil.SystemTest(t)
 
@@ -240,13 +269,11 @@ func TestDecryptAsymmetric(t *testing.T) {
 }
 
 func TestDecryptSymmetric(t *testing.T) {
-	testutil.SystemTest(t)
-
-	name := fixture.SymmetricKeyName
+	name, opts := testSymmetricKey(t)
 
 	// Encrypt some data to decrypt.
 	ctx := context.Background()
-	client, err := kms.NewKeyManagementClient(ctx)
+	client, err := kms.NewKeyManagementClient(ctx, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,7 +286,7 @@ func TestDecryptSymmetric(t *testing.T) {
 	}
 
 	var b bytes.Buffer
-	if err := decryptSymmetric(&b, name, result.Ciphertext); err != nil {
+	if err := decryptSymmetric(&b, name, result.Ciphertext, opts...); err != nil {
 		t.Fatal(err)
 	}
 
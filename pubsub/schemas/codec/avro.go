@@ -0,0 +1,705 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/linkedin/goavro/v2"
+)
+
+type avroCodec struct {
+	cfg    pubsub.SchemaConfig
+	codec  *goavro.Codec
+	schema *avroSchema
+}
+
+func newAvroCodec(cfg *pubsub.SchemaConfig) (*avroCodec, error) {
+	codec, err := goavro.NewCodec(cfg.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("codec: schema %s@%s: %w", cfg.Name, cfg.RevisionID, err)
+	}
+	schema, err := parseAvro(cfg.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("codec: schema %s@%s: %w", cfg.Name, cfg.RevisionID, err)
+	}
+	return &avroCodec{cfg: *cfg, codec: codec, schema: schema}, nil
+}
+
+func (c *avroCodec) Schema() *pubsub.SchemaConfig {
+	cfg := c.cfg
+	return &cfg
+}
+
+func (c *avroCodec) Encode(v interface{}, enc pubsub.SchemaEncoding) ([]byte, error) {
+	native, err := toNative(c.schema, reflect.ValueOf(v))
+	if err != nil {
+		return nil, fmt.Errorf("codec: encoding %T: %w", v, err)
+	}
+	var data []byte
+	switch enc {
+	case pubsub.EncodingBinary:
+		data, err = c.codec.BinaryFromNative(nil, native)
+	case pubsub.EncodingJSON:
+		data, err = c.codec.TextualFromNative(nil, native)
+	default:
+		return nil, fmt.Errorf("codec: unsupported encoding %v", enc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("codec: encoding %T: %w", v, err)
+	}
+	return data, nil
+}
+
+func (c *avroCodec) Decode(data []byte, enc pubsub.SchemaEncoding, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("codec: decoding into %T: not a non-nil pointer", v)
+	}
+	var native interface{}
+	var err error
+	switch enc {
+	case pubsub.EncodingBinary:
+		native, _, err = c.codec.NativeFromBinary(data)
+	case pubsub.EncodingJSON:
+		native, _, err = c.codec.NativeFromTextual(data)
+	default:
+		return fmt.Errorf("codec: unsupported encoding %v", enc)
+	}
+	if err != nil {
+		return fmt.Errorf("codec: decoding %s@%s: %w", c.cfg.Name, c.cfg.RevisionID, err)
+	}
+	if err := fromNative(c.schema, native, rv.Elem()); err != nil {
+		return fmt.Errorf("codec: decoding into %T: %w", v, err)
+	}
+	return nil
+}
+
+// An avroSchema is the part of a parsed Avro schema needed to map Go values
+// to the native values used by goavro. goavro itself validates the schema.
+type avroSchema struct {
+	typ     string // a primitive type, "record", "enum", "array", "map", "fixed" or "union"
+	name    string // full name of named types
+	fields  []avroField
+	items   *avroSchema // array items
+	values  *avroSchema // map values
+	union   []*avroSchema
+	symbols []string
+	size    int // fixed size
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+// unionName returns the name goavro uses for s as a union branch.
+func (s *avroSchema) unionName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.typ
+}
+
+func (s *avroSchema) String() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.typ
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+func parseAvro(definition string) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(definition), &v); err != nil {
+		return nil, err
+	}
+	p := avroParser{named: make(map[string]*avroSchema)}
+	return p.parse(v, "")
+}
+
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{typ: v}, nil
+		}
+		if s, ok := p.named[fullName(v, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", v)
+	case []interface{}:
+		s := &avroSchema{typ: "union"}
+		for _, b := range v {
+			bs, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.union = append(s.union, bs)
+		}
+		return s, nil
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	}
+	return nil, fmt.Errorf("invalid schema %v", v)
+}
+
+func (p *avroParser) parseComplex(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, ok := v["type"].(string)
+	if !ok {
+		// A type such as {"type": {"type": "array", ...}}.
+		return p.parse(v["type"], namespace)
+	}
+	s := &avroSchema{typ: typ}
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s without a name", typ)
+		}
+		if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s.name = fullName(name, namespace)
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		}
+		p.named[s.name] = s
+	}
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		fields, _ := v["fields"].([]interface{})
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record %s: invalid field %v", s.name, f)
+			}
+			name, _ := fm["name"].(string)
+			fs, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("record %s: field %s: %w", s.name, name, err)
+			}
+			s.fields = append(s.fields, avroField{name: name, schema: fs})
+		}
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, sym := range symbols {
+			name, _ := sym.(string)
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, _ := v["size"].(float64)
+		s.size = int(size)
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array: %w", err)
+		}
+		s.items = items
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map: %w", err)
+		}
+		s.values = values
+	default:
+		if !avroPrimitives[typ] {
+			return p.parse(typ, namespace)
+		}
+	}
+	return s, nil
+}
+
+func fullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// toNative converts v to the native form goavro uses for schema s.
+func toNative(s *avroSchema, v reflect.Value) (interface{}, error) {
+	if s.typ == "union" {
+		return unionToNative(s, v)
+	}
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if s.typ == "null" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("nil value for %s", s)
+		}
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct && s.typ != "record" {
+			// A logical type such as a *big.Rat decimal.
+			return v.Interface(), nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		if s.typ == "null" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("nil value for %s", s)
+	}
+	if v.Kind() == reflect.Struct && s.typ != "record" {
+		// A logical type such as a time.Time timestamp.
+		return v.Interface(), nil
+	}
+	switch s.typ {
+	case "boolean":
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), nil
+		}
+	case "int", "long":
+		var n int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v.Uint() > 1<<63-1 {
+				return nil, fmt.Errorf("%d overflows %s", v.Uint(), s.typ)
+			}
+			n = int64(v.Uint())
+		default:
+			return nil, fmt.Errorf("cannot use %s as %s", v.Type(), s)
+		}
+		if s.typ == "long" {
+			return n, nil
+		}
+		if int64(int32(n)) != n {
+			return nil, fmt.Errorf("%d overflows int", n)
+		}
+		return int32(n), nil
+	case "float", "double":
+		var f float64
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		default:
+			return nil, fmt.Errorf("cannot use %s as %s", v.Type(), s)
+		}
+		if s.typ == "float" {
+			return float32(f), nil
+		}
+		return f, nil
+	case "string":
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+	case "enum":
+		if v.Kind() == reflect.String {
+			for _, sym := range s.symbols {
+				if sym == v.String() {
+					return sym, nil
+				}
+			}
+			return nil, fmt.Errorf("%q is not a symbol of %s", v.String(), s)
+		}
+	case "bytes", "fixed":
+		if v.Type().ConvertibleTo(bytesType) {
+			return v.Convert(bytesType).Bytes(), nil
+		}
+		if v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return b, nil
+		}
+	case "array":
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			a := make([]interface{}, v.Len())
+			for i := range a {
+				n, err := toNative(s.items, v.Index(i))
+				if err != nil {
+					return nil, fmt.Errorf("[%d]: %w", i, err)
+				}
+				a[i] = n
+			}
+			return a, nil
+		}
+	case "map":
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				k := iter.Key().String()
+				n, err := toNative(s.values, iter.Value())
+				if err != nil {
+					return nil, fmt.Errorf("[%q]: %w", k, err)
+				}
+				m[k] = n
+			}
+			return m, nil
+		}
+	case "record":
+		return recordToNative(s, v)
+	}
+	return nil, fmt.Errorf("cannot use %s as %s", v.Type(), s)
+}
+
+// recordToNative converts a struct or a map to a record. Fields missing
+// from v are left out, so that goavro uses their defaults.
+func recordToNative(s *avroSchema, v reflect.Value) (interface{}, error) {
+	m := make(map[string]interface{}, len(s.fields))
+	switch {
+	case v.Kind() == reflect.Struct:
+		fields := structFields(v.Type())
+		for _, f := range s.fields {
+			i, ok := fields.lookup(f.name)
+			if !ok {
+				continue
+			}
+			n, err := toNative(f.schema, v.FieldByIndex(i))
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", s.name, f.name, err)
+			}
+			m[f.name] = n
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		for _, f := range s.fields {
+			fv := v.MapIndex(reflect.ValueOf(f.name).Convert(v.Type().Key()))
+			if !fv.IsValid() {
+				continue
+			}
+			n, err := toNative(f.schema, fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", s.name, f.name, err)
+			}
+			m[f.name] = n
+		}
+	default:
+		return nil, fmt.Errorf("cannot use %s as %s", v.Type(), s)
+	}
+	return m, nil
+}
+
+// unionToNative converts v to the first branch of s that accepts it. A nil
+// pointer or interface is null, and a map with a branch name as its only key
+// is taken to be already in native form.
+func unionToNative(s *avroSchema, v reflect.Value) (interface{}, error) {
+	for v.Kind() == reflect.Interface || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		if v.IsNil() {
+			for _, b := range s.union {
+				if b.typ == "null" {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("nil value for union without null")
+		}
+		v = v.Elem()
+	}
+	if m, ok := v.Interface().(map[string]interface{}); ok && len(m) == 1 {
+		for _, b := range s.union {
+			if val, ok := m[b.unionName()]; ok {
+				n, err := toNative(b, reflect.ValueOf(val))
+				if err != nil {
+					return nil, err
+				}
+				return goavro.Union(b.unionName(), n), nil
+			}
+		}
+	}
+	for _, b := range s.union {
+		if b.typ == "null" {
+			continue
+		}
+		if n, err := toNative(b, v); err == nil {
+			return goavro.Union(b.unionName(), n), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot use %s as any branch of union", v.Type())
+}
+
+// fromNative stores native, decoded with schema s, in v.
+func fromNative(s *avroSchema, native interface{}, v reflect.Value) error {
+	if s.typ == "union" {
+		if native == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		m, ok := native.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return fmt.Errorf("invalid union value %v", native)
+		}
+		for _, b := range s.union {
+			if val, ok := m[b.unionName()]; ok {
+				return fromNative(b, val, v)
+			}
+		}
+		return fmt.Errorf("invalid union value %v", native)
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if p := plain(s, native); p != nil {
+			v.Set(reflect.ValueOf(p))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	case reflect.Ptr:
+		if native == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if nv := reflect.ValueOf(native); nv.Type().AssignableTo(v.Type()) {
+			v.Set(nv)
+			return nil
+		}
+		return fromNative(s, native, v.Elem())
+	}
+	if native == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	nv := reflect.ValueOf(native)
+	switch s.typ {
+	case "record":
+		return recordFromNative(s, native, v)
+	case "array":
+		a, ok := native.([]interface{})
+		if !ok || v.Kind() != reflect.Slice {
+			break
+		}
+		sv := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i, n := range a {
+			if err := fromNative(s.items, n, sv.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		v.Set(sv)
+		return nil
+	case "map":
+		m, ok := native.(map[string]interface{})
+		if !ok || v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		mv := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, n := range m {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := fromNative(s.values, n, ev); err != nil {
+				return fmt.Errorf("[%q]: %w", k, err)
+			}
+			mv.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), ev)
+		}
+		v.Set(mv)
+		return nil
+	}
+	if nv.Type().AssignableTo(v.Type()) {
+		v.Set(nv)
+		return nil
+	}
+	switch x := native.(type) {
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(x)
+			return nil
+		}
+	case int32, int64:
+		n := nv.Int()
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(n) {
+				return fmt.Errorf("%d overflows %s", n, v.Type())
+			}
+			v.SetInt(n)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return fmt.Errorf("%d overflows %s", n, v.Type())
+			}
+			v.SetUint(uint64(n))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(n))
+			return nil
+		}
+	case float32, float64:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(nv.Float())
+			return nil
+		}
+	case string:
+		if v.Kind() == reflect.String {
+			v.SetString(x)
+			return nil
+		}
+	case []byte:
+		if v.Type().ConvertibleTo(bytesType) {
+			v.Set(reflect.ValueOf(append([]byte(nil), x...)).Convert(v.Type()))
+			return nil
+		}
+		if v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 && v.Len() == len(x) {
+			reflect.Copy(v, reflect.ValueOf(x))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot store %s in %s", s, v.Type())
+}
+
+func recordFromNative(s *avroSchema, native interface{}, v reflect.Value) error {
+	m, ok := native.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid record value %v", native)
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		fields := structFields(v.Type())
+		for _, f := range s.fields {
+			i, ok := fields.lookup(f.name)
+			if !ok {
+				continue
+			}
+			if err := fromNative(f.schema, m[f.name], v.FieldByIndex(i)); err != nil {
+				return fmt.Errorf("%s.%s: %w", s.name, f.name, err)
+			}
+		}
+		return nil
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		mv := reflect.MakeMapWithSize(v.Type(), len(s.fields))
+		for _, f := range s.fields {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := fromNative(f.schema, m[f.name], ev); err != nil {
+				return fmt.Errorf("%s.%s: %w", s.name, f.name, err)
+			}
+			mv.SetMapIndex(reflect.ValueOf(f.name).Convert(v.Type().Key()), ev)
+		}
+		v.Set(mv)
+		return nil
+	}
+	return fmt.Errorf("cannot store %s in %s", s, v.Type())
+}
+
+// plain returns native with unions replaced by their values.
+func plain(s *avroSchema, native interface{}) interface{} {
+	switch s.typ {
+	case "union":
+		m, ok := native.(map[string]interface{})
+		if !ok {
+			return native
+		}
+		for _, b := range s.union {
+			if val, ok := m[b.unionName()]; ok {
+				return plain(b, val)
+			}
+		}
+	case "record":
+		m, ok := native.(map[string]interface{})
+		if !ok {
+			return native
+		}
+		p := make(map[string]interface{}, len(m))
+		for _, f := range s.fields {
+			if val, ok := m[f.name]; ok {
+				p[f.name] = plain(f.schema, val)
+			}
+		}
+		return p
+	case "array":
+		a, ok := native.([]interface{})
+		if !ok {
+			return native
+		}
+		p := make([]interface{}, len(a))
+		for i, n := range a {
+			p[i] = plain(s.items, n)
+		}
+		return p
+	case "map":
+		m, ok := native.(map[string]interface{})
+		if !ok {
+			return native
+		}
+		p := make(map[string]interface{}, len(m))
+		for k, n := range m {
+			p[k] = plain(s.values, n)
+		}
+		return p
+	}
+	return native
+}
+
+// fieldMap indexes the exported fields of a struct type by the Avro field
+// names they match.
+type fieldMap struct {
+	tagged map[string][]int // by avro or json tag
+	folded map[string][]int // by folded Go name
+}
+
+func (m *fieldMap) lookup(name string) ([]int, bool) {
+	if i, ok := m.tagged[name]; ok {
+		return i, true
+	}
+	i, ok := m.folded[fold(name)]
+	return i, ok
+}
+
+// fold lowercases name and drops underscores.
+func fold(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+var fieldMaps sync.Map // reflect.Type to *fieldMap
+
+func structFields(t reflect.Type) *fieldMap {
+	if m, ok := fieldMaps.Load(t); ok {
+		return m.(*fieldMap)
+	}
+	m := &fieldMap{tagged: make(map[string][]int), folded: make(map[string][]int)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Anonymous {
+			continue
+		}
+		if name, ok := f.Tag.Lookup("avro"); ok {
+			if name != "-" {
+				m.tagged[name] = f.Index
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name != "" {
+			m.tagged[name] = f.Index
+		}
+		m.folded[fold(f.Name)] = f.Index
+	}
+	fieldMaps.Store(t, m)
+	return m
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codec encodes and decodes Pub/Sub messages for topics with an Avro
+// or Protocol Buffer schema, mapping them to and from Go values.
+//
+// Subscribers pass each message to Registry.Decode. It looks up the schema
+// revision the message was published with, from the googclient_schemaname
+// and googclient_schemarevisionid attributes, and decodes the message with
+// that revision in the encoding named by googclient_schemaencoding. The
+// codec for a revision is fetched once and cached, since a revision never
+// changes.
+//
+//	reg := codec.NewRegistry(schemaClient)
+//	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+//		var state statepb.State
+//		if err := reg.Decode(ctx, msg, &state); err != nil {
+//			msg.Nack()
+//			return
+//		}
+//		...
+//	})
+//
+// Publishers use an Encoder for the topic, which encodes values with the
+// topic's newest allowed revision in the topic's encoding:
+//
+//	enc, err := reg.TopicEncoder(ctx, topic)
+//	...
+//	msg, err := enc.Encode(&statepb.State{Name: "Alaska", PostAbbr: "AK"})
+//	...
+//	topic.Publish(ctx, msg)
+//
+// Avro records map to Go structs field by field. A struct field matches the
+// Avro field named by its `avro` tag, or else by its `json` tag, or else the
+// field whose name equals the Go field name ignoring case and underscores,
+// so PostAbbr matches post_abbr. Nullable unions map to pointers. Avro values
+// can also be decoded into a map[string]interface{} or an interface{}.
+//
+// Protocol Buffer messages map to generated Go types, such as the State type
+// in us-states.pb.go. The Go type's full name must match the schema's
+// message, and fields present in both must agree in number and type.
+//
+// Either way a value can be decoded with any revision of its schema: fields
+// the value's type doesn't know are ignored, and fields the revision lacks
+// are left unset. That lets publishers and subscribers move to a new
+// revision independently, as long as each revision is a compatible change
+// of the one before.
+package codec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Attributes set by Pub/Sub on messages published to a topic with a schema.
+const (
+	AttrSchemaName = "googclient_schemaname"
+	AttrRevisionID = "googclient_schemarevisionid"
+	AttrEncoding   = "googclient_schemaencoding"
+)
+
+// ErrNoSchema is returned for messages or topics without a schema.
+var ErrNoSchema = errors.New("codec: no schema")
+
+// A SchemaSource fetches schema revisions. It is implemented by
+// *pubsub.SchemaClient; a schema ID of the form "id@revision" selects a
+// revision, and a plain ID the newest one.
+type SchemaSource interface {
+	Schema(ctx context.Context, schemaID string, view pubsub.SchemaView) (*pubsub.SchemaConfig, error)
+}
+
+// A Codec encodes and decodes values with one schema revision.
+type Codec interface {
+	// Schema returns the revision used by the codec.
+	Schema() *pubsub.SchemaConfig
+	// Encode returns the encoding of v.
+	Encode(v interface{}, enc pubsub.SchemaEncoding) ([]byte, error)
+	// Decode decodes data into v, which must be a non-nil pointer.
+	Decode(data []byte, enc pubsub.SchemaEncoding, v interface{}) error
+}
+
+// NewCodec returns a codec for the schema revision cfg.
+func NewCodec(cfg *pubsub.SchemaConfig) (Codec, error) {
+	switch cfg.Type {
+	case pubsub.SchemaAvro:
+		return newAvroCodec(cfg)
+	case pubsub.SchemaProtocolBuffer:
+		return newProtoCodec(cfg)
+	}
+	return nil, fmt.Errorf("codec: schema %s has unsupported type %v", cfg.Name, cfg.Type)
+}
+
+// A Registry fetches schema revisions from a SchemaSource and caches a Codec
+// for each. It is safe for concurrent use.
+type Registry struct {
+	src SchemaSource
+
+	mu     sync.Mutex
+	codecs map[string]Codec // by "schemaID@revisionID"
+}
+
+// NewRegistry returns a Registry that fetches schemas from src.
+func NewRegistry(src SchemaSource) *Registry {
+	return &Registry{src: src, codecs: make(map[string]Codec)}
+}
+
+// Codec returns the codec for a revision of a schema. The schema is named by
+// its ID or its full resource name; schemas are always fetched from the
+// project of the registry's SchemaSource. An empty revisionID selects the
+// newest revision, which is fetched on every call.
+func (r *Registry) Codec(ctx context.Context, schema, revisionID string) (Codec, error) {
+	id := schema[strings.LastIndex(schema, "/")+1:]
+	if id == "" {
+		return nil, fmt.Errorf("codec: invalid schema name %q", schema)
+	}
+	if revisionID != "" {
+		r.mu.Lock()
+		c, ok := r.codecs[id+"@"+revisionID]
+		r.mu.Unlock()
+		if ok {
+			return c, nil
+		}
+		id += "@" + revisionID
+	}
+	cfg, err := r.src.Schema(ctx, id, pubsub.SchemaViewFull)
+	if err != nil {
+		return nil, fmt.Errorf("codec: fetching schema %s: %w", id, err)
+	}
+	if revisionID != "" && cfg.RevisionID != revisionID {
+		return nil, fmt.Errorf("codec: fetching schema %s: got revision %q", id, cfg.RevisionID)
+	}
+	key := strings.SplitN(id, "@", 2)[0] + "@" + cfg.RevisionID
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.codecs[key]; ok {
+		return c, nil
+	}
+	c, err := NewCodec(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.codecs[key] = c
+	return c, nil
+}
+
+// Decode decodes a message received from a subscription into v, using the
+// schema revision and encoding named by the message's attributes. It returns
+// an error wrapping ErrNoSchema if the message has no schema attributes.
+func (r *Registry) Decode(ctx context.Context, msg *pubsub.Message, v interface{}) error {
+	name := msg.Attributes[AttrSchemaName]
+	if name == "" {
+		return fmt.Errorf("message %s: %w", msg.ID, ErrNoSchema)
+	}
+	enc, err := ParseEncoding(msg.Attributes[AttrEncoding])
+	if err != nil {
+		return fmt.Errorf("message %s: %w", msg.ID, err)
+	}
+	c, err := r.Codec(ctx, name, msg.Attributes[AttrRevisionID])
+	if err != nil {
+		return fmt.Errorf("message %s: %w", msg.ID, err)
+	}
+	if err := c.Decode(msg.Data, enc, v); err != nil {
+		return fmt.Errorf("message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// ParseEncoding parses the value of the googclient_schemaencoding
+// attribute.
+func ParseEncoding(s string) (pubsub.SchemaEncoding, error) {
+	switch s {
+	case "BINARY":
+		return pubsub.EncodingBinary, nil
+	case "JSON":
+		return pubsub.EncodingJSON, nil
+	}
+	return pubsub.EncodingUnspecified, fmt.Errorf("codec: unknown encoding %q", s)
+}
+
+// An Encoder encodes messages for a topic with a schema.
+type Encoder struct {
+	Codec    Codec
+	Encoding pubsub.SchemaEncoding
+}
+
+// TopicEncoder returns an Encoder for the topic's schema settings. It uses
+// the newest revision the topic accepts, which is fetched once: create a new
+// Encoder to pick up revisions committed later. It returns an error wrapping
+// ErrNoSchema if the topic has no schema.
+func (r *Registry) TopicEncoder(ctx context.Context, t *pubsub.Topic) (*Encoder, error) {
+	cfg, err := t.Config(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("topic %s: %w", t.ID(), err)
+	}
+	s := cfg.SchemaSettings
+	if s == nil || s.Schema == "" {
+		return nil, fmt.Errorf("topic %s: %w", t.ID(), ErrNoSchema)
+	}
+	if s.Encoding != pubsub.EncodingBinary && s.Encoding != pubsub.EncodingJSON {
+		return nil, fmt.Errorf("topic %s: unsupported encoding %v", t.ID(), s.Encoding)
+	}
+	c, err := r.Codec(ctx, s.Schema, s.LastRevisionID)
+	if err != nil {
+		return nil, fmt.Errorf("topic %s: %w", t.ID(), err)
+	}
+	return &Encoder{Codec: c, Encoding: s.Encoding}, nil
+}
+
+// Encode returns a message holding the encoding of v.
+func (e *Encoder) Encode(v interface{}) (*pubsub.Message, error) {
+	data, err := e.Codec.Encode(v, e.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &pubsub.Message{Data: data}, nil
+}
@@ -0,0 +1,534 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	statepb "github.com/GoogleCloudPlatform/golang-samples/internal/pubsub/schemas"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const projectID = "codec-test"
+
+// countingSource counts the schemas fetched through it.
+type countingSource struct {
+	SchemaSource
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (s *countingSource) Schema(ctx context.Context, id string, view pubsub.SchemaView) (*pubsub.SchemaConfig, error) {
+	s.mu.Lock()
+	s.fetched = append(s.fetched, id)
+	s.mu.Unlock()
+	return s.SchemaSource.Schema(ctx, id, view)
+}
+
+// newFake starts a fake Pub/Sub server and returns clients for it.
+func newFake(t *testing.T) (*pubsub.Client, *pubsub.SchemaClient) {
+	t.Helper()
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	opts := []option.ClientOption{
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+	client, err := pubsub.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		t.Fatalf("pubsub.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	schemaClient, err := pubsub.NewSchemaClient(ctx, projectID, opts...)
+	if err != nil {
+		t.Fatalf("pubsub.NewSchemaClient: %v", err)
+	}
+	t.Cleanup(func() { schemaClient.Close() })
+	return client, schemaClient
+}
+
+// createSchema creates a schema with a revision for each resource file and
+// returns the revisions.
+func createSchema(t *testing.T, c *pubsub.SchemaClient, id string, typ pubsub.SchemaType, files ...string) []*pubsub.SchemaConfig {
+	t.Helper()
+	ctx := context.Background()
+	var revs []*pubsub.SchemaConfig
+	for i, file := range files {
+		def, err := os.ReadFile("../resources/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := pubsub.SchemaConfig{Type: typ, Definition: string(def)}
+		var rev *pubsub.SchemaConfig
+		if i == 0 {
+			rev, err = c.CreateSchema(ctx, id, cfg)
+		} else {
+			rev, err = c.CommitSchema(ctx, id, cfg)
+		}
+		if err != nil {
+			t.Fatalf("creating schema %s from %s: %v", id, file, err)
+		}
+		revs = append(revs, rev)
+	}
+	return revs
+}
+
+type state struct {
+	Name     string
+	PostAbbr string
+}
+
+type statePlus struct {
+	Name       string
+	PostAbbr   string `avro:"post_abbr"`
+	Population int64
+}
+
+func TestAvroRevisions(t *testing.T) {
+	ctx := context.Background()
+	_, schemaClient := newFake(t)
+	revs := createSchema(t, schemaClient, "states", pubsub.SchemaAvro, "us-states.avsc", "us-states-plus.avsc")
+	reg := NewRegistry(schemaClient)
+
+	alaska := state{Name: "Alaska", PostAbbr: "AK"}
+	alaskaPlus := statePlus{Name: "Alaska", PostAbbr: "AK", Population: 733391}
+	tests := []struct {
+		rev     int
+		in      interface{}
+		into    interface{} // a pointer to a zero value
+		want    interface{}
+		wantMap map[string]interface{}
+	}{
+		// The old revision drops the population.
+		{0, alaskaPlus, &statePlus{}, &statePlus{Name: "Alaska", PostAbbr: "AK"},
+			map[string]interface{}{"name": "Alaska", "post_abbr": "AK"}},
+		// The new revision's default fills in a missing population.
+		{1, alaska, &statePlus{}, &statePlus{Name: "Alaska", PostAbbr: "AK"},
+			map[string]interface{}{"name": "Alaska", "post_abbr": "AK", "population": int64(0)}},
+		{1, &alaskaPlus, &statePlus{}, &alaskaPlus,
+			map[string]interface{}{"name": "Alaska", "post_abbr": "AK", "population": int64(733391)}},
+		{1, alaskaPlus, &state{}, &alaska, nil},
+		// Generated protobuf types match by their json tags.
+		{1, &statepb.State{Name: "Alaska", PostAbbr: "AK"}, &statepb.State{}, &statepb.State{Name: "Alaska", PostAbbr: "AK"}, nil},
+	}
+	for _, enc := range []pubsub.SchemaEncoding{pubsub.EncodingBinary, pubsub.EncodingJSON} {
+		for i, tc := range tests {
+			c, err := reg.Codec(ctx, revs[tc.rev].Name, revs[tc.rev].RevisionID)
+			if err != nil {
+				t.Fatalf("Codec: %v", err)
+			}
+			data, err := c.Encode(tc.in, enc)
+			if err != nil {
+				t.Errorf("%d/%v: Encode: %v", i, enc, err)
+				continue
+			}
+			into := tc.into
+			if err := c.Decode(data, enc, into); err != nil {
+				t.Errorf("%d/%v: Decode: %v", i, enc, err)
+				continue
+			}
+			if pm, ok := into.(proto.Message); ok {
+				if !proto.Equal(pm, tc.want.(proto.Message)) {
+					t.Errorf("%d/%v: Decode: got %v, want %v", i, enc, pm, tc.want)
+				}
+			} else if !reflect.DeepEqual(into, tc.want) {
+				t.Errorf("%d/%v: Decode: got %+v, want %+v", i, enc, into, tc.want)
+			}
+			if tc.wantMap == nil {
+				continue
+			}
+			var m map[string]interface{}
+			if err := c.Decode(data, enc, &m); err != nil {
+				t.Errorf("%d/%v: Decode map: %v", i, enc, err)
+			} else if !reflect.DeepEqual(m, tc.wantMap) {
+				t.Errorf("%d/%v: Decode map: got %v, want %v", i, enc, m, tc.wantMap)
+			}
+		}
+	}
+}
+
+const testAvsc = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "test",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["CREATE", "DELETE"]}},
+    {"name": "note", "type": ["null", "string"], "default": null},
+    {"name": "counts", "type": {"type": "array", "items": "int"}},
+    {"name": "scores", "type": {"type": "map", "values": "double"}},
+    {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+    {"name": "origin", "type": {"type": "record", "name": "Origin", "fields": [
+      {"name": "host", "type": "string"},
+      {"name": "port", "type": "int"}
+    ]}},
+    {"name": "parent", "type": ["null", "Origin"], "default": null},
+    {"name": "value", "type": ["null", "long", "string"], "default": null}
+  ]
+}`
+
+type origin struct {
+	Host string
+	Port uint16
+}
+
+type event struct {
+	ID     int64
+	Kind   string
+	Note   *string
+	Counts []int
+	Scores map[string]float64
+	Hash   [4]byte
+	Origin origin
+	Parent *origin
+	Value  interface{}
+	Extra  string `avro:"-"`
+}
+
+func TestAvroTypes(t *testing.T) {
+	c, err := NewCodec(&pubsub.SchemaConfig{Name: "events", Type: pubsub.SchemaAvro, Definition: testAvsc, RevisionID: "1"})
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	note := "first"
+	tests := []event{
+		{ID: 1, Kind: "CREATE", Counts: []int{}, Scores: map[string]float64{}},
+		{
+			ID: 2, Kind: "DELETE", Note: &note, Counts: []int{1, 2, 3},
+			Scores: map[string]float64{"a": 0.5}, Hash: [4]byte{1, 2, 3, 4},
+			Origin: origin{Host: "example.com", Port: 443},
+			Parent: &origin{Host: "parent.example.com", Port: 80},
+			Value:  "text",
+		},
+		{ID: 3, Kind: "CREATE", Counts: []int{}, Scores: map[string]float64{}, Value: int64(7)},
+	}
+	for _, enc := range []pubsub.SchemaEncoding{pubsub.EncodingBinary, pubsub.EncodingJSON} {
+		for _, in := range tests {
+			in.Extra = "ignored"
+			data, err := c.Encode(&in, enc)
+			if err != nil {
+				t.Errorf("%d/%v: Encode: %v", in.ID, enc, err)
+				continue
+			}
+			var got event
+			if err := c.Decode(data, enc, &got); err != nil {
+				t.Errorf("%d/%v: Decode: %v", in.ID, enc, err)
+				continue
+			}
+			in.Extra = ""
+			if !reflect.DeepEqual(got, in) {
+				t.Errorf("%d/%v: Decode: got %+v, want %+v", in.ID, enc, got, in)
+			}
+		}
+	}
+
+	data, err := c.Encode(tests[1], pubsub.EncodingJSON)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, want := range []string{`"note":{"string":"first"}`, `"parent":{"test.Origin":{`, `"value":{"string":"text"}`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Encode JSON: got %s, want it to contain %s", data, want)
+		}
+	}
+
+	var m map[string]interface{}
+	if err := c.Decode(data, pubsub.EncodingJSON, &m); err != nil {
+		t.Fatalf("Decode map: %v", err)
+	}
+	wantParent := map[string]interface{}{"host": "parent.example.com", "port": int32(80)}
+	if m["note"] != "first" || m["value"] != "text" || !reflect.DeepEqual(m["parent"], wantParent) {
+		t.Errorf("Decode map: got %v, want unions unwrapped", m)
+	}
+
+	bad := []struct {
+		name string
+		v    interface{}
+	}{
+		{"bad symbol", event{Kind: "UPDATE"}},
+		{"int overflow", event{Kind: "CREATE", Counts: []int{1 << 40}}},
+		{"wrong type", event{Kind: "CREATE", Value: 1.5}},
+		{"missing field", map[string]interface{}{"id": int64(1)}},
+		{"not a record", "event"},
+	}
+	for _, tc := range bad {
+		if _, err := c.Encode(tc.v, pubsub.EncodingBinary); err == nil {
+			t.Errorf("Encode %s: got no error", tc.name)
+		}
+	}
+	var small struct{ Port int8 }
+	data, err = c.Encode(map[string]interface{}{
+		"id": int64(1), "kind": "CREATE", "counts": []interface{}{}, "scores": map[string]interface{}{},
+		"hash": []byte{0, 0, 0, 0}, "origin": map[string]interface{}{"host": "h", "port": int32(300)},
+	}, pubsub.EncodingBinary)
+	if err != nil {
+		t.Fatalf("Encode map: %v", err)
+	}
+	var wrapper struct{ Origin *struct{ Port int8 } }
+	if err := c.Decode(data, pubsub.EncodingBinary, &wrapper); err == nil {
+		t.Errorf("Decode overflow: got %+v, want error", wrapper.Origin)
+	}
+	if err := c.Decode(data, pubsub.EncodingBinary, small); err == nil {
+		t.Errorf("Decode into non-pointer: got no error")
+	}
+}
+
+func TestProtoRevisions(t *testing.T) {
+	ctx := context.Background()
+	_, schemaClient := newFake(t)
+	revs := createSchema(t, schemaClient, "states", pubsub.SchemaProtocolBuffer, "us-states.proto", "us-states-plus.proto")
+	reg := NewRegistry(schemaClient)
+
+	// A message from the new revision, with a population the generated type
+	// doesn't know.
+	alaska := &statepb.State{Name: "Alaska", PostAbbr: "AK"}
+	binary, err := proto.Marshal(alaska)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary = protowire.AppendTag(binary, 3, protowire.VarintType)
+	binary = protowire.AppendVarint(binary, 733391)
+	json := []byte(`{"name":"Alaska","postAbbr":"AK","population":"733391"}`)
+
+	for _, rev := range revs {
+		c, err := reg.Codec(ctx, rev.Name, rev.RevisionID)
+		if err != nil {
+			t.Fatalf("Codec: %v", err)
+		}
+		for _, enc := range []pubsub.SchemaEncoding{pubsub.EncodingBinary, pubsub.EncodingJSON} {
+			data, err := c.Encode(alaska, enc)
+			if err != nil {
+				t.Errorf("%s/%v: Encode: %v", rev.RevisionID, enc, err)
+				continue
+			}
+			var got statepb.State
+			if err := c.Decode(data, enc, &got); err != nil {
+				t.Errorf("%s/%v: Decode: %v", rev.RevisionID, enc, err)
+			} else if !proto.Equal(&got, alaska) {
+				t.Errorf("%s/%v: Decode: got %v, want %v", rev.RevisionID, enc, &got, alaska)
+			}
+		}
+
+		var got statepb.State
+		if err := c.Decode(binary, pubsub.EncodingBinary, &got); err != nil {
+			t.Errorf("%s: Decode binary with unknown field: %v", rev.RevisionID, err)
+		} else if got.Name != "Alaska" || len(got.ProtoReflect().GetUnknown()) == 0 {
+			t.Errorf("%s: Decode binary with unknown field: got %v, want unknown field kept", rev.RevisionID, &got)
+		}
+		got.Reset()
+		if err := c.Decode(json, pubsub.EncodingJSON, &got); err != nil {
+			t.Errorf("%s: Decode JSON with unknown field: %v", rev.RevisionID, err)
+		} else if !proto.Equal(&got, alaska) {
+			t.Errorf("%s: Decode JSON with unknown field: got %v, want %v", rev.RevisionID, &got, alaska)
+		}
+
+		var m map[string]interface{}
+		if err := c.Decode(binary, pubsub.EncodingBinary, &m); err == nil {
+			t.Errorf("%s: Decode into map: got no error", rev.RevisionID)
+		}
+		if _, err := c.Encode(timestamppb.Now(), pubsub.EncodingBinary); err == nil {
+			t.Errorf("%s: Encode Timestamp: got no error", rev.RevisionID)
+		}
+	}
+}
+
+func TestProtoCompatibility(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		ok   bool
+	}{
+		{"same", `syntax = "proto3"; package utilities; message State { string name = 1; string post_abbr = 2; }`, true},
+		{"added fields", `
+			syntax = "proto3";
+			package utilities;
+			option java_outer_classname = "StateProto";
+			/* A state. */
+			message State {
+				string name = 1; // The common name.
+				string post_abbr = 2 [deprecated = true];
+				map<string, int64> counts = 3;
+				oneof size {
+					int64 population = 4;
+					double area = 5;
+				}
+				message City { string name = 1; int32 post_abbr = 2; }
+				repeated City cities = 6;
+				reserved 7, 8;
+			}`, true},
+		{"removed field", `syntax = "proto3"; package utilities; message State { string name = 1; }`, true},
+		{"other package", `syntax = "proto3"; package geo; message State { string name = 1; }`, false},
+		{"renumbered", `syntax = "proto3"; package utilities; message State { string name = 1; string post_abbr = 3; }`, false},
+		{"renamed", `syntax = "proto3"; package utilities; message State { string name = 1; string abbr = 2; }`, false},
+		{"retyped", `syntax = "proto3"; package utilities; message State { string name = 1; int32 post_abbr = 2; }`, false},
+		{"repeated", `syntax = "proto3"; package utilities; message State { string name = 1; repeated string post_abbr = 2; }`, false},
+		{"retyped in oneof", `syntax = "proto3"; package utilities; message State { oneof n { bytes name = 1; } }`, false},
+	}
+	for _, tc := range tests {
+		c, err := NewCodec(&pubsub.SchemaConfig{Name: "states", Type: pubsub.SchemaProtocolBuffer, Definition: tc.def})
+		if err != nil {
+			t.Errorf("%s: NewCodec: %v", tc.name, err)
+			continue
+		}
+		_, err = c.Encode(&statepb.State{Name: "Alaska"}, pubsub.EncodingBinary)
+		if got := err == nil; got != tc.ok {
+			t.Errorf("%s: Encode: got error %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+
+	for _, def := range []string{
+		`syntax = "proto3";`,
+		`message A { string a = 1; } message B { string b = 1; }`,
+		`import "other.proto"; message A { string a = 1; }`,
+		`message A { string a = x; }`,
+		`message A { string a = 1;`,
+	} {
+		if _, err := NewCodec(&pubsub.SchemaConfig{Name: "bad", Type: pubsub.SchemaProtocolBuffer, Definition: def}); err == nil {
+			t.Errorf("NewCodec(%q): got no error", def)
+		}
+	}
+}
+
+func TestRegistryDecode(t *testing.T) {
+	ctx := context.Background()
+	_, schemaClient := newFake(t)
+	revs := createSchema(t, schemaClient, "states", pubsub.SchemaAvro, "us-states.avsc", "us-states-plus.avsc")
+	src := &countingSource{SchemaSource: schemaClient}
+	reg := NewRegistry(src)
+
+	var msgs []*pubsub.Message
+	for i, rev := range revs {
+		c, err := NewCodec(rev)
+		if err != nil {
+			t.Fatalf("NewCodec: %v", err)
+		}
+		for _, enc := range []string{"BINARY", "JSON"} {
+			e, _ := ParseEncoding(enc)
+			data, err := c.Encode(statePlus{Name: "Alaska", PostAbbr: "AK", Population: int64(i)}, e)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			msgs = append(msgs, &pubsub.Message{
+				ID:   rev.RevisionID + enc,
+				Data: data,
+				Attributes: map[string]string{
+					AttrSchemaName: rev.Name,
+					AttrRevisionID: rev.RevisionID,
+					AttrEncoding:   enc,
+				},
+			})
+		}
+	}
+	for round := 0; round < 2; round++ {
+		for _, msg := range msgs {
+			var got state
+			if err := reg.Decode(ctx, msg, &got); err != nil {
+				t.Errorf("Decode(%s): %v", msg.ID, err)
+			} else if want := (state{Name: "Alaska", PostAbbr: "AK"}); got != want {
+				t.Errorf("Decode(%s): got %+v, want %+v", msg.ID, got, want)
+			}
+		}
+	}
+	want := []string{"states@" + revs[0].RevisionID, "states@" + revs[1].RevisionID}
+	if !reflect.DeepEqual(src.fetched, want) {
+		t.Errorf("fetched %q, want %q", src.fetched, want)
+	}
+
+	var got state
+	if err := reg.Decode(ctx, &pubsub.Message{ID: "plain", Data: []byte("{}")}, &got); !errors.Is(err, ErrNoSchema) {
+		t.Errorf("Decode without schema: got %v, want %v", err, ErrNoSchema)
+	}
+	bad := []map[string]string{
+		{AttrSchemaName: revs[0].Name, AttrRevisionID: revs[0].RevisionID, AttrEncoding: "XML"},
+		{AttrSchemaName: revs[0].Name, AttrRevisionID: "missing", AttrEncoding: "JSON"},
+		{AttrSchemaName: "projects/codec-test/schemas/missing", AttrEncoding: "JSON"},
+	}
+	for _, attrs := range bad {
+		if err := reg.Decode(ctx, &pubsub.Message{Data: msgs[1].Data, Attributes: attrs}, &got); err == nil {
+			t.Errorf("Decode(%v): got no error", attrs)
+		}
+	}
+}
+
+func TestTopicEncoder(t *testing.T) {
+	ctx := context.Background()
+	client, schemaClient := newFake(t)
+	revs := createSchema(t, schemaClient, "states", pubsub.SchemaAvro, "us-states.avsc", "us-states-plus.avsc")
+	reg := NewRegistry(schemaClient)
+
+	tests := []struct {
+		settings *pubsub.SchemaSettings
+		wantRev  string
+		wantErr  error
+	}{
+		{&pubsub.SchemaSettings{Schema: revs[0].Name, Encoding: pubsub.EncodingJSON}, revs[1].RevisionID, nil},
+		{&pubsub.SchemaSettings{Schema: revs[0].Name, Encoding: pubsub.EncodingBinary, LastRevisionID: revs[0].RevisionID}, revs[0].RevisionID, nil},
+		{nil, "", ErrNoSchema},
+	}
+	for i, tc := range tests {
+		topic, err := client.CreateTopicWithConfig(ctx, "topic"+string(rune('a'+i)), &pubsub.TopicConfig{SchemaSettings: tc.settings})
+		if err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+		enc, err := reg.TopicEncoder(ctx, topic)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("%d: TopicEncoder: got %v, want %v", i, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: TopicEncoder: %v", i, err)
+			continue
+		}
+		if got := enc.Codec.Schema().RevisionID; got != tc.wantRev {
+			t.Errorf("%d: revision: got %s, want %s", i, got, tc.wantRev)
+		}
+		if enc.Encoding != tc.settings.Encoding {
+			t.Errorf("%d: encoding: got %v, want %v", i, enc.Encoding, tc.settings.Encoding)
+		}
+		in := statePlus{Name: "Alaska", PostAbbr: "AK", Population: 733391}
+		msg, err := enc.Encode(in)
+		if err != nil {
+			t.Errorf("%d: Encode: %v", i, err)
+			continue
+		}
+		var got statePlus
+		if err := enc.Codec.Decode(msg.Data, enc.Encoding, &got); err != nil {
+			t.Errorf("%d: Decode: %v", i, err)
+		}
+		if tc.wantRev == revs[0].RevisionID {
+			in.Population = 0
+		}
+		if got != in {
+			t.Errorf("%d: Decode: got %+v, want %+v", i, got, in)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type protoCodec struct {
+	cfg    pubsub.SchemaConfig
+	schema *protoSchema
+
+	mu      sync.Mutex
+	checked map[protoreflect.FullName]error
+}
+
+func newProtoCodec(cfg *pubsub.SchemaConfig) (*protoCodec, error) {
+	schema, err := parseProto(cfg.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("codec: schema %s@%s: %w", cfg.Name, cfg.RevisionID, err)
+	}
+	return &protoCodec{cfg: *cfg, schema: schema, checked: make(map[protoreflect.FullName]error)}, nil
+}
+
+func (c *protoCodec) Schema() *pubsub.SchemaConfig {
+	cfg := c.cfg
+	return &cfg
+}
+
+// message returns v as a message after checking that its type matches the
+// schema.
+func (c *protoCodec) message(v interface{}) (proto.Message, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	desc := m.ProtoReflect().Descriptor()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err, ok := c.checked[desc.FullName()]
+	if !ok {
+		err = c.schema.check(desc)
+		if err != nil {
+			err = fmt.Errorf("codec: %T does not match schema %s@%s: %w", v, c.cfg.Name, c.cfg.RevisionID, err)
+		}
+		c.checked[desc.FullName()] = err
+	}
+	return m, err
+}
+
+func (c *protoCodec) Encode(v interface{}, enc pubsub.SchemaEncoding) ([]byte, error) {
+	m, err := c.message(v)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch enc {
+	case pubsub.EncodingBinary:
+		data, err = proto.Marshal(m)
+	case pubsub.EncodingJSON:
+		data, err = protojson.Marshal(m)
+	default:
+		return nil, fmt.Errorf("codec: unsupported encoding %v", enc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("codec: encoding %T: %w", v, err)
+	}
+	return data, nil
+}
+
+// Decode decodes data into v, which must be a proto.Message. Fields not
+// known to v are kept as unknown fields in the binary encoding and dropped in
+// the JSON encoding.
+func (c *protoCodec) Decode(data []byte, enc pubsub.SchemaEncoding, v interface{}) error {
+	m, err := c.message(v)
+	if err != nil {
+		return err
+	}
+	switch enc {
+	case pubsub.EncodingBinary:
+		err = proto.Unmarshal(data, m)
+	case pubsub.EncodingJSON:
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+	default:
+		return fmt.Errorf("codec: unsupported encoding %v", enc)
+	}
+	if err != nil {
+		return fmt.Errorf("codec: decoding %s@%s: %w", c.cfg.Name, c.cfg.RevisionID, err)
+	}
+	return nil
+}
+
+// A protoSchema is the top-level message of a Protocol Buffer schema, as
+// far as needed to check that a Go type matches it. Pub/Sub schemas are a
+// single file with one top-level message and no imports.
+type protoSchema struct {
+	name   protoreflect.FullName
+	fields []protoField
+}
+
+type protoField struct {
+	name     string
+	number   protoreflect.FieldNumber
+	typ      string // a scalar type, "map", or a message or enum name
+	repeated bool
+}
+
+// check reports whether desc can be used with the schema: it must have the
+// same full name, and fields present in both must agree in name, number and
+// type.
+func (s *protoSchema) check(desc protoreflect.MessageDescriptor) error {
+	if desc.FullName() != s.name {
+		return fmt.Errorf("message is %s, not %s", desc.FullName(), s.name)
+	}
+	for _, f := range s.fields {
+		if fd := desc.Fields().ByName(protoreflect.Name(f.name)); fd != nil && fd.Number() != f.number {
+			return fmt.Errorf("field %s is %d, not %d", f.name, fd.Number(), f.number)
+		}
+		fd := desc.Fields().ByNumber(f.number)
+		if fd == nil {
+			continue
+		}
+		if string(fd.Name()) != f.name {
+			return fmt.Errorf("field %d is %s, not %s", f.number, fd.Name(), f.name)
+		}
+		if fd.IsMap() != (f.typ == "map") || (!fd.IsMap() && fd.IsList() != f.repeated) {
+			return fmt.Errorf("field %s has a different cardinality", f.name)
+		}
+		switch {
+		case f.typ == "map":
+		case protoScalars[f.typ]:
+			if fd.Kind().String() != f.typ {
+				return fmt.Errorf("field %s is %v, not %s", f.name, fd.Kind(), f.typ)
+			}
+		default:
+			if k := fd.Kind(); k != protoreflect.MessageKind && k != protoreflect.GroupKind && k != protoreflect.EnumKind {
+				return fmt.Errorf("field %s is %v, not %s", f.name, k, f.typ)
+			}
+		}
+	}
+	return nil
+}
+
+var protoScalars = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true,
+	"uint32": true, "uint64": true, "sint32": true, "sint64": true,
+	"fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+func parseProto(definition string) (*protoSchema, error) {
+	p := protoParser{toks: tokenizeProto(definition)}
+	var pkg string
+	var s *protoSchema
+	for !p.done() {
+		switch tok := p.next(); tok {
+		case "package":
+			pkg = p.next()
+			p.skipStatement()
+		case "message":
+			name := p.next()
+			if p.next() != "{" {
+				return nil, fmt.Errorf("message %s: missing body", name)
+			}
+			if s != nil {
+				return nil, fmt.Errorf("more than one top-level message")
+			}
+			s = &protoSchema{name: protoreflect.FullName(name)}
+			if pkg != "" {
+				s.name = protoreflect.FullName(pkg + "." + name)
+			}
+			if err := p.parseFields(s, name); err != nil {
+				return nil, err
+			}
+		case "enum", "service", "extend":
+			p.skipBlock()
+		case "import":
+			return nil, fmt.Errorf("imports are not supported")
+		case ";":
+		default:
+			p.skipStatement()
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("no message")
+	}
+	return s, nil
+}
+
+type protoParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *protoParser) done() bool { return p.pos >= len(p.toks) }
+
+func (p *protoParser) next() string {
+	if p.done() {
+		return ""
+	}
+	p.pos++
+	return p.toks[p.pos-1]
+}
+
+// skipStatement skips to the end of the current statement, including any
+// block it opens.
+func (p *protoParser) skipStatement() {
+	for !p.done() {
+		switch p.next() {
+		case ";":
+			return
+		case "{":
+			p.skipBody()
+			return
+		}
+	}
+}
+
+// skipBlock skips a declaration followed by a block.
+func (p *protoParser) skipBlock() {
+	for !p.done() {
+		if p.next() == "{" {
+			p.skipBody()
+			return
+		}
+	}
+}
+
+// skipBody skips to the brace closing an opened block.
+func (p *protoParser) skipBody() {
+	for depth := 1; depth > 0 && !p.done(); {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+}
+
+// parseFields parses the fields in the body of message msg up to its
+// closing brace. Fields of oneofs belong to the message; nested types are
+// skipped.
+func (p *protoParser) parseFields(s *protoSchema, msg string) error {
+	for !p.done() {
+		var stmt []string
+		for tok := p.next(); tok != ";"; tok = p.next() {
+			if tok == "" {
+				return fmt.Errorf("message %s: unexpected end", msg)
+			}
+			if tok == "}" && len(stmt) == 0 {
+				return nil
+			}
+			if tok == "{" {
+				break
+			}
+			if tok == "[" {
+				// Field options.
+				for tok != "]" && tok != "" {
+					tok = p.next()
+				}
+				continue
+			}
+			stmt = append(stmt, tok)
+		}
+		if len(stmt) == 0 {
+			continue
+		}
+		switch stmt[0] {
+		case "oneof":
+			if err := p.parseFields(s, msg); err != nil {
+				return err
+			}
+			continue
+		case "message", "enum", "extend":
+			p.skipBody()
+			continue
+		case "option", "reserved", "extensions":
+			continue
+		}
+		f, err := parseProtoField(stmt)
+		if err != nil {
+			return fmt.Errorf("message %s: %w", msg, err)
+		}
+		s.fields = append(s.fields, f)
+	}
+	return fmt.Errorf("message %s: unexpected end", msg)
+}
+
+// parseProtoField parses the tokens of a field declaration such as
+// "repeated string names = 3" or "map < string , int64 > counts = 4".
+func parseProtoField(stmt []string) (protoField, error) {
+	var f protoField
+	switch stmt[0] {
+	case "repeated":
+		f.repeated = true
+		stmt = stmt[1:]
+	case "optional", "required":
+		stmt = stmt[1:]
+	}
+	if len(stmt) > 0 && stmt[0] == "map" {
+		f.typ = "map"
+		for len(stmt) > 0 && stmt[0] != ">" {
+			stmt = stmt[1:]
+		}
+		if len(stmt) > 0 {
+			stmt[0] = "map"
+		}
+	}
+	if len(stmt) != 4 || stmt[2] != "=" {
+		return f, fmt.Errorf("invalid field %q", strings.Join(stmt, " "))
+	}
+	if f.typ == "" {
+		f.typ = strings.TrimPrefix(stmt[0], ".")
+	}
+	f.name = stmt[1]
+	n, err := strconv.ParseInt(stmt[3], 0, 32)
+	if err != nil {
+		return f, fmt.Errorf("field %s: invalid number %q", f.name, stmt[3])
+	}
+	f.number = protoreflect.FieldNumber(n)
+	return f, nil
+}
+
+// tokenizeProto splits a .proto file into identifiers, numbers, strings and
+// punctuation, dropping comments.
+func tokenizeProto(src string) []string {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return toks
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case unicode.IsSpace(rune(c)):
+			i++
+		case isIdentByte(c):
+			j := i
+			for j < len(src) && isIdentByte(src[j]) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c == '+' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
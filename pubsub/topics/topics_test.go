@@ -21,6 +21,7 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +31,7 @@ import (
 	"cloud.google.com/go/iam"
 	"cloud.google.com/go/pubsub"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
 	"google.golang.org/api/iterator"
 )
 
@@ -44,12 +46,22 @@ const (
 // down every time, so this speeds things up.
 var once sync.Once
 
-func setup(t *testing.T) *pubsub.Client {
+// testProject returns the project to run a test in. Without
+// GOLANG_SAMPLES_PROJECT_ID set, Pub/Sub clients created during the test,
+// including those of the samples, connect to a fake instead.
+func testProject(t *testing.T) string {
+	if os.Getenv("GOLANG_SAMPLES_PROJECT_ID") == "" {
+		t.Setenv("PUBSUB_EMULATOR_HOST", fakes.NewPubSub(t).Server.Addr)
+		return "fake-project"
+	}
+	return testutil.SystemTest(t).ProjectID
+}
+
+func setup(t *testing.T, projectID string) *pubsub.Client {
 	ctx := context.Background()
-	tc := testutil.SystemTest(t)
 
 	var err error
-	client, err := pubsub.NewClient(ctx, tc.ProjectID)
+	client, err := pubsub.NewClient(ctx, projectID)
 	if err != nil {
 		t.Fatalf("failed to create client: %v", err)
 	}
@@ -91,10 +103,10 @@ func setup(t *testing.T) *pubsub.Client {
 }
 
 func TestCreate(t *testing.T) {
-	client := setup(t)
-	tc := testutil.SystemTest(t)
+	projectID := testProject(t)
+	client := setup(t, projectID)
 	buf := new(bytes.Buffer)
-	if err := create(buf, tc.ProjectID, topicID); err != nil {
+	if err := create(buf, projectID, topicID); err != nil {
 		t.Fatalf("failed to create a topic: %v", err)
 	}
 	ok, err := client.Topic(topicID).Exists(context.Background())
@@ -134,7 +146,7 @@ func TestPublish(t *testing.T) {
 	// TODO(jbd): Merge topics and subscriptions programs maybe?
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	if err := publish(buf, tc.ProjectID, topicID, "hello world"); err != nil {
@@ -145,7 +157,7 @@ func TestPublish(t *testing.T) {
 func TestPublishThatScales(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	if err := publishThatScales(buf, tc.ProjectID, topicID, 10); err != nil {
@@ -156,7 +168,7 @@ func TestPublishThatScales(t *testing.T) {
 func TestPublishWithSettings(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	if err := publishWithSettings(ioutil.Discard, tc.ProjectID, topicID); err != nil {
 		t.Errorf("failed to publish message: %v", err)
@@ -166,7 +178,7 @@ func TestPublishWithSettings(t *testing.T) {
 func TestPublishCustomAttributes(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	if err := publishCustomAttributes(buf, tc.ProjectID, topicID); err != nil {
@@ -177,7 +189,7 @@ func TestPublishCustomAttributes(t *testing.T) {
 func TestPublishWithRetrySettings(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	if err := publishWithRetrySettings(buf, tc.ProjectID, topicID, "hello world"); err != nil {
@@ -188,7 +200,7 @@ func TestPublishWithRetrySettings(t *testing.T) {
 func TestIAM(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 
 	testutil.Retry(t, 10, time.Second, func(r *testutil.R) {
@@ -226,7 +238,7 @@ func TestIAM(t *testing.T) {
 func TestPublishWithOrderingKey(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	publishWithOrderingKey(buf, tc.ProjectID, topicID)
@@ -241,7 +253,7 @@ func TestPublishWithOrderingKey(t *testing.T) {
 func TestResumePublishWithOrderingKey(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	resumePublishWithOrderingKey(buf, tc.ProjectID, topicID)
@@ -256,7 +268,7 @@ func TestResumePublishWithOrderingKey(t *testing.T) {
 func TestPublishWithFlowControl(t *testing.T) {
 	ctx := context.Background()
 	tc := testutil.SystemTest(t)
-	client := setup(t)
+	client := setup(t, tc.ProjectID)
 	client.CreateTopic(ctx, topicID)
 	buf := new(bytes.Buffer)
 	if err := publishWithFlowControlSettings(buf, tc.ProjectID, topicID); err != nil {
@@ -266,8 +278,8 @@ func TestPublishWithFlowControl(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	ctx := context.Background()
-	tc := testutil.SystemTest(t)
-	client := setup(t)
+	projectID := testProject(t)
+	client := setup(t, projectID)
 
 	topic := client.Topic(topicID)
 	ok, err := topic.Exists(ctx)
@@ -282,7 +294,7 @@ func TestDelete(t *testing.T) {
 	}
 
 	buf := new(bytes.Buffer)
-	if err := delete(buf, tc.ProjectID, topicID); err != nil {
+	if err := delete(buf, projectID, topicID); err != nil {
 		t.Fatalf("failed to delete topic (%q): %v", topicID, err)
 	}
 	ok, err = client.Topic(topicID).Exists(context.Background())
@@ -15,106 +15,50 @@
 package samples
 
 import (
-	"bufio"
-	"log"
-	"os/exec"
-	"regexp"
-	"sync"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
-)
 
-var startRe = regexp.MustCompile("\\[START ([[:word:]]+)\\]")
+	"github.com/GoogleCloudPlatform/golang-samples/internal/regiontag"
+)
 
-func listPackages() <-chan string {
-	c := make(chan string)
-	go func() {
-		cmd := exec.Command("go", "list", "./...")
-		out, err := cmd.StdoutPipe()
+// TestRegionTags checks that no region tags of the root module appear in
+// go doc output. Run internal/regiontaglint for the other checks.
+func TestRegionTags(t *testing.T) {
+	err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err = cmd.Start(); err != nil {
-			log.Fatal(err)
-		}
-
-		scanner := bufio.NewScanner(out)
-		for scanner.Scan() {
-			c <- scanner.Text()
+			return err
 		}
-		close(c)
-	}()
-	return c
-}
-
-type RegionTag struct {
-	file string
-	line string
-}
-
-func findRegionTags(pkgs <-chan string) <-chan RegionTag {
-	c := make(chan RegionTag)
-	go func() {
-		for p := range pkgs {
-			goDoc := exec.Command("go", "doc", p)
-			out, err := goDoc.StdoutPipe()
-			if err != nil {
-				log.Fatal(err)
+		if d.IsDir() {
+			if path == "." {
+				return nil
 			}
-
-			if err = goDoc.Start(); err != nil {
-				log.Fatal(err)
+			name := d.Name()
+			if name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
 			}
-
-			scanner := bufio.NewScanner(out)
-
-			// filter affected lines only
-			for scanner.Scan() {
-				text := scanner.Text()
-				if !startRe.MatchString(text) {
-					continue
-				}
-				c <- RegionTag{file: p, line: text}
+			// Nested modules are checked by their own tests.
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-		close(c)
-	}()
-	return c
-}
-
-func merge(cs ...<-chan RegionTag) <-chan RegionTag {
-	var wg sync.WaitGroup
-	out := make(chan RegionTag)
-
-	output := func(c <-chan RegionTag) {
-		for n := range c {
-			out <- n
+		if !strings.HasSuffix(path, ".go") {
+			return nil
 		}
-		wg.Done()
-	}
-	wg.Add(len(cs))
-	for _, c := range cs {
-		go output(c)
-	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-	return out
-}
-
-func TestRegionTags(t *testing.T) {
-
-	in := listPackages()
-	const workers = 4
-
-	var cs [workers]<-chan RegionTag
-	for i := 0; i < workers; i++ {
-		cs[i] = findRegionTags(in)
-	}
-
-	for tag := range merge(cs[:]...) {
-		t.Errorf("\nFile: %v\nLine: %#v", tag.file, tag.line)
+		_, problems, err := regiontag.LintFile(path, nil)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			if p.Check == regiontag.CheckDoc {
+				t.Error(p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 }
@@ -15,12 +15,23 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+	"github.com/GoogleCloudPlatform/golang-samples/run/image-processing/moderation"
 )
 
 func TestHelloPubSubErrors(t *testing.T) {
@@ -75,3 +86,68 @@ func TestHelloPubSubErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestModeratePubSub(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ok.png", "bad.png"} {
+		f, err := os.Create(filepath.Join(dir, "in", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	detector := moderation.NewFakeDetector()
+	detector.Set("in", "ok.png", &visionpb.SafeSearchAnnotation{Adult: visionpb.Likelihood_UNLIKELY})
+	detector.Set("in", "bad.png", &visionpb.SafeSearchAnnotation{Violence: visionpb.Likelihood_VERY_LIKELY})
+	p := &moderation.Pipeline{
+		Detector:     detector,
+		Storage:      &moderation.LocalStorage{Dir: dir},
+		Thresholds:   moderation.DefaultThresholds(),
+		Actions:      []moderation.Action{moderation.Blur{Radius: 2}},
+		OutputBucket: "out",
+	}
+	var pipelineErr error
+	defer func(f func(context.Context) (*moderation.Pipeline, error)) { loadPipeline = f }(loadPipeline)
+	loadPipeline = func(context.Context) (*moderation.Pipeline, error) { return p, pipelineErr }
+
+	tests := []struct {
+		name        string
+		data        string
+		pipelineErr error
+		wantCode    int
+		wantOutput  bool
+	}{
+		{name: "ok", data: `{"bucket":"in","name":"ok.png"}`, wantCode: http.StatusOK},
+		{name: "flagged", data: `{"bucket":"in","name":"bad.png"}`, wantCode: http.StatusOK, wantOutput: true},
+		{name: "missing", data: `{"bucket":"in","name":"missing.png"}`, wantCode: http.StatusInternalServerError},
+		{name: "no_name", data: `{"bucket":"in"}`, wantCode: http.StatusBadRequest},
+		{name: "misconfigured", data: `{"bucket":"in","name":"ok.png"}`, pipelineErr: errors.New("no bucket"), wantCode: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		pipelineErr = test.pipelineErr
+		data := base64.StdEncoding.EncodeToString([]byte(test.data))
+		req := httptest.NewRequest("POST", "/moderate", strings.NewReader(fmt.Sprintf(`{"message": {"data": "%s"}}`, data)))
+		rr := httptest.NewRecorder()
+
+		ModeratePubSub(rr, req)
+
+		if code := rr.Result().StatusCode; code != test.wantCode {
+			t.Errorf("ModeratePubSub(%q): got %d, want %d", test.name, code, test.wantCode)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "bad.png")); err != nil {
+		t.Errorf("flagged image not written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "ok.png")); err == nil {
+		t.Errorf("unflagged image was written")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	vision "cloud.google.com/go/vision/apiv1"
+	"github.com/GoogleCloudPlatform/golang-samples/run/image-processing/imagemagick"
+	"github.com/GoogleCloudPlatform/golang-samples/run/image-processing/moderation"
+)
+
+// The /moderate route processes uploads with the pure-Go moderation
+// pipeline instead of ImageMagick. Point a Pub/Sub push subscription at it to
+// use it. It is configured with environment variables:
+//
+//	BLURRED_BUCKET_NAME    bucket for processed images (required)
+//	MODERATION_THRESHOLDS  e.g. "adult=VERY_LIKELY,violence=LIKELY"
+//	                       (default: adult and violence at VERY_LIKELY)
+//	MODERATION_ACTIONS     e.g. "thumbnail:512,blur:8,watermark" (default: "blur:8")
+func init() {
+	http.HandleFunc("/moderate", ModeratePubSub)
+}
+
+var (
+	pipelineOnce sync.Once
+	pipeline     *moderation.Pipeline
+	pipelineErr  error
+)
+
+// loadPipeline returns the pipeline configured from the environment,
+// creating it on first use. Tests replace it.
+var loadPipeline = func(ctx context.Context) (*moderation.Pipeline, error) {
+	pipelineOnce.Do(func() {
+		pipeline, pipelineErr = newPipeline(ctx)
+	})
+	return pipeline, pipelineErr
+}
+
+func newPipeline(ctx context.Context) (*moderation.Pipeline, error) {
+	outputBucket := os.Getenv("BLURRED_BUCKET_NAME")
+	if outputBucket == "" {
+		return nil, errors.New("BLURRED_BUCKET_NAME must be set")
+	}
+	thresholds := moderation.DefaultThresholds()
+	if s := os.Getenv("MODERATION_THRESHOLDS"); s != "" {
+		var err error
+		if thresholds, err = moderation.ParseThresholds(s); err != nil {
+			return nil, err
+		}
+	}
+	actionSpec := os.Getenv("MODERATION_ACTIONS")
+	if actionSpec == "" {
+		actionSpec = "blur:8"
+	}
+	actions, err := moderation.ParseActions(actionSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a context that outlives the first request for the clients.
+	storageClient, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	visionClient, err := vision.NewImageAnnotatorClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &moderation.Pipeline{
+		Detector:     &moderation.VisionDetector{Client: visionClient},
+		Storage:      &moderation.GCSStorage{Client: storageClient},
+		Thresholds:   thresholds,
+		Actions:      actions,
+		OutputBucket: outputBucket,
+	}, nil
+}
+
+// ModeratePubSub receives a Pub/Sub push message for an uploaded image and
+// runs it through the moderation pipeline.
+func ModeratePubSub(w http.ResponseWriter, r *http.Request) {
+	var m PubSubMessage
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ioutil.ReadAll: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		log.Printf("json.Unmarshal: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	var e imagemagick.GCSEvent
+	if err := json.Unmarshal(m.Message.Data, &e); err != nil {
+		log.Printf("json.Unmarshal: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if e.Name == "" || e.Bucket == "" {
+		log.Printf("invalid GCSEvent: expected name and bucket")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	p, err := loadPipeline(r.Context())
+	if err != nil {
+		log.Printf("moderation pipeline: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := p.Process(r.Context(), e.Bucket, e.Name); err != nil {
+		log.Printf("Pipeline.Process: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
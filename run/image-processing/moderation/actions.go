@@ -0,0 +1,281 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package moderation
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// Action transforms a flagged image.
+type Action interface {
+	Apply(img image.Image) (image.Image, error)
+	String() string
+}
+
+// Blur applies a box blur with the given radius in pixels. Three passes are
+// made, which closely approximates a Gaussian blur.
+type Blur struct {
+	Radius int
+}
+
+// Apply implements Action.
+func (b Blur) Apply(img image.Image) (image.Image, error) {
+	if b.Radius <= 0 {
+		return nil, fmt.Errorf("invalid radius %d", b.Radius)
+	}
+	dst := toRGBA(img)
+	tmp := image.NewRGBA(dst.Bounds())
+	for i := 0; i < 3; i++ {
+		boxBlur(tmp, dst, b.Radius, true)
+		boxBlur(dst, tmp, b.Radius, false)
+	}
+	return dst, nil
+}
+
+func (b Blur) String() string { return fmt.Sprintf("blur:%d", b.Radius) }
+
+// boxBlur blurs src into dst along a single axis using a running sum.
+func boxBlur(dst, src *image.RGBA, radius int, horizontal bool) {
+	r := src.Bounds()
+	outer, inner := r.Dy(), r.Dx()
+	if !horizontal {
+		outer, inner = inner, outer
+	}
+	offset := func(o, i int) int {
+		if horizontal {
+			return o*src.Stride + i*4
+		}
+		return i*src.Stride + o*4
+	}
+	for o := 0; o < outer; o++ {
+		var sum [4]int
+		// Prime the window with the edge pixel repeated, then the first
+		// radius pixels.
+		for i := -radius; i <= radius; i++ {
+			p := offset(o, clamp(i, 0, inner-1))
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[p+c])
+			}
+		}
+		n := 2*radius + 1
+		for i := 0; i < inner; i++ {
+			p := offset(o, i)
+			for c := 0; c < 4; c++ {
+				dst.Pix[p+c] = uint8(sum[c] / n)
+			}
+			in := offset(o, clamp(i+radius+1, 0, inner-1))
+			out := offset(o, clamp(i-radius, 0, inner-1))
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[in+c]) - int(src.Pix[out+c])
+			}
+		}
+	}
+}
+
+// Pixelate replaces each Size×Size block with its average color.
+type Pixelate struct {
+	Size int
+}
+
+// Apply implements Action.
+func (p Pixelate) Apply(img image.Image) (image.Image, error) {
+	if p.Size <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", p.Size)
+	}
+	dst := toRGBA(img)
+	b := dst.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += p.Size {
+		for x := b.Min.X; x < b.Max.X; x += p.Size {
+			block := image.Rect(x, y, x+p.Size, y+p.Size).Intersect(b)
+			draw.Draw(dst, block, &image.Uniform{average(dst, block)}, image.Point{}, draw.Src)
+		}
+	}
+	return dst, nil
+}
+
+func (p Pixelate) String() string { return fmt.Sprintf("pixelate:%d", p.Size) }
+
+// Thumbnail scales the image down so neither side exceeds MaxSize, keeping the
+// aspect ratio. Images that are already small enough are left unchanged.
+type Thumbnail struct {
+	MaxSize int
+}
+
+// Apply implements Action.
+func (t Thumbnail) Apply(img image.Image) (image.Image, error) {
+	if t.MaxSize <= 0 {
+		return nil, fmt.Errorf("invalid max size %d", t.MaxSize)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= t.MaxSize && h <= t.MaxSize {
+		return img, nil
+	}
+	nw, nh := t.MaxSize, h*t.MaxSize/w
+	if h > w {
+		nw, nh = w*t.MaxSize/h, t.MaxSize
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	// Area averaging: each destination pixel is the mean of the source pixels
+	// it covers.
+	for y := 0; y < nh; y++ {
+		y0, y1 := y*h/nh, (y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := x*w/nw, (x+1)*w/nw
+			dst.SetRGBA(x, y, average(src, image.Rect(x0, y0, x1, y1).Add(src.Bounds().Min)))
+		}
+	}
+	return dst, nil
+}
+
+func (t Thumbnail) String() string { return fmt.Sprintf("thumbnail:%d", t.MaxSize) }
+
+// Watermark overlays Mark in the bottom right corner of the image. If Mark is
+// nil, a translucent band is drawn across the bottom of the image instead.
+type Watermark struct {
+	Mark image.Image
+	// Opacity is in the range (0, 1]. Zero means 0.5.
+	Opacity float64
+}
+
+// Apply implements Action.
+func (wm Watermark) Apply(img image.Image) (image.Image, error) {
+	opacity := wm.Opacity
+	if opacity == 0 {
+		opacity = 0.5
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("invalid opacity %v", opacity)
+	}
+	dst := toRGBA(img)
+	b := dst.Bounds()
+	mask := &image.Uniform{color.Alpha{uint8(opacity * 0xff)}}
+	if wm.Mark == nil {
+		band := image.Rect(b.Min.X, b.Max.Y-b.Dy()/8-1, b.Max.X, b.Max.Y)
+		draw.DrawMask(dst, band, image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+		return dst, nil
+	}
+	mb := wm.Mark.Bounds()
+	r := image.Rect(b.Max.X-mb.Dx(), b.Max.Y-mb.Dy(), b.Max.X, b.Max.Y).Intersect(b)
+	draw.DrawMask(dst, r, wm.Mark, mb.Min, mask, image.Point{}, draw.Over)
+	return dst, nil
+}
+
+func (wm Watermark) String() string { return "watermark" }
+
+// Reject stops the pipeline without writing any output.
+type Reject struct{}
+
+// Apply implements Action by always returning ErrRejected.
+func (Reject) Apply(img image.Image) (image.Image, error) { return nil, ErrRejected }
+
+func (Reject) String() string { return "reject" }
+
+// ParseActions parses a comma separated list of actions, for example
+// "thumbnail:512,blur:8,watermark". Supported actions are blur:RADIUS,
+// pixelate:SIZE, thumbnail:MAXSIZE, watermark[:OPACITY] and reject.
+func ParseActions(s string) ([]Action, error) {
+	var actions []Action
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, arg, hasArg := strings.Cut(spec, ":")
+		switch strings.ToLower(name) {
+		case "blur", "pixelate", "thumbnail":
+			if !hasArg {
+				return nil, fmt.Errorf("action %q requires an argument", name)
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid argument for %q: %q", name, arg)
+			}
+			switch strings.ToLower(name) {
+			case "blur":
+				actions = append(actions, Blur{Radius: n})
+			case "pixelate":
+				actions = append(actions, Pixelate{Size: n})
+			default:
+				actions = append(actions, Thumbnail{MaxSize: n})
+			}
+		case "watermark":
+			var wm Watermark
+			if hasArg {
+				o, err := strconv.ParseFloat(arg, 64)
+				if err != nil || o <= 0 || o > 1 {
+					return nil, fmt.Errorf("invalid opacity for watermark: %q", arg)
+				}
+				wm.Opacity = o
+			}
+			actions = append(actions, wm)
+		case "reject":
+			actions = append(actions, Reject{})
+		default:
+			return nil, fmt.Errorf("unknown action %q", name)
+		}
+	}
+	return actions, nil
+}
+
+// toRGBA returns a copy of img as an *image.RGBA. A copy is always made so
+// actions never modify their input.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
+
+// average returns the mean color of img within r.
+func average(img *image.RGBA, r image.Rectangle) color.RGBA {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return color.RGBA{}
+	}
+	var sum [4]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := img.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				sum[c] += int(img.Pix[p+c])
+			}
+		}
+	}
+	n := r.Dx() * r.Dy()
+	return color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)}
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
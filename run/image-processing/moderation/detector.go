@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	vision "cloud.google.com/go/vision/apiv1"
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+// Detector returns the SafeSearch annotation of an image.
+type Detector interface {
+	SafeSearch(ctx context.Context, bucket, name string) (*visionpb.SafeSearchAnnotation, error)
+}
+
+// VisionDetector is a Detector backed by the Cloud Vision API.
+type VisionDetector struct {
+	Client *vision.ImageAnnotatorClient
+}
+
+// SafeSearch runs SafeSearch detection on gs://bucket/name.
+func (d *VisionDetector) SafeSearch(ctx context.Context, bucket, name string) (*visionpb.SafeSearchAnnotation, error) {
+	img := vision.NewImageFromURI(fmt.Sprintf("gs://%s/%s", bucket, name))
+	return d.Client.DetectSafeSearch(ctx, img, nil)
+}
+
+// FakeDetector is an in-memory Detector for tests.
+type FakeDetector struct {
+	mu          sync.Mutex
+	annotations map[string]*visionpb.SafeSearchAnnotation
+	calls       []string
+}
+
+// NewFakeDetector returns an empty FakeDetector.
+func NewFakeDetector() *FakeDetector {
+	return &FakeDetector{annotations: make(map[string]*visionpb.SafeSearchAnnotation)}
+}
+
+// Set sets the annotation returned for bucket/name.
+func (d *FakeDetector) Set(bucket, name string, a *visionpb.SafeSearchAnnotation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.annotations[bucket+"/"+name] = a
+}
+
+// SafeSearch returns the annotation set for bucket/name, or an error if none
+// was set.
+func (d *FakeDetector) SafeSearch(ctx context.Context, bucket, name string) (*visionpb.SafeSearchAnnotation, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := bucket + "/" + name
+	d.calls = append(d.calls, key)
+	a, ok := d.annotations[key]
+	if !ok {
+		return nil, fmt.Errorf("no annotation for %q", key)
+	}
+	return a, nil
+}
+
+// Calls returns the bucket/name keys SafeSearch was called with.
+func (d *FakeDetector) Calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.calls...)
+}
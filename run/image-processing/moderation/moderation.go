@@ -0,0 +1,257 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package moderation contains a configurable image moderation pipeline.
+//
+// A Pipeline asks a Detector for the SafeSearch annotation of an image, compares
+// it against per-category likelihood thresholds and, when any threshold is
+// met, runs a chain of Actions over the decoded image. All image operations
+// are implemented in pure Go, so no system packages such as ImageMagick are
+// required. The image-processing service runs it on its /moderate route.
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+// Category is a SafeSearch category.
+type Category string
+
+// SafeSearch categories.
+const (
+	Adult    Category = "adult"
+	Spoof    Category = "spoof"
+	Medical  Category = "medical"
+	Violence Category = "violence"
+	Racy     Category = "racy"
+)
+
+// Categories lists every known Category.
+var Categories = []Category{Adult, Spoof, Medical, Violence, Racy}
+
+// likelihood returns the likelihood the annotation assigns to c.
+func (c Category) likelihood(a *visionpb.SafeSearchAnnotation) visionpb.Likelihood {
+	switch c {
+	case Adult:
+		return a.GetAdult()
+	case Spoof:
+		return a.GetSpoof()
+	case Medical:
+		return a.GetMedical()
+	case Violence:
+		return a.GetViolence()
+	case Racy:
+		return a.GetRacy()
+	}
+	return visionpb.Likelihood_UNKNOWN
+}
+
+// Thresholds maps a Category to the minimum likelihood at which an image is
+// flagged. Categories that are not present are never flagged.
+type Thresholds map[Category]visionpb.Likelihood
+
+// DefaultThresholds matches the behavior of the original sample: flag images
+// that are very likely adult or violent.
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		Adult:    visionpb.Likelihood_VERY_LIKELY,
+		Violence: visionpb.Likelihood_VERY_LIKELY,
+	}
+}
+
+// Flagged returns the categories of a that meet their threshold, in the order
+// of Categories.
+func (t Thresholds) Flagged(a *visionpb.SafeSearchAnnotation) []Category {
+	var flagged []Category
+	for _, c := range Categories {
+		min, ok := t[c]
+		if !ok || min == visionpb.Likelihood_UNKNOWN {
+			continue
+		}
+		if c.likelihood(a) >= min {
+			flagged = append(flagged, c)
+		}
+	}
+	return flagged
+}
+
+// ParseThresholds parses a comma separated list of category=LIKELIHOOD pairs,
+// for example "adult=LIKELY,violence=VERY_LIKELY".
+func ParseThresholds(s string) (Thresholds, error) {
+	t := Thresholds{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid threshold %q: want category=LIKELIHOOD", pair)
+		}
+		c := Category(strings.ToLower(strings.TrimSpace(k)))
+		if !knownCategory(c) {
+			return nil, fmt.Errorf("unknown category %q", k)
+		}
+		l, ok := visionpb.Likelihood_value[strings.ToUpper(strings.TrimSpace(v))]
+		if !ok {
+			return nil, fmt.Errorf("unknown likelihood %q", v)
+		}
+		t[c] = visionpb.Likelihood(l)
+	}
+	return t, nil
+}
+
+func knownCategory(c Category) bool {
+	for _, k := range Categories {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the thresholds in the format accepted by ParseThresholds.
+func (t Thresholds) String() string {
+	var pairs []string
+	for c, l := range t {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", c, l))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Pipeline moderates images read from Storage.
+type Pipeline struct {
+	Detector   Detector
+	Storage    Storage
+	Thresholds Thresholds
+	// Actions are applied in order to flagged images.
+	Actions []Action
+	// OutputBucket is where processed images are written. Images are written
+	// with the same name they were read with.
+	OutputBucket string
+}
+
+// Result describes what the Pipeline did with an image.
+type Result struct {
+	// Flagged lists the categories that met their threshold. It is empty when
+	// the image was detected as OK.
+	Flagged []Category
+	// Rejected is true when a Reject action stopped the pipeline.
+	Rejected bool
+	// Output is the name of the written object, if any.
+	Output string
+}
+
+// ErrRejected is returned by an Action to stop the pipeline without writing
+// any output.
+var ErrRejected = errors.New("image rejected")
+
+// Process moderates gs://bucket/name (or its equivalent in p.Storage).
+func (p *Pipeline) Process(ctx context.Context, bucket, name string) (*Result, error) {
+	if p.OutputBucket == "" {
+		return nil, errors.New("moderation: OutputBucket must be set")
+	}
+	a, err := p.Detector.SafeSearch(ctx, bucket, name)
+	if err != nil {
+		return nil, fmt.Errorf("SafeSearch: %w", err)
+	}
+	res := &Result{Flagged: p.Thresholds.Flagged(a)}
+	if len(res.Flagged) == 0 {
+		log.Printf("The image %q was detected as OK.", name)
+		return res, nil
+	}
+
+	img, format, err := p.read(ctx, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range p.Actions {
+		img, err = action.Apply(img)
+		if errors.Is(err, ErrRejected) {
+			log.Printf("The image %q was rejected (%v).", name, res.Flagged)
+			res.Rejected = true
+			return res, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+	}
+	if err := p.write(ctx, name, img, format); err != nil {
+		return nil, err
+	}
+	res.Output = name
+	log.Printf("Moderated image uploaded to %s/%s", p.OutputBucket, name)
+	return res, nil
+}
+
+func (p *Pipeline) read(ctx context.Context, bucket, name string) (image.Image, string, error) {
+	r, err := p.Storage.NewReader(ctx, bucket, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("NewReader: %w", err)
+	}
+	defer r.Close()
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("image.Decode: %w", err)
+	}
+	return img, format, nil
+}
+
+func (p *Pipeline) write(ctx context.Context, name string, img image.Image, format string) error {
+	// Encode before opening the writer so a failed encode does not leave a
+	// partial object behind.
+	var buf bytes.Buffer
+	if err := encode(&buf, img, format); err != nil {
+		return err
+	}
+	w, err := p.Storage.NewWriter(ctx, p.OutputBucket, name)
+	if err != nil {
+		return fmt.Errorf("NewWriter: %w", err)
+	}
+	if _, err := io.Copy(w, &buf); err != nil {
+		w.Close()
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %w", err)
+	}
+	return nil
+}
+
+// encode writes img to w in the given format, as reported by image.Decode.
+func encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package moderation
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/vision/v2/apiv1/visionpb"
+)
+
+// checkerboard returns a w×h image of alternating black and white pixels.
+func checkerboard(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name       string
+		annotation *visionpb.SafeSearchAnnotation
+		actions    string
+		wantFlag   bool
+		wantReject bool
+		wantSize   int
+	}{
+		{
+			name:       "ok",
+			annotation: &visionpb.SafeSearchAnnotation{Adult: visionpb.Likelihood_UNLIKELY},
+			actions:    "blur:2",
+		},
+		{
+			name:       "blurred",
+			annotation: &visionpb.SafeSearchAnnotation{Violence: visionpb.Likelihood_VERY_LIKELY},
+			actions:    "blur:2,watermark",
+			wantFlag:   true,
+			wantSize:   32,
+		},
+		{
+			name:       "thumbnail",
+			annotation: &visionpb.SafeSearchAnnotation{Racy: visionpb.Likelihood_LIKELY},
+			actions:    "pixelate:4,thumbnail:8",
+			wantFlag:   true,
+			wantSize:   8,
+		},
+		{
+			name:       "rejected",
+			annotation: &visionpb.SafeSearchAnnotation{Adult: visionpb.Likelihood_POSSIBLE},
+			actions:    "reject",
+			wantFlag:   true,
+			wantReject: true,
+		},
+	}
+
+	thresholds, err := ParseThresholds("adult=POSSIBLE,violence=VERY_LIKELY,racy=LIKELY")
+	if err != nil {
+		t.Fatalf("ParseThresholds: %v", err)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePNG(t, filepath.Join(dir, "in", "img.png"), checkerboard(32, 32))
+
+			detector := NewFakeDetector()
+			detector.Set("in", "img.png", tc.annotation)
+			actions, err := ParseActions(tc.actions)
+			if err != nil {
+				t.Fatalf("ParseActions(%q): %v", tc.actions, err)
+			}
+			p := &Pipeline{
+				Detector:     detector,
+				Storage:      &LocalStorage{Dir: dir},
+				Thresholds:   thresholds,
+				Actions:      actions,
+				OutputBucket: "out",
+			}
+			res, err := p.Process(context.Background(), "in", "img.png")
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if got := len(res.Flagged) > 0; got != tc.wantFlag {
+				t.Errorf("flagged = %v (%v), want %v", got, res.Flagged, tc.wantFlag)
+			}
+			if res.Rejected != tc.wantReject {
+				t.Errorf("Rejected = %v, want %v", res.Rejected, tc.wantReject)
+			}
+
+			f, err := os.Open(filepath.Join(dir, "out", "img.png"))
+			if tc.wantSize == 0 {
+				if err == nil {
+					f.Close()
+					t.Errorf("output written, want none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("output not written: %v", err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatalf("png.Decode: %v", err)
+			}
+			if got := img.Bounds().Dx(); got != tc.wantSize {
+				t.Errorf("output width = %d, want %d", got, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestBlurSmoothsImage(t *testing.T) {
+	src := checkerboard(16, 16)
+	out, err := Blur{Radius: 2}.Apply(src)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	r, _, _, _ := out.At(8, 8).RGBA()
+	// A blurred checkerboard is roughly mid-gray everywhere.
+	if r < 0x6000 || r > 0xa000 {
+		t.Errorf("blurred pixel = %#x, want mid-gray", r)
+	}
+	if got, _, _, _ := src.At(8, 8).RGBA(); got != 0xffff {
+		t.Errorf("Apply modified its input")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"adult", "nope=LIKELY", "adult=SOMETIMES"} {
+		if _, err := ParseThresholds(s); err == nil {
+			t.Errorf("ParseThresholds(%q) got nil error", s)
+		}
+	}
+	for _, s := range []string{"blur", "blur:0", "sharpen:2", "watermark:2"} {
+		if _, err := ParseActions(s); err == nil {
+			t.Errorf("ParseActions(%q) got nil error", s)
+		}
+	}
+}
+
+func TestLocalStorageRejectsEscape(t *testing.T) {
+	s := &LocalStorage{Dir: t.TempDir()}
+	if _, err := s.NewWriter(context.Background(), "b", "../../etc/passwd"); err == nil {
+		t.Errorf("NewWriter with escaping name got nil error")
+	}
+}
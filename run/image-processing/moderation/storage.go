@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Storage reads and writes named objects in buckets.
+type Storage interface {
+	NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error)
+	NewWriter(ctx context.Context, bucket, name string) (io.WriteCloser, error)
+}
+
+// GCSStorage is a Storage backed by Cloud Storage.
+type GCSStorage struct {
+	Client *storage.Client
+}
+
+// NewReader opens gs://bucket/name for reading.
+func (s *GCSStorage) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	return s.Client.Bucket(bucket).Object(name).NewReader(ctx)
+}
+
+// NewWriter opens gs://bucket/name for writing. The object is created when the
+// writer is closed.
+func (s *GCSStorage) NewWriter(ctx context.Context, bucket, name string) (io.WriteCloser, error) {
+	return s.Client.Bucket(bucket).Object(name).NewWriter(ctx), nil
+}
+
+// LocalStorage is a Storage backed by a local directory. Each bucket is a
+// subdirectory of Dir.
+type LocalStorage struct {
+	Dir string
+}
+
+// NewReader opens Dir/bucket/name for reading.
+func (s *LocalStorage) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	p, err := s.path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// NewWriter creates Dir/bucket/name, and any missing parent directories.
+func (s *LocalStorage) NewWriter(ctx context.Context, bucket, name string) (io.WriteCloser, error) {
+	p, err := s.path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+// path returns the local path of bucket/name, refusing names that would
+// escape the bucket directory.
+func (s *LocalStorage) path(bucket, name string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket %q", bucket)
+	}
+	root := filepath.Join(s.Dir, bucket)
+	p := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return p, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// cacheKey returns the content hash identifying a rendered diagram.
+func cacheKey(opts renderOptions, dot string) string {
+	h := sha256.New()
+	h.Write([]byte(opts.format + "\x00" + opts.engine + "\x00"))
+	h.Write([]byte(dot))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// diagramCache is an in-memory LRU cache of rendered diagrams, bounded by
+// both entry count and total size.
+type diagramCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	size       int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newDiagramCache(maxEntries, maxBytes int) *diagramCache {
+	return &diagramCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached diagram for key, if any.
+func (c *diagramCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// add stores data under key, evicting the least recently used entries as
+// needed. Entries larger than the whole cache are not stored.
+func (c *diagramCache) add(key string, data []byte) {
+	if len(data) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, data: data})
+	c.size += len(data)
+	for c.ll.Len() > c.maxEntries || c.size > c.maxBytes {
+		oldest := c.ll.Back()
+		ent := oldest.Value.(*cacheEntry)
+		c.ll.Remove(oldest)
+		delete(c.items, ent.key)
+		c.size -= len(ent.data)
+	}
+}
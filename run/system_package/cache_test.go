@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestDiagramCache(t *testing.T) {
+	c := newDiagramCache(2, 10)
+	c.add("a", []byte("aaa"))
+	c.add("b", []byte("bbb"))
+	c.get("a") // a is now the most recently used.
+	c.add("c", []byte("ccc"))
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("get(b): got hit, want eviction of least recently used entry")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("get(%s): got miss, want hit", key)
+		}
+	}
+
+	c.add("d", []byte("dddddddd"))
+	if c.size > c.maxBytes {
+		t.Errorf("size: got %d, want <= %d", c.size, c.maxBytes)
+	}
+	c.add("huge", make([]byte, 11))
+	if _, ok := c.get("huge"); ok {
+		t.Errorf("get(huge): entry larger than the cache was stored")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	png := renderOptions{format: "png", engine: "dot"}
+	svg := renderOptions{format: "svg", engine: "dot"}
+	if cacheKey(png, "digraph{}") == cacheKey(svg, "digraph{}") {
+		t.Errorf("cacheKey: same key for different formats")
+	}
+	if cacheKey(png, "digraph{}") != cacheKey(png, "digraph{}") {
+		t.Errorf("cacheKey: different keys for the same input")
+	}
+}
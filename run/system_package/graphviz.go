@@ -17,6 +17,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,8 +26,41 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
+const (
+	// maxInputBytes bounds the size of a DOT definition, whether provided in
+	// the query string or the request body.
+	maxInputBytes = 256 << 10
+	// maxOutputBytes bounds the size of a rendered diagram.
+	maxOutputBytes = 16 << 20
+	// renderTimeout stops runaway layouts.
+	renderTimeout = 10 * time.Second
+)
+
+// formats maps supported output formats to their Content-Type.
+var formats = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+	"pdf": "application/pdf",
+}
+
+// engines lists the supported Graphviz layout engines.
+var engines = map[string]bool{
+	"dot":       true,
+	"neato":     true,
+	"fdp":       true,
+	"sfdp":      true,
+	"circo":     true,
+	"twopi":     true,
+	"osage":     true,
+	"patchwork": true,
+}
+
+// cache holds rendered diagrams keyed by a hash of their inputs.
+var cache = newDiagramCache(128, 64<<20)
+
 func main() {
 	// Verify the dot utility is available at startup
 	// instead of waiting for a first request.
@@ -37,7 +72,10 @@ func main() {
 		log.Fatal(`graphviz-web: ("/usr/bin/dot") not executable`)
 	}
 
-	http.HandleFunc("/diagram.png", diagramHandler)
+	http.HandleFunc("/diagram", diagramHandler)
+	for format := range formats {
+		http.HandleFunc("/diagram."+format, diagramHandler)
+	}
 
 	// Determine port for HTTP service.
 	port := os.Getenv("PORT")
@@ -57,45 +95,132 @@ func main() {
 // [START run_system_package_handler]
 
 // diagramHandler renders a diagram using HTTP request parameters and the dot command.
+//
+// The DOT definition is read from the "dot" query parameter of a GET request,
+// or from the body of a POST request. The output format is taken from the
+// path extension (/diagram.svg) or the "format" query parameter, and the
+// layout engine from the "engine" query parameter.
 func diagramHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	var dot string
+	switch r.Method {
+	case http.MethodGet:
+		dot = r.URL.Query().Get("dot")
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInputBytes))
+		if err != nil {
+			log.Printf("io.ReadAll: %v", err)
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		dot = string(body)
+	default:
 		log.Printf("method not allowed: %s", r.Method)
 		http.Error(w, fmt.Sprintf("HTTP Method %s Not Allowed", r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
-	q := r.URL.Query()
-	dot := q.Get("dot")
 	if dot == "" {
 		log.Print("no graphviz definition provided")
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	if len(dot) > maxInputBytes {
+		log.Printf("graphviz definition too large: %d bytes", len(dot))
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	// Cache header must be set before writing a response.
-	w.Header().Set("Cache-Control", "public, max-age=86400")
+	opts, err := parseRenderOptions(r)
+	if err != nil {
+		log.Printf("parseRenderOptions: %v", err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	input := strings.NewReader(dot)
-	if err := createDiagram(w, input); err != nil {
-		log.Printf("createDiagram: %v", err)
-		// Do not cache error responses.
-		w.Header().Del("Cache-Control")
-		if strings.Contains(err.Error(), "syntax") {
-			http.Error(w, "Bad Request: DOT syntax error", http.StatusBadRequest)
-		} else {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	key := cacheKey(opts, dot)
+	etag := `"` + key + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	out, ok := cache.get(key)
+	if !ok {
+		buf := new(bytes.Buffer)
+		if err := createDiagram(r.Context(), buf, strings.NewReader(dot), opts); err != nil {
+			log.Printf("createDiagram: %v", err)
+			switch {
+			case errors.Is(err, errRenderTimeout), errors.Is(err, errOutputTooLarge):
+				http.Error(w, "Unprocessable Entity: diagram too complex", http.StatusUnprocessableEntity)
+			case strings.Contains(err.Error(), "syntax"):
+				http.Error(w, "Bad Request: DOT syntax error", http.StatusBadRequest)
+			default:
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
 		}
+		out = buf.Bytes()
+		cache.add(key, out)
+	}
+
+	// Only successful responses are cached.
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", formats[opts.format])
+	w.Header().Set("ETag", etag)
+	if _, err := w.Write(out); err != nil {
+		log.Printf("ResponseWriter.Write: %v", err)
 	}
 }
 
 // [END run_system_package_handler]
 // [END cloudrun_system_package_handler]
 
+// renderOptions selects the output of createDiagram.
+type renderOptions struct {
+	format string
+	engine string
+}
+
+// parseRenderOptions reads the output format and layout engine from r.
+func parseRenderOptions(r *http.Request) (renderOptions, error) {
+	opts := renderOptions{format: "png", engine: "dot"}
+	q := r.URL.Query()
+	if ext := strings.TrimPrefix(r.URL.Path, "/diagram."); ext != r.URL.Path {
+		opts.format = ext
+	}
+	if f := q.Get("format"); f != "" {
+		opts.format = strings.ToLower(f)
+	}
+	if e := q.Get("engine"); e != "" {
+		opts.engine = strings.ToLower(e)
+	}
+	if _, ok := formats[opts.format]; !ok {
+		return opts, fmt.Errorf("unsupported format %q", opts.format)
+	}
+	if !engines[opts.engine] {
+		return opts, fmt.Errorf("unsupported engine %q", opts.engine)
+	}
+	return opts, nil
+}
+
+var (
+	errRenderTimeout  = errors.New("render timed out")
+	errOutputTooLarge = errors.New("rendered diagram too large")
+)
+
 // [START cloudrun_system_package_exec]
 // [START run_system_package_exec]
 
 // createDiagram generates a diagram image from the provided io.Reader written to the io.Writer.
-func createDiagram(w io.Writer, r io.Reader) error {
+func createDiagram(ctx context.Context, w io.Writer, r io.Reader, opts renderOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, renderTimeout)
+	defer cancel()
+
 	stderr := new(bytes.Buffer)
 	args := []string{
 		"-Glabel=Made on Cloud Run",
@@ -103,14 +228,22 @@ func createDiagram(w io.Writer, r io.Reader) error {
 		"-Glabeljust=right",
 		"-Glabelloc=bottom",
 		"-Gfontcolor=gray",
-		"-Tpng",
+		"-K" + opts.engine,
+		"-T" + opts.format,
 	}
-	cmd := exec.Command("/usr/bin/dot", args...)
+	cmd := exec.CommandContext(ctx, "/usr/bin/dot", args...)
 	cmd.Stdin = r
-	cmd.Stdout = w
+	out := &limitedWriter{w: w, n: maxOutputBytes}
+	cmd.Stdout = out
 	cmd.Stderr = stderr
 
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("exec(%s): %w", cmd.Path, errRenderTimeout)
+		}
+		if out.exceeded {
+			return fmt.Errorf("exec(%s): %w", cmd.Path, errOutputTooLarge)
+		}
 		return fmt.Errorf("exec(%s) failed (%w): %s", cmd.Path, err, stderr.String())
 	}
 
@@ -119,3 +252,20 @@ func createDiagram(w io.Writer, r io.Reader) error {
 
 // [END run_system_package_exec]
 // [END cloudrun_system_package_exec]
+
+// limitedWriter writes to w until n bytes have been written, then fails.
+type limitedWriter struct {
+	w        io.Writer
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		l.exceeded = true
+		return 0, errOutputTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -18,6 +18,7 @@ import (
 	"image"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
@@ -105,6 +106,129 @@ func TestDiagramHandlerImage(t *testing.T) {
 	}
 }
 
+func TestDiagramHandlerRequestErrors(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		label  string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{
+			label:  "method",
+			method: "PUT",
+			target: "/diagram.png",
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			label:  "empty post",
+			method: "POST",
+			target: "/diagram.png",
+			want:   http.StatusBadRequest,
+		},
+		{
+			label:  "large query",
+			method: "GET",
+			target: "/diagram.png?dot=" + strings.Repeat("a", maxInputBytes+1),
+			want:   http.StatusRequestEntityTooLarge,
+		},
+		{
+			label:  "large body",
+			method: "POST",
+			target: "/diagram.png",
+			body:   strings.Repeat("a", maxInputBytes+1),
+			want:   http.StatusRequestEntityTooLarge,
+		},
+		{
+			label:  "format",
+			method: "GET",
+			target: "/diagram.gif?dot=digraph%7B%7D",
+			want:   http.StatusBadRequest,
+		},
+		{
+			label:  "engine",
+			method: "GET",
+			target: "/diagram?engine=mspaint&dot=digraph%7B%7D",
+			want:   http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		rr := httptest.NewRecorder()
+		diagramHandler(rr, req)
+
+		if got := rr.Result().StatusCode; got != test.want {
+			t.Errorf("response (%s) status: got %d, want %d", test.label, got, test.want)
+		}
+		if got := rr.Result().Header.Get("Cache-Control"); got != "" {
+			t.Errorf("response (%s) Cache-Control: got %q, want none", test.label, got)
+		}
+	}
+}
+
+func TestDiagramHandlerFormats(t *testing.T) {
+	checkGraphviz(t)
+
+	const dot = "digraph G { A -> {B, C, D} -> {F} }"
+	tests := []struct {
+		label       string
+		method      string
+		target      string
+		body        string
+		contentType string
+		prefix      string
+	}{
+		{
+			label:       "svg extension",
+			method:      "GET",
+			target:      "/diagram.svg?dot=" + url.QueryEscape(dot),
+			contentType: "image/svg+xml",
+			prefix:      "<?xml",
+		},
+		{
+			label:       "pdf parameter",
+			method:      "GET",
+			target:      "/diagram?format=pdf&engine=neato&dot=" + url.QueryEscape(dot),
+			contentType: "application/pdf",
+			prefix:      "%PDF",
+		},
+		{
+			label:       "post body",
+			method:      "POST",
+			target:      "/diagram.png?engine=circo",
+			body:        dot,
+			contentType: "image/png",
+			prefix:      "\x89PNG",
+		},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		rr := httptest.NewRecorder()
+		diagramHandler(rr, req)
+
+		if got := rr.Result().Header.Get("Content-Type"); got != test.contentType {
+			t.Errorf("response (%s) Content-Type: got %q, want %q", test.label, got, test.contentType)
+		}
+		if !strings.HasPrefix(rr.Body.String(), test.prefix) {
+			t.Errorf("response (%s) body: does not start with %q", test.label, test.prefix)
+		}
+
+		// A repeated request with the ETag is served from the cache.
+		req = httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		req.Header.Set("If-None-Match", rr.Result().Header.Get("ETag"))
+		rr = httptest.NewRecorder()
+		diagramHandler(rr, req)
+		if got := rr.Result().StatusCode; got != http.StatusNotModified {
+			t.Errorf("response (%s) with ETag: got %d, want %d", test.label, got, http.StatusNotModified)
+		}
+	}
+}
+
 func checkGraphviz(t *testing.T) {
 	fileInfo, err := os.Stat("/usr/bin/dot")
 	if err != nil {
@@ -12,11 +12,14 @@ require (
 
 require (
 	cloud.google.com/go v0.110.2 // indirect
+	cloud.google.com/go/artifactregistry v1.14.1 // indirect
+	cloud.google.com/go/cloudbuild v1.10.1 // indirect
 	cloud.google.com/go/compute v1.20.1 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
 	cloud.google.com/go/iam v1.1.0 // indirect
 	cloud.google.com/go/logging v1.7.0 // indirect
 	cloud.google.com/go/longrunning v0.5.0 // indirect
+	cloud.google.com/go/run v1.2.0 // indirect
 	cloud.google.com/go/storage v1.30.1 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
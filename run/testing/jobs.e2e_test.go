@@ -15,7 +15,10 @@
 package cloudruntests
 
 import (
+	"context"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/golang-samples/internal/cloudrunci"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
@@ -39,16 +42,25 @@ func TestCloudRunJobs(t *testing.T) {
 	if err := crj.Create(); err != nil {
 		t.Fatalf("Create %q: %v", crj.Name, err)
 	}
-	if err := crj.Run(); err != nil {
+	e, err := crj.Run()
+	if err != nil {
 		t.Errorf("Run(%s): %s", crj.Name, err)
 	}
+	if e != nil {
+		for _, task := range e.Tasks {
+			t.Logf("Task %d: %s after %d attempt(s) in %v", task.Index, task.Status, task.Attempts, task.Duration())
+		}
+	}
 
-	found, err := crj.LogEntries("", "Completed Task", 5)
-	if err != nil {
-		t.Errorf("LogEntries: %v", err)
+	// Every task logs its completion.
+	want := 1
+	if e != nil && len(e.Tasks) > 0 {
+		want = len(e.Tasks)
 	}
-	if !found {
-		t.Errorf("Failed to find log entries for job")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := crj.WaitForLogs(ctx, "", want, cloudrunci.PayloadMatches(regexp.MustCompile(`Completed Task #\d+`))); err != nil {
+		t.Errorf("WaitForLogs: %v", err)
 	}
 
 	defer crj.Clean()
@@ -15,8 +15,11 @@
 package cloudruntests
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -56,6 +59,68 @@ func TestSigtermHandlerService(t *testing.T) {
 	}
 }
 
+// TestSigtermHandlerLocal runs the sample locally and checks that it shuts
+// down gracefully on SIGTERM.
+func TestSigtermHandlerLocal(t *testing.T) {
+	r, err := testutil.BuildMainDir("../sigterm-handler")
+	if r == nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name      string
+		terminate bool
+	}{
+		{name: "external SIGTERM"},
+		{name: "terminate parameter", terminate: true},
+	}
+	for _, test := range tests {
+		p, err := r.StartServer(ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: StartServer: %v", test.name, err)
+		}
+		// The Runner has no t to stop the server if the test fails early.
+		t.Cleanup(func() { p.Terminate(5 * time.Second) })
+		url := p.URL
+		if test.terminate {
+			url += "/?terminate=1"
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("%s: http.Get: %v", test.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: response status: got %d, want %d (%s)", test.name, resp.StatusCode, http.StatusOK, body)
+		}
+
+		var code int
+		if test.terminate {
+			code, err = p.Wait(ctx)
+		} else {
+			code, err = p.Terminate(15 * time.Second)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if code != 0 {
+			t.Errorf("%s: exit code: got %d, want 0", test.name, code)
+		}
+		for _, want := range []string{"terminated signal caught", "server exited"} {
+			if got := string(p.Stderr()); !strings.Contains(got, want) {
+				t.Errorf("%s: stderr: got %q, want it to contain %q", test.name, got, want)
+			}
+		}
+	}
+}
+
 func GetLogEntries(service *cloudrunci.Service, t *testing.T) {
 	// Create timestamp filters
 	minsAgo := time.Now().Add(-5 * time.Minute)
@@ -63,10 +128,9 @@ func GetLogEntries(service *cloudrunci.Service, t *testing.T) {
 	filter := fmt.Sprintf(`timestamp>="%s" severity="default" NOT protoPayload.serviceName="run.googleapis.com"`, timeFormat)
 
 	find := "terminated signal caught"
-	attempts := 6
-	found, err := service.LogEntries(filter, find, attempts)
-	if err != nil || !found {
-		t.Errorf("%q log entry not found. (%v)", find, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := service.WaitForLogs(ctx, filter, 1, cloudrunci.PayloadContains(find)); err != nil {
+		t.Errorf("%q log entry not found: %v", find, err)
 	}
-	return
 }
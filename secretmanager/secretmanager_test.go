@@ -26,16 +26,18 @@ import (
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
 	"github.com/gofrs/uuid"
+	"google.golang.org/api/option"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 )
 
-func testClient(tb testing.TB) (*secretmanager.Client, context.Context) {
+func testClient(tb testing.TB, opts ...option.ClientOption) (*secretmanager.Client, context.Context) {
 	tb.Helper()
 
 	ctx := context.Background()
-	client, err := secretmanager.NewClient(ctx)
+	client, err := secretmanager.NewClient(ctx, opts...)
 	if err != nil {
 		tb.Fatalf("testClient: failed to create client: %v", err)
 	}
@@ -52,12 +54,12 @@ func testName(tb testing.TB) string {
 	return u.String()
 }
 
-func testSecret(tb testing.TB, projectID string) *secretmanagerpb.Secret {
+func testSecret(tb testing.TB, projectID string, opts ...option.ClientOption) *secretmanagerpb.Secret {
 	tb.Helper()
 
 	secretID := testName(tb)
 
-	client, ctx := testClient(tb)
+	client, ctx := testClient(tb, opts...)
 	secret, err := client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
 		Parent:   fmt.Sprintf("projects/%s", projectID),
 		SecretId: secretID,
@@ -76,10 +78,10 @@ func testSecret(tb testing.TB, projectID string) *secretmanagerpb.Secret {
 	return secret
 }
 
-func testSecretVersion(tb testing.TB, parent string, payload []byte) *secretmanagerpb.SecretVersion {
+func testSecretVersion(tb testing.TB, parent string, payload []byte, opts ...option.ClientOption) *secretmanagerpb.SecretVersion {
 	tb.Helper()
 
-	client, ctx := testClient(tb)
+	client, ctx := testClient(tb, opts...)
 
 	version, err := client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
 		Parent: parent,
@@ -93,10 +95,10 @@ func testSecretVersion(tb testing.TB, parent string, payload []byte) *secretmana
 	return version
 }
 
-func testCleanupSecret(tb testing.TB, name string) {
+func testCleanupSecret(tb testing.TB, name string, opts ...option.ClientOption) {
 	tb.Helper()
 
-	client, ctx := testClient(tb)
+	client, ctx := testClient(tb, opts...)
 
 	if err := client.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{
 		Name: name,
@@ -107,6 +109,16 @@ func testCleanupSecret(tb testing.TB, name string) {
 	}
 }
 
+// testProject returns the project to run a test in, and options for the
+// clients of the test and its samples. Without GOLANG_SAMPLES_PROJECT_ID set,
+// the options connect them to a fake.
+func testProject(t *testing.T) (string, []option.ClientOption) {
+	if os.Getenv("GOLANG_SAMPLES_PROJECT_ID") == "" {
+		return "fake-project", fakes.NewSecretManager(t).ClientOptions()
+	}
+	return testutil.SystemTest(t).ProjectID, nil
+}
+
 func testIamUser(tb testing.TB) string {
 	tb.Helper()
 
@@ -119,16 +131,16 @@ func testIamUser(tb testing.TB) string {
 }
 
 func TestAccessSecretVersion(t *testing.T) {
-	tc := testutil.SystemTest(t)
+	projectID, opts := testProject(t)
 
 	payload := []byte("my-secret")
-	secret := testSecret(t, tc.ProjectID)
-	defer testCleanupSecret(t, secret.Name)
+	secret := testSecret(t, projectID, opts...)
+	defer testCleanupSecret(t, secret.Name, opts...)
 
-	version := testSecretVersion(t, secret.Name, payload)
+	version := testSecretVersion(t, secret.Name, payload, opts...)
 
 	var b bytes.Buffer
-	if err := accessSecretVersion(&b, version.Name); err != nil {
+	if err := accessSecretVersion(&b, version.Name, opts...); err != nil {
 		t.Fatal(err)
 	}
 
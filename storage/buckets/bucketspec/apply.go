@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bucketspec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrNotConfirmed is returned by Apply for plans with irreversible changes
+// that were not confirmed.
+var ErrNotConfirmed = errors.New("plan has irreversible changes that were not confirmed")
+
+// Apply makes the changes of the plan: it creates the bucket if needed,
+// updates its attributes, sets its IAM policy, and locks its retention
+// policy. Plans that lock a retention policy are only applied if
+// confirmLock is set; otherwise nothing is changed and ErrNotConfirmed is
+// returned.
+//
+// The bucket update and IAM policy are conditional on the bucket and policy
+// being as they were when the plan was made, so a stale plan fails rather
+// than overwriting other changes.
+func (p *Plan) Apply(ctx context.Context, client *storage.Client, confirmLock bool) error {
+	if p.lock && !confirmLock {
+		return ErrNotConfirmed
+	}
+	b := client.Bucket(p.Spec.Bucket)
+	if p.Create {
+		attrs := &storage.BucketAttrs{Location: p.Spec.Location, StorageClass: p.Spec.StorageClass}
+		if err := b.Create(ctx, p.Spec.Project, attrs); err != nil {
+			return fmt.Errorf("Bucket(%q).Create: %w", p.Spec.Bucket, err)
+		}
+		// Plan the rest against the new bucket, which has server defaults.
+		next, err := MakePlan(ctx, client, p.Spec)
+		if err != nil {
+			return err
+		}
+		return next.Apply(ctx, client, confirmLock)
+	}
+
+	metageneration := p.metageneration
+	if p.updated {
+		attrs, err := b.If(storage.BucketConditions{MetagenerationMatch: metageneration}).Update(ctx, p.update)
+		if err != nil {
+			return fmt.Errorf("Bucket(%q).Update: %w", p.Spec.Bucket, err)
+		}
+		metageneration = attrs.MetaGeneration
+	}
+	if p.policy != nil {
+		if err := b.IAM().V3().SetPolicy(ctx, p.policy); err != nil {
+			return fmt.Errorf("Bucket(%q).IAM().V3().SetPolicy: %w", p.Spec.Bucket, err)
+		}
+	}
+	if p.lock {
+		if err := b.If(storage.BucketConditions{MetagenerationMatch: metageneration}).LockRetentionPolicy(ctx); err != nil {
+			return fmt.Errorf("Bucket(%q).LockRetentionPolicy: %w", p.Spec.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// Export returns the spec of an existing bucket, including its IAM policy.
+func Export(ctx context.Context, client *storage.Client, bucket string) (*Spec, error) {
+	b := client.Bucket(bucket)
+	attrs, err := b.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%q).Attrs: %w", bucket, err)
+	}
+	policy, err := b.IAM().V3().Policy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%q).IAM().V3().Policy: %w", bucket, err)
+	}
+	return Current(attrs, policy), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command bucketctl configures Cloud Storage buckets from spec files; see
+// package bucketspec for the format.
+//
+//	bucketctl plan spec.yaml...
+//	bucketctl apply [-confirm-lock bucket] spec.yaml...
+//	bucketctl export bucket
+//
+// plan prints the changes that would bring each bucket to its spec, and
+// apply makes them. Locking a retention policy is permanent, so apply only
+// does it if the bucket is named with -confirm-lock. export prints the spec
+// of an existing bucket.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/storage/buckets/bucketspec"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  bucketctl plan spec.yaml...
+  bucketctl apply [-confirm-lock bucket] spec.yaml...
+  bucketctl export bucket`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("bucketctl: ")
+	if len(os.Args) < 3 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	confirmLock := fs.String("confirm-lock", "", "Allow locking the retention policy of `bucket`.")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() == 0 {
+		usage()
+	}
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	switch cmd {
+	case "plan", "apply":
+		failed := false
+		for _, path := range fs.Args() {
+			if err := run(ctx, client, path, cmd == "apply", *confirmLock); err != nil {
+				log.Print(err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "export":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		spec, err := bucketspec.Export(ctx, client, fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := spec.Marshal()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+	default:
+		usage()
+	}
+}
+
+func run(ctx context.Context, client *storage.Client, path string, apply bool, confirmLock string) error {
+	spec, err := bucketspec.ReadSpec(path)
+	if err != nil {
+		return err
+	}
+	plan, err := bucketspec.MakePlan(ctx, client, spec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Print(plan)
+	if !apply || plan.Empty() {
+		return nil
+	}
+	err = plan.Apply(ctx, client, confirmLock == spec.Bucket)
+	if errors.Is(err, bucketspec.ErrNotConfirmed) {
+		return fmt.Errorf("%s: not applied: locking the retention policy of %s is permanent; run again with -confirm-lock %s", path, spec.Bucket, spec.Bucket)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("Bucket %s updated.\n", spec.Bucket)
+	return nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bucketspec
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+)
+
+func TestParseSpec(t *testing.T) {
+	s, err := ParseSpec([]byte(`
+bucket: b
+labels: {env: prod}
+versioning: true
+cors:
+- origins: ["*"]
+  methods: [GET]
+  maxAge: 1h
+retention:
+  period: 30d
+lifecycle:
+- action: Delete
+  condition: {age: 30, isLive: false}
+`))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	if got, want := time.Duration(s.Retention.Period), 30*24*time.Hour; got != want {
+		t.Errorf("ParseSpec: got retention %v, want %v", got, want)
+	}
+	if got, want := time.Duration(s.CORS[0].MaxAge), time.Hour; got != want {
+		t.Errorf("ParseSpec: got maxAge %v, want %v", got, want)
+	}
+	if s.Versioning == nil || !*s.Versioning || s.RequesterPays != nil {
+		t.Errorf("ParseSpec: got versioning %v and requesterPays %v, want true and unset", s.Versioning, s.RequesterPays)
+	}
+
+	s, err = ParseSpec([]byte(`{"bucket": "b", "cors": [], "retention": {"period": 60}}`))
+	if err != nil {
+		t.Fatalf("ParseSpec(JSON): %v", err)
+	}
+	if s.CORS == nil || len(s.CORS) != 0 || s.Lifecycle != nil || time.Duration(s.Retention.Period) != time.Minute {
+		t.Errorf("ParseSpec(JSON): got cors %#v, lifecycle %#v and retention %v, want empty, unset and 1m", s.CORS, s.Lifecycle, s.Retention.Period)
+	}
+
+	for _, bad := range []string{
+		`labels: {a: b}`,
+		`{bucket: b, versioning: true, versionning: false}`,
+		`{bucket: b, publicAccessPrevention: on}`,
+		`{bucket: b, rpo: FAST}`,
+		`{bucket: b, retention: {period: 1 week}}`,
+		`{bucket: b, retention: {locked: true}}`,
+		`{bucket: b, lifecycle: [{condition: {age: 1}}]}`,
+		`{bucket: b, lifecycle: [{action: Delete, condition: {createdBefore: yesterday}}]}`,
+		`{bucket: b, iam: [{role: r, members: [a]}, {role: r, members: [b]}]}`,
+	} {
+		if _, err := ParseSpec([]byte(bad)); err == nil {
+			t.Errorf("ParseSpec(%s): got nil error, want error", bad)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	attrs := &storage.BucketAttrs{
+		Name:                     "b",
+		Location:                 "US",
+		StorageClass:             "STANDARD",
+		Labels:                   map[string]string{"env": "dev", "team": "x"},
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: true},
+		CORS:                     []storage.CORS{{Origins: []string{"*"}, Methods: []string{"GET"}}},
+		RetentionPolicy:          &storage.RetentionPolicy{RetentionPeriod: time.Hour, IsLocked: true},
+	}
+	policy := &iam.Policy3{Bindings: []*iampb.Binding{
+		{Role: "roles/storage.legacyBucketOwner", Members: []string{"projectOwner:p"}},
+		{Role: "roles/storage.objectViewer", Members: []string{"user:b@example.com", "user:a@example.com"}},
+	}}
+	yes := true
+	spec := &Spec{
+		Bucket:                   "b",
+		Labels:                   map[string]string{"env": "prod", "cost": "1"},
+		UniformBucketLevelAccess: &yes,
+		Versioning:               &yes,
+		PublicAccessPrevention:   "enforced",
+		CORS:                     []CORS{},
+		Retention:                &Retention{Period: Duration(2 * time.Hour), Locked: true},
+		IAM: []Binding{
+			{Role: "roles/storage.objectViewer", Members: []string{"user:a@example.com", "user:b@example.com"}},
+			{Role: "roles/storage.objectAdmin", Members: []string{"group:admins@example.com"}},
+		},
+	}
+	p, err := Diff(spec, attrs, policy)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := []Change{
+		{Field: "labels.cost", New: "1"},
+		{Field: "labels.env", Old: "dev", New: "prod"},
+		{Field: "labels.team", Old: "x"},
+		{Field: "versioning", Old: "false", New: "true"},
+		{Field: "publicAccessPrevention", Old: "inherited", New: "enforced"},
+		{Field: "cors", Old: `[{"origins":["*"],"methods":["GET"]}]`},
+		{Field: "retention.period", Old: "1h0m0s", New: "2h0m0s"},
+		{Field: "iam[roles/storage.objectAdmin]", New: `["group:admins@example.com"]`},
+	}
+	if !reflect.DeepEqual(p.Changes, want) {
+		t.Errorf("Diff: got changes\n%v\nwant\n%v", p.Changes, want)
+	}
+	if p.NeedsConfirmation() {
+		t.Errorf("Diff: plan needs confirmation, but the policy is already locked")
+	}
+	if got := len(p.policy.Bindings); got != 3 {
+		t.Errorf("Diff: got %d bindings, want 3", got)
+	}
+
+	for _, r := range []*Retention{
+		{},
+		{Period: Duration(time.Minute), Locked: true},
+		{Period: Duration(time.Hour)},
+	} {
+		if _, err := Diff(&Spec{Bucket: "b", Retention: r}, attrs, nil); err == nil {
+			t.Errorf("Diff(retention %+v) of locked policy: got nil error, want error", r)
+		}
+	}
+	if _, err := Diff(&Spec{Bucket: "b", Location: "EU"}, attrs, nil); err == nil {
+		t.Errorf("Diff(location EU): got nil error, want error")
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	fake := fakes.NewStorage(t)
+	client, err := storage.NewClient(ctx, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	apply := func(src string, confirm bool) (*Plan, error) {
+		t.Helper()
+		spec, err := ParseSpec([]byte(src))
+		if err != nil {
+			t.Fatalf("ParseSpec: %v", err)
+		}
+		p, err := MakePlan(ctx, client, spec)
+		if err != nil {
+			t.Fatalf("MakePlan: %v", err)
+		}
+		if err := p.Apply(ctx, client, confirm); err != nil {
+			return p, err
+		}
+		again, err := MakePlan(ctx, client, spec)
+		if err != nil {
+			t.Fatalf("MakePlan after Apply: %v", err)
+		}
+		if !again.Empty() {
+			t.Errorf("MakePlan after Apply: got changes\n%v", again)
+		}
+		return p, nil
+	}
+
+	const spec = `
+bucket: b
+project: p
+location: US
+labels: {env: prod}
+uniformBucketLevelAccess: true
+publicAccessPrevention: enforced
+defaultKMSKey: projects/p/locations/us/keyRings/r/cryptoKeys/k
+cors:
+- origins: [https://example.com]
+  methods: [GET, PUT]
+  maxAge: 10m
+lifecycle:
+- action: SetStorageClass
+  storageClass: NEARLINE
+  condition: {age: 30, matchesPrefix: [logs/]}
+website: {mainPageSuffix: index.html}
+iam:
+- role: roles/storage.objectViewer
+  members: [allUsers]
+`
+	p, err := apply(spec, false)
+	if err != nil {
+		t.Fatalf("Apply(create): %v", err)
+	}
+	if !p.Create {
+		t.Errorf("Apply: bucket was not created")
+	}
+	attrs, err := client.Bucket("b").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs: %v", err)
+	}
+	if attrs.Labels["env"] != "prod" || attrs.Encryption == nil || len(attrs.Lifecycle.Rules) != 1 || attrs.Website.MainPageSuffix != "index.html" {
+		t.Errorf("Apply: got attrs %+v, want spec applied", attrs)
+	}
+
+	// Removing settings, and leaving others unmanaged.
+	if _, err := apply(`
+bucket: b
+labels: {}
+defaultKMSKey: ""
+cors: []
+lifecycle: []
+iam:
+- role: roles/storage.objectViewer
+  members: []
+`, false); err != nil {
+		t.Fatalf("Apply(remove): %v", err)
+	}
+	exported, err := Export(ctx, client, "b")
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(exported.Labels) != 0 || *exported.DefaultKMSKey != "" || len(exported.CORS) != 0 || len(exported.IAM) != 0 ||
+		exported.PublicAccessPrevention != "enforced" || exported.Website.MainPageSuffix != "index.html" {
+		t.Errorf("Apply(remove): got spec %+v", exported)
+	}
+
+	// The exported spec round trips.
+	out, err := exported.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if _, err := apply(string(out), false); err != nil {
+		t.Fatalf("Apply(exported): %v", err)
+	}
+
+	// Locking needs confirmation, and nothing changes without it.
+	const locked = `{bucket: b, labels: {locked: "yes"}, retention: {period: 1d, locked: true}}`
+	if _, err := apply(locked, false); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("Apply(lock) without confirmation: got %v, want %v", err, ErrNotConfirmed)
+	}
+	if attrs, _ := client.Bucket("b").Attrs(ctx); attrs.RetentionPolicy != nil || attrs.Labels["locked"] != "" {
+		t.Errorf("Apply(lock) without confirmation changed the bucket")
+	}
+	if _, err := apply(locked, true); err != nil {
+		t.Fatalf("Apply(lock): %v", err)
+	}
+	if attrs, _ := client.Bucket("b").Attrs(ctx); attrs.RetentionPolicy == nil || !attrs.RetentionPolicy.IsLocked {
+		t.Errorf("Apply(lock): got retention policy %+v, want locked", attrs.RetentionPolicy)
+	}
+
+	// A stale plan fails.
+	s, _ := ParseSpec([]byte(`{bucket: b, versioning: true}`))
+	p, err = MakePlan(ctx, client, s)
+	if err != nil {
+		t.Fatalf("MakePlan: %v", err)
+	}
+	if _, err := client.Bucket("b").Update(ctx, storage.BucketAttrsToUpdate{RequesterPays: true}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := p.Apply(ctx, client, false); err == nil {
+		t.Errorf("Apply(stale plan): got nil error, want error")
+	}
+}
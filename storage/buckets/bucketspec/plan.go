@@ -0,0 +1,351 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bucketspec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+)
+
+// Change is a difference between a spec and a bucket. Old is empty for
+// settings added and New for settings removed.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+	// Irreversible marks changes that cannot be undone, which Apply makes
+	// only when confirmed.
+	Irreversible bool
+}
+
+func (c Change) String() string {
+	s := ""
+	switch {
+	case c.Old == "":
+		s = fmt.Sprintf("+ %s: %s", c.Field, c.New)
+	case c.New == "":
+		s = fmt.Sprintf("- %s: %s", c.Field, c.Old)
+	default:
+		s = fmt.Sprintf("~ %s: %s -> %s", c.Field, c.Old, c.New)
+	}
+	if c.Irreversible {
+		s += " (IRREVERSIBLE)"
+	}
+	return s
+}
+
+// Plan is the set of changes that bring a bucket to its spec.
+type Plan struct {
+	Spec *Spec
+	// Create is set if the bucket does not exist.
+	Create  bool
+	Changes []Change
+
+	update         storage.BucketAttrsToUpdate
+	updated        bool
+	metageneration int64
+	// policy is the new IAM policy, or nil if it is unchanged.
+	policy *iam.Policy3
+	lock   bool
+}
+
+// Empty reports whether there is nothing to do.
+func (p *Plan) Empty() bool {
+	return !p.Create && len(p.Changes) == 0
+}
+
+// NeedsConfirmation reports whether the plan makes irreversible changes.
+func (p *Plan) NeedsConfirmation() bool {
+	return p.lock
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	switch {
+	case p.Create:
+		fmt.Fprintf(&b, "Bucket %s will be created in %s.\n", p.Spec.Bucket, p.Spec.Location)
+	case p.Empty():
+		fmt.Fprintf(&b, "Bucket %s is up to date.\n", p.Spec.Bucket)
+		return b.String()
+	default:
+		fmt.Fprintf(&b, "Bucket %s will be updated.\n", p.Spec.Bucket)
+	}
+	for _, c := range p.Changes {
+		fmt.Fprintf(&b, "  %s\n", c)
+	}
+	return b.String()
+}
+
+// MakePlan compares the spec with its bucket.
+func MakePlan(ctx context.Context, client *storage.Client, spec *Spec) (*Plan, error) {
+	b := client.Bucket(spec.Bucket)
+	attrs, err := b.Attrs(ctx)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		if spec.Project == "" || spec.Location == "" {
+			return nil, fmt.Errorf("bucket %s does not exist, and project and location are needed to create it", spec.Bucket)
+		}
+		p, err := Diff(spec, &storage.BucketAttrs{Name: spec.Bucket, Location: spec.Location}, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.Create = true
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%q).Attrs: %w", spec.Bucket, err)
+	}
+	var policy *iam.Policy3
+	if spec.IAM != nil {
+		if policy, err = b.IAM().V3().Policy(ctx); err != nil {
+			return nil, fmt.Errorf("Bucket(%q).IAM().V3().Policy: %w", spec.Bucket, err)
+		}
+	}
+	return Diff(spec, attrs, policy)
+}
+
+// Diff compares the spec with a bucket's attributes and IAM policy. The
+// policy is only needed if the spec has IAM bindings. It returns an error
+// if the spec cannot be applied, such as one shortening a locked retention
+// policy.
+func Diff(spec *Spec, attrs *storage.BucketAttrs, policy *iam.Policy3) (*Plan, error) {
+	cur := Current(attrs, policy)
+	p := &Plan{Spec: spec, metageneration: attrs.MetaGeneration}
+	ua := &p.update
+	change := func(field string, old, new interface{}) bool {
+		o, n := render(old), render(new)
+		if o == n {
+			return false
+		}
+		p.Changes = append(p.Changes, Change{Field: field, Old: o, New: n})
+		p.updated = true
+		return true
+	}
+
+	if spec.Location != "" && !strings.EqualFold(spec.Location, cur.Location) {
+		return nil, fmt.Errorf("location: bucket is in %s, not %s; buckets cannot be moved", cur.Location, spec.Location)
+	}
+	if spec.StorageClass != "" && change("storageClass", cur.StorageClass, spec.StorageClass) {
+		ua.StorageClass = spec.StorageClass
+	}
+	if spec.Labels != nil {
+		keys := make(map[string]bool)
+		for k := range spec.Labels {
+			keys[k] = true
+		}
+		for k := range cur.Labels {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			old, ok := cur.Labels[k]
+			new, want := spec.Labels[k]
+			switch {
+			case !want:
+				change("labels."+k, old, "")
+				ua.DeleteLabel(k)
+			case !ok || old != new:
+				change("labels."+k, old, new)
+				ua.SetLabel(k, new)
+			}
+		}
+	}
+	if spec.Versioning != nil && change("versioning", *cur.Versioning, *spec.Versioning) {
+		ua.VersioningEnabled = *spec.Versioning
+	}
+	if spec.RequesterPays != nil && change("requesterPays", *cur.RequesterPays, *spec.RequesterPays) {
+		ua.RequesterPays = *spec.RequesterPays
+	}
+	if spec.DefaultEventBasedHold != nil && change("defaultEventBasedHold", *cur.DefaultEventBasedHold, *spec.DefaultEventBasedHold) {
+		ua.DefaultEventBasedHold = *spec.DefaultEventBasedHold
+	}
+	if spec.UniformBucketLevelAccess != nil && change("uniformBucketLevelAccess", *cur.UniformBucketLevelAccess, *spec.UniformBucketLevelAccess) {
+		ua.UniformBucketLevelAccess = &storage.UniformBucketLevelAccess{Enabled: *spec.UniformBucketLevelAccess}
+	}
+	if spec.PublicAccessPrevention != "" && change("publicAccessPrevention", cur.PublicAccessPrevention, spec.PublicAccessPrevention) {
+		ua.PublicAccessPrevention = storage.PublicAccessPreventionInherited
+		if spec.PublicAccessPrevention == "enforced" {
+			ua.PublicAccessPrevention = storage.PublicAccessPreventionEnforced
+		}
+	}
+	if spec.DefaultKMSKey != nil && change("defaultKMSKey", *cur.DefaultKMSKey, *spec.DefaultKMSKey) {
+		ua.Encryption = &storage.BucketEncryption{DefaultKMSKeyName: *spec.DefaultKMSKey}
+	}
+	if spec.RPO != "" && change("rpo", cur.RPO, spec.RPO) {
+		ua.RPO = storage.RPODefault
+		if spec.RPO == "ASYNC_TURBO" {
+			ua.RPO = storage.RPOAsyncTurbo
+		}
+	}
+	if spec.Autoclass != nil && change("autoclass", cur.Autoclass, spec.Autoclass) {
+		ua.Autoclass = &storage.Autoclass{Enabled: spec.Autoclass.Enabled, TerminalStorageClass: spec.Autoclass.TerminalStorageClass}
+	}
+	if spec.CORS != nil && change("cors", cur.CORS, spec.CORS) {
+		ua.CORS = []storage.CORS{}
+		for _, c := range spec.CORS {
+			ua.CORS = append(ua.CORS, storage.CORS{
+				Origins:         c.Origins,
+				Methods:         c.Methods,
+				ResponseHeaders: c.ResponseHeaders,
+				MaxAge:          c.MaxAge.duration(),
+			})
+		}
+	}
+	if spec.Lifecycle != nil && change("lifecycle", cur.Lifecycle, spec.Lifecycle) {
+		ua.Lifecycle = &storage.Lifecycle{}
+		for _, r := range spec.Lifecycle {
+			ua.Lifecycle.Rules = append(ua.Lifecycle.Rules, toLifecycleRule(r))
+		}
+	}
+	if spec.Website != nil && change("website", cur.Website, spec.Website) {
+		ua.Website = &storage.BucketWebsite{MainPageSuffix: spec.Website.MainPageSuffix, NotFoundPage: spec.Website.NotFoundPage}
+	}
+	if spec.Retention != nil {
+		if err := p.diffRetention(cur.Retention, spec.Retention); err != nil {
+			return nil, err
+		}
+	}
+	if spec.IAM != nil {
+		p.diffIAM(policy, spec.IAM)
+	}
+	return p, nil
+}
+
+func (p *Plan) diffRetention(cur, spec *Retention) error {
+	if cur.Locked {
+		switch {
+		case spec.Period <= 0:
+			return errors.New("retention: the policy is locked and cannot be removed")
+		case spec.Period < cur.Period:
+			return fmt.Errorf("retention: the policy is locked and cannot be shortened from %v to %v", cur.Period, spec.Period)
+		case !spec.Locked:
+			return errors.New("retention: the policy is locked and cannot be unlocked")
+		}
+	}
+	if spec.Period != cur.Period {
+		old, new := "", ""
+		if cur.Period > 0 {
+			old = cur.Period.String()
+		}
+		if spec.Period > 0 {
+			new = spec.Period.String()
+		}
+		p.Changes = append(p.Changes, Change{Field: "retention.period", Old: old, New: new})
+		p.update.RetentionPolicy = &storage.RetentionPolicy{RetentionPeriod: spec.Period.duration()}
+		p.updated = true
+	}
+	if spec.Locked && !cur.Locked {
+		p.Changes = append(p.Changes, Change{Field: "retention.locked", Old: "false", New: "true", Irreversible: true})
+		p.lock = true
+	}
+	return nil
+}
+
+// diffIAM sets the members of each role in bindings, keeping the bindings
+// of other roles.
+func (p *Plan) diffIAM(policy *iam.Policy3, bindings []Binding) {
+	if policy == nil {
+		policy = &iam.Policy3{}
+	}
+	want := make(map[string]Binding)
+	for _, b := range bindings {
+		b.Members = sortedMembers(b.Members)
+		want[bindingKey(b.Role, b.Condition)] = b
+	}
+	var changed bool
+	var out []*iampb.Binding
+	found := make(map[string]bool)
+	for _, pb := range policy.Bindings {
+		var c *Condition
+		if pb.Condition != nil {
+			c = &Condition{Title: pb.Condition.Title, Description: pb.Condition.Description, Expression: pb.Condition.Expression}
+		}
+		key := bindingKey(pb.Role, c)
+		b, ok := want[key]
+		if !ok {
+			out = append(out, pb)
+			continue
+		}
+		found[key] = true
+		old := sortedMembers(pb.Members)
+		if !reflect.DeepEqual(old, b.Members) || !reflect.DeepEqual(c, b.Condition) {
+			p.Changes = append(p.Changes, Change{Field: "iam[" + key + "]", Old: render(old), New: render(b.Members)})
+			changed = true
+		}
+		if len(b.Members) > 0 {
+			out = append(out, toBinding(b))
+		}
+	}
+	for _, b := range bindings {
+		key := bindingKey(b.Role, b.Condition)
+		if found[key] || len(b.Members) == 0 {
+			continue
+		}
+		b.Members = sortedMembers(b.Members)
+		p.Changes = append(p.Changes, Change{Field: "iam[" + key + "]", New: render(b.Members)})
+		out = append(out, toBinding(b))
+		changed = true
+	}
+	if changed {
+		policy.Bindings = out
+		p.policy = policy
+	}
+}
+
+// render formats a setting for a Change; empty values render as "".
+func render(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return fmt.Sprint(v)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Len() == 0 {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if s := string(b); s != "{}" && s != "null" {
+		return s
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedMembers(members []string) []string {
+	out := append([]string{}, members...)
+	sort.Strings(out)
+	return out
+}
+
+func (d Duration) duration() time.Duration { return time.Duration(d) }
@@ -0,0 +1,409 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bucketspec configures Cloud Storage buckets declaratively. A Spec
+// describes the wanted configuration of a bucket; Diff compares it with the
+// bucket and returns a Plan of the changes, which Apply makes with a single
+// bucket update and, if needed, an IAM policy update and a retention policy
+// lock.
+//
+// Fields left out of a spec are not managed: the bucket keeps whatever it
+// has. To remove a setting, give its empty value, such as cors: [] or
+// defaultKMSKey: "".
+package bucketspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+	"google.golang.org/genproto/googleapis/type/expr"
+	"gopkg.in/yaml.v2"
+)
+
+// Spec is the configuration of a bucket, read from YAML or JSON:
+//
+//	bucket: my-bucket
+//	project: my-project   # Only used to create the bucket.
+//	location: US
+//	labels:
+//	  env: prod
+//	uniformBucketLevelAccess: true
+//	publicAccessPrevention: enforced
+//	lifecycle:
+//	- action: Delete
+//	  condition:
+//	    age: 365
+//	retention:
+//	  period: 30d
+//	iam:
+//	- role: roles/storage.objectViewer
+//	  members: [group:readers@example.com]
+type Spec struct {
+	Bucket   string `yaml:"bucket" json:"bucket"`
+	Project  string `yaml:"project,omitempty" json:"project,omitempty"`
+	Location string `yaml:"location,omitempty" json:"location,omitempty"`
+
+	StorageClass string `yaml:"storageClass,omitempty" json:"storageClass,omitempty"`
+	// Labels are the complete set of labels: labels not listed are
+	// removed.
+	Labels                   map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Versioning               *bool             `yaml:"versioning,omitempty" json:"versioning,omitempty"`
+	RequesterPays            *bool             `yaml:"requesterPays,omitempty" json:"requesterPays,omitempty"`
+	DefaultEventBasedHold    *bool             `yaml:"defaultEventBasedHold,omitempty" json:"defaultEventBasedHold,omitempty"`
+	UniformBucketLevelAccess *bool             `yaml:"uniformBucketLevelAccess,omitempty" json:"uniformBucketLevelAccess,omitempty"`
+	// PublicAccessPrevention is "enforced" or "inherited".
+	PublicAccessPrevention string `yaml:"publicAccessPrevention,omitempty" json:"publicAccessPrevention,omitempty"`
+	// DefaultKMSKey is the name of the Cloud KMS key new objects are
+	// encrypted with by default, or empty for Google-managed keys.
+	DefaultKMSKey *string `yaml:"defaultKMSKey,omitempty" json:"defaultKMSKey,omitempty"`
+	// RPO is the recovery point objective of dual-region buckets: DEFAULT
+	// or ASYNC_TURBO.
+	RPO       string          `yaml:"rpo,omitempty" json:"rpo,omitempty"`
+	Autoclass *Autoclass      `yaml:"autoclass,omitempty" json:"autoclass,omitempty"`
+	CORS      []CORS          `yaml:"cors,omitempty" json:"cors,omitempty"`
+	Lifecycle []LifecycleRule `yaml:"lifecycle,omitempty" json:"lifecycle,omitempty"`
+	Retention *Retention      `yaml:"retention,omitempty" json:"retention,omitempty"`
+	Website   *Website        `yaml:"website,omitempty" json:"website,omitempty"`
+	// IAM sets the members of each role and condition listed. Bindings of
+	// other roles are kept; a binding without members is removed.
+	IAM []Binding `yaml:"iam,omitempty" json:"iam,omitempty"`
+}
+
+// Autoclass configures automatic storage class transitions.
+type Autoclass struct {
+	Enabled              bool   `yaml:"enabled" json:"enabled"`
+	TerminalStorageClass string `yaml:"terminalStorageClass,omitempty" json:"terminalStorageClass,omitempty"`
+}
+
+// CORS is a cross-origin resource sharing rule.
+type CORS struct {
+	Origins         []string `yaml:"origins,omitempty" json:"origins,omitempty"`
+	Methods         []string `yaml:"methods,omitempty" json:"methods,omitempty"`
+	ResponseHeaders []string `yaml:"responseHeaders,omitempty" json:"responseHeaders,omitempty"`
+	MaxAge          Duration `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// LifecycleRule is an object lifecycle management rule.
+type LifecycleRule struct {
+	// Action is Delete, SetStorageClass or AbortIncompleteMultipartUpload.
+	Action       string             `yaml:"action" json:"action"`
+	StorageClass string             `yaml:"storageClass,omitempty" json:"storageClass,omitempty"`
+	Condition    LifecycleCondition `yaml:"condition" json:"condition"`
+}
+
+// LifecycleCondition selects the objects a lifecycle rule applies to.
+type LifecycleCondition struct {
+	Age int64 `yaml:"age,omitempty" json:"age,omitempty"`
+	// CreatedBefore is a date, such as 2024-01-31.
+	CreatedBefore           string   `yaml:"createdBefore,omitempty" json:"createdBefore,omitempty"`
+	IsLive                  *bool    `yaml:"isLive,omitempty" json:"isLive,omitempty"`
+	NumNewerVersions        int64    `yaml:"numNewerVersions,omitempty" json:"numNewerVersions,omitempty"`
+	DaysSinceNoncurrentTime int64    `yaml:"daysSinceNoncurrentTime,omitempty" json:"daysSinceNoncurrentTime,omitempty"`
+	MatchesStorageClasses   []string `yaml:"matchesStorageClass,omitempty" json:"matchesStorageClass,omitempty"`
+	MatchesPrefix           []string `yaml:"matchesPrefix,omitempty" json:"matchesPrefix,omitempty"`
+	MatchesSuffix           []string `yaml:"matchesSuffix,omitempty" json:"matchesSuffix,omitempty"`
+}
+
+// Retention is a retention policy. A zero Period removes the policy.
+// Locking is permanent: a locked policy cannot be removed or shortened,
+// and the bucket cannot be deleted until all objects have been retained
+// for the period.
+type Retention struct {
+	Period Duration `yaml:"period" json:"period"`
+	Locked bool     `yaml:"locked,omitempty" json:"locked,omitempty"`
+}
+
+// Website configures static website serving. Empty fields remove it.
+type Website struct {
+	MainPageSuffix string `yaml:"mainPageSuffix,omitempty" json:"mainPageSuffix,omitempty"`
+	NotFoundPage   string `yaml:"notFoundPage,omitempty" json:"notFoundPage,omitempty"`
+}
+
+// Binding grants a role to members, optionally under a condition.
+type Binding struct {
+	Role      string     `yaml:"role" json:"role"`
+	Members   []string   `yaml:"members" json:"members"`
+	Condition *Condition `yaml:"condition,omitempty" json:"condition,omitempty"`
+}
+
+// Condition is an IAM condition.
+type Condition struct {
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Expression  string `yaml:"expression" json:"expression"`
+}
+
+// Duration is a time.Duration written as a string, such as "1h30m", with a
+// "d" suffix for whole days, or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var secs int64
+	if err := unmarshal(&secs); err == nil {
+		*d = Duration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d Duration) String() string {
+	if v := time.Duration(d); v != 0 && v%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", v/(24*time.Hour))
+	}
+	return time.Duration(d).String()
+}
+
+// ParseSpec parses a YAML or JSON spec. Unknown fields are errors.
+func ParseSpec(data []byte) (*Spec, error) {
+	var s Spec
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReadSpec reads a YAML or JSON spec file.
+func ReadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Marshal returns the spec as YAML.
+func (s *Spec) Marshal() ([]byte, error) {
+	return yaml.Marshal(s)
+}
+
+func (s *Spec) validate() error {
+	if s.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	switch s.PublicAccessPrevention {
+	case "", "enforced", "inherited":
+	default:
+		return fmt.Errorf("publicAccessPrevention: got %q, want enforced or inherited", s.PublicAccessPrevention)
+	}
+	switch s.RPO {
+	case "", "DEFAULT", "ASYNC_TURBO":
+	default:
+		return fmt.Errorf("rpo: got %q, want DEFAULT or ASYNC_TURBO", s.RPO)
+	}
+	for i, r := range s.Lifecycle {
+		if r.Action == "" {
+			return fmt.Errorf("lifecycle[%d]: action is required", i)
+		}
+		if r.Condition.CreatedBefore != "" {
+			if _, err := time.Parse("2006-01-02", r.Condition.CreatedBefore); err != nil {
+				return fmt.Errorf("lifecycle[%d]: createdBefore: %w", i, err)
+			}
+		}
+	}
+	if s.Retention != nil && s.Retention.Locked && s.Retention.Period <= 0 {
+		return errors.New("retention: a locked policy needs a period")
+	}
+	seen := make(map[string]bool)
+	for i, b := range s.IAM {
+		if b.Role == "" {
+			return fmt.Errorf("iam[%d]: role is required", i)
+		}
+		k := bindingKey(b.Role, b.Condition)
+		if seen[k] {
+			return fmt.Errorf("iam[%d]: %s is listed twice", i, k)
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// Current returns the full spec of a bucket with the given attributes and
+// IAM policy, which may be nil.
+func Current(attrs *storage.BucketAttrs, policy *iam.Policy3) *Spec {
+	s := &Spec{
+		Bucket:                   attrs.Name,
+		Location:                 attrs.Location,
+		StorageClass:             attrs.StorageClass,
+		Labels:                   attrs.Labels,
+		Versioning:               boolPtr(attrs.VersioningEnabled),
+		RequesterPays:            boolPtr(attrs.RequesterPays),
+		DefaultEventBasedHold:    boolPtr(attrs.DefaultEventBasedHold),
+		UniformBucketLevelAccess: boolPtr(attrs.UniformBucketLevelAccess.Enabled),
+		PublicAccessPrevention:   "inherited",
+		RPO:                      "DEFAULT",
+		DefaultKMSKey:            new(string),
+		Website:                  &Website{},
+		Retention:                &Retention{},
+	}
+	if s.Labels == nil {
+		s.Labels = map[string]string{}
+	}
+	if attrs.PublicAccessPrevention == storage.PublicAccessPreventionEnforced {
+		s.PublicAccessPrevention = "enforced"
+	}
+	if attrs.RPO == storage.RPOAsyncTurbo {
+		s.RPO = "ASYNC_TURBO"
+	}
+	if attrs.Encryption != nil {
+		*s.DefaultKMSKey = attrs.Encryption.DefaultKMSKeyName
+	}
+	s.Autoclass = &Autoclass{}
+	if ac := attrs.Autoclass; ac != nil {
+		s.Autoclass = &Autoclass{Enabled: ac.Enabled, TerminalStorageClass: ac.TerminalStorageClass}
+	}
+	s.CORS = []CORS{}
+	for _, c := range attrs.CORS {
+		s.CORS = append(s.CORS, CORS{
+			Origins:         c.Origins,
+			Methods:         c.Methods,
+			ResponseHeaders: c.ResponseHeaders,
+			MaxAge:          Duration(c.MaxAge),
+		})
+	}
+	s.Lifecycle = []LifecycleRule{}
+	for _, r := range attrs.Lifecycle.Rules {
+		s.Lifecycle = append(s.Lifecycle, fromLifecycleRule(r))
+	}
+	if rp := attrs.RetentionPolicy; rp != nil {
+		s.Retention = &Retention{Period: Duration(rp.RetentionPeriod), Locked: rp.IsLocked}
+	}
+	if w := attrs.Website; w != nil {
+		s.Website = &Website{MainPageSuffix: w.MainPageSuffix, NotFoundPage: w.NotFoundPage}
+	}
+	if policy != nil {
+		for _, b := range policy.Bindings {
+			sb := Binding{Role: b.Role, Members: append([]string(nil), b.Members...)}
+			sort.Strings(sb.Members)
+			if c := b.Condition; c != nil {
+				sb.Condition = &Condition{Title: c.Title, Description: c.Description, Expression: c.Expression}
+			}
+			s.IAM = append(s.IAM, sb)
+		}
+	}
+	return s
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func fromLifecycleRule(r storage.LifecycleRule) LifecycleRule {
+	c := r.Condition
+	lr := LifecycleRule{
+		Action:       r.Action.Type,
+		StorageClass: r.Action.StorageClass,
+		Condition: LifecycleCondition{
+			Age:                     c.AgeInDays,
+			NumNewerVersions:        c.NumNewerVersions,
+			DaysSinceNoncurrentTime: c.DaysSinceNoncurrentTime,
+			MatchesStorageClasses:   c.MatchesStorageClasses,
+			MatchesPrefix:           c.MatchesPrefix,
+			MatchesSuffix:           c.MatchesSuffix,
+		},
+	}
+	if !c.CreatedBefore.IsZero() {
+		lr.Condition.CreatedBefore = c.CreatedBefore.Format("2006-01-02")
+	}
+	switch c.Liveness {
+	case storage.Live:
+		lr.Condition.IsLive = boolPtr(true)
+	case storage.Archived:
+		lr.Condition.IsLive = boolPtr(false)
+	}
+	return lr
+}
+
+func toLifecycleRule(r LifecycleRule) storage.LifecycleRule {
+	c := r.Condition
+	lr := storage.LifecycleRule{
+		Action: storage.LifecycleAction{Type: r.Action, StorageClass: r.StorageClass},
+		Condition: storage.LifecycleCondition{
+			AgeInDays:               c.Age,
+			NumNewerVersions:        c.NumNewerVersions,
+			DaysSinceNoncurrentTime: c.DaysSinceNoncurrentTime,
+			MatchesStorageClasses:   c.MatchesStorageClasses,
+			MatchesPrefix:           c.MatchesPrefix,
+			MatchesSuffix:           c.MatchesSuffix,
+		},
+	}
+	if c.CreatedBefore != "" {
+		// Validated by ParseSpec.
+		lr.Condition.CreatedBefore, _ = time.Parse("2006-01-02", c.CreatedBefore)
+	}
+	if c.IsLive != nil {
+		lr.Condition.Liveness = storage.Archived
+		if *c.IsLive {
+			lr.Condition.Liveness = storage.Live
+		}
+	}
+	return lr
+}
+
+// bindingKey identifies the binding of a role under a condition.
+func bindingKey(role string, c *Condition) string {
+	if c == nil {
+		return role
+	}
+	return fmt.Sprintf("%s if %q", role, c.Title)
+}
+
+func toBinding(b Binding) *iampb.Binding {
+	pb := &iampb.Binding{Role: b.Role, Members: b.Members}
+	if c := b.Condition; c != nil {
+		pb.Condition = &expr.Expr{Title: c.Title, Description: c.Description, Expression: c.Expression}
+	}
+	return pb
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csek
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// saveEvery is how many objects are finished between checkpoint saves.
+const saveEvery = 100
+
+// checkpoint is the saved progress of a migration.
+type checkpoint struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// Target identifies the key objects are migrated to, without
+	// revealing it.
+	Target string `json:"target"`
+	// After is the name of the last object finished such that it and all
+	// objects before it finished without error, and Report counts only
+	// those objects. A resumed migration lists the objects after it, so
+	// failures are retried and no object is counted twice.
+	After  string `json:"after,omitempty"`
+	Report Report `json:"report"`
+}
+
+// loadCheckpoint reads the checkpoint at path, which must be for the same
+// migration as want. If there is none, want is returned.
+func loadCheckpoint(path string, want *checkpoint) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return want, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	if cp.Bucket != want.Bucket || cp.Prefix != want.Prefix || cp.Target != want.Target {
+		return nil, fmt.Errorf("checkpoint %s is for a different migration (gs://%s/%s to %s)", path, cp.Bucket, cp.Prefix, cp.Target)
+	}
+	return &cp, nil
+}
+
+// progress tracks finished objects, which finish out of order, and saves
+// the checkpoint periodically if path is set.
+type progress struct {
+	cp     *checkpoint
+	path   string
+	notify func(*Action)
+
+	mu sync.Mutex
+	// total is the report of the migration, including the objects finished
+	// after cp.After.
+	total Report
+	// next is the number of the first object not counted in cp, and
+	// finished holds the objects after it that have finished. A failure
+	// stops cp from advancing, so that the next run retries it.
+	next     int64
+	finished map[int64]*Action
+	blocked  bool
+	unsaved  int
+	saveErr  error
+}
+
+func newProgress(cp *checkpoint, path string, notify func(*Action)) *progress {
+	p := &progress{cp: cp, path: path, notify: notify, total: cp.Report, finished: make(map[int64]*Action)}
+	p.total.Failed = append([]*Action(nil), cp.Report.Failed...)
+	return p
+}
+
+// add counts a in r.
+func (r *Report) add(a *Action) {
+	switch {
+	case a.Err != nil:
+		r.Failed = append(r.Failed, a)
+	case a.Op == OpSkip:
+		r.Skipped++
+	default:
+		r.Rewritten++
+	}
+}
+
+// done records that object number seq has finished.
+func (p *progress) done(seq int64, a *Action) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total.add(a)
+	if p.notify != nil {
+		p.notify(a)
+	}
+	if p.blocked {
+		return
+	}
+
+	p.finished[seq] = a
+	for {
+		f, ok := p.finished[p.next]
+		if !ok {
+			break
+		}
+		if f.Err != nil {
+			p.blocked = true
+			p.finished = nil
+			break
+		}
+		delete(p.finished, p.next)
+		p.cp.Report.add(f)
+		p.cp.After = f.Object
+		p.next++
+		p.unsaved++
+	}
+	if p.unsaved >= saveEvery && p.saveErr == nil {
+		p.saveErr = p.saveLocked()
+	}
+}
+
+// save saves the checkpoint, and returns the first error saving it.
+func (p *progress) save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.saveErr == nil {
+		p.saveErr = p.saveLocked()
+	}
+	return p.saveErr
+}
+
+func (p *progress) saveLocked() error {
+	p.unsaved = 0
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write and rename, so that an interrupted save keeps the previous
+	// checkpoint.
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("could not save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return fmt.Errorf("could not save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// report returns a copy of the report so far.
+func (p *progress) report() *Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.total
+	r.Failed = append([]*Action(nil), r.Failed...)
+	return &r
+}
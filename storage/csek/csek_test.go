@@ -0,0 +1,324 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csek
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+)
+
+const kmsKey = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+var (
+	key1 = Key{Name: "one", Key: bytes.Repeat([]byte{1}, 32)}
+	key2 = Key{Name: "two", Key: bytes.Repeat([]byte{2}, 32)}
+	key3 = Key{Name: "three", Key: bytes.Repeat([]byte{3}, 32)}
+	lost = Key{Key: bytes.Repeat([]byte{9}, 32)}
+)
+
+func newClient(t *testing.T) (*fakes.Storage, *storage.Client) {
+	t.Helper()
+	fake := fakes.NewStorage(t)
+	client, err := storage.NewClient(context.Background(), fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("storage.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if err := client.Bucket("b").Create(context.Background(), "my-project", nil); err != nil {
+		t.Fatalf("Bucket.Create: %v", err)
+	}
+	return fake, client
+}
+
+// put writes an object encrypted with key, or unencrypted if key is nil.
+func put(t *testing.T, client *storage.Client, bucket, name string, key []byte) {
+	t.Helper()
+	o := client.Bucket(bucket).Object(name)
+	if key != nil {
+		o = o.Key(key)
+	}
+	w := o.NewWriter(context.Background())
+	w.Metadata = map[string]string{"owner": "test"}
+	if _, err := w.Write([]byte("data of " + name)); err != nil {
+		t.Fatalf("Write(%s): %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(%s): %v", name, err)
+	}
+}
+
+func TestReadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	write := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "keys.json")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	r, err := ReadKeyRing(write(map[string][]Key{"keys": {key1, key2, lost}}))
+	if err != nil {
+		t.Fatalf("ReadKeyRing: %v", err)
+	}
+	if r.Len() != 3 {
+		t.Errorf("Len: got %d, want 3", r.Len())
+	}
+	if k, ok := r.Lookup(key2.SHA256()); !ok || k.Name != "two" {
+		t.Errorf("Lookup(two): got (%v, %v), want key two", k.Name, ok)
+	}
+	if _, ok := r.Lookup(key3.SHA256()); ok {
+		t.Errorf("Lookup(three): got a key, want none")
+	}
+	if k, ok := r.Named("one"); !ok || !bytes.Equal(k.Key, key1.Key) {
+		t.Errorf("Named(one): got (%v, %v), want key one", k.Name, ok)
+	}
+
+	for _, keys := range [][]Key{
+		{{Name: "short", Key: []byte("short")}},
+		{key1, {Name: "one", Key: key2.Key}},
+	} {
+		if _, err := ReadKeyRing(write(map[string][]Key{"keys": keys})); err == nil {
+			t.Errorf("ReadKeyRing(%v): got nil error, want error", keys)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	put(t, client, "b", "other/a", key1.Key)
+	put(t, client, "b", "p/a", key1.Key)
+	put(t, client, "b", "p/b", key2.Key)
+	put(t, client, "b", "p/lost", lost.Key)
+	put(t, client, "b", "p/new", key3.Key)
+	put(t, client, "b", "p/plain", nil)
+	keys, err := NewKeyRing(key1, key2, key3)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+
+	run := func(m *Migrator) (*Report, map[string]*Action) {
+		t.Helper()
+		actions := make(map[string]*Action)
+		m.Client, m.Keys, m.Concurrency = client, keys, 2
+		m.Progress = func(a *Action) { actions[a.Object] = a }
+		report, err := m.Run(ctx, "b", "p/")
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return report, actions
+	}
+	ops := func(actions map[string]*Action) map[string]string {
+		got := make(map[string]string)
+		for name, a := range actions {
+			got[name] = a.Op
+			if a.Err != nil {
+				got[name] = "error"
+			}
+		}
+		return got
+	}
+
+	fake.Reset()
+	report, actions := run(&Migrator{NewKey: key3.Key, DryRun: true})
+	if n := fake.Count("rewriteTo"); n != 0 {
+		t.Errorf("dry run: got %d rewrites, want 0", n)
+	}
+	if report.Rewritten != 2 {
+		t.Errorf("dry run: got %d rewritten, want 2", report.Rewritten)
+	}
+
+	report, actions = run(&Migrator{NewKey: key3.Key})
+	want := map[string]string{"p/a": OpRotate, "p/b": OpRotate, "p/lost": "error", "p/new": OpSkip, "p/plain": OpSkip}
+	if got := ops(actions); !reflect.DeepEqual(got, want) {
+		t.Errorf("rotate: got %v, want %v", got, want)
+	}
+	if report.Rewritten != 2 || report.Skipped != 2 || len(report.Failed) != 1 || report.Failed[0].Reason != ReasonNoKey {
+		t.Errorf("rotate: got report %+v, want 2 rewritten, 2 skipped and p/lost failed", report)
+	}
+	if got := actions["p/b"].Reason; got != "from key two" {
+		t.Errorf("rotate: got reason %q for p/b, want %q", got, "from key two")
+	}
+	for _, name := range []string{"p/a", "p/b", "p/new"} {
+		attrs, err := client.Bucket("b").Object(name).Attrs(ctx)
+		if err != nil {
+			t.Fatalf("Attrs(%s): %v", name, err)
+		}
+		if attrs.CustomerKeySHA256 != key3.SHA256() || attrs.Metadata["owner"] != "test" {
+			t.Errorf("rotate: %s has key %s and metadata %v, want key three and metadata kept", name, attrs.CustomerKeySHA256, attrs.Metadata)
+		}
+	}
+	if attrs, _ := client.Bucket("b").Object("other/a").Attrs(ctx); attrs.CustomerKeySHA256 != key1.SHA256() {
+		t.Errorf("rotate: object outside the prefix was rewritten")
+	}
+
+	_, actions = run(&Migrator{KMSKeyName: kmsKey})
+	want = map[string]string{"p/a": OpMigrate, "p/b": OpMigrate, "p/lost": "error", "p/new": OpMigrate, "p/plain": OpSkip}
+	if got := ops(actions); !reflect.DeepEqual(got, want) {
+		t.Errorf("migrate: got %v, want %v", got, want)
+	}
+	attrs, err := client.Bucket("b").Object("p/a").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs: %v", err)
+	}
+	if attrs.CustomerKeySHA256 != "" || attrs.KMSKeyName != kmsKey {
+		t.Errorf("migrate: p/a has key %q and KMS key %q, want KMS key only", attrs.CustomerKeySHA256, attrs.KMSKeyName)
+	}
+
+	if _, err := (&Migrator{Client: client, Keys: keys}).Run(ctx, "b", ""); err == nil {
+		t.Errorf("Run without a target: got nil error, want error")
+	}
+}
+
+func TestMigratorCheckpoint(t *testing.T) {
+	fake, client := newClient(t)
+	const n = 250
+	for i := 0; i < n; i++ {
+		put(t, client, "b", fmt.Sprintf("obj-%03d", i), key1.Key)
+	}
+	keys, err := NewKeyRing(key1)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Interrupt the first run part way through.
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	var first []string
+	m := &Migrator{
+		Client:      client,
+		Keys:        keys,
+		KMSKeyName:  kmsKey,
+		Concurrency: 4,
+		Checkpoint:  path,
+		Progress: func(a *Action) {
+			mu.Lock()
+			defer mu.Unlock()
+			if first = append(first, a.Object); len(first) == 120 {
+				cancel()
+			}
+		},
+	}
+	fake.Reset()
+	if _, err := m.Run(ctx, "b", ""); err == nil {
+		t.Fatalf("interrupted Run: got nil error, want error")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("checkpoint not saved: %v", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	sort.Strings(first)
+	if cp.After == "" || cp.After > first[len(first)-1] {
+		t.Errorf("checkpoint: got After %q, want at most %q", cp.After, first[len(first)-1])
+	}
+
+	// The second run lists only objects after the checkpoint, and its report
+	// includes the first run.
+	m.Progress = nil
+	report, err := m.Run(context.Background(), "b", "")
+	if err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+	// Objects rewritten as the first run was interrupted, after the
+	// checkpoint, are skipped by the second; each object is counted once.
+	if report.Rewritten > n || report.Rewritten+report.Skipped != n || len(report.Failed) != 0 {
+		t.Errorf("resumed Run: got %d rewritten, %d skipped and %d failed, want %d in all and 0 failed", report.Rewritten, report.Skipped, len(report.Failed), n)
+	}
+	if got := fake.Count("rewriteTo"); got > n+4 {
+		t.Errorf("got %d rewrites, want at most %d", got, n+4)
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("obj-%03d", i)
+		attrs, err := client.Bucket("b").Object(name).Attrs(context.Background())
+		if err != nil || attrs.KMSKeyName != kmsKey {
+			t.Fatalf("%s not migrated: %v", name, err)
+		}
+	}
+
+	m.KMSKeyName = kmsKey + "-other"
+	if _, err := m.Run(context.Background(), "b", ""); err == nil {
+		t.Errorf("Run with another target: got nil error, want checkpoint mismatch")
+	}
+}
+
+func TestMigratorCheckpointRetriesFailures(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t)
+	put(t, client, "b", "a", key1.Key)
+	put(t, client, "b", "b", lost.Key)
+	put(t, client, "b", "c", key1.Key)
+	keys, err := NewKeyRing(key1)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	m := &Migrator{Client: client, Keys: keys, KMSKeyName: kmsKey, Concurrency: 2, Checkpoint: path}
+
+	report, err := m.Run(ctx, "b", "")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Rewritten != 2 || len(report.Failed) != 1 || report.Failed[0].Object != "b" {
+		t.Errorf("Run: got report %+v, want 2 rewritten and b failed", report)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("checkpoint not saved: %v", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	// The checkpoint stops before the failure, and counts only a.
+	if cp.After != "a" || cp.Report.Rewritten != 1 || cp.Report.Skipped != 0 || len(cp.Report.Failed) != 0 {
+		t.Errorf("checkpoint: got After %q and report %+v, want After a and 1 rewritten", cp.After, cp.Report)
+	}
+
+	// With the missing key, b is retried and c, already migrated, skipped.
+	if m.Keys, err = NewKeyRing(key1, lost); err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	report, err = m.Run(ctx, "b", "")
+	if err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+	if report.Rewritten != 2 || report.Skipped != 1 || len(report.Failed) != 0 {
+		t.Errorf("resumed Run: got report %+v, want 2 rewritten and 1 skipped", report)
+	}
+	if attrs, err := client.Bucket("b").Object("b").Attrs(ctx); err != nil || attrs.KMSKeyName != kmsKey {
+		t.Errorf("b not migrated on retry: %v", err)
+	}
+}
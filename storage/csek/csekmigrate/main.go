@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command csekmigrate rewrites the objects in a bucket that are encrypted
+// with customer-supplied keys, to a new customer-supplied key or to a Cloud
+// KMS key. The keys objects are encrypted with are read from a key ring
+// file; see csek.ReadKeyRing for its format.
+//
+//	csekmigrate -keys keys.json -kms-key projects/P/locations/L/keyRings/R/cryptoKeys/K gs://bucket/prefix
+//	csekmigrate -keys keys.json -new-key 2024-q1 -checkpoint rotate.json gs://bucket
+//
+// It prints each object rewritten or failed, and a summary, and exits with
+// status 1 if any object failed. Run it again to retry failures: objects
+// already migrated are skipped.
+//
+//	Usage of csekmigrate:
+//	  -checkpoint file
+//	      Save progress to file, and resume from it.
+//	  -concurrency int
+//	      Number of objects rewritten at once. (default 8)
+//	  -dry-run
+//	      Report what would be done without doing it.
+//	  -keys file
+//	      Key ring file holding the keys objects are encrypted with.
+//	  -kms-key name
+//	      KMS key to migrate objects to.
+//	  -new-key name
+//	      Name of the key in the key ring to rotate objects to.
+//	  -v	Also print objects skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/storage/csek"
+)
+
+var (
+	keysFile    = flag.String("keys", "", "Key ring `file` holding the keys objects are encrypted with.")
+	kmsKey      = flag.String("kms-key", "", "KMS key to migrate objects to.")
+	newKey      = flag.String("new-key", "", "`name` of the key in the key ring to rotate objects to.")
+	checkpoint  = flag.String("checkpoint", "", "Save progress to `file`, and resume from it.")
+	concurrency = flag.Int("concurrency", 8, "Number of objects rewritten at once.")
+	dryRun      = flag.Bool("dry-run", false, "Report what would be done without doing it.")
+	verbose     = flag.Bool("v", false, "Also print objects skipped.")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("csekmigrate: ")
+	flag.Parse()
+	if flag.NArg() != 1 || *keysFile == "" || (*kmsKey == "") == (*newKey == "") {
+		fmt.Fprintln(os.Stderr, "usage: csekmigrate -keys file (-kms-key name | -new-key name) [flags] gs://bucket[/prefix]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	bucket, prefix, ok := parseURL(flag.Arg(0))
+	if !ok {
+		log.Fatalf("%q is not a gs://bucket/prefix URL", flag.Arg(0))
+	}
+
+	keys, err := csek.ReadKeyRing(*keysFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := &csek.Migrator{
+		Keys:        keys,
+		KMSKeyName:  *kmsKey,
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+		Checkpoint:  *checkpoint,
+		Progress: func(a *csek.Action) {
+			switch {
+			case a.Err != nil:
+				fmt.Printf("FAIL %s#%d: %v\n", a.Object, a.Generation, a.Err)
+			case a.Op != csek.OpSkip || *verbose:
+				fmt.Printf("%s %s#%d (%s)\n", a.Op, a.Object, a.Generation, a.Reason)
+			}
+		},
+	}
+	if *newKey != "" {
+		k, ok := keys.Named(*newKey)
+		if !ok {
+			log.Fatalf("no key named %q in %s", *newKey, *keysFile)
+		}
+		m.NewKey = k.Key
+	}
+
+	// Stop cleanly on interrupt, so the checkpoint is saved.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+	m.Client = client
+
+	report, runErr := m.Run(ctx, bucket, prefix)
+	if report != nil {
+		verb := "rewritten"
+		if *dryRun {
+			verb = "to rewrite"
+		}
+		fmt.Printf("%d %s, %d skipped, %d failed\n", report.Rewritten, verb, report.Skipped, len(report.Failed))
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseURL splits gs://bucket/prefix.
+func parseURL(u string) (bucket, prefix string, ok bool) {
+	if !strings.HasPrefix(u, "gs://") {
+		return "", "", false
+	}
+	bucket = strings.TrimPrefix(u, "gs://")
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, prefix = bucket[:i], bucket[i+1:]
+	}
+	return bucket, prefix, bucket != ""
+}
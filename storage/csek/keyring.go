@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package csek manages customer-supplied encryption keys (CSEK) for Cloud
+// Storage objects, and rotates objects to new keys or migrates them to
+// Cloud KMS keys (CMEK) in bulk.
+//
+// Cloud Storage does not keep customer-supplied keys; it reports the SHA256
+// of the key that encrypts each object. A KeyRing maps those hashes back to
+// the keys, so a Migrator can rewrite every object in a bucket without being
+// told which key encrypts which object.
+package csek
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Key is a customer-supplied AES-256 key.
+type Key struct {
+	// Name identifies the key to people, e.g. "2023-q4".
+	Name string `json:"name"`
+	// Key is the 32-byte key, base64-encoded in JSON.
+	Key []byte `json:"key"`
+}
+
+// SHA256 returns the base64-encoded SHA256 of the key, as reported by
+// ObjectAttrs.CustomerKeySHA256.
+func (k Key) SHA256() string {
+	return KeySHA256(k.Key)
+}
+
+// KeySHA256 returns the base64-encoded SHA256 of key.
+func KeySHA256(key []byte) string {
+	sum := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// KeyRing holds customer-supplied keys by their SHA256.
+type KeyRing struct {
+	keys   map[string]Key
+	byName map[string]Key
+}
+
+// NewKeyRing returns a key ring holding keys. Keys must be 32 bytes long
+// and names, if given, unique.
+func NewKeyRing(keys ...Key) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[string]Key), byName: make(map[string]Key)}
+	for i, k := range keys {
+		if len(k.Key) != 32 {
+			return nil, fmt.Errorf("key %d (%q): got %d bytes, want 32", i, k.Name, len(k.Key))
+		}
+		if k.Name != "" {
+			if _, ok := r.byName[k.Name]; ok {
+				return nil, fmt.Errorf("key %d: duplicate name %q", i, k.Name)
+			}
+			r.byName[k.Name] = k
+		}
+		r.keys[k.SHA256()] = k
+	}
+	return r, nil
+}
+
+// ReadKeyRing reads a key ring file:
+//
+//	{
+//	  "keys": [
+//	    {"name": "2023-q4", "key": "TIbv/fjexq+VmtXzAlc63J4z5kFmWJ6NdAPQulQBT7g="},
+//	    ...
+//	  ]
+//	}
+//
+// The file holds secrets and should be readable only by its owner.
+func ReadKeyRing(path string) (*KeyRing, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Keys []Key `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r, err := NewKeyRing(f.Keys...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Lookup returns the key with the given base64-encoded SHA256.
+func (r *KeyRing) Lookup(sha256 string) (Key, bool) {
+	k, ok := r.keys[sha256]
+	return k, ok
+}
+
+// Named returns the key with the given name.
+func (r *KeyRing) Named(name string) (Key, bool) {
+	k, ok := r.byName[name]
+	return k, ok
+}
+
+// Len returns the number of keys.
+func (r *KeyRing) Len() int {
+	return len(r.keys)
+}
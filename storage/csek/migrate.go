@@ -0,0 +1,235 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Migration operations and the reasons objects are skipped.
+const (
+	OpRotate  = "rotate"
+	OpMigrate = "migrate"
+	OpSkip    = "skip"
+
+	ReasonNotCSEK = "not encrypted with a customer-supplied key"
+	ReasonRotated = "already encrypted with the new key"
+	ReasonNoKey   = "key not in key ring"
+	ReasonRewrite = "rewrite failed"
+)
+
+// Migrator rewrites the objects in a bucket that are encrypted with
+// customer-supplied keys, either with a new customer-supplied key (NewKey)
+// or with a Cloud KMS key (KMSKeyName). Exactly one must be set.
+//
+// Each object is rewritten in place, keeping its metadata, with a
+// generation precondition so that objects changed during the migration are
+// reported as failures rather than overwritten. Objects already migrated
+// are skipped, so a migration can be run again to retry failures.
+type Migrator struct {
+	Client *storage.Client
+	// Keys holds the keys that objects are encrypted with.
+	Keys *KeyRing
+	// NewKey is the key to rotate objects to.
+	NewKey []byte
+	// KMSKeyName is the KMS key to migrate objects to, in the form
+	// projects/P/locations/L/keyRings/R/cryptoKeys/K. The Cloud Storage
+	// service agent needs permission to use it.
+	KMSKeyName string
+	// DryRun reports what would be done without doing it.
+	DryRun bool
+	// Concurrency is the number of objects rewritten at once. It defaults
+	// to 8.
+	Concurrency int
+	// Checkpoint is the path of a file where progress is saved, so that an
+	// interrupted migration resumes after the objects it finished, up to
+	// its first failure, which is retried. It is not used with DryRun.
+	Checkpoint string
+	// Progress, if set, is called after each object, from one goroutine at
+	// a time.
+	Progress func(*Action)
+}
+
+// Action is what was done to an object. The Reason of objects rewritten is
+// the key they were encrypted with. Failed actions have Err set.
+type Action struct {
+	Object     string `json:"object"`
+	Generation int64  `json:"generation"`
+	Op         string `json:"op"`
+	Reason     string `json:"reason,omitempty"`
+	Err        error  `json:"-"`
+}
+
+// Report summarizes a migration, including the work saved in a checkpoint
+// by earlier runs.
+type Report struct {
+	// Rewritten is the number of objects rotated or migrated, or that
+	// would be with DryRun.
+	Rewritten int `json:"rewritten"`
+	Skipped   int `json:"skipped"`
+	// Failed lists the failures, in the order they happened.
+	Failed []*Action `json:"failed"`
+}
+
+// Run migrates the objects under prefix in bucket. It continues past
+// failures, which are listed in the report. An error is returned only if
+// the objects cannot be listed or the checkpoint cannot be saved, or if ctx
+// is done; the report then covers the objects finished so far.
+func (m *Migrator) Run(ctx context.Context, bucket, prefix string) (*Report, error) {
+	if (m.NewKey == nil) == (m.KMSKeyName == "") {
+		return nil, errors.New("exactly one of NewKey and KMSKeyName must be set")
+	}
+	if m.NewKey != nil && len(m.NewKey) != 32 {
+		return nil, fmt.Errorf("NewKey: got %d bytes, want 32", len(m.NewKey))
+	}
+	if m.Keys == nil {
+		return nil, errors.New("no key ring")
+	}
+
+	cp := &checkpoint{Bucket: bucket, Prefix: prefix, Target: m.target()}
+	if m.Checkpoint != "" && !m.DryRun {
+		var err error
+		if cp, err = loadCheckpoint(m.Checkpoint, cp); err != nil {
+			return nil, err
+		}
+	}
+	path := m.Checkpoint
+	if m.DryRun {
+		path = ""
+	}
+	prog := newProgress(cp, path, m.Progress)
+
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	type job struct {
+		seq   int64
+		attrs *storage.ObjectAttrs
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				a := m.migrate(ctx, bucket, j.attrs)
+				if a.Err != nil && ctx.Err() != nil {
+					// Interrupted: leave it for the next run.
+					continue
+				}
+				prog.done(j.seq, a)
+			}
+		}()
+	}
+
+	listErr := m.list(ctx, bucket, prefix, cp.After, func(seq int64, attrs *storage.ObjectAttrs) {
+		jobs <- job{seq, attrs}
+	})
+	close(jobs)
+	wg.Wait()
+	saveErr := prog.save()
+
+	switch {
+	case listErr != nil:
+		return prog.report(), listErr
+	case saveErr != nil:
+		return prog.report(), saveErr
+	}
+	return prog.report(), ctx.Err()
+}
+
+// target identifies the destination of a migration in checkpoints.
+func (m *Migrator) target() string {
+	if m.KMSKeyName != "" {
+		return "kms:" + m.KMSKeyName
+	}
+	return "csek:" + KeySHA256(m.NewKey)
+}
+
+// list calls f with each object under prefix whose name sorts after after,
+// in name order, numbering them from zero.
+func (m *Migrator) list(ctx context.Context, bucket, prefix, after string, f func(int64, *storage.ObjectAttrs)) error {
+	q := &storage.Query{Prefix: prefix, StartOffset: after}
+	if err := q.SetAttrSelection([]string{"Name", "Generation", "CustomerKeySHA256", "KMSKeyName"}); err != nil {
+		return err
+	}
+	it := m.Client.Bucket(bucket).Objects(ctx, q)
+	var seq int64
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Bucket(%q).Objects: %w", bucket, err)
+		}
+		if after != "" && attrs.Name <= after {
+			continue
+		}
+		f(seq, attrs)
+		seq++
+	}
+}
+
+// migrate rewrites one object.
+func (m *Migrator) migrate(ctx context.Context, bucket string, attrs *storage.ObjectAttrs) *Action {
+	a := &Action{Object: attrs.Name, Generation: attrs.Generation, Op: OpMigrate}
+	if m.NewKey != nil {
+		a.Op = OpRotate
+	}
+	switch {
+	case attrs.CustomerKeySHA256 == "":
+		a.Op, a.Reason = OpSkip, ReasonNotCSEK
+		return a
+	case m.NewKey != nil && attrs.CustomerKeySHA256 == KeySHA256(m.NewKey):
+		a.Op, a.Reason = OpSkip, ReasonRotated
+		return a
+	}
+	key, ok := m.Keys.Lookup(attrs.CustomerKeySHA256)
+	if !ok {
+		a.Reason = ReasonNoKey
+		a.Err = fmt.Errorf("no key with SHA256 %s", attrs.CustomerKeySHA256)
+		return a
+	}
+	a.Reason = "from key " + key.SHA256()
+	if key.Name != "" {
+		a.Reason = "from key " + key.Name
+	}
+	if m.DryRun {
+		return a
+	}
+
+	o := m.Client.Bucket(bucket).Object(attrs.Name)
+	src := o.Generation(attrs.Generation).Key(key.Key)
+	dst := o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	if m.NewKey != nil {
+		dst = dst.Key(m.NewKey)
+	}
+	c := dst.CopierFrom(src)
+	c.DestinationKMSKeyName = m.KMSKeyName
+	if _, err := c.Run(ctx); err != nil {
+		a.Reason = ReasonRewrite
+		a.Err = fmt.Errorf("Copier.Run: %w", err)
+	}
+	return a
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message is a notification message as captured for replay, one JSON object
+// per line. Data is base64 encoded, as in the Pub/Sub REST API.
+type Message struct {
+	ID          string            `json:"messageId,omitempty"`
+	PublishTime time.Time         `json:"publishTime"`
+	Attributes  map[string]string `json:"attributes"`
+	Data        []byte            `json:"data,omitempty"`
+}
+
+// Stats counts the messages a Consumer has handled.
+type Stats struct {
+	Received   int
+	Applied    int
+	Duplicates int
+	Invalid    int
+}
+
+// Consumer applies notification messages to an Index.
+type Consumer struct {
+	Index *Index
+	// Capture, if set, receives every message handled, for Replay.
+	Capture io.Writer
+	// Progress, if set, is called with every valid event and whether it
+	// changed the index.
+	Progress func(e *Event, applied bool)
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Stats returns the counts of messages handled so far.
+func (c *Consumer) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Handle applies m to the index. Invalid messages are logged and counted,
+// not returned as errors, so that they are not redelivered forever.
+func (c *Consumer) Handle(m *Message) error {
+	c.mu.Lock()
+	c.stats.Received++
+	if c.Capture != nil {
+		b, err := json.Marshal(m)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		if _, err := c.Capture.Write(append(b, '\n')); err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("capturing message: %w", err)
+		}
+	}
+	c.mu.Unlock()
+
+	e, err := ParseEvent(m.Attributes, m.Data)
+	if err != nil {
+		log.Printf("message %s: %v", m.ID, err)
+		c.mu.Lock()
+		c.stats.Invalid++
+		c.mu.Unlock()
+		return nil
+	}
+	applied, err := c.Index.Apply(e)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if applied {
+		c.stats.Applied++
+	} else {
+		c.stats.Duplicates++
+	}
+	c.mu.Unlock()
+	if c.Progress != nil {
+		c.Progress(e, applied)
+	}
+	return nil
+}
+
+// Receive handles messages from sub until ctx is done. Messages are
+// acknowledged once applied and nacked if the index cannot be updated.
+func (c *Consumer) Receive(ctx context.Context, sub *pubsub.Subscription) error {
+	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		m := &Message{ID: msg.ID, PublishTime: msg.PublishTime, Attributes: msg.Attributes, Data: msg.Data}
+		if err := c.Handle(m); err != nil {
+			log.Printf("message %s: %v", m.ID, err)
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("Receive: %w", err)
+	}
+	return nil
+}
+
+// Replay handles the messages captured in r, in order.
+func (c *Consumer) Replay(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := c.Handle(&m); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,323 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+)
+
+func openIndex(t *testing.T) *Index {
+	t.Helper()
+	x, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatalf("OpenIndex: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	return x
+}
+
+// names returns the names and prefixes of list, with prefixes marked by a
+// trailing "*".
+func names(list []*storage.ObjectAttrs) []string {
+	var s []string
+	for _, a := range list {
+		if a.Prefix != "" {
+			s = append(s, a.Prefix+"*")
+			continue
+		}
+		s = append(s, a.Name)
+	}
+	return s
+}
+
+func TestParseEvent(t *testing.T) {
+	attrs := func(kv ...string) map[string]string {
+		m := map[string]string{"bucketId": "b", "objectId": "o", "objectGeneration": "7", "eventType": ObjectFinalize}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return m
+	}
+	payload := `{"bucket":"b","name":"o","generation":"7","metageneration":"3","size":"42","contentType":"text/plain"}`
+
+	tests := []struct {
+		name    string
+		attrs   map[string]string
+		data    string
+		want    *Event
+		wantErr bool
+	}{
+		{
+			name:  "json payload",
+			attrs: attrs("payloadFormat", PayloadJSON, "overwroteGeneration", "6", "eventTime", "2024-03-01T10:00:00.5Z"),
+			data:  payload,
+			want: &Event{
+				Type: ObjectFinalize, Bucket: "b", Name: "o", Generation: 7, OverwroteGeneration: 6,
+				EventTime: time.Date(2024, 3, 1, 10, 0, 0, 5e8, time.UTC),
+				Object:    &Object{Bucket: "b", Name: "o", Generation: 7, Metageneration: 3, Size: 42, ContentType: "text/plain"},
+			},
+		},
+		{
+			name:  "no payload",
+			attrs: attrs("payloadFormat", PayloadNone, "eventType", ObjectDelete),
+			want:  &Event{Type: ObjectDelete, Bucket: "b", Name: "o", Generation: 7},
+		},
+		{name: "unknown type", attrs: attrs("eventType", "OBJECT_RENAMED"), wantErr: true},
+		{name: "no generation", attrs: attrs("objectGeneration", ""), wantErr: true},
+		{name: "bad payload", attrs: attrs("payloadFormat", PayloadJSON), data: "{", wantErr: true},
+		{name: "payload mismatch", attrs: attrs("payloadFormat", PayloadJSON, "objectGeneration", "8"), data: payload, wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseEvent(tc.attrs, []byte(tc.data))
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidEvent) {
+				t.Errorf("%s: ParseEvent: got error %v, want ErrInvalidEvent", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseEvent: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: ParseEvent: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func checkReplayed(t *testing.T, x *Index) {
+	t.Helper()
+	list, err := x.List("b", nil, 0)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got, want := names(list), []string{"a.txt", "b.txt", "dir/x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List: got %q, want %q", got, want)
+	}
+	o, err := x.Get("b", "a.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if o.Generation != 2 || o.Size != 20 {
+		t.Errorf("Get(a.txt): got generation %d, size %d, want 2, 20", o.Generation, o.Size)
+	}
+	if o, err := x.Get("b", "dir/x"); err != nil || o.Metageneration != 2 || o.ContentType != "application/json" {
+		t.Errorf("Get(dir/x): got %+v, %v, want metageneration 2 with the updated content type", o, err)
+	}
+	if _, err := x.Get("b", "dir/y"); err != ErrNotFound {
+		t.Errorf("Get(dir/y): got %v, want ErrNotFound", err)
+	}
+	if list, err := x.List("c", nil, 0); err != nil || len(list) != 1 {
+		t.Errorf("List(c): got %q, %v, want [other]", names(list), err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f, err := os.Open("testdata/events.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	x := openIndex(t)
+	c := &Consumer{Index: x}
+	if err := c.Replay(f); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got, want := c.Stats(), (Stats{Received: 15, Applied: 10, Duplicates: 4, Invalid: 1}); got != want {
+		t.Errorf("Stats: got %+v, want %+v", got, want)
+	}
+	checkReplayed(t, x)
+
+	if err := c.Replay(strings.NewReader("{\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Replay: got %v, want an error for line 1", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	x := openIndex(t)
+	for i, name := range []string{"a", "d/1", "d/2", "d/e/3", "d/f/4", "d/9", "d/g/5", "e", "é", "h/6"} {
+		e := &Event{Type: ObjectFinalize, Bucket: "b", Name: name, Generation: int64(i + 1)}
+		if _, err := x.Apply(e); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	// Deleting every object under d/g/ and h/ leaves only tombstones there.
+	for name, gen := range map[string]int64{"d/9": 6, "d/g/5": 7, "h/6": 10} {
+		if _, err := x.Apply(&Event{Type: ObjectDelete, Bucket: "b", Name: name, Generation: gen}); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+
+	tests := []struct {
+		q     storage.Query
+		limit int
+		want  []string
+	}{
+		{want: []string{"a", "d/1", "d/2", "d/e/3", "d/f/4", "e", "é"}},
+		{q: storage.Query{Delimiter: "/"}, want: []string{"a", "d/*", "e", "é"}},
+		{q: storage.Query{Prefix: "d/", Delimiter: "/"}, want: []string{"d/1", "d/2", "d/e/*", "d/f/*"}},
+		{q: storage.Query{Prefix: "d/g/", Delimiter: "/"}},
+		{q: storage.Query{Prefix: "d/", StartOffset: "d/2", EndOffset: "d/f"}, want: []string{"d/2", "d/e/3"}},
+		{q: storage.Query{StartOffset: "d/1\x00"}, limit: 2, want: []string{"d/2", "d/e/3"}},
+		{q: storage.Query{Prefix: "x"}},
+	}
+	for _, tc := range tests {
+		list, err := x.List("b", &tc.q, tc.limit)
+		if err != nil {
+			t.Errorf("List(%+v): %v", tc.q, err)
+			continue
+		}
+		if got := names(list); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("List(%+v, %d): got %q, want %q", tc.q, tc.limit, got, tc.want)
+		}
+	}
+	if list, err := x.List("missing", nil, 0); err != nil || len(list) != 0 {
+		t.Errorf("List(missing): got %v, %v, want nothing", list, err)
+	}
+}
+
+func TestApplyOrder(t *testing.T) {
+	x := openIndex(t)
+	now := time.Now()
+	apply := func(typ string, gen, metagen int64) bool {
+		t.Helper()
+		e := &Event{Type: typ, Bucket: "b", Name: "o", Generation: gen, EventTime: now}
+		if typ != ObjectDelete {
+			e.Object = &Object{Bucket: "b", Name: "o", Generation: gen, Metageneration: metagen}
+		}
+		applied, err := x.Apply(e)
+		if err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+		return applied
+	}
+	steps := []struct {
+		typ          string
+		gen, metagen int64
+		want         bool
+	}{
+		{ObjectMetadataUpdate, 5, 2, true},
+		{ObjectFinalize, 5, 1, false},
+		{ObjectMetadataUpdate, 5, 2, false},
+		{ObjectFinalize, 4, 1, false},
+		{ObjectDelete, 4, 0, false},
+		{ObjectDelete, 5, 0, true},
+		{ObjectMetadataUpdate, 5, 3, false},
+		{ObjectFinalize, 6, 1, true},
+	}
+	for i, s := range steps {
+		if got := apply(s.typ, s.gen, s.metagen); got != s.want {
+			t.Errorf("step %d: %s #%d/%d: got applied %v, want %v", i, s.typ, s.gen, s.metagen, got, s.want)
+		}
+	}
+
+	if apply(ObjectDelete, 6, 0) != true {
+		t.Fatalf("delete #6 not applied")
+	}
+	if n, err := x.Prune(now); err != nil || n != 0 {
+		t.Errorf("Prune(now): got %d, %v, want 0", n, err)
+	}
+	if n, err := x.Prune(now.Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("Prune(later): got %d, %v, want 1", n, err)
+	}
+	if !apply(ObjectFinalize, 6, 1) {
+		t.Errorf("finalize #6 after pruning: not applied")
+	}
+}
+
+func TestReceive(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+
+	fake := fakes.NewPubSub(t)
+	client, err := pubsub.NewClient(ctx, "p", fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("pubsub.NewClient: %v", err)
+	}
+	defer client.Close()
+	topic, err := client.CreateTopic(ctx, "notifications")
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	sub, err := client.CreateSubscription(ctx, "index", pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatalf("CreateSubscription: %v", err)
+	}
+
+	// Publish the captured messages, as Cloud Storage would.
+	data, err := ioutil.ReadFile("testdata/events.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*Message
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var m Message
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, &m)
+	}
+	for _, m := range msgs {
+		if _, err := topic.Publish(ctx, &pubsub.Message{Attributes: m.Attributes, Data: m.Data}).Get(ctx); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	topic.Stop()
+
+	var capture bytes.Buffer
+	x := openIndex(t)
+	c := &Consumer{Index: x, Capture: &capture}
+	rctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	go func() {
+		for c.Stats().Received < len(msgs) && rctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+	if err := c.Receive(rctx, sub); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if st := c.Stats(); st.Received != len(msgs) || st.Invalid != 1 {
+		t.Fatalf("Stats: got %+v, want %d received, 1 invalid", st, len(msgs))
+	}
+	checkReplayed(t, x)
+
+	// The capture replays to the same index.
+	y := openIndex(t)
+	if err := (&Consumer{Index: y}).Replay(&capture); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	checkReplayed(t, y)
+}
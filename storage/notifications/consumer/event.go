@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consumer consumes Cloud Storage Pub/Sub notifications and
+// materializes them into a local index of bucket contents.
+//
+// Notifications may be delivered more than once and out of order. The index
+// keeps the latest generation and metageneration it has seen for every object
+// name, including deleted ones, so that replayed or late events cannot undo
+// newer ones.
+package consumer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Event types, as in the eventType attribute of a notification.
+const (
+	ObjectFinalize       = "OBJECT_FINALIZE"
+	ObjectDelete         = "OBJECT_DELETE"
+	ObjectMetadataUpdate = "OBJECT_METADATA_UPDATE"
+	ObjectArchive        = "OBJECT_ARCHIVE"
+)
+
+// Payload formats, as in the payloadFormat attribute of a notification.
+const (
+	PayloadJSON = "JSON_API_V1"
+	PayloadNone = "NONE"
+)
+
+// ErrInvalidEvent is returned for messages that are not valid notifications.
+var ErrInvalidEvent = errors.New("invalid notification")
+
+// Event is a parsed object change notification.
+type Event struct {
+	Type               string
+	NotificationConfig string
+	Bucket             string
+	Name               string
+	Generation         int64
+	EventTime          time.Time
+	// OverwroteGeneration is the generation a finalize replaced, and
+	// OverwrittenByGeneration the generation that replaced a deleted or
+	// archived object, or zero.
+	OverwroteGeneration     int64
+	OverwrittenByGeneration int64
+	// Object is the object resource from a JSON_API_V1 payload, or nil if
+	// the notification has no payload.
+	Object *Object
+}
+
+// Object is the object metadata kept in the index.
+type Object struct {
+	Bucket         string            `json:"bucket"`
+	Name           string            `json:"name"`
+	Generation     int64             `json:"generation,string"`
+	Metageneration int64             `json:"metageneration,string"`
+	Size           int64             `json:"size,string"`
+	ContentType    string            `json:"contentType,omitempty"`
+	StorageClass   string            `json:"storageClass,omitempty"`
+	MD5Hash        string            `json:"md5Hash,omitempty"`
+	CRC32C         string            `json:"crc32c,omitempty"`
+	Etag           string            `json:"etag,omitempty"`
+	Created        time.Time         `json:"timeCreated"`
+	Updated        time.Time         `json:"updated"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// Metageneration returns the metageneration of the event's object, or zero
+// if the notification has no payload.
+func (e *Event) Metageneration() int64 {
+	if e.Object == nil {
+		return 0
+	}
+	return e.Object.Metageneration
+}
+
+// String returns a one-line description of the event.
+func (e *Event) String() string {
+	return fmt.Sprintf("%s gs://%s/%s#%d", e.Type, e.Bucket, e.Name, e.Generation)
+}
+
+// ParseEvent parses the attributes and data of a notification message.
+func ParseEvent(attrs map[string]string, data []byte) (*Event, error) {
+	e := &Event{
+		Type:               attrs["eventType"],
+		NotificationConfig: attrs["notificationConfig"],
+		Bucket:             attrs["bucketId"],
+		Name:               attrs["objectId"],
+	}
+	switch e.Type {
+	case ObjectFinalize, ObjectDelete, ObjectMetadataUpdate, ObjectArchive:
+	case "":
+		return nil, fmt.Errorf("%w: no eventType attribute", ErrInvalidEvent)
+	default:
+		return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidEvent, e.Type)
+	}
+	if e.Bucket == "" || e.Name == "" {
+		return nil, fmt.Errorf("%w: no bucketId or objectId attribute", ErrInvalidEvent)
+	}
+	var err error
+	if e.Generation, err = parseGeneration(attrs, "objectGeneration"); err != nil {
+		return nil, err
+	}
+	if e.Generation == 0 {
+		return nil, fmt.Errorf("%w: no objectGeneration attribute", ErrInvalidEvent)
+	}
+	if e.OverwroteGeneration, err = parseGeneration(attrs, "overwroteGeneration"); err != nil {
+		return nil, err
+	}
+	if e.OverwrittenByGeneration, err = parseGeneration(attrs, "overwrittenByGeneration"); err != nil {
+		return nil, err
+	}
+	if s := attrs["eventTime"]; s != "" {
+		if e.EventTime, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, fmt.Errorf("%w: eventTime: %v", ErrInvalidEvent, err)
+		}
+	}
+
+	switch f := attrs["payloadFormat"]; f {
+	case PayloadJSON:
+		var o Object
+		if err := json.Unmarshal(data, &o); err != nil {
+			return nil, fmt.Errorf("%w: payload: %v", ErrInvalidEvent, err)
+		}
+		if o.Bucket != e.Bucket || o.Name != e.Name || o.Generation != e.Generation {
+			return nil, fmt.Errorf("%w: payload is for gs://%s/%s#%d", ErrInvalidEvent, o.Bucket, o.Name, o.Generation)
+		}
+		e.Object = &o
+	case PayloadNone, "":
+	default:
+		return nil, fmt.Errorf("%w: unknown payload format %q", ErrInvalidEvent, f)
+	}
+	return e, nil
+}
+
+func parseGeneration(attrs map[string]string, key string) (int64, error) {
+	s, ok := attrs[key]
+	if !ok {
+		return 0, nil
+	}
+	g, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || g <= 0 {
+		return 0, fmt.Errorf("%w: %s: invalid generation %q", ErrInvalidEvent, key, s)
+	}
+	return g, nil
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned by Get for objects not in the index.
+var ErrNotFound = errors.New("object not in index")
+
+// objectsKey is the top-level bolt bucket holding one nested bucket per
+// Cloud Storage bucket, keyed by object name.
+var objectsKey = []byte("objects")
+
+// record is the indexed state of one object name. Deleted records are kept
+// as tombstones so that late events for older generations are ignored.
+type record struct {
+	Generation     int64     `json:"g"`
+	Metageneration int64     `json:"m,omitempty"`
+	Deleted        bool      `json:"d,omitempty"`
+	EventTime      time.Time `json:"t"`
+	Object         *Object   `json:"o,omitempty"`
+}
+
+// Index is a local index of bucket contents built from notifications.
+type Index struct {
+	db *bolt.DB
+}
+
+// OpenIndex opens the index at path, creating it if needed.
+func OpenIndex(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt.Open: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(objectsKey)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index.
+func (x *Index) Close() error {
+	return x.db.Close()
+}
+
+// Apply applies e to the index. It reports false if e is a duplicate or
+// older than what the index already holds for the object.
+func (x *Index) Apply(e *Event) (bool, error) {
+	var applied bool
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(objectsKey).CreateBucketIfNotExists([]byte(e.Bucket))
+		if err != nil {
+			return err
+		}
+		var cur *record
+		if v := b.Get([]byte(e.Name)); v != nil {
+			cur = new(record)
+			if err := json.Unmarshal(v, cur); err != nil {
+				return fmt.Errorf("decoding %s: %w", e.Name, err)
+			}
+		}
+		if !supersedes(e, cur) {
+			return nil
+		}
+		rec := &record{Generation: e.Generation, EventTime: e.EventTime}
+		switch e.Type {
+		case ObjectDelete, ObjectArchive:
+			rec.Deleted = true
+		default:
+			rec.Metageneration = e.Metageneration()
+			rec.Object = e.Object
+			if rec.Object == nil {
+				rec.Object = &Object{Bucket: e.Bucket, Name: e.Name, Generation: e.Generation}
+			}
+		}
+		v, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		applied = true
+		return b.Put([]byte(e.Name), v)
+	})
+	if err != nil {
+		return false, fmt.Errorf("applying %v: %w", e, err)
+	}
+	return applied, nil
+}
+
+// supersedes reports whether e is newer than cur. A newer generation always
+// wins. Within a generation a deletion is final, and metadata changes are
+// ordered by metageneration.
+func supersedes(e *Event, cur *record) bool {
+	switch {
+	case cur == nil:
+		return true
+	case e.Generation != cur.Generation:
+		return e.Generation > cur.Generation
+	case cur.Deleted:
+		return false
+	case e.Type == ObjectDelete || e.Type == ObjectArchive:
+		return true
+	default:
+		return e.Metageneration() > cur.Metageneration
+	}
+}
+
+// Get returns the live object with the given name.
+func (x *Index) Get(bucket, name string) (*Object, error) {
+	var o *Object
+	err := x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(objectsKey).Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		var rec record
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("decoding %s: %w", name, err)
+		}
+		o = rec.Object
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, ErrNotFound
+	}
+	return o, nil
+}
+
+// List lists the live objects of bucket in name order, like
+// BucketHandle.Objects. Prefix, Delimiter, StartOffset and EndOffset of q
+// are supported; with a delimiter, prefixes are returned as ObjectAttrs with
+// only Prefix set. At most limit entries are returned if limit is positive.
+func (x *Index) List(bucket string, q *storage.Query, limit int) ([]*storage.ObjectAttrs, error) {
+	if q == nil {
+		q = &storage.Query{}
+	}
+	var list []*storage.ObjectAttrs
+	err := x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(objectsKey).Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		start := q.Prefix
+		if q.StartOffset > start {
+			start = q.StartOffset
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(start)); k != nil; {
+			if limit > 0 && len(list) == limit {
+				return nil
+			}
+			name := string(k)
+			if !strings.HasPrefix(name, q.Prefix) || (q.EndOffset != "" && name >= q.EndOffset) {
+				return nil
+			}
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("decoding %s: %w", name, err)
+			}
+			if rec.Deleted {
+				k, v = c.Next()
+				continue
+			}
+			// A prefix is listed once a live object is found under it,
+			// so prefixes holding only tombstones are left out.
+			if q.Delimiter != "" {
+				if i := strings.Index(name[len(q.Prefix):], q.Delimiter); i >= 0 {
+					p := name[:len(q.Prefix)+i+len(q.Delimiter)]
+					list = append(list, &storage.ObjectAttrs{Prefix: p})
+					end := prefixEnd([]byte(p))
+					if end == nil {
+						return nil
+					}
+					k, v = c.Seek(end)
+					continue
+				}
+			}
+			list = append(list, rec.Object.attrs())
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix p,
+// or nil if there is none.
+func prefixEnd(p []byte) []byte {
+	end := append([]byte(nil), p...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Prune removes the tombstones of objects deleted before t. Events for those
+// generations that arrive later are no longer recognized as stale.
+func (x *Index) Prune(t time.Time) (int, error) {
+	var n int
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(objectsKey).ForEach(func(name, _ []byte) error {
+			b := tx.Bucket(objectsKey).Bucket(name)
+			// Deleting while iterating can skip keys, so collect first.
+			var stale [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				var rec record
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return fmt.Errorf("decoding %s: %w", k, err)
+				}
+				if rec.Deleted && rec.EventTime.Before(t) {
+					stale = append(stale, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range stale {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			n += len(stale)
+			return nil
+		})
+	})
+	return n, err
+}
+
+// attrs converts o to the form returned by the storage client.
+func (o *Object) attrs() *storage.ObjectAttrs {
+	a := &storage.ObjectAttrs{
+		Bucket:         o.Bucket,
+		Name:           o.Name,
+		Generation:     o.Generation,
+		Metageneration: o.Metageneration,
+		Size:           o.Size,
+		ContentType:    o.ContentType,
+		StorageClass:   o.StorageClass,
+		Etag:           o.Etag,
+		Created:        o.Created,
+		Updated:        o.Updated,
+		Metadata:       o.Metadata,
+	}
+	if md5, err := base64.StdEncoding.DecodeString(o.MD5Hash); err == nil && len(md5) > 0 {
+		a.MD5 = md5
+	}
+	if crc, err := base64.StdEncoding.DecodeString(o.CRC32C); err == nil && len(crc) == 4 {
+		a.CRC32C = binary.BigEndian.Uint32(crc)
+	}
+	return a
+}
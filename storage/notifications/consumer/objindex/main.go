@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command objindex maintains a local index of bucket contents from Cloud
+// Storage Pub/Sub notifications, and lists objects from it.
+//
+//	objindex consume [-db file] [-capture file] projects/P/subscriptions/S
+//	objindex replay [-db file] events.jsonl...
+//	objindex ls [-db file] [-d delimiter] [-n limit] gs://bucket/prefix
+//	objindex prune [-db file] [-age duration]
+//
+// consume applies notifications from a subscription until interrupted,
+// optionally capturing them to a file that replay applies later. The
+// notifications must use the JSON_API_V1 payload format for the index to
+// hold object metadata.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/storage/notifications/consumer"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  objindex consume [-db file] [-capture file] projects/P/subscriptions/S
+  objindex replay [-db file] events.jsonl...
+  objindex ls [-db file] [-d delimiter] [-n limit] gs://bucket/prefix
+  objindex prune [-db file] [-age duration]`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("objindex: ")
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	db := fs.String("db", "objindex.db", "Index `file`.")
+	capture := fs.String("capture", "", "Append the messages consumed to `file`.")
+	delim := fs.String("d", "", "List prefixes up to `delimiter`.")
+	limit := fs.Int("n", 0, "List at most `limit` entries.")
+	age := fs.Duration("age", 7*24*time.Hour, "Forget deletions older than `duration`.")
+	fs.Parse(os.Args[2:])
+
+	x, err := consumer.OpenIndex(*db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer x.Close()
+
+	switch cmd {
+	case "consume":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		err = consume(x, fs.Arg(0), *capture)
+	case "replay":
+		if fs.NArg() == 0 {
+			usage()
+		}
+		c := &consumer.Consumer{Index: x}
+		for _, path := range fs.Args() {
+			if err = replay(c, path); err != nil {
+				break
+			}
+		}
+		st := c.Stats()
+		fmt.Printf("%d messages: %d applied, %d duplicate or stale, %d invalid\n", st.Received, st.Applied, st.Duplicates, st.Invalid)
+	case "ls":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		err = list(x, fs.Arg(0), *delim, *limit)
+	case "prune":
+		var n int
+		n, err = x.Prune(time.Now().Add(-*age))
+		fmt.Printf("%d deletions forgotten\n", n)
+	default:
+		usage()
+	}
+	if err != nil {
+		x.Close()
+		log.Fatal(err)
+	}
+}
+
+func consume(x *consumer.Index, subscription, capture string) error {
+	parts := strings.Split(subscription, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "subscriptions" {
+		return fmt.Errorf("%q is not a projects/P/subscriptions/S name", subscription)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := pubsub.NewClient(ctx, parts[1])
+	if err != nil {
+		return fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	c := &consumer.Consumer{
+		Index: x,
+		Progress: func(e *consumer.Event, applied bool) {
+			if applied {
+				fmt.Println(e)
+			}
+		},
+	}
+	if capture != "" {
+		f, err := os.OpenFile(capture, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		c.Capture = f
+	}
+	return c.Receive(ctx, client.Subscription(parts[3]))
+}
+
+func replay(c *consumer.Consumer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.Replay(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func list(x *consumer.Index, u, delim string, limit int) error {
+	if !strings.HasPrefix(u, "gs://") {
+		return fmt.Errorf("%q is not a gs://bucket/prefix URL", u)
+	}
+	bucket, prefix := strings.TrimPrefix(u, "gs://"), ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, prefix = bucket[:i], bucket[i+1:]
+	}
+	list, err := x.List(bucket, &storage.Query{Prefix: prefix, Delimiter: delim}, limit)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, a := range list {
+		if a.Prefix != "" {
+			fmt.Fprintf(tw, "\t\tgs://%s/%s\n", bucket, a.Prefix)
+			continue
+		}
+		// Notifications without a payload carry no metadata.
+		updated := "-"
+		if !a.Updated.IsZero() {
+			updated = a.Updated.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\tgs://%s/%s#%d\n", a.Size, updated, bucket, a.Name, a.Generation)
+	}
+	return tw.Flush()
+}
@@ -31,6 +31,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
 )
 
 // TestObjects runs all samples tests of the package.
@@ -337,7 +338,16 @@ func TestObjects(t *testing.T) {
 }
 
 func TestKMSObjects(t *testing.T) {
-	tc := testutil.SystemTest(t)
+	projectID := os.Getenv("GOLANG_SAMPLES_PROJECT_ID")
+	keyRingID := os.Getenv("GOLANG_SAMPLES_KMS_KEYRING")
+	cryptoKeyID := os.Getenv("GOLANG_SAMPLES_KMS_CRYPTOKEY")
+	if projectID == "" {
+		// Without a project, run the samples against a Cloud Storage fake.
+		t.Setenv("STORAGE_EMULATOR_HOST", fakes.NewStorage(t).URL())
+		projectID, keyRingID, cryptoKeyID = "fake-project", "fake-keyring", "fake-key"
+	} else if keyRingID == "" || cryptoKeyID == "" {
+		t.Skip("GOLANG_SAMPLES_KMS_KEYRING and GOLANG_SAMPLES_KMS_CRYPTOKEY must be set")
+	}
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
 	if err != nil {
@@ -345,18 +355,12 @@ func TestKMSObjects(t *testing.T) {
 	}
 	defer client.Close()
 
-	keyRingID := os.Getenv("GOLANG_SAMPLES_KMS_KEYRING")
-	cryptoKeyID := os.Getenv("GOLANG_SAMPLES_KMS_CRYPTOKEY")
-	if keyRingID == "" || cryptoKeyID == "" {
-		t.Skip("GOLANG_SAMPLES_KMS_KEYRING and GOLANG_SAMPLES_KMS_CRYPTOKEY must be set")
-	}
-
-	bucket := tc.ProjectID + "-samples-object-bucket-1"
+	bucket := projectID + "-samples-object-bucket-1"
 	object := "foo.txt"
 
-	testutil.CleanBucket(ctx, t, tc.ProjectID, bucket)
+	testutil.CleanBucket(ctx, t, projectID, bucket)
 
-	kmsKeyName := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", tc.ProjectID, "global", keyRingID, cryptoKeyID)
+	kmsKeyName := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", projectID, "global", keyRingID, cryptoKeyID)
 	t.Run("сhangeObjectCSEKtoKMS", func(t *testing.T) {
 		object1 := "foo1.txt"
 		key := []byte("my-secret-AES-256-encryption-key")
@@ -15,30 +15,43 @@
 package objects
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"time"
 
 	"cloud.google.com/go/storage"
 )
 
-func signedURL(bucket, object string) error {
-	// Download a p12 service account private key from the Google Developers Console.
-	// And convert it to PEM by running the command below:
-	//	$ openssl pkcs12 -in key.p12 -passin pass:notasecret -out my-private-key.pem -nodes
-	pkey, err := ioutil.ReadFile("my-private-key.pem")
+// signedURL prints a V4 signed URL to download the object, valid for an
+// hour, signed with a service account JSON key file downloaded from the
+// Google Cloud console.
+func signedURL(w io.Writer, bucket, object, keyFile string) error {
+	// bucket := "bucket-name"
+	// object := "object-name"
+	// keyFile := "service-account.json"
+	b, err := ioutil.ReadFile(keyFile)
 	if err != nil {
-		return err
+		return fmt.Errorf("ioutil.ReadFile: %w", err)
+	}
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
 	}
 	url, err := storage.SignedURL(bucket, object, &storage.SignedURLOptions{
-		GoogleAccessID: "xxx@developer.gserviceaccount.com",
-		PrivateKey:     pkey,
+		GoogleAccessID: key.ClientEmail,
+		PrivateKey:     []byte(key.PrivateKey),
+		Scheme:         storage.SigningSchemeV4,
 		Method:         "GET",
-		Expires:        time.Now().Add(48 * time.Hour),
+		Expires:        time.Now().Add(time.Hour),
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("storage.SignedURL: %w", err)
 	}
-	fmt.Println(url)
+	fmt.Fprintln(w, url)
 	return nil
 }
@@ -0,0 +1,238 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Kinds of faults.
+const (
+	// FaultStatus answers with an HTTP error status, such as 429 or 503.
+	FaultStatus = "status"
+	// FaultReset fails the request with a connection reset.
+	FaultReset = "reset"
+	// FaultSlowBody delays the response body.
+	FaultSlowBody = "slow-body"
+	// FaultBodyReset cuts the response body off half way.
+	FaultBodyReset = "body-reset"
+)
+
+// Fault is a failure injected into a request.
+type Fault struct {
+	Kind string
+	// Status is the HTTP status of FaultStatus.
+	Status int
+	// Delay is how long FaultSlowBody delays the body.
+	Delay time.Duration
+	// Applied sends the request to the server before failing it, as when a
+	// response is lost: the server has made the change, but the client sees
+	// an error. Otherwise the request never reaches the server. It has no
+	// effect on FaultSlowBody and FaultBodyReset, which always send it.
+	Applied bool
+}
+
+func (f Fault) String() string {
+	s := f.Kind
+	switch f.Kind {
+	case FaultStatus:
+		s = fmt.Sprint(f.Status)
+	case FaultSlowBody:
+		s += " " + f.Delay.String()
+	}
+	if f.Applied {
+		s += " (applied)"
+	}
+	return s
+}
+
+// Status returns a fault answering with the HTTP status code.
+func Status(code int) Fault {
+	return Fault{Kind: FaultStatus, Status: code}
+}
+
+// Reset returns a fault resetting the connection.
+func Reset() Fault {
+	return Fault{Kind: FaultReset}
+}
+
+// Request is a request made through a FaultTransport.
+type Request struct {
+	// N numbers requests from 1.
+	N      int
+	Method string
+	URL    string
+	Fault  *Fault
+	// Status is the status the client saw, or 0 if the request failed.
+	Status   int
+	Duration time.Duration
+}
+
+// FaultTransport is an http.RoundTripper that injects faults into chosen
+// requests, numbered from 1, and records all requests.
+type FaultTransport struct {
+	// Base makes the requests. It defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// Faults maps request numbers to the faults injected into them.
+	Faults map[int]Fault
+
+	mu       sync.Mutex
+	requests []*Request
+}
+
+// Requests returns the requests made so far.
+func (t *FaultTransport) Requests() []*Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Request(nil), t.requests...)
+}
+
+// Reset forgets the requests made and sets new faults, so that request
+// numbering starts again from 1.
+func (t *FaultTransport) Reset(faults map[int]Fault) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = nil
+	t.Faults = faults
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	r := &Request{N: len(t.requests) + 1, Method: req.Method, URL: req.URL.String()}
+	if f, ok := t.Faults[r.N]; ok {
+		r.Fault = &f
+	}
+	t.requests = append(t.requests, r)
+	t.mu.Unlock()
+
+	start := time.Now()
+	resp, err := t.roundTrip(req, r.Fault)
+	t.mu.Lock()
+	r.Duration = time.Since(start)
+	if err == nil {
+		r.Status = resp.StatusCode
+	}
+	t.mu.Unlock()
+	return resp, err
+}
+
+func (t *FaultTransport) roundTrip(req *http.Request, f *Fault) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if f == nil {
+		return base.RoundTrip(req)
+	}
+	send := f.Applied || f.Kind == FaultSlowBody || f.Kind == FaultBodyReset
+	var resp *http.Response
+	if send {
+		var err error
+		if resp, err = base.RoundTrip(req); err != nil {
+			return nil, err
+		}
+	} else if req.Body != nil {
+		// Consume the body as a server would before failing, so the client
+		// cannot tell whether it was sent.
+		io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	switch f.Kind {
+	case FaultStatus:
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		body := fmt.Sprintf(`{"error": {"code": %d, "message": "injected fault: %s"}}`, f.Status, http.StatusText(f.Status))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+			StatusCode:    f.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json; charset=UTF-8"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	case FaultReset:
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case FaultSlowBody:
+		resp.Body = &slowBody{ReadCloser: resp.Body, delay: f.Delay, done: req.Context().Done()}
+		return resp, nil
+	case FaultBodyReset:
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &cutBody{r: bytes.NewReader(data[:len(data)/2])}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unknown fault kind %q", f.Kind)
+}
+
+// slowBody delays its first read, unless the request is canceled.
+type slowBody struct {
+	io.ReadCloser
+	delay   time.Duration
+	done    <-chan struct{}
+	waited  bool
+	aborted bool
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if !b.waited {
+		b.waited = true
+		select {
+		case <-time.After(b.delay):
+		case <-b.done:
+			b.aborted = true
+		}
+	}
+	if b.aborted {
+		return 0, errors.New("request canceled while reading body")
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// cutBody returns its data, then fails as a dropped connection does.
+type cutBody struct {
+	r *bytes.Reader
+}
+
+func (b *cutBody) Read(p []byte) (int, error) {
+	if b.r.Len() == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b.r.Read(p)
+}
+
+func (b *cutBody) Close() error { return nil }
@@ -0,0 +1,348 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Operations run by a Harness.
+const (
+	OpRead      = "read"
+	OpWrite     = "write"
+	OpDelete    = "delete"
+	OpCompose   = "compose"
+	OpResumable = "resumable-upload"
+)
+
+// Outcomes of an operation.
+const (
+	// OutcomeOK means the operation succeeded.
+	OutcomeOK = "ok"
+	// OutcomeFailed means the operation failed and had no effect.
+	OutcomeFailed = "failed"
+	// OutcomeAmbiguous means the operation failed but had its effect, as
+	// when a delete is retried after the first attempt succeeded.
+	OutcomeAmbiguous = "failed but applied"
+	// OutcomeLost means the operation succeeded but had no effect.
+	OutcomeLost = "succeeded but not applied"
+)
+
+// Policy is a named retry configuration.
+type Policy struct {
+	Name    string
+	Options []storage.RetryOption
+}
+
+// Harness runs storage operations through a FaultTransport under several
+// retry policies, and reports how each policy copes with the faults.
+//
+// Each operation runs against new objects in Bucket, which are set up and
+// checked by a separate client that sees no faults.
+type Harness struct {
+	Bucket string
+	// ClientOptions configure the clients, e.g. with an endpoint. The
+	// harness adds its own HTTP client.
+	ClientOptions []option.ClientOption
+	// Preconditions makes writes, deletes and composes conditional on the
+	// object generation, which makes them idempotent.
+	Preconditions bool
+	// Timeout limits each operation, including retries. It defaults to a
+	// minute.
+	Timeout time.Duration
+}
+
+// Result is the result of one operation under one policy.
+type Result struct {
+	Policy string
+	Op     string
+	// Faults lists the faults injected, by request number.
+	Faults string
+	// Requests is the number of requests made, and Retries the number more
+	// than without faults, or zero if the operation gave up early.
+	Requests int
+	Retries  int
+	Outcome  string
+	Err      error
+	Latency  time.Duration
+}
+
+// op is an operation and its setup and check.
+type op struct {
+	// setup creates the objects the operation needs, returning the
+	// generation of the object it acts on, if any.
+	setup func(ctx context.Context, b *storage.BucketHandle, name string) (int64, error)
+	run   func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error
+	// applied reports whether the operation had its effect. Read-only
+	// operations have none.
+	applied func(ctx context.Context, b *storage.BucketHandle, name string) (bool, error)
+}
+
+var (
+	smallData = []byte("hello, retries")
+	// bigData takes several chunks of a resumable upload.
+	bigData = bytes.Repeat([]byte("0123456789abcdef"), 40*1024)
+)
+
+const chunkSize = 256 * 1024
+
+var ops = map[string]op{
+	OpRead: {
+		setup: func(ctx context.Context, b *storage.BucketHandle, name string) (int64, error) {
+			return put(ctx, b.Object(name), smallData)
+		},
+		run: func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error {
+			r, err := b.Object(name).NewReader(ctx)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data, smallData) {
+				return fmt.Errorf("read %q, want %q", data, smallData)
+			}
+			return nil
+		},
+	},
+	OpWrite: {
+		run: func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error {
+			o := b.Object(name)
+			if conds {
+				o = o.If(storage.Conditions{DoesNotExist: true})
+			}
+			// A single-request upload is not buffered, so the client
+			// cannot retry it whatever the policy.
+			w := o.NewWriter(ctx)
+			w.ChunkSize = 0
+			return write(w, smallData)
+		},
+		applied: hasData(smallData),
+	},
+	OpResumable: {
+		run: func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error {
+			o := b.Object(name)
+			if conds {
+				o = o.If(storage.Conditions{DoesNotExist: true})
+			}
+			w := o.NewWriter(ctx)
+			w.ChunkSize = chunkSize
+			return write(w, bigData)
+		},
+		applied: hasData(bigData),
+	},
+	OpDelete: {
+		setup: func(ctx context.Context, b *storage.BucketHandle, name string) (int64, error) {
+			return put(ctx, b.Object(name), smallData)
+		},
+		run: func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error {
+			o := b.Object(name)
+			if conds {
+				o = o.If(storage.Conditions{GenerationMatch: gen})
+			}
+			return o.Delete(ctx)
+		},
+		applied: func(ctx context.Context, b *storage.BucketHandle, name string) (bool, error) {
+			_, err := b.Object(name).Attrs(ctx)
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				return true, nil
+			}
+			return false, err
+		},
+	},
+	OpCompose: {
+		setup: func(ctx context.Context, b *storage.BucketHandle, name string) (int64, error) {
+			half := len(smallData) / 2
+			if _, err := put(ctx, b.Object(name+".1"), smallData[:half]); err != nil {
+				return 0, err
+			}
+			return put(ctx, b.Object(name+".2"), smallData[half:])
+		},
+		run: func(ctx context.Context, b *storage.BucketHandle, name string, gen int64, conds bool) error {
+			dst := b.Object(name)
+			if conds {
+				dst = dst.If(storage.Conditions{DoesNotExist: true})
+			}
+			_, err := dst.ComposerFrom(b.Object(name+".1"), b.Object(name+".2")).Run(ctx)
+			return err
+		},
+		applied: hasData(smallData),
+	},
+}
+
+func put(ctx context.Context, o *storage.ObjectHandle, data []byte) (int64, error) {
+	w := o.NewWriter(ctx)
+	if err := write(w, data); err != nil {
+		return 0, err
+	}
+	return w.Attrs().Generation, nil
+}
+
+func write(w *storage.Writer, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func hasData(want []byte) func(context.Context, *storage.BucketHandle, string) (bool, error) {
+	return func(ctx context.Context, b *storage.BucketHandle, name string) (bool, error) {
+		r, err := b.Object(name).NewReader(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		return bytes.Equal(got, want), err
+	}
+}
+
+// Run runs each operation under each policy, with faults injected into
+// the requests of each operation, numbered from 1. It returns an error if
+// the clients cannot be created or an operation cannot be set up or
+// checked; failures of the operations themselves are results.
+func (h *Harness) Run(ctx context.Context, policies []Policy, opNames []string, faults map[int]Fault) ([]*Result, error) {
+	checker, err := storage.NewClient(ctx, h.ClientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer checker.Close()
+	base, err := htransport.NewTransport(ctx, http.DefaultTransport, h.ClientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("htransport.NewTransport: %w", err)
+	}
+	ft := &FaultTransport{Base: base}
+	opts := append(append([]option.ClientOption(nil), h.ClientOptions...), option.WithHTTPClient(&http.Client{Transport: ft}))
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	prefix := fmt.Sprintf("retry-harness-%d/", time.Now().UnixNano())
+	var results []*Result
+	for _, name := range opNames {
+		o, ok := ops[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+		// Count the requests of the operation without faults.
+		baseline, err := h.runOp(ctx, checker, client, ft, o, Policy{Name: "baseline"}, prefix+name+"/baseline", nil)
+		if err != nil {
+			return nil, err
+		}
+		if baseline.Err != nil {
+			return nil, fmt.Errorf("%s without faults: %w", name, baseline.Err)
+		}
+		for _, p := range policies {
+			res, err := h.runOp(ctx, checker, client, ft, o, p, prefix+name+"/"+p.Name, faults)
+			if err != nil {
+				return nil, err
+			}
+			res.Op = name
+			if res.Requests > baseline.Requests {
+				res.Retries = res.Requests - baseline.Requests
+			}
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+func (h *Harness) runOp(ctx context.Context, checker, client *storage.Client, ft *FaultTransport, o op, p Policy, name string, faults map[int]Fault) (*Result, error) {
+	var gen int64
+	if o.setup != nil {
+		var err error
+		if gen, err = o.setup(ctx, checker.Bucket(h.Bucket), name); err != nil {
+			return nil, fmt.Errorf("setting up %s: %w", name, err)
+		}
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ft.Reset(faults)
+	start := time.Now()
+	runErr := o.run(opCtx, client.Bucket(h.Bucket).Retryer(p.Options...), name, gen, h.Preconditions)
+	res := &Result{Policy: p.Name, Err: runErr, Latency: time.Since(start)}
+
+	var hit []string
+	for _, r := range ft.Requests() {
+		res.Requests++
+		if r.Fault != nil {
+			hit = append(hit, fmt.Sprintf("#%d %s", r.N, r.Fault))
+		}
+	}
+	res.Faults = strings.Join(hit, ", ")
+
+	applied := runErr == nil
+	if o.applied != nil {
+		var err error
+		if applied, err = o.applied(ctx, checker.Bucket(h.Bucket), name); err != nil {
+			return nil, fmt.Errorf("checking %s: %w", name, err)
+		}
+	}
+	switch {
+	case runErr == nil && applied:
+		res.Outcome = OutcomeOK
+	case runErr == nil:
+		res.Outcome = OutcomeLost
+	case applied:
+		res.Outcome = OutcomeAmbiguous
+	default:
+		res.Outcome = OutcomeFailed
+	}
+	return res, nil
+}
+
+// WriteReport writes the results as a table.
+func WriteReport(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POLICY\tOPERATION\tFAULTS\tREQUESTS\tRETRIES\tOUTCOME\tLATENCY\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+			if len(errText) > 60 {
+				errText = errText[:57] + "..."
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%v\t%s\n",
+			r.Policy, r.Op, r.Faults, r.Requests, r.Retries, r.Outcome, r.Latency.Round(time.Millisecond), errText)
+	}
+	return tw.Flush()
+}
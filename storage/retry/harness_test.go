@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	"github.com/googleapis/gax-go/v2"
+)
+
+var fast = storage.WithBackoff(gax.Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond})
+
+var policies = []Policy{
+	{Name: "idempotent", Options: []storage.RetryOption{fast}},
+	{Name: "always", Options: []storage.RetryOption{fast, storage.WithPolicy(storage.RetryAlways)}},
+	{Name: "never", Options: []storage.RetryOption{fast, storage.WithPolicy(storage.RetryNever)}},
+}
+
+func newHarness(t *testing.T) *Harness {
+	t.Helper()
+	fake := fakes.NewStorage(t)
+	fake.AddObject("b", "seed", nil)
+	return &Harness{Bucket: "b", ClientOptions: fake.ClientOptions(), Timeout: 10 * time.Second}
+}
+
+// outcomes maps policy and operation to outcome and retries.
+type outcomes map[string]struct {
+	outcome string
+	retries int
+}
+
+func check(t *testing.T, name string, results []*Result, want outcomes) {
+	t.Helper()
+	var buf bytes.Buffer
+	WriteReport(&buf, results)
+	for _, r := range results {
+		key := r.Policy + " " + r.Op
+		w, ok := want[key]
+		if !ok {
+			continue
+		}
+		if r.Outcome != w.outcome || r.Retries != w.retries {
+			t.Errorf("%s: %s: got %q with %d retries, want %q with %d\n%s", name, key, r.Outcome, r.Retries, w.outcome, w.retries, buf.String())
+		}
+	}
+}
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+	all := []string{OpRead, OpWrite, OpDelete, OpCompose, OpResumable}
+
+	h := newHarness(t)
+	results, err := h.Run(ctx, policies, all, map[int]Fault{1: Status(http.StatusServiceUnavailable)})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	check(t, "503", results, outcomes{
+		"idempotent read":   {OutcomeOK, 1},
+		"never read":        {OutcomeFailed, 0},
+		"idempotent delete": {OutcomeFailed, 0},
+		"always delete":     {OutcomeOK, 1},
+		"idempotent write":  {OutcomeFailed, 0},
+		"always compose":    {OutcomeOK, 1},
+	})
+
+	// With preconditions, writes and deletes are idempotent, and a lost
+	// response to a delete is detected rather than retried blindly.
+	h.Preconditions = true
+	results, err = h.Run(ctx, policies, all, map[int]Fault{1: {Kind: FaultStatus, Status: http.StatusTooManyRequests, Applied: true}})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	check(t, "429 applied, preconditions", results, outcomes{
+		"idempotent read":        {OutcomeOK, 1},
+		"idempotent delete":      {OutcomeAmbiguous, 1},
+		"never delete":           {OutcomeAmbiguous, 0},
+		"never resumable-upload": {OutcomeFailed, 0},
+		"idempotent write":       {OutcomeAmbiguous, 0},
+		"idempotent compose":     {OutcomeAmbiguous, 1},
+	})
+
+	h.Preconditions = false
+	results, err = h.Run(ctx, policies, []string{OpRead, OpResumable}, map[int]Fault{2: Reset()})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The uploader resends a chunk after a connection reset whatever the
+	// policy.
+	check(t, "reset", results, outcomes{
+		"always resumable-upload": {OutcomeOK, 1},
+		"never resumable-upload":  {OutcomeOK, 1},
+		"idempotent read":         {OutcomeOK, 0},
+	})
+
+	results, err = h.Run(ctx, policies, []string{OpRead}, map[int]Fault{
+		1: {Kind: FaultBodyReset},
+		2: {Kind: FaultSlowBody, Delay: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The reader resumes an interrupted body whatever the policy.
+	check(t, "body faults", results, outcomes{
+		"idempotent read": {OutcomeOK, 1},
+		"never read":      {OutcomeOK, 1},
+	})
+	for _, r := range results {
+		if r.Policy == "idempotent" && r.Latency < 50*time.Millisecond {
+			t.Errorf("body faults: got latency %v, want at least the 50ms slow body", r.Latency)
+		}
+	}
+}
+
+func TestFaultTransport(t *testing.T) {
+	srv := fakes.NewStorage(t)
+	ft := &FaultTransport{Faults: map[int]Fault{2: Status(http.StatusTooManyRequests), 3: Reset()}}
+	c := &http.Client{Transport: ft}
+	var statuses []int
+	for i := 0; i < 4; i++ {
+		resp, err := c.Get(srv.URL() + "/storage/v1/b")
+		if err != nil {
+			statuses = append(statuses, 0)
+			continue
+		}
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if want := []int{200, 429, 0, 200}; !equal(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+	reqs := ft.Requests()
+	if len(reqs) != 4 || reqs[1].Fault == nil || reqs[0].Fault != nil || reqs[2].Status != 0 {
+		t.Errorf("Requests: got %+v, want 4 with the faults recorded", reqs)
+	}
+	if n := srv.Count("GET /storage/v1/b"); n != 2 {
+		t.Errorf("server got %d requests, want 2: injected faults are not sent", n)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -18,16 +18,25 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"regexp"
 	"strings"
 	"testing"
 
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/replay"
 )
 
+// TestConfigureRetries runs against GOLANG_SAMPLES_PROJECT_ID. Run it with
+// -record to save testdata/replay/TestConfigureRetries.json, which is replayed
+// when no project is set.
 func TestConfigureRetries(t *testing.T) {
-	tc := testutil.SystemTest(t)
+	tc, s := replay.SystemTest(t)
 	ctx := context.Background()
+	// The sample creates its own client, so requests are recorded and replayed
+	// through STORAGE_EMULATOR_HOST.
+	s.SetStorageEmulatorHost(ctx)
+	s.AddScrubber(regexp.MustCompile(`storage-buckets-test-[0-9a-f-]{36}`), "storage-buckets-test-BUCKET")
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		t.Fatalf("storage.NewClient: %v", err)
@@ -0,0 +1,37 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3sdk uses the AWS SDK for Go with Cloud Storage through the XML
+// API in interoperability mode, authenticated with HMAC keys.
+//
+// The XML API implements enough of Amazon S3 for the operations here, with
+// these differences:
+//
+//   - Requests are signed for region "auto". Path-style and virtual-hosted
+//     bucket addressing both work; NewClient uses path style.
+//   - Multi-object delete (DeleteObjects) is not implemented and fails with
+//     NotImplemented. DeleteObjects here falls back to deleting objects one
+//     at a time.
+//   - Copying into an upload part (UploadPartCopy) is not implemented. Use
+//     CopyObject, which copies objects of any size in one request.
+//   - The ETag of an object from a multipart upload is not an MD5 of its
+//     data, and such objects have no MD5 hash in Cloud Storage: check them
+//     with their CRC32C, from the x-goog-hash header.
+//   - Object versions are generations, given in x-goog-generation headers
+//     rather than x-amz-version-id.
+//   - S3 server-side encryption and checksum headers (x-amz-server-side-
+//     encryption*, x-amz-checksum-*) are ignored or rejected; use the
+//     x-goog-encryption-* headers for customer-supplied keys.
+//   - Presigned URLs are limited to 7 days, as in S3.
+package s3sdk
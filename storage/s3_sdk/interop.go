@@ -0,0 +1,189 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Endpoint is the Cloud Storage XML API endpoint.
+const Endpoint = "https://storage.googleapis.com"
+
+// MinPartSize is the minimum size of all but the last part of a multipart
+// upload.
+const MinPartSize = s3manager.MinUploadPartSize
+
+// maxPresignExpiry is the longest a presigned URL may be valid.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// maxDeleteObjects is the most keys a DeleteObjects request may name.
+const maxDeleteObjects = 1000
+
+// NewClient returns an S3 client for the XML API at endpoint, usually
+// Endpoint, that signs requests with the HMAC key accessID and secret.
+func NewClient(endpoint, accessID, secret string) (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("auto"),
+		Endpoint:         aws.String(endpoint),
+		Credentials:      credentials.NewStaticCredentials(accessID, secret, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("session.NewSession: %w", err)
+	}
+	return s3.New(sess), nil
+}
+
+// UploadMultipart uploads r to bucket/key in parts of partSize bytes,
+// several at once, and returns the ETag of the object. Data smaller than a
+// part is uploaded in a single request. A failed upload is aborted.
+func UploadMultipart(ctx context.Context, client *s3.S3, bucket, key string, r io.Reader, partSize int64) (string, error) {
+	if partSize < MinPartSize {
+		return "", fmt.Errorf("part size %d is less than %d", partSize, MinPartSize)
+	}
+	u := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+	})
+	out, err := u.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Upload: %w", err)
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// PresignGet returns a URL that downloads bucket/key without credentials
+// until it expires.
+func PresignGet(client *s3.S3, bucket, key string, expires time.Duration) (string, error) {
+	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return presign(req, expires)
+}
+
+// PresignPut returns a URL that uploads bucket/key without credentials until
+// it expires. The upload must set the given Content-Type header, which is
+// signed.
+func PresignPut(client *s3.S3, bucket, key, contentType string, expires time.Duration) (string, error) {
+	req, _ := client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	return presign(req, expires)
+}
+
+func presign(req *request.Request, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maxPresignExpiry {
+		return "", fmt.Errorf("expiry %v is not between 0 and %v", expires, maxPresignExpiry)
+	}
+	u, err := req.Presign(expires)
+	if err != nil {
+		return "", fmt.Errorf("Presign: %w", err)
+	}
+	return u, nil
+}
+
+// CopyObject copies srcBucket/srcKey to dstBucket/dstKey, with its metadata.
+func CopyObject(ctx context.Context, client *s3.S3, srcBucket, srcKey, dstBucket, dstKey string) error {
+	_, err := client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String((&url.URL{Path: srcBucket + "/" + srcKey}).EscapedPath()),
+	})
+	if err != nil {
+		return fmt.Errorf("CopyObject: %w", err)
+	}
+	return nil
+}
+
+// DeleteObjects deletes keys from bucket and returns the number deleted. It
+// uses multi-object delete requests and, where they are not implemented as
+// in Cloud Storage, deletes the objects one at a time. Keys that do not
+// exist count as deleted. The error is that of the first key that failed.
+func DeleteObjects(ctx context.Context, client *s3.S3, bucket string, keys []string) (int, error) {
+	deleted, batch := 0, true
+	var firstErr error
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		chunk := keys[start:]
+		if len(chunk) > maxDeleteObjects {
+			chunk = chunk[:maxDeleteObjects]
+		}
+		if batch {
+			n, err := deleteBatch(ctx, client, bucket, chunk)
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && aerr.Code() == "NotImplemented" {
+				batch = false
+			} else {
+				deleted += n
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		for _, k := range chunk {
+			_, err := client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(k),
+			})
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("DeleteObject(%q): %w", k, err)
+				}
+				continue
+			}
+			deleted++
+		}
+	}
+	return deleted, firstErr
+}
+
+// deleteBatch deletes up to maxDeleteObjects keys in one request.
+func deleteBatch(ctx context.Context, client *s3.S3, bucket string, keys []string) (int, error) {
+	objs := make([]*s3.ObjectIdentifier, len(keys))
+	for i, k := range keys {
+		objs[i] = &s3.ObjectIdentifier{Key: aws.String(k)}
+	}
+	out, err := client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{Objects: objs, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("DeleteObjects: %w", err)
+	}
+	if len(out.Errors) > 0 {
+		e := out.Errors[0]
+		return len(keys) - len(out.Errors), fmt.Errorf("DeleteObjects(%q): %s: %s", aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+	}
+	return len(keys), nil
+}
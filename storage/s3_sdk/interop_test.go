@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func newFakeClient(t *testing.T) (*fakes.S3, *s3.S3) {
+	t.Helper()
+	fake := fakes.NewS3(t)
+	fake.AddObject("b", "seed", []byte("seed"))
+	client, err := NewClient(fake.URL(), fake.AccessKeyID, fake.SecretKey)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return fake, client
+}
+
+func TestUploadMultipart(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeClient(t)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), int(2*MinPartSize+1024)/16)
+	etag, err := UploadMultipart(ctx, client, "b", "dir/big object", bytes.NewReader(data), MinPartSize)
+	if err != nil {
+		t.Fatalf("UploadMultipart: %v", err)
+	}
+	if !strings.HasSuffix(etag, `-3"`) {
+		t.Errorf("UploadMultipart: got ETag %s, want one of a three-part upload", etag)
+	}
+	if got, _ := fake.Object("b", "dir/big object"); !bytes.Equal(got, data) {
+		t.Errorf("UploadMultipart: uploaded %d bytes, want %d", len(got), len(data))
+	}
+
+	// Small data is uploaded in one request.
+	fake.Reset()
+	if _, err := UploadMultipart(ctx, client, "b", "small", strings.NewReader("small"), MinPartSize); err != nil {
+		t.Fatalf("UploadMultipart: %v", err)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("UploadMultipart(small): made %d requests, want 1", n)
+	}
+
+	if _, err := UploadMultipart(ctx, client, "b", "x", strings.NewReader("x"), 1024); err == nil {
+		t.Errorf("UploadMultipart with 1 KiB parts: got success, want an error")
+	}
+}
+
+func TestPresign(t *testing.T) {
+	fake, client := newFakeClient(t)
+
+	u, err := PresignPut(client, "b", "uploaded.txt", "text/plain", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignPut: %v", err)
+	}
+	put := func(u, contentType string) int {
+		req, err := http.NewRequest(http.MethodPut, u, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put(u, "image/png"); code != http.StatusForbidden {
+		t.Errorf("PUT with another content type: got %d, want 403", code)
+	}
+	if code := put(u, "text/plain"); code != http.StatusOK {
+		t.Fatalf("PUT: got %d, want 200", code)
+	}
+
+	u, err = PresignGet(client, "b", "uploaded.txt", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignGet: %v", err)
+	}
+	get := func(u string) (int, string) {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	if code, body := get(u); code != http.StatusOK || body != "hello" {
+		t.Errorf("GET: got %d %q, want 200 %q", code, body, "hello")
+	}
+	if code, _ := get(strings.Replace(u, "uploaded.txt", "seed", 1)); code != http.StatusForbidden {
+		t.Errorf("GET of another object: got %d, want 403", code)
+	}
+	if _, ok := fake.Object("b", "uploaded.txt"); !ok {
+		t.Errorf("uploaded.txt not uploaded")
+	}
+	if _, err := PresignGet(client, "b", "seed", 8*24*time.Hour); err == nil {
+		t.Errorf("PresignGet for 8 days: got success, want an error")
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeClient(t)
+	fake.AddObject("src", "a dir/ünïcode+name", []byte("payload"))
+
+	if err := CopyObject(ctx, client, "src", "a dir/ünïcode+name", "b", "copy"); err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	if got, _ := fake.Object("b", "copy"); string(got) != "payload" {
+		t.Errorf("CopyObject: got %q, want %q", got, "payload")
+	}
+	if err := CopyObject(ctx, client, "src", "missing", "b", "copy2"); err == nil {
+		t.Errorf("CopyObject of a missing object: got success, want an error")
+	}
+}
+
+func TestDeleteObjects(t *testing.T) {
+	ctx := context.Background()
+	for _, gcs := range []bool{false, true} {
+		fake, client := newFakeClient(t)
+		fake.GCS = gcs
+		var keys []string
+		for i := 0; i < 1500; i++ {
+			k := fmt.Sprintf("obj-%04d", i)
+			fake.AddObject("b", k, nil)
+			keys = append(keys, k)
+		}
+		fake.Reset()
+		n, err := DeleteObjects(ctx, client, "b", append(keys, "missing"))
+		if err != nil || n != len(keys)+1 {
+			t.Errorf("GCS %v: DeleteObjects: got %d, %v, want %d deleted", gcs, n, err, len(keys)+1)
+		}
+		for _, k := range keys {
+			if _, ok := fake.Object("b", k); ok {
+				t.Errorf("GCS %v: %s not deleted", gcs, k)
+				break
+			}
+		}
+		// Cloud Storage refuses the first batch, then objects are deleted
+		// one at a time.
+		batches, singles := fake.Count("POST /b"), len(fake.Calls())-fake.Count("POST /b")
+		if want := map[bool][2]int{false: {2, 0}, true: {1, len(keys) + 1}}[gcs]; batches != want[0] || singles != want[1] {
+			t.Errorf("GCS %v: got %d batch and %d single requests, want %d and %d", gcs, batches, singles, want[0], want[1])
+		}
+	}
+
+	_, client := newFakeClient(t)
+	if _, err := DeleteObjects(ctx, client, "missing-bucket", []string{"a"}); err == nil {
+		t.Errorf("DeleteObjects in a missing bucket: got success, want an error")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// maxRequestSize limits the size of request bodies.
+const maxRequestSize = 64 << 10
+
+// Handler serves the service over HTTP, for browsers that upload directly
+// to Cloud Storage:
+//
+//	POST /sign    {"route": ..., "method": "PUT", "object": ..., "contentType": ...}
+//	POST /policy  {"route": ..., "object": ..., "contentType": ...}
+//
+// The responses are a SignedURL and a PostPolicy as JSON. Authenticate
+// callers before the handler, for example with middleware: anyone who can
+// reach it can upload to the routes' buckets.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/sign", s.serve(func(ctx context.Context, req *Request) (interface{}, error) {
+		return s.SignURL(ctx, req)
+	}))
+	mux.Handle("/policy", s.serve(func(ctx context.Context, req *Request) (interface{}, error) {
+		return s.PostPolicy(ctx, req)
+	}))
+	return mux
+}
+
+func (s *Service) serve(sign func(context.Context, *Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Request
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := sign(r.Context(), &req)
+		if err != nil {
+			code := errorCode(err)
+			if code == http.StatusInternalServerError {
+				log.Printf("signing: %v", err)
+				http.Error(w, "Could not sign", code)
+				return
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("json.Encode: %v", err)
+		}
+	})
+}
+
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownRoute):
+		return http.StatusNotFound
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidObject):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
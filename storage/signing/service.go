@@ -0,0 +1,273 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// DefaultMaxExpiry is the longest a URL or policy is valid for on a
+	// route without MaxExpiry.
+	DefaultMaxExpiry = 15 * time.Minute
+
+	// maxExpiry is the longest V4 signatures can be valid for.
+	maxExpiry = 7 * 24 * time.Hour
+)
+
+// Errors returned by Service, which the HTTP handler maps to status codes.
+var (
+	ErrUnknownRoute  = errors.New("unknown route")
+	ErrForbidden     = errors.New("not allowed on this route")
+	ErrInvalidObject = errors.New("invalid object name")
+)
+
+// Route is a set of objects that may be signed for, and the limits on what
+// is signed.
+type Route struct {
+	// Name identifies the route in requests.
+	Name string
+	// Bucket holds the objects, and Prefix is prepended to each object
+	// name, so that clients can only sign for objects under it.
+	Bucket string
+	Prefix string
+	// Methods are the allowed HTTP methods for signed URLs, e.g. GET or
+	// PUT. POST policies are allowed if Methods includes POST.
+	Methods []string
+	// MaxExpiry is the longest a URL or policy is valid for. Requests
+	// without an expiry get MaxExpiry. Defaults to DefaultMaxExpiry, and
+	// can be at most 7 days.
+	MaxExpiry time.Duration
+	// ContentTypes are the allowed content types of uploads. If empty, any
+	// content type is allowed.
+	ContentTypes []string
+	// MaxSize is the largest upload allowed by POST policies, in bytes. If
+	// zero, the size is not limited.
+	MaxSize int64
+}
+
+func (r *Route) allowsMethod(method string) bool {
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) allowsContentType(contentType string) bool {
+	if len(r.ContentTypes) == 0 {
+		return true
+	}
+	for _, ct := range r.ContentTypes {
+		if strings.EqualFold(ct, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) maxExpiry() time.Duration {
+	if r.MaxExpiry <= 0 {
+		return DefaultMaxExpiry
+	}
+	return r.MaxExpiry
+}
+
+// Request asks for a signed URL or POST policy.
+type Request struct {
+	Route  string `json:"route"`
+	Method string `json:"method,omitempty"`
+	// Object is the object name relative to the route's Prefix.
+	Object string `json:"object"`
+	// ContentType must be sent by the client with the upload. It is
+	// required for uploads on routes that limit content types.
+	ContentType string `json:"contentType,omitempty"`
+	// Expiry is how long the URL or policy is valid for, in seconds. If
+	// zero, the route's maximum is used.
+	Expiry int64 `json:"expiry,omitempty"`
+}
+
+// SignedURL is a signed URL, and the headers the client must send with it.
+type SignedURL struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Expires time.Time         `json:"expires"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// PostPolicy is a signed POST policy: an HTML form posting the fields,
+// followed by a file field, to URL uploads the file.
+type PostPolicy struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+}
+
+// Service signs URLs and POST policies for the objects of its routes.
+type Service struct {
+	Signer Signer
+	Routes []*Route
+}
+
+// Validate checks the configuration of the routes.
+func (s *Service) Validate() error {
+	if s.Signer == nil {
+		return errors.New("no signer")
+	}
+	names := make(map[string]bool)
+	for _, r := range s.Routes {
+		switch {
+		case r.Name == "":
+			return errors.New("route without a name")
+		case names[r.Name]:
+			return fmt.Errorf("route %q: duplicate name", r.Name)
+		case r.Bucket == "":
+			return fmt.Errorf("route %q: no bucket", r.Name)
+		case len(r.Methods) == 0:
+			return fmt.Errorf("route %q: no methods", r.Name)
+		case r.MaxExpiry > maxExpiry:
+			return fmt.Errorf("route %q: MaxExpiry %v is longer than %v", r.Name, r.MaxExpiry, maxExpiry)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// SignURL returns a V4 signed URL for req. Uploads are bound to the
+// requested content type, which the client must send.
+func (s *Service) SignURL(ctx context.Context, req *Request) (*SignedURL, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method == http.MethodPost {
+		return nil, fmt.Errorf("%w: use a POST policy for POST uploads", ErrForbidden)
+	}
+	r, object, expiry, err := s.check(req, method)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(expiry)
+	opts := &storage.SignedURLOptions{
+		Scheme:         storage.SigningSchemeV4,
+		GoogleAccessID: s.Signer.AccessID(),
+		SignBytes: func(b []byte) ([]byte, error) {
+			return s.Signer.Sign(ctx, b)
+		},
+		Method:  method,
+		Expires: expires,
+	}
+	var headers map[string]string
+	if method == http.MethodPut && req.ContentType != "" {
+		opts.ContentType = req.ContentType
+		headers = map[string]string{"Content-Type": req.ContentType}
+	}
+	u, err := storage.SignedURL(r.Bucket, object, opts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.SignedURL: %w", err)
+	}
+	return &SignedURL{URL: u, Method: method, Expires: expires, Headers: headers}, nil
+}
+
+// PostPolicy returns a V4 POST policy for req, limited to the route's
+// MaxSize and bound to the requested content type.
+func (s *Service) PostPolicy(ctx context.Context, req *Request) (*PostPolicy, error) {
+	r, object, expiry, err := s.check(req, http.MethodPost)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(expiry)
+	opts := &storage.PostPolicyV4Options{
+		GoogleAccessID: s.Signer.AccessID(),
+		SignRawBytes: func(b []byte) ([]byte, error) {
+			return s.Signer.Sign(ctx, b)
+		},
+		Expires: expires,
+		Fields:  &storage.PolicyV4Fields{ContentType: req.ContentType},
+	}
+	if r.MaxSize > 0 {
+		opts.Conditions = append(opts.Conditions, storage.ConditionContentLengthRange(0, uint64(r.MaxSize)))
+	}
+	p, err := storage.GenerateSignedPostPolicyV4(r.Bucket, object, opts)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GenerateSignedPostPolicyV4: %w", err)
+	}
+	return &PostPolicy{URL: p.URL, Fields: p.Fields, Expires: expires}, nil
+}
+
+// check finds the route of req and checks req against it. It returns the
+// route, the full object name and the expiry.
+func (s *Service) check(req *Request, method string) (*Route, string, time.Duration, error) {
+	var r *Route
+	for _, rr := range s.Routes {
+		if rr.Name == req.Route {
+			r = rr
+			break
+		}
+	}
+	if r == nil {
+		return nil, "", 0, fmt.Errorf("%w: %q", ErrUnknownRoute, req.Route)
+	}
+	if !r.allowsMethod(method) {
+		return nil, "", 0, fmt.Errorf("%w: method %s", ErrForbidden, method)
+	}
+	if err := validObject(req.Object); err != nil {
+		return nil, "", 0, err
+	}
+	if method != http.MethodGet && method != http.MethodHead && method != http.MethodDelete && !r.allowsContentType(req.ContentType) {
+		return nil, "", 0, fmt.Errorf("%w: content type %q", ErrForbidden, req.ContentType)
+	}
+	expiry := time.Duration(req.Expiry) * time.Second
+	switch {
+	case expiry < 0:
+		return nil, "", 0, fmt.Errorf("%w: negative expiry", ErrForbidden)
+	case expiry == 0:
+		expiry = r.maxExpiry()
+	case expiry > r.maxExpiry():
+		return nil, "", 0, fmt.Errorf("%w: expiry %v is longer than %v", ErrForbidden, expiry, r.maxExpiry())
+	}
+	return r, r.Prefix + req.Object, expiry, nil
+}
+
+// validObject rejects object names that are not valid in Cloud Storage, or
+// that could refer to objects outside a route's prefix when the bucket is
+// served as a website or mirrored to disk.
+func validObject(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty", ErrInvalidObject)
+	case len(name) > 1024:
+		return fmt.Errorf("%w: longer than 1024 bytes", ErrInvalidObject)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("%w: not UTF-8", ErrInvalidObject)
+	case strings.ContainsAny(name, "\r\n"):
+		return fmt.Errorf("%w: contains a line break", ErrInvalidObject)
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidObject, name)
+		}
+	}
+	return nil
+}
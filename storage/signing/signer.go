@@ -0,0 +1,171 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signing issues V4 signed URLs and POST policies for Cloud Storage
+// objects, within limits set per route, and serves them over HTTP so that
+// browsers can upload directly to a bucket.
+//
+// Signatures are made by a Signer: a service account key, the IAM
+// Credentials API, or a key generated for tests.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// Signer signs bytes with the key of a service account, using RSA
+// PKCS #1 v1.5 with SHA-256.
+type Signer interface {
+	// AccessID is the email address of the service account.
+	AccessID() string
+	Sign(ctx context.Context, b []byte) ([]byte, error)
+}
+
+// KeySigner signs with a private key held in memory.
+type KeySigner struct {
+	accessID string
+	key      *rsa.PrivateKey
+}
+
+// NewPEMSigner returns a signer for the service account accessID with a
+// PEM-encoded PKCS #1 or PKCS #8 RSA private key.
+func NewPEMSigner(accessID string, pemKey []byte) (*KeySigner, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return &KeySigner{accessID: accessID, key: key}, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("got %T private key, want RSA", key)
+	}
+	return &KeySigner{accessID: accessID, key: rsaKey}, nil
+}
+
+// NewJSONKeySigner returns a signer for a service account JSON key file,
+// as downloaded from the Google Cloud console.
+func NewJSONKeySigner(jsonKey []byte) (*KeySigner, error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(jsonKey, &key); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, errors.New("not a service account key: missing client_email or private_key")
+	}
+	return NewPEMSigner(key.ClientEmail, []byte(key.PrivateKey))
+}
+
+// AccessID implements Signer.
+func (s *KeySigner) AccessID() string { return s.accessID }
+
+// Sign implements Signer.
+func (s *KeySigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
+	sum := sha256.Sum256(b)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+}
+
+// IAMSigner signs with the IAM Credentials API, so that no key leaves the
+// service account. The caller needs the iam.serviceAccounts.signBlob
+// permission on it, e.g. through roles/iam.serviceAccountTokenCreator.
+type IAMSigner struct {
+	serviceAccount string
+	svc            *iamcredentials.Service
+}
+
+// NewIAMSigner returns a signer for the service account with the given
+// email address.
+func NewIAMSigner(ctx context.Context, serviceAccount string, opts ...option.ClientOption) (*IAMSigner, error) {
+	svc, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("iamcredentials.NewService: %w", err)
+	}
+	return &IAMSigner{serviceAccount: serviceAccount, svc: svc}, nil
+}
+
+// AccessID implements Signer.
+func (s *IAMSigner) AccessID() string { return s.serviceAccount }
+
+// Sign implements Signer.
+func (s *IAMSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
+	name := "projects/-/serviceAccounts/" + s.serviceAccount
+	resp, err := s.svc.Projects.ServiceAccounts.SignBlob(name, &iamcredentials.SignBlobRequest{
+		Payload: base64.StdEncoding.EncodeToString(b),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("SignBlob: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.SignedBlob)
+}
+
+// TestSigner signs with a key generated on creation, and records what it
+// signs so tests can check signatures.
+type TestSigner struct {
+	KeySigner
+
+	mu     sync.Mutex
+	signed [][]byte
+}
+
+// NewTestSigner returns a signer with a new 2048-bit key.
+func NewTestSigner(accessID string) (*TestSigner, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &TestSigner{KeySigner: KeySigner{accessID: accessID, key: key}}, nil
+}
+
+// Sign implements Signer.
+func (s *TestSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
+	s.mu.Lock()
+	s.signed = append(s.signed, append([]byte(nil), b...))
+	s.mu.Unlock()
+	return s.KeySigner.Sign(ctx, b)
+}
+
+// Signed returns the byte strings signed so far.
+func (s *TestSigner) Signed() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.signed...)
+}
+
+// Verify checks that sig is a signature of b by this signer.
+func (s *TestSigner) Verify(b, sig []byte) error {
+	sum := sha256.Sum256(b)
+	return rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, sum[:], sig)
+}
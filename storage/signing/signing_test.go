@@ -0,0 +1,357 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+const accessID = "signer@my-project.iam.gserviceaccount.com"
+
+func newService(t *testing.T) (*Service, *TestSigner) {
+	t.Helper()
+	signer, err := NewTestSigner(accessID)
+	if err != nil {
+		t.Fatalf("NewTestSigner: %v", err)
+	}
+	s := &Service{
+		Signer: signer,
+		Routes: []*Route{
+			{Name: "download", Bucket: "b", Prefix: "public/", Methods: []string{"GET"}, MaxExpiry: time.Hour},
+			{Name: "upload", Bucket: "b", Prefix: "uploads/", Methods: []string{"PUT", "POST"}, ContentTypes: []string{"image/png", "image/jpeg"}, MaxSize: 1 << 20},
+		},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	return s, signer
+}
+
+func TestSignURL(t *testing.T) {
+	ctx := context.Background()
+	s, signer := newService(t)
+	tests := []struct {
+		req         Request
+		wantErr     error
+		wantPath    string
+		wantExpires int
+		wantHeaders string
+	}{
+		{
+			req:         Request{Route: "download", Object: "a/b.txt"},
+			wantPath:    "/b/public/a/b.txt",
+			wantExpires: 3600,
+			wantHeaders: "host",
+		},
+		{
+			req:         Request{Route: "download", Method: "get", Object: "b.txt", Expiry: 60},
+			wantPath:    "/b/public/b.txt",
+			wantExpires: 60,
+			wantHeaders: "host",
+		},
+		{
+			req:         Request{Route: "upload", Method: "PUT", Object: "x.png", ContentType: "image/png"},
+			wantPath:    "/b/uploads/x.png",
+			wantExpires: 900,
+			wantHeaders: "content-type;host",
+		},
+		{req: Request{Route: "nope", Object: "b.txt"}, wantErr: ErrUnknownRoute},
+		{req: Request{Route: "download", Method: "PUT", Object: "b.txt"}, wantErr: ErrForbidden},
+		{req: Request{Route: "download", Object: "b.txt", Expiry: 7200}, wantErr: ErrForbidden},
+		{req: Request{Route: "download", Object: "../secret.txt"}, wantErr: ErrInvalidObject},
+		{req: Request{Route: "download", Object: ""}, wantErr: ErrInvalidObject},
+		{req: Request{Route: "upload", Method: "PUT", Object: "x.exe", ContentType: "application/octet-stream"}, wantErr: ErrForbidden},
+		{req: Request{Route: "upload", Method: "PUT", Object: "x.png"}, wantErr: ErrForbidden},
+		{req: Request{Route: "upload", Method: "POST", Object: "x.png", ContentType: "image/png"}, wantErr: ErrForbidden},
+	}
+	for _, tc := range tests {
+		got, err := s.SignURL(ctx, &tc.req)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("SignURL(%+v): got error %v, want %v", tc.req, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SignURL(%+v): %v", tc.req, err)
+			continue
+		}
+		u, err := url.Parse(got.URL)
+		if err != nil {
+			t.Errorf("SignURL(%+v): url.Parse: %v", tc.req, err)
+			continue
+		}
+		q := u.Query()
+		if u.Path != tc.wantPath {
+			t.Errorf("SignURL(%+v): got path %q, want %q", tc.req, u.Path, tc.wantPath)
+		}
+		// The client library signs a second or so after the expiry is set.
+		if got, _ := strconv.Atoi(q.Get("X-Goog-Expires")); got < tc.wantExpires-2 || got > tc.wantExpires {
+			t.Errorf("SignURL(%+v): got X-Goog-Expires %d, want about %d", tc.req, got, tc.wantExpires)
+		}
+		if got := q.Get("X-Goog-SignedHeaders"); got != tc.wantHeaders {
+			t.Errorf("SignURL(%+v): got X-Goog-SignedHeaders %q, want %q", tc.req, got, tc.wantHeaders)
+		}
+		if got := q.Get("X-Goog-Credential"); !strings.HasPrefix(got, accessID+"/") {
+			t.Errorf("SignURL(%+v): got X-Goog-Credential %q, want prefix %q", tc.req, got, accessID)
+		}
+		if tc.req.ContentType != "" && got.Headers["Content-Type"] != tc.req.ContentType {
+			t.Errorf("SignURL(%+v): got headers %v, want Content-Type %s", tc.req, got.Headers, tc.req.ContentType)
+		}
+		sig, err := hex.DecodeString(q.Get("X-Goog-Signature"))
+		if err != nil {
+			t.Errorf("SignURL(%+v): bad signature: %v", tc.req, err)
+			continue
+		}
+		signed := signer.Signed()
+		if err := signer.Verify(signed[len(signed)-1], sig); err != nil {
+			t.Errorf("SignURL(%+v): Verify: %v", tc.req, err)
+		}
+	}
+}
+
+func TestPostPolicy(t *testing.T) {
+	ctx := context.Background()
+	s, signer := newService(t)
+	start := time.Now()
+	p, err := s.PostPolicy(ctx, &Request{Route: "upload", Object: "x.png", ContentType: "image/png", Expiry: 300})
+	if err != nil {
+		t.Fatalf("PostPolicy: %v", err)
+	}
+	if min, max := start.Add(5*time.Minute), time.Now().Add(5*time.Minute); p.Expires.Before(min) || p.Expires.After(max) {
+		t.Errorf("PostPolicy: got expiry %v, want between %v and %v", p.Expires, min, max)
+	}
+	for k, want := range map[string]string{
+		"key":              "uploads/x.png",
+		"content-type":     "image/png",
+		"x-goog-algorithm": "GOOG4-RSA-SHA256",
+	} {
+		if got := p.Fields[k]; got != want {
+			t.Errorf("PostPolicy: got field %s = %q, want %q", k, got, want)
+		}
+	}
+	policy, err := base64.StdEncoding.DecodeString(p.Fields["policy"])
+	if err != nil {
+		t.Fatalf("PostPolicy: bad policy: %v", err)
+	}
+	if !bytes.Contains(policy, []byte(`["content-length-range",0,1048576]`)) {
+		t.Errorf("PostPolicy: policy %s has no content-length-range condition", policy)
+	}
+	sig, err := hex.DecodeString(p.Fields["x-goog-signature"])
+	if err != nil {
+		t.Fatalf("PostPolicy: bad signature: %v", err)
+	}
+	if err := signer.Verify([]byte(p.Fields["policy"]), sig); err != nil {
+		t.Errorf("PostPolicy: Verify: %v", err)
+	}
+
+	for _, req := range []Request{
+		{Route: "download", Object: "x.png"},
+		{Route: "upload", Object: "x.gif", ContentType: "image/gif"},
+	} {
+		if _, err := s.PostPolicy(ctx, &req); !errors.Is(err, ErrForbidden) {
+			t.Errorf("PostPolicy(%+v): got error %v, want %v", req, err, ErrForbidden)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	signer, err := NewTestSigner(accessID)
+	if err != nil {
+		t.Fatalf("NewTestSigner: %v", err)
+	}
+	tests := []struct {
+		name   string
+		routes []*Route
+	}{
+		{"no name", []*Route{{Bucket: "b", Methods: []string{"GET"}}}},
+		{"duplicate", []*Route{{Name: "r", Bucket: "b", Methods: []string{"GET"}}, {Name: "r", Bucket: "b", Methods: []string{"GET"}}}},
+		{"no bucket", []*Route{{Name: "r", Methods: []string{"GET"}}}},
+		{"no methods", []*Route{{Name: "r", Bucket: "b"}}},
+		{"expiry", []*Route{{Name: "r", Bucket: "b", Methods: []string{"GET"}, MaxExpiry: 8 * 24 * time.Hour}}},
+	}
+	for _, tc := range tests {
+		s := &Service{Signer: signer, Routes: tc.routes}
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: Validate: got nil error, want error", tc.name)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	s, _ := newService(t)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/sign", `{"route": "download", "object": "a.txt"}`, http.StatusOK},
+		{"POST", "/policy", `{"route": "upload", "object": "a.png", "contentType": "image/png"}`, http.StatusOK},
+		{"GET", "/sign", "", http.StatusMethodNotAllowed},
+		{"POST", "/sign", `{"route": "download"`, http.StatusBadRequest},
+		{"POST", "/sign", `{"route": "download", "object": "a.txt", "bucket": "other"}`, http.StatusBadRequest},
+		{"POST", "/sign", `{"route": "download", "object": "../a.txt"}`, http.StatusBadRequest},
+		{"POST", "/sign", `{"route": "nope", "object": "a.txt"}`, http.StatusNotFound},
+		{"POST", "/policy", `{"route": "download", "object": "a.txt"}`, http.StatusForbidden},
+		{"POST", "/other", `{}`, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.method, tc.path, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s %s: got status %d, want %d (%s)", tc.method, tc.path, tc.body, resp.StatusCode, tc.want, body)
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			var got struct{ URL string }
+			if err := json.Unmarshal(body, &got); err != nil || got.URL == "" {
+				t.Errorf("%s %s: got body %s, want JSON with a URL", tc.method, tc.path, body)
+			}
+		}
+	}
+}
+
+func TestNewPEMSigner(t *testing.T) {
+	ctx := context.Background()
+	ts, err := NewTestSigner(accessID)
+	if err != nil {
+		t.Fatalf("NewTestSigner: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ts.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(ts.key)})
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	jsonKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": accessID,
+		"private_key":  string(pkcs8PEM),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]func() (*KeySigner, error){
+		"PKCS1": func() (*KeySigner, error) { return NewPEMSigner(accessID, pkcs1PEM) },
+		"PKCS8": func() (*KeySigner, error) { return NewPEMSigner(accessID, pkcs8PEM) },
+		"JSON":  func() (*KeySigner, error) { return NewJSONKeySigner(jsonKey) },
+	}
+	for name, newSigner := range signers {
+		s, err := newSigner()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if s.AccessID() != accessID {
+			t.Errorf("%s: got AccessID %q, want %q", name, s.AccessID(), accessID)
+		}
+		sig, err := s.Sign(ctx, []byte("data"))
+		if err != nil {
+			t.Errorf("%s: Sign: %v", name, err)
+			continue
+		}
+		if err := ts.Verify([]byte("data"), sig); err != nil {
+			t.Errorf("%s: Verify: %v", name, err)
+		}
+	}
+
+	if _, err := NewPEMSigner(accessID, []byte("not a key")); err == nil {
+		t.Errorf("NewPEMSigner(garbage): got nil error, want error")
+	}
+	if _, err := NewJSONKeySigner([]byte(`{"type": "authorized_user"}`)); err == nil {
+		t.Errorf("NewJSONKeySigner(user credentials): got nil error, want error")
+	}
+}
+
+func TestIAMSigner(t *testing.T) {
+	ctx := context.Background()
+	ts, err := NewTestSigner(accessID)
+	if err != nil {
+		t.Fatalf("NewTestSigner: %v", err)
+	}
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		var req iamcredentials.SignBlobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, err := base64.StdEncoding.DecodeString(req.Payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig, err := ts.Sign(r.Context(), payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(&iamcredentials.SignBlobResponse{
+			KeyId:      "key",
+			SignedBlob: base64.StdEncoding.EncodeToString(sig),
+		})
+	}))
+	defer srv.Close()
+
+	s, err := NewIAMSigner(ctx, accessID, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("NewIAMSigner: %v", err)
+	}
+	sig, err := s.Sign(ctx, []byte("data"))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if want := "/v1/projects/-/serviceAccounts/" + accessID + ":signBlob"; gotPath != want {
+		t.Errorf("Sign: got request to %q, want %q", gotPath, want)
+	}
+	if err := ts.Verify([]byte("data"), sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+)
+
+// Downloader downloads objects as concurrent byte-range slices.
+type Downloader struct {
+	Client *storage.Client
+	// SliceSize is the size of each byte range. It defaults to 32 MiB.
+	SliceSize int64
+	// Concurrency is the number of slices downloaded at once. It defaults
+	// to 8.
+	Concurrency int
+}
+
+// Download downloads the object to the file dst. The data is written to a
+// temporary file next to dst, which is renamed to dst once the CRC32C
+// checksum of the whole object is verified. All slices are read from the
+// generation of the object current when Download starts.
+func (d *Downloader) Download(ctx context.Context, bucket, object, dst string) (*storage.ObjectAttrs, error) {
+	obj := d.Client.Bucket(bucket).Object(object)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).Attrs: %w", object, err)
+	}
+	obj = obj.Generation(attrs.Generation)
+
+	tmp := dst + ".download"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("os.Create: %w", err)
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	// Preallocate the file so slices can be written at their offsets.
+	if err := f.Truncate(attrs.Size); err != nil {
+		return nil, fmt.Errorf("Truncate: %w", err)
+	}
+
+	offsets, lengths := parts(attrs.Size, partSize(d.SliceSize))
+	crcs := make([]uint32, len(offsets))
+	err = forEach(ctx, len(offsets), concurrency(d.Concurrency), func(ctx context.Context, i int) error {
+		if lengths[i] == 0 {
+			return nil
+		}
+		r, err := obj.NewRangeReader(ctx, offsets[i], lengths[i])
+		if err != nil {
+			return fmt.Errorf("NewRangeReader(%d, %d): %w", offsets[i], lengths[i], err)
+		}
+		defer r.Close()
+		h := crc32.New(castagnoli)
+		n, err := io.Copy(io.MultiWriter(&offsetWriter{f, offsets[i]}, h), r)
+		if err != nil {
+			return fmt.Errorf("read bytes %d-%d: %w", offsets[i], offsets[i]+lengths[i]-1, err)
+		}
+		if n != lengths[i] {
+			return fmt.Errorf("read bytes %d-%d: got %d bytes, want %d", offsets[i], offsets[i]+lengths[i]-1, n, lengths[i])
+		}
+		crcs[i] = h.Sum32()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var crc uint32
+	for i, c := range crcs {
+		crc = crc32cCombine(crc, c, lengths[i])
+	}
+	if crc != attrs.CRC32C {
+		return nil, fmt.Errorf("CRC32C mismatch: got %08x, want %08x", crc, attrs.CRC32C)
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("Close: %w", err)
+	}
+	f = nil
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("os.Rename: %w", err)
+	}
+	return attrs, nil
+}
+
+// offsetWriter writes to w starting at off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+func partSize(n int64) int64 {
+	if n > 0 {
+		return n
+	}
+	return defaultPartSize
+}
+
+func concurrency(n int) int {
+	if n > 0 {
+		return n
+	}
+	return defaultConcurrency
+}
@@ -0,0 +1,426 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// MtimeKey is the object metadata key holding the modification time of the
+// file an object was uploaded from, in seconds since the epoch, as set by
+// gsutil.
+const MtimeKey = "goog-reserved-file-mtime"
+
+// Sync operations and the reasons for them.
+const (
+	OpUpload   = "upload"
+	OpDownload = "download"
+	OpDelete   = "delete"
+
+	ReasonNew      = "new"
+	ReasonSize     = "size"
+	ReasonChecksum = "checksum"
+	ReasonMtime    = "mtime"
+	ReasonExtra    = "extra"
+)
+
+// Syncer synchronizes a local directory and the objects under a bucket
+// prefix, like rsync: files that are missing or differ at the destination
+// are copied, and with Delete, files only at the destination are deleted.
+//
+// Files differ if their sizes differ, or if their checksums differ: MD5 if
+// the object has one, CRC32C otherwise. With UseMtime, files of the same
+// size are instead compared by the modification time recorded in the
+// object's metadata under MtimeKey, which avoids reading local files;
+// objects without it are compared by checksum.
+//
+// A prefix names a directory: "out" and "out/" both mean the objects under
+// "out/", and never "output/x". An empty prefix means the whole bucket.
+// Objects whose names are not clean paths relative to the prefix, such as
+// "a//b" or "../b", are ignored, as are the temporary objects of uploads in
+// progress under ".parallel-upload/", the default Uploader.TempPrefix, unless
+// the prefix is under it.
+type Syncer struct {
+	Client *storage.Client
+	// Delete deletes files at the destination that are not at the source,
+	// making the destination a mirror of the source. Files excluded by
+	// Include and Exclude are never deleted. Exclude an Uploader's
+	// TempPrefix other than the default to keep its uploads resumable.
+	Delete bool
+	// DryRun reports what would be done without doing it.
+	DryRun bool
+	// UseMtime compares files by modification time instead of checksum.
+	UseMtime bool
+	// Include and Exclude are path.Match patterns for the slash-separated
+	// paths of files relative to the directory or prefix. Patterns without
+	// a slash match the base name. If Include is empty, all files are
+	// included. Exclude takes precedence.
+	Include []string
+	Exclude []string
+	// Concurrency is the number of files copied or deleted at once. It
+	// defaults to 8.
+	Concurrency int
+}
+
+// SyncAction is a file copied or deleted by a sync.
+type SyncAction struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+	Err    error  `json:"-"`
+}
+
+// SyncResult reports a sync.
+type SyncResult struct {
+	// Actions are sorted by path.
+	Actions []*SyncAction
+	// Unchanged is the number of files that were already in sync.
+	Unchanged int
+}
+
+// Failed returns the actions that failed.
+func (r *SyncResult) Failed() []*SyncAction {
+	var failed []*SyncAction
+	for _, a := range r.Actions {
+		if a.Err != nil {
+			failed = append(failed, a)
+		}
+	}
+	return failed
+}
+
+// entry is a file in a directory or bucket.
+type entry struct {
+	size    int64
+	mtime   time.Time // Zero for objects without MtimeKey.
+	crc32c  uint32
+	md5     []byte
+	hasHash bool // Whether crc32c and md5 are set.
+}
+
+// Upload synchronizes the objects under prefix in bucket with the files in
+// dir. The result lists the actions taken, or that would be taken with
+// DryRun; failed actions have their Err set, and an error is returned.
+func (s *Syncer) Upload(ctx context.Context, dir, bucket, prefix string) (*SyncResult, error) {
+	prefix = dirPrefix(prefix)
+	local, err := s.listLocal(dir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := s.listRemote(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	b := s.Client.Bucket(bucket)
+	return s.run(ctx, dir, local, remote, OpUpload, func(ctx context.Context, a *SyncAction) error {
+		name := prefix + a.Path
+		if a.Op == OpDelete {
+			return b.Object(name).Delete(ctx)
+		}
+		return uploadFile(ctx, b.Object(name), filepath.Join(dir, filepath.FromSlash(a.Path)))
+	})
+}
+
+// Download synchronizes the files in dir with the objects under prefix in
+// bucket. See Upload.
+func (s *Syncer) Download(ctx context.Context, bucket, prefix, dir string) (*SyncResult, error) {
+	prefix = dirPrefix(prefix)
+	remote, err := s.listRemote(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	local, err := s.listLocal(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	b := s.Client.Bucket(bucket)
+	return s.run(ctx, dir, remote, local, OpDownload, func(ctx context.Context, a *SyncAction) error {
+		file := filepath.Join(dir, filepath.FromSlash(a.Path))
+		if a.Op == OpDelete {
+			return os.Remove(file)
+		}
+		return downloadFile(ctx, b.Object(prefix+a.Path), file)
+	})
+}
+
+// run compares src and dst, and then performs the resulting actions with do.
+func (s *Syncer) run(ctx context.Context, dir string, src, dst map[string]*entry, op string, do func(context.Context, *SyncAction) error) (*SyncResult, error) {
+	res := &SyncResult{}
+	for p, se := range src {
+		de, ok := dst[p]
+		reason := ""
+		switch {
+		case !ok:
+			reason = ReasonNew
+		case se.size != de.size:
+			reason = ReasonSize
+		default:
+			var err error
+			if reason, err = s.compare(dir, p, se, de); err != nil {
+				return nil, err
+			}
+		}
+		if reason == "" {
+			res.Unchanged++
+			continue
+		}
+		res.Actions = append(res.Actions, &SyncAction{Op: op, Path: p, Size: se.size, Reason: reason})
+	}
+	if s.Delete {
+		for p, de := range dst {
+			if _, ok := src[p]; !ok {
+				res.Actions = append(res.Actions, &SyncAction{Op: OpDelete, Path: p, Size: de.size, Reason: ReasonExtra})
+			}
+		}
+	}
+	sort.Slice(res.Actions, func(i, j int) bool { return res.Actions[i].Path < res.Actions[j].Path })
+	if s.DryRun {
+		return res, nil
+	}
+
+	forEach(ctx, len(res.Actions), concurrency(s.Concurrency), func(ctx context.Context, i int) error {
+		a := res.Actions[i]
+		a.Err = do(ctx, a)
+		return nil
+	})
+	if failed := res.Failed(); len(failed) > 0 {
+		return res, fmt.Errorf("%d of %d files failed to sync; first: %s %s: %w", len(failed), len(res.Actions), failed[0].Op, failed[0].Path, failed[0].Err)
+	}
+	return res, ctx.Err()
+}
+
+// compare returns the reason two entries of the same size differ, or "" if
+// they don't. One of them is a local file under dir.
+func (s *Syncer) compare(dir, p string, a, b *entry) (string, error) {
+	if s.UseMtime && !a.mtime.IsZero() && !b.mtime.IsZero() {
+		if a.mtime.Unix() != b.mtime.Unix() {
+			return ReasonMtime, nil
+		}
+		return "", nil
+	}
+	for _, e := range []*entry{a, b} {
+		if !e.hasHash {
+			if err := e.hashFile(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+				return "", err
+			}
+		}
+	}
+	if a.md5 != nil && b.md5 != nil {
+		if string(a.md5) != string(b.md5) {
+			return ReasonChecksum, nil
+		}
+		return "", nil
+	}
+	if a.crc32c != b.crc32c {
+		return ReasonChecksum, nil
+	}
+	return "", nil
+}
+
+func (e *entry) hashFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, m := crc32.New(castagnoli), md5.New()
+	if _, err := io.Copy(io.MultiWriter(c, m), f); err != nil {
+		return fmt.Errorf("read %s: %w", file, err)
+	}
+	e.crc32c, e.md5, e.hasHash = c.Sum32(), m.Sum(nil), true
+	return nil
+}
+
+// match reports whether the file at p is included by the filters.
+func (s *Syncer) match(p string) bool {
+	matches := func(patterns []string) bool {
+		for _, pat := range patterns {
+			name := p
+			if !strings.Contains(pat, "/") {
+				name = path.Base(p)
+			}
+			if ok, _ := path.Match(pat, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(s.Include) > 0 && !matches(s.Include) {
+		return false
+	}
+	return !matches(s.Exclude)
+}
+
+// validate checks the filter patterns.
+func (s *Syncer) validate() error {
+	for _, pat := range append(append([]string(nil), s.Include...), s.Exclude...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pat, err)
+		}
+	}
+	return nil
+}
+
+// listLocal returns the regular files under dir, by slash-separated path.
+func (s *Syncer) listLocal(dir string) (map[string]*entry, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	files := make(map[string]*entry)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		if !s.match(p) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[p] = &entry{size: fi.Size(), mtime: fi.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// dirPrefix returns prefix ending in a slash, unless it is empty.
+func dirPrefix(prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+	return prefix + "/"
+}
+
+// listRemote returns the objects under prefix, by path relative to prefix.
+func (s *Syncer) listRemote(ctx context.Context, bucket, prefix string) (map[string]*entry, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	objects := make(map[string]*entry)
+	it := s.Client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).Objects: %w", bucket, err)
+		}
+		if strings.HasPrefix(attrs.Name, defaultTempPrefix) && !strings.HasPrefix(prefix, defaultTempPrefix) {
+			continue
+		}
+		p := strings.TrimPrefix(attrs.Name, prefix)
+		// Skip "directory" placeholders, and names that are not clean
+		// relative paths, which could escape the directory.
+		if p == "" || strings.HasSuffix(p, "/") || !localPath(p) || !s.match(p) {
+			continue
+		}
+		e := &entry{size: attrs.Size, crc32c: attrs.CRC32C, md5: attrs.MD5, hasHash: true}
+		if sec, err := strconv.ParseInt(attrs.Metadata[MtimeKey], 10, 64); err == nil {
+			e.mtime = time.Unix(sec, 0)
+		}
+		objects[p] = e
+	}
+}
+
+// localPath reports whether p is a clean, relative path within its
+// directory.
+func localPath(p string) bool {
+	return p == path.Clean(p) && !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// uploadFile uploads file to obj, recording its modification time.
+func uploadFile(ctx context.Context, obj *storage.ObjectHandle, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w := obj.NewWriter(ctx)
+	w.Metadata = map[string]string{MtimeKey: strconv.FormatInt(fi.ModTime().Unix(), 10)}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// downloadFile downloads obj to file through a temporary file, and sets the
+// file's modification time from the object's metadata.
+func downloadFile(ctx context.Context, obj *storage.ObjectHandle, file string) error {
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := obj.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".download"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	// The reader verifies the checksum of the whole object.
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if sec, err := strconv.ParseInt(attrs.Metadata[MtimeKey], 10, 64); err == nil {
+		t := time.Unix(sec, 0)
+		return os.Chtimes(file, t, t)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// actions summarizes a result as "op path reason" strings.
+func actions(res *SyncResult) []string {
+	var out []string
+	for _, a := range res.Actions {
+		out = append(out, a.Op+" "+a.Path+" "+a.Reason)
+	}
+	return out
+}
+
+func TestSyncUpload(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.txt":     "same",
+		"sub/b.txt": "new content",
+		"c.txt":     "new file",
+		"debug.log": "excluded",
+	})
+	fake.AddObject(bucket, "out/a.txt", []byte("same"))
+	fake.AddObject(bucket, "out/sub/b.txt", []byte("old content"))
+	fake.AddObject(bucket, "out/stale.txt", []byte("stale"))
+	fake.AddObject(bucket, "out/old.log", []byte("excluded"))
+	fake.AddObject(bucket, "out/dir/", nil)
+	fake.AddObject(bucket, "out/../escape", []byte("ignored"))
+
+	tests := []struct {
+		name string
+		s    Syncer
+		want []string
+	}{
+		{
+			name: "dry run",
+			s:    Syncer{DryRun: true, Exclude: []string{"*.log"}},
+			want: []string{"upload c.txt new", "upload sub/b.txt checksum"},
+		},
+		{
+			name: "include",
+			s:    Syncer{DryRun: true, Include: []string{"sub/*"}},
+			want: []string{"upload sub/b.txt checksum"},
+		},
+		{
+			name: "mirror",
+			s:    Syncer{Delete: true, Exclude: []string{"*.log"}},
+			want: []string{"upload c.txt new", "delete stale.txt extra", "upload sub/b.txt checksum"},
+		},
+		{
+			name: "in sync",
+			s:    Syncer{Delete: true, Exclude: []string{"*.log"}},
+			want: nil,
+		},
+	}
+	for _, tc := range tests {
+		tc.s.Client = client
+		res, err := tc.s.Upload(ctx, dir, bucket, "out/")
+		if err != nil {
+			t.Fatalf("%s: Upload: %v", tc.name, err)
+		}
+		if got := actions(res); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got actions %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for name, want := range map[string]string{"out/sub/b.txt": "new content", "out/c.txt": "new file", "out/old.log": "excluded"} {
+		if got, _ := fake.Object(bucket, name); string(got) != want {
+			t.Errorf("object %s: got %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"out/stale.txt", "out/debug.log"} {
+		if _, ok := fake.Object(bucket, name); ok {
+			t.Errorf("object %s: got it, want it deleted or skipped", name)
+		}
+	}
+
+	// Same size, different modification time.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "c.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	s := Syncer{Client: client, UseMtime: true, Exclude: []string{"*.log"}}
+	res, err := s.Upload(ctx, dir, bucket, "out/")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := actions(res), []string{"upload c.txt mtime"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UseMtime: got actions %q, want %q", got, want)
+	}
+}
+
+// TestSyncSiblingPrefix checks that a prefix without a trailing slash is a
+// directory, and that objects under sibling prefixes are left alone.
+func TestSyncSiblingPrefix(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "new"})
+	fake.AddObject(bucket, "out/stale.txt", []byte("stale"))
+	fake.AddObject(bucket, "output/x", []byte("sibling"))
+	fake.AddObject(bucket, "outa.txt", []byte("sibling"))
+
+	s := Syncer{Client: client, Delete: true}
+	res, err := s.Upload(ctx, dir, bucket, "out")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := actions(res), []string{"upload a.txt new", "delete stale.txt extra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Upload: got actions %q, want %q", got, want)
+	}
+	for name, want := range map[string]string{"out/a.txt": "new", "output/x": "sibling", "outa.txt": "sibling"} {
+		if got, _ := fake.Object(bucket, name); string(got) != want {
+			t.Errorf("object %s: got %q, want %q", name, got, want)
+		}
+	}
+
+	down := t.TempDir()
+	s = Syncer{Client: client, Delete: true}
+	res, err = s.Download(ctx, bucket, "out", down)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, want := actions(res), []string{"download a.txt new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Download: got actions %q, want %q", got, want)
+	}
+}
+
+// TestSyncBucketKeepsUploads checks that mirroring a whole bucket leaves the
+// temporary objects of uploads in progress alone.
+func TestSyncBucketKeepsUploads(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "new"})
+	fake.AddObject(bucket, "stale.txt", []byte("stale"))
+	fake.AddObject(bucket, ".parallel-upload/0123456789abcdef/0", []byte("chunk"))
+
+	s := Syncer{Client: client, Delete: true}
+	res, err := s.Upload(ctx, dir, bucket, "")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := actions(res), []string{"upload a.txt new", "delete stale.txt extra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Upload: got actions %q, want %q", got, want)
+	}
+	if _, ok := fake.Object(bucket, ".parallel-upload/0123456789abcdef/0"); !ok {
+		t.Errorf("temporary object deleted, want it kept")
+	}
+}
+
+func TestSyncDownload(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	mtime := time.Unix(1700000000, 0)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fake.AddObject(bucket, "data/../escape", []byte("ignored"))
+	if _, err := (&Syncer{Client: client}).Upload(ctx, src, bucket, "data/"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	s := &Syncer{Client: client, Delete: true, UseMtime: true}
+	res, err := s.Download(ctx, bucket, "data/", dst)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, want := actions(res), []string{"download a.txt new", "download sub/b.txt new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Download: got actions %q, want %q", got, want)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("Download: got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "escape")); !os.IsNotExist(err) {
+		t.Errorf("Download: wrote outside of the directory")
+	}
+
+	writeFiles(t, dst, map[string]string{"extra.txt": "extra"})
+	res, err = s.Download(ctx, bucket, "data/", dst)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, want := actions(res), []string{"delete extra.txt extra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mirror Download: got actions %q, want %q", got, want)
+	}
+	if res.Unchanged != 2 {
+		t.Errorf("mirror Download: got %d unchanged files, want 2", res.Unchanged)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("mirror Download: extra.txt not deleted")
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	res, err := (&Syncer{Client: client}).Upload(ctx, dir, "missing-bucket", "")
+	if err == nil {
+		t.Fatalf("Upload to missing bucket: got nil error, result %v", actions(res))
+	}
+
+	if _, err := (&Syncer{Client: client, Include: []string{"["}}).Upload(ctx, dir, bucket, ""); err == nil {
+		t.Errorf("Upload with bad pattern: got nil error")
+	}
+}
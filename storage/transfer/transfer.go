@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transfer speeds up transfers of large objects by splitting them
+// into parts transferred concurrently, and of many files by transferring
+// only those that changed.
+//
+// Downloader downloads byte ranges of an object into a preallocated file
+// and verifies the CRC32C checksum of the whole. Uploader uploads chunks of
+// a file as temporary objects, composes them into the destination and
+// deletes them; an interrupted upload reuses the chunks already uploaded
+// when it is retried. Syncer synchronizes a local directory and a bucket
+// prefix in either direction.
+package transfer
+
+import (
+	"context"
+	"hash/crc32"
+	"sync"
+)
+
+const (
+	defaultPartSize    = 32 << 20
+	defaultConcurrency = 8
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32cCombine returns the CRC32C of the concatenation of two byte
+// sequences, given their CRC32Cs and the length of the second, as in zlib's
+// crc32_combine.
+func crc32cCombine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+	// odd is the operator that appends a single zero bit to a CRC.
+	var even, odd [32]uint32
+	odd[0] = 0x82f63b78 // Castagnoli polynomial, reversed.
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd) // Two zero bits.
+	gf2MatrixSquare(&odd, &even) // Four zero bits.
+
+	// Apply len2 zero bytes to crc1, squaring the operator for each bit of
+	// len2.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
+
+// forEach calls f for 0 <= i < n with at most concurrency calls in flight.
+// It stops at the first error, cancelling the context of the other calls,
+// and returns it.
+func forEach(ctx context.Context, n, concurrency int, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := f(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// parts splits size bytes into parts of partSize bytes, the last of which
+// may be shorter. There is always at least one part.
+func parts(size, partSize int64) (offsets []int64, lengths []int64) {
+	if size == 0 {
+		return []int64{0}, []int64{0}
+	}
+	for off := int64(0); off < size; off += partSize {
+		n := partSize
+		if size-off < n {
+			n = size - off
+		}
+		offsets = append(offsets, off)
+		lengths = append(lengths, n)
+	}
+	return offsets, lengths
+}
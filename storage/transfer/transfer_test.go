@@ -0,0 +1,205 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil/fakes"
+	"google.golang.org/api/iterator"
+)
+
+const bucket = "my-bucket"
+
+func newClient(t *testing.T) (*fakes.Storage, *storage.Client) {
+	t.Helper()
+	fake := fakes.NewStorage(t)
+	client, err := storage.NewClient(context.Background(), fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("storage.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return fake, client
+}
+
+func randomData(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestCRC32CCombine(t *testing.T) {
+	data := randomData(10000)
+	for _, split := range []int{0, 1, 100, 4096, 9999, 10000} {
+		a := crc32.Checksum(data[:split], castagnoli)
+		b := crc32.Checksum(data[split:], castagnoli)
+		got := crc32cCombine(a, b, int64(len(data)-split))
+		if want := crc32.Checksum(data, castagnoli); got != want {
+			t.Errorf("crc32cCombine split at %d: got %08x, want %08x", split, got, want)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	dir := t.TempDir()
+
+	for _, size := range []int{0, 1000, 10000, 10240} {
+		data := randomData(size)
+		fake.AddObject(bucket, "obj", data)
+		dst := filepath.Join(dir, "obj")
+		d := &Downloader{Client: client, SliceSize: 1024, Concurrency: 4}
+		fake.Reset()
+		if _, err := d.Download(ctx, bucket, "obj", dst); err != nil {
+			t.Fatalf("Download(%d bytes): %v", size, err)
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Download(%d bytes): got %d different bytes", size, len(got))
+		}
+		if _, err := os.Stat(dst + ".download"); !os.IsNotExist(err) {
+			t.Errorf("Download(%d bytes): temporary file left behind", size)
+		}
+		if want := (size + 1023) / 1024; len(fake.Calls())-1 != want {
+			t.Errorf("Download(%d bytes): got %d range reads, want %d", size, len(fake.Calls())-1, want)
+		}
+	}
+
+	if _, err := (&Downloader{Client: client}).Download(ctx, bucket, "missing", filepath.Join(dir, "missing")); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("Download(missing): got %v, want ErrObjectNotExist", err)
+	}
+}
+
+func listObjects(t *testing.T, client *storage.Client, prefix string) []string {
+	t.Helper()
+	var names []string
+	it := client.Bucket(bucket).Objects(context.Background(), &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("Objects: %v", err)
+		}
+		names = append(names, attrs.Name)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	fake.AddObject(bucket, "placeholder", nil) // Creates the bucket.
+	dir := t.TempDir()
+
+	tests := []struct {
+		size     int
+		composes int
+	}{
+		{size: 0, composes: 1},
+		{size: 100, composes: 1},
+		{size: 32 * 100, composes: 1},
+		// 40 chunks are composed into 2 objects, and then the destination.
+		{size: 40*100 - 50, composes: 3},
+		// 1025 chunks: 33 objects, then 2, then the destination.
+		{size: 1025 * 100, composes: 33 + 2 + 1},
+	}
+	for _, tc := range tests {
+		data := randomData(tc.size)
+		src := filepath.Join(dir, "src")
+		if err := ioutil.WriteFile(src, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fake.Reset()
+		u := &Uploader{Client: client, ChunkSize: 100, Concurrency: 8}
+		attrs, err := u.Upload(ctx, src, bucket, "dst")
+		if err != nil {
+			t.Fatalf("Upload(%d bytes): %v", tc.size, err)
+		}
+		if attrs.Size != int64(tc.size) {
+			t.Errorf("Upload(%d bytes): got size %d", tc.size, attrs.Size)
+		}
+		if got, _ := fake.Object(bucket, "dst"); !bytes.Equal(got, data) {
+			t.Errorf("Upload(%d bytes): got %d different bytes", tc.size, len(got))
+		}
+		if got := fake.Count("compose"); got != tc.composes {
+			t.Errorf("Upload(%d bytes): got %d composes, want %d", tc.size, got, tc.composes)
+		}
+		if left := listObjects(t, client, ".parallel-upload/"); len(left) != 0 {
+			t.Errorf("Upload(%d bytes): temporary objects left behind: %v", tc.size, left)
+		}
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newClient(t)
+	fake.AddObject(bucket, "placeholder", nil) // Creates the bucket.
+	data := randomData(1000)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupt the upload after 4 of its 10 chunks.
+	cctx, cancel := context.WithCancel(ctx)
+	u := &Uploader{Client: client, ChunkSize: 100, Concurrency: 1, TempPrefix: "tmp/"}
+	u.chunkUploaded = func(i int) {
+		if i == 3 {
+			cancel()
+		}
+	}
+	if _, err := u.Upload(cctx, src, bucket, "dst"); err == nil {
+		t.Fatalf("interrupted Upload: got nil error")
+	}
+	if got := len(listObjects(t, client, "tmp/")); got != 4 {
+		t.Fatalf("interrupted Upload: got %d temporary objects, want 4", got)
+	}
+
+	uploads := 0
+	u.chunkUploaded = nil
+	fake.Reset()
+	if _, err := u.Upload(ctx, src, bucket, "dst"); err != nil {
+		t.Fatalf("resumed Upload: %v", err)
+	}
+	for _, c := range fake.Calls() {
+		if strings.HasPrefix(c.Method, "POST /upload/") {
+			uploads++
+		}
+	}
+	if uploads != 6 {
+		t.Errorf("resumed Upload: got %d chunk uploads, want 6", uploads)
+	}
+	if got, _ := fake.Object(bucket, "dst"); !bytes.Equal(got, data) {
+		t.Errorf("resumed Upload: got %d different bytes", len(got))
+	}
+	if left := listObjects(t, client, "tmp/"); len(left) != 0 {
+		t.Errorf("resumed Upload: temporary objects left behind: %v", left)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxComposeSources is the maximum number of objects composed at once.
+const maxComposeSources = 32
+
+// Uploader uploads files as parallel composite uploads: chunks of the file
+// are uploaded concurrently as temporary objects, which are composed into
+// the destination object and then deleted.
+//
+// Composite objects have a CRC32C checksum but no MD5 hash, and the
+// temporary objects are charged as any other object while they exist, so
+// buckets with retention policies or early deletion charges are better
+// served by a single upload.
+type Uploader struct {
+	Client *storage.Client
+	// ChunkSize is the size of each temporary object. It defaults to
+	// 32 MiB.
+	ChunkSize int64
+	// Concurrency is the number of chunks uploaded at once. It defaults to
+	// 8.
+	Concurrency int
+	// TempPrefix is prepended to the names of temporary objects. It
+	// defaults to ".parallel-upload/".
+	TempPrefix string
+
+	// chunkUploaded, if set, is called after each chunk is uploaded.
+	chunkUploaded func(i int)
+}
+
+// Upload uploads the file src to the object.
+//
+// The temporary objects of an upload are named after the destination, and
+// the size and modification time of src. If Upload is interrupted, calling
+// it again with the same arguments reuses the chunks already uploaded,
+// once their checksums are verified.
+func (u *Uploader) Upload(ctx context.Context, src, bucket, object string) (*storage.ObjectAttrs, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Stat: %w", err)
+	}
+
+	chunkSize := partSize(u.ChunkSize)
+	b := u.Client.Bucket(bucket)
+	tmpPrefix := u.tempPrefix(bucket, object, chunkSize, fi)
+	offsets, lengths := parts(fi.Size(), chunkSize)
+	chunks := make([]string, len(offsets))
+	crcs := make([]uint32, len(offsets))
+	err = forEach(ctx, len(offsets), concurrency(u.Concurrency), func(ctx context.Context, i int) error {
+		chunks[i] = fmt.Sprintf("%schunk-%05d", tmpPrefix, i)
+		crc, err := u.uploadChunk(ctx, b.Object(chunks[i]), io.NewSectionReader(f, offsets[i], lengths[i]))
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+		crcs[i] = crc
+		if u.chunkUploaded != nil {
+			u.chunkUploaded(i)
+		}
+		return nil
+	})
+	// Chunks are left in place on failure so that the upload can resume.
+	if err != nil {
+		return nil, err
+	}
+	temps := append([]string(nil), chunks...)
+
+	var crc uint32
+	for i, c := range crcs {
+		crc = crc32cCombine(crc, c, lengths[i])
+	}
+
+	// Compose the chunks in groups of up to 32 until there are few enough
+	// to compose into the destination.
+	sources := chunks
+	for level := 1; len(sources) > maxComposeSources; level++ {
+		next := make([]string, (len(sources)+maxComposeSources-1)/maxComposeSources)
+		for i := range next {
+			next[i] = fmt.Sprintf("%scompose-%d-%05d", tmpPrefix, level, i)
+		}
+		temps = append(temps, next...)
+		err := forEach(ctx, len(next), concurrency(u.Concurrency), func(ctx context.Context, i int) error {
+			end := (i + 1) * maxComposeSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			_, err := compose(ctx, b, next[i], sources[i*maxComposeSources:end])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		sources = next
+	}
+	attrs, err := compose(ctx, b, object, sources)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.CRC32C != crc {
+		return nil, fmt.Errorf("CRC32C mismatch: got %08x, want %08x", attrs.CRC32C, crc)
+	}
+
+	if err := deleteAll(ctx, b, temps, concurrency(u.Concurrency)); err != nil {
+		return attrs, fmt.Errorf("uploaded, but could not delete temporary objects: %w", err)
+	}
+	return attrs, nil
+}
+
+// defaultTempPrefix is the default Uploader.TempPrefix.
+const defaultTempPrefix = ".parallel-upload/"
+
+// tempPrefix returns the prefix of the temporary objects of an upload.
+func (u *Uploader) tempPrefix(bucket, object string, chunkSize int64, fi os.FileInfo) string {
+	prefix := u.TempPrefix
+	if prefix == "" {
+		prefix = defaultTempPrefix
+	}
+	h := sha256.New()
+	for _, s := range []string{bucket, object, strconv.FormatInt(chunkSize, 10), strconv.FormatInt(fi.Size(), 10), strconv.FormatInt(fi.ModTime().UnixNano(), 10)} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s%s/", prefix, hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+// uploadChunk uploads r to obj unless obj already holds it, and returns
+// its CRC32C.
+func (u *Uploader) uploadChunk(ctx context.Context, obj *storage.ObjectHandle, r *io.SectionReader) (uint32, error) {
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+	crc := h.Sum32()
+
+	attrs, err := obj.Attrs(ctx)
+	if err == nil && attrs.Size == r.Size() && attrs.CRC32C == crc {
+		return crc, nil
+	}
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return 0, fmt.Errorf("Attrs: %w", err)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	w := obj.NewWriter(ctx)
+	// Chunks are uploaded in a single request.
+	w.ChunkSize = 0
+	w.CRC32C = crc
+	w.SendCRC32C = true
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return 0, fmt.Errorf("write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("Writer.Close: %w", err)
+	}
+	return crc, nil
+}
+
+func compose(ctx context.Context, b *storage.BucketHandle, dst string, sources []string) (*storage.ObjectAttrs, error) {
+	srcs := make([]*storage.ObjectHandle, len(sources))
+	for i, s := range sources {
+		srcs[i] = b.Object(s)
+	}
+	attrs, err := b.Object(dst).ComposerFrom(srcs...).Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("compose %q: %w", dst, err)
+	}
+	return attrs, nil
+}
+
+// deleteAll deletes the named objects, continuing past failures, and
+// returns the first error.
+func deleteAll(ctx context.Context, b *storage.BucketHandle, names []string, concurrency int) error {
+	var (
+		mu    sync.Mutex
+		first error
+	)
+	forEach(ctx, len(names), concurrency, func(ctx context.Context, i int) error {
+		err := b.Object(names[i]).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			mu.Lock()
+			if first == nil {
+				first = fmt.Errorf("Object(%q).Delete: %w", names[i], err)
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+	return first
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	storagetransfer "cloud.google.com/go/storagetransfer/apiv1"
+	"cloud.google.com/go/storagetransfer/apiv1/storagetransferpb"
+)
+
+// Client is the part of the Storage Transfer Service API a Manager uses.
+// NewClient adapts a storagetransfer.Client; tests use a fake.
+type Client interface {
+	CreateTransferJob(ctx context.Context, req *storagetransferpb.CreateTransferJobRequest) (*storagetransferpb.TransferJob, error)
+	GetTransferJob(ctx context.Context, req *storagetransferpb.GetTransferJobRequest) (*storagetransferpb.TransferJob, error)
+	UpdateTransferJob(ctx context.Context, req *storagetransferpb.UpdateTransferJobRequest) (*storagetransferpb.TransferJob, error)
+	// RunTransferJob starts an operation of a job and returns its name.
+	RunTransferJob(ctx context.Context, req *storagetransferpb.RunTransferJobRequest) (string, error)
+	// GetTransferOperation returns the state of the named operation.
+	GetTransferOperation(ctx context.Context, name string) (*storagetransferpb.TransferOperation, error)
+	PauseTransferOperation(ctx context.Context, req *storagetransferpb.PauseTransferOperationRequest) error
+	ResumeTransferOperation(ctx context.Context, req *storagetransferpb.ResumeTransferOperationRequest) error
+	// CancelTransferOperation cancels the named operation.
+	CancelTransferOperation(ctx context.Context, name string) error
+}
+
+// NewClient returns a Client that calls the service with c.
+func NewClient(c *storagetransfer.Client) Client {
+	return gapicClient{c}
+}
+
+type gapicClient struct {
+	c *storagetransfer.Client
+}
+
+func (g gapicClient) CreateTransferJob(ctx context.Context, req *storagetransferpb.CreateTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	return g.c.CreateTransferJob(ctx, req)
+}
+
+func (g gapicClient) GetTransferJob(ctx context.Context, req *storagetransferpb.GetTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	return g.c.GetTransferJob(ctx, req)
+}
+
+func (g gapicClient) UpdateTransferJob(ctx context.Context, req *storagetransferpb.UpdateTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	return g.c.UpdateTransferJob(ctx, req)
+}
+
+func (g gapicClient) RunTransferJob(ctx context.Context, req *storagetransferpb.RunTransferJobRequest) (string, error) {
+	op, err := g.c.RunTransferJob(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return op.Name(), nil
+}
+
+func (g gapicClient) GetTransferOperation(ctx context.Context, name string) (*storagetransferpb.TransferOperation, error) {
+	lro, err := g.c.LROClient.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	op := &storagetransferpb.TransferOperation{}
+	if err := lro.Metadata.UnmarshalTo(op); err != nil {
+		return nil, fmt.Errorf("operation %s: %w", name, err)
+	}
+	return op, nil
+}
+
+func (g gapicClient) PauseTransferOperation(ctx context.Context, req *storagetransferpb.PauseTransferOperationRequest) error {
+	return g.c.PauseTransferOperation(ctx, req)
+}
+
+func (g gapicClient) ResumeTransferOperation(ctx context.Context, req *storagetransferpb.ResumeTransferOperationRequest) error {
+	return g.c.ResumeTransferOperation(ctx, req)
+}
+
+func (g gapicClient) CancelTransferOperation(ctx context.Context, name string) error {
+	return g.c.LROClient.CancelOperation(ctx, &longrunningpb.CancelOperationRequest{Name: name})
+}
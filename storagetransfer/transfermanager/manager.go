@@ -0,0 +1,256 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storagetransfer/apiv1/storagetransferpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// DefaultPollInterval is how often Wait checks an operation by default.
+const DefaultPollInterval = 10 * time.Second
+
+var (
+	// ErrFailed is returned by Wait for an operation that failed.
+	ErrFailed = errors.New("transfer operation failed")
+	// ErrAborted is returned by Wait for an operation that was canceled.
+	ErrAborted = errors.New("transfer operation aborted")
+	// ErrNoOperation is returned by Latest for a job that has not run.
+	ErrNoOperation = errors.New("transfer job has not run")
+)
+
+// updateMask lists the job fields a spec manages.
+var updateMask = []string{"description", "transfer_spec", "schedule", "logging_config", "status"}
+
+// Manager creates transfer jobs from specs and follows their operations.
+type Manager struct {
+	Client Client
+	// PollInterval is how often Wait checks an operation, or
+	// DefaultPollInterval if zero.
+	PollInterval time.Duration
+	// Progress, if set, is called by Wait whenever the status or counters
+	// of the operation change.
+	Progress func(*Progress)
+}
+
+// Progress is the state of a transfer operation.
+type Progress struct {
+	Operation string
+	Status    storagetransferpb.TransferOperation_Status
+	Counters  *storagetransferpb.TransferCounters
+	// Errors is the number of errors reported, by all error codes.
+	Errors int64
+}
+
+func newProgress(op *storagetransferpb.TransferOperation) *Progress {
+	p := &Progress{Operation: op.GetName(), Status: op.GetStatus(), Counters: op.GetCounters()}
+	if p.Counters == nil {
+		p.Counters = &storagetransferpb.TransferCounters{}
+	}
+	for _, e := range op.GetErrorBreakdowns() {
+		p.Errors += e.GetErrorCount()
+	}
+	return p
+}
+
+// String returns a one-line summary such as
+// "IN_PROGRESS: 3/10 objects, 1.5 MiB/12.0 MiB copied, 1 failed".
+func (p *Progress) String() string {
+	c := p.Counters
+	s := fmt.Sprintf("%s: %d/%d objects, %s/%s copied", p.Status,
+		c.ObjectsCopiedToSink, c.ObjectsFoundFromSource,
+		formatBytes(c.BytesCopiedToSink), formatBytes(c.BytesFoundFromSource))
+	if n := c.ObjectsFromSourceSkippedBySync; n > 0 {
+		s += fmt.Sprintf(", %d skipped", n)
+	}
+	if n := c.ObjectsDeletedFromSource + c.ObjectsDeletedFromSink; n > 0 {
+		s += fmt.Sprintf(", %d deleted", n)
+	}
+	if n := c.ObjectsFromSourceFailed + c.ObjectsFailedToDeleteFromSink; n > 0 {
+		s += fmt.Sprintf(", %d failed", n)
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, exp := float64(n)/unit, 0
+	for v >= unit && exp < 4 {
+		v /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, "KMGTP"[exp])
+}
+
+// Apply creates the job a spec describes or, if the spec names an existing
+// job, updates it to match. It reports whether the job was created.
+func (m *Manager) Apply(ctx context.Context, spec *Spec) (*storagetransferpb.TransferJob, bool, error) {
+	job, err := spec.Job()
+	if err != nil {
+		return nil, false, err
+	}
+	if spec.Name != "" {
+		_, err := m.Client.GetTransferJob(ctx, &storagetransferpb.GetTransferJobRequest{JobName: spec.Name, ProjectId: spec.Project})
+		if err == nil {
+			updated, err := m.Client.UpdateTransferJob(ctx, &storagetransferpb.UpdateTransferJobRequest{
+				JobName:                    spec.Name,
+				ProjectId:                  spec.Project,
+				TransferJob:                job,
+				UpdateTransferJobFieldMask: &fieldmaskpb.FieldMask{Paths: updateMask},
+			})
+			if err != nil {
+				return nil, false, fmt.Errorf("UpdateTransferJob: %w", err)
+			}
+			return updated, false, nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return nil, false, fmt.Errorf("GetTransferJob: %w", err)
+		}
+	}
+	created, err := m.Client.CreateTransferJob(ctx, &storagetransferpb.CreateTransferJobRequest{TransferJob: job})
+	if err != nil {
+		return nil, false, fmt.Errorf("CreateTransferJob: %w", err)
+	}
+	return created, true, nil
+}
+
+// Run starts an operation of a job and returns its name.
+func (m *Manager) Run(ctx context.Context, project, job string) (string, error) {
+	name, err := m.Client.RunTransferJob(ctx, &storagetransferpb.RunTransferJobRequest{JobName: job, ProjectId: project})
+	if err != nil {
+		return "", fmt.Errorf("RunTransferJob: %w", err)
+	}
+	return name, nil
+}
+
+// Latest returns the name of the latest operation of a job.
+func (m *Manager) Latest(ctx context.Context, project, job string) (string, error) {
+	j, err := m.Client.GetTransferJob(ctx, &storagetransferpb.GetTransferJobRequest{JobName: job, ProjectId: project})
+	if err != nil {
+		return "", fmt.Errorf("GetTransferJob: %w", err)
+	}
+	if j.LatestOperationName == "" {
+		return "", ErrNoOperation
+	}
+	return j.LatestOperationName, nil
+}
+
+// Wait follows an operation until it ends, and returns its final state. A
+// paused operation is waited on until it is resumed or canceled. It returns
+// ErrFailed or ErrAborted with the operation if it did not succeed. If ctx
+// is done first, Wait returns and the operation continues.
+func (m *Manager) Wait(ctx context.Context, name string) (*storagetransferpb.TransferOperation, error) {
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	var last *Progress
+	for {
+		op, err := m.Client.GetTransferOperation(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("getting operation %s: %w", name, err)
+		}
+		p := newProgress(op)
+		if m.Progress != nil && (last == nil || p.Status != last.Status || p.Errors != last.Errors || !proto.Equal(p.Counters, last.Counters)) {
+			m.Progress(p)
+		}
+		last = p
+		switch op.GetStatus() {
+		case storagetransferpb.TransferOperation_SUCCESS:
+			return op, nil
+		case storagetransferpb.TransferOperation_FAILED:
+			return op, ErrFailed
+		case storagetransferpb.TransferOperation_ABORTED:
+			return op, ErrAborted
+		}
+		select {
+		case <-ctx.Done():
+			return op, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Pause pauses an operation.
+func (m *Manager) Pause(ctx context.Context, name string) error {
+	if err := m.Client.PauseTransferOperation(ctx, &storagetransferpb.PauseTransferOperationRequest{Name: name}); err != nil {
+		return fmt.Errorf("PauseTransferOperation: %w", err)
+	}
+	return nil
+}
+
+// Resume resumes a paused operation.
+func (m *Manager) Resume(ctx context.Context, name string) error {
+	if err := m.Client.ResumeTransferOperation(ctx, &storagetransferpb.ResumeTransferOperationRequest{Name: name}); err != nil {
+		return fmt.Errorf("ResumeTransferOperation: %w", err)
+	}
+	return nil
+}
+
+// Cancel cancels an operation. Objects already transferred are kept.
+func (m *Manager) Cancel(ctx context.Context, name string) error {
+	if err := m.Client.CancelTransferOperation(ctx, name); err != nil {
+		return fmt.Errorf("CancelOperation: %w", err)
+	}
+	return nil
+}
+
+// ErrorEntry is one line of an exported error log. Entries with a URL are
+// objects that failed; an entry with Omitted counts the errors of a code
+// the operation reported without their objects.
+type ErrorEntry struct {
+	Operation string   `json:"operation"`
+	Code      string   `json:"code"`
+	URL       string   `json:"url,omitempty"`
+	Details   []string `json:"details,omitempty"`
+	Omitted   int64    `json:"omitted,omitempty"`
+}
+
+// ExportErrors writes the errors of an operation to w as lines of JSON, and
+// returns the number of objects listed. An operation only lists a sample of
+// the objects that failed for each error code; enable logging in the spec
+// to log every failure to Cloud Logging.
+func ExportErrors(w io.Writer, op *storagetransferpb.TransferOperation) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+	for _, e := range op.GetErrorBreakdowns() {
+		code := e.GetErrorCode().String()
+		for _, l := range e.GetErrorLogEntries() {
+			if err := enc.Encode(&ErrorEntry{Operation: op.GetName(), Code: code, URL: l.GetUrl(), Details: l.GetErrorDetails()}); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if omitted := e.GetErrorCount() - int64(len(e.GetErrorLogEntries())); omitted > 0 {
+			if err := enc.Encode(&ErrorEntry{Operation: op.GetName(), Code: code, Omitted: omitted}); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
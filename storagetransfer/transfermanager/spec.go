@@ -0,0 +1,472 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transfermanager creates Storage Transfer Service jobs from
+// declarative specs and follows their operations to completion.
+//
+// A spec names one source and one sink, in YAML or JSON:
+//
+//	project: my-project
+//	name: transferJobs/nightly-logs
+//	description: Copy yesterday's logs
+//	source:
+//	  aws:
+//	    bucket: my-logs
+//	    path: app/
+//	    roleArn: arn:aws:iam::123456789012:role/transfer
+//	sink:
+//	  gcs:
+//	    bucket: my-archive
+//	    path: logs/
+//	conditions:
+//	  minAge: 1d
+//	options:
+//	  overwrite: different
+//	schedule:
+//	  start: 2024-03-01
+//	  at: "02:00:00"
+//	  every: 1d
+//	logging:
+//	  actions: [copy]
+//	  states: [failed]
+//
+// Sources are gcs, aws, azure, s3Compatible, http and posix; sinks are gcs
+// and posix. AWS and Azure credentials come from a Secret Manager secret, an
+// AWS role, or the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AZURE_SAS_TOKEN environment variables, so that specs hold no secrets.
+//
+// A spec without a schedule describes a job that runs once, when it is run
+// explicitly.
+package transfermanager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storagetransfer/apiv1/storagetransferpb"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/genproto/googleapis/type/timeofday"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v2"
+)
+
+// Spec is the declarative form of a transfer job.
+type Spec struct {
+	Project string `yaml:"project" json:"project"`
+	// Name is the job name, "transferJobs/" followed by a unique ID. If
+	// set, applying the spec again updates the job; if empty, the service
+	// names a new job each time.
+	Name        string      `yaml:"name,omitempty" json:"name,omitempty"`
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+	Source      Source      `yaml:"source" json:"source"`
+	Sink        Sink        `yaml:"sink" json:"sink"`
+	Manifest    string      `yaml:"manifest,omitempty" json:"manifest,omitempty"`
+	Conditions  *Conditions `yaml:"conditions,omitempty" json:"conditions,omitempty"`
+	Options     *Options    `yaml:"options,omitempty" json:"options,omitempty"`
+	Schedule    *Schedule   `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	Logging     *Logging    `yaml:"logging,omitempty" json:"logging,omitempty"`
+}
+
+// Source is the data source of a transfer. Exactly one field is set.
+type Source struct {
+	GCS          *GCS          `yaml:"gcs,omitempty" json:"gcs,omitempty"`
+	AWS          *AWS          `yaml:"aws,omitempty" json:"aws,omitempty"`
+	Azure        *Azure        `yaml:"azure,omitempty" json:"azure,omitempty"`
+	S3Compatible *S3Compatible `yaml:"s3Compatible,omitempty" json:"s3Compatible,omitempty"`
+	HTTP         *HTTP         `yaml:"http,omitempty" json:"http,omitempty"`
+	POSIX        *POSIX        `yaml:"posix,omitempty" json:"posix,omitempty"`
+}
+
+// Sink is the data sink of a transfer. Exactly one field is set.
+type Sink struct {
+	GCS   *GCS   `yaml:"gcs,omitempty" json:"gcs,omitempty"`
+	POSIX *POSIX `yaml:"posix,omitempty" json:"posix,omitempty"`
+}
+
+// GCS is a Cloud Storage bucket and optional path prefix ending in "/".
+type GCS struct {
+	Bucket string `yaml:"bucket" json:"bucket"`
+	Path   string `yaml:"path,omitempty" json:"path,omitempty"`
+}
+
+// AWS is an Amazon S3 bucket. Credentials come from RoleARN,
+// CredentialsSecret or, if neither is set, the environment.
+type AWS struct {
+	Bucket            string `yaml:"bucket" json:"bucket"`
+	Path              string `yaml:"path,omitempty" json:"path,omitempty"`
+	RoleARN           string `yaml:"roleArn,omitempty" json:"roleArn,omitempty"`
+	CredentialsSecret string `yaml:"credentialsSecret,omitempty" json:"credentialsSecret,omitempty"`
+}
+
+// Azure is an Azure Blob Storage container. Credentials come from
+// CredentialsSecret or, if it is not set, the environment.
+type Azure struct {
+	StorageAccount    string `yaml:"storageAccount" json:"storageAccount"`
+	Container         string `yaml:"container" json:"container"`
+	Path              string `yaml:"path,omitempty" json:"path,omitempty"`
+	CredentialsSecret string `yaml:"credentialsSecret,omitempty" json:"credentialsSecret,omitempty"`
+}
+
+// S3Compatible is a bucket in S3-compatible storage, read by transfer
+// agents in AgentPool.
+type S3Compatible struct {
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Path      string `yaml:"path,omitempty" json:"path,omitempty"`
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	AgentPool string `yaml:"agentPool" json:"agentPool"`
+}
+
+// HTTP is a URL list: a TSV file of the URLs to transfer.
+type HTTP struct {
+	ListURL string `yaml:"listUrl" json:"listUrl"`
+}
+
+// POSIX is a directory on file systems served by transfer agents in
+// AgentPool.
+type POSIX struct {
+	RootDirectory string `yaml:"rootDirectory" json:"rootDirectory"`
+	AgentPool     string `yaml:"agentPool" json:"agentPool"`
+}
+
+// Conditions select the objects transferred.
+type Conditions struct {
+	IncludePrefixes   []string   `yaml:"includePrefixes,omitempty" json:"includePrefixes,omitempty"`
+	ExcludePrefixes   []string   `yaml:"excludePrefixes,omitempty" json:"excludePrefixes,omitempty"`
+	MinAge            Duration   `yaml:"minAge,omitempty" json:"minAge,omitempty"`
+	MaxAge            Duration   `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+	LastModifiedSince *time.Time `yaml:"lastModifiedSince,omitempty" json:"lastModifiedSince,omitempty"`
+}
+
+// Options control overwriting and deletion.
+type Options struct {
+	// Overwrite is when objects in the sink are overwritten: "different"
+	// (the default), "never" or "always".
+	Overwrite          string `yaml:"overwrite,omitempty" json:"overwrite,omitempty"`
+	DeleteUniqueInSink bool   `yaml:"deleteUniqueInSink,omitempty" json:"deleteUniqueInSink,omitempty"`
+	DeleteFromSource   bool   `yaml:"deleteFromSource,omitempty" json:"deleteFromSource,omitempty"`
+}
+
+// Schedule runs the job from Start, a date such as "2024-03-01", at the UTC
+// time of day At, such as "02:00:00", every Every until End. Without End the
+// job runs until disabled; without Every it runs once a day.
+type Schedule struct {
+	Start string   `yaml:"start" json:"start"`
+	End   string   `yaml:"end,omitempty" json:"end,omitempty"`
+	At    string   `yaml:"at,omitempty" json:"at,omitempty"`
+	Every Duration `yaml:"every,omitempty" json:"every,omitempty"`
+}
+
+// Logging sends a log entry to Cloud Logging for every object acted on with
+// one of Actions ("find", "copy", "delete") and ending in one of States
+// ("succeeded", "failed").
+type Logging struct {
+	Actions []string `yaml:"actions" json:"actions"`
+	States  []string `yaml:"states" json:"states"`
+}
+
+// Duration is a time.Duration written as a string, such as "1h30m", with a
+// "d" suffix for whole days, or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var secs int64
+	if err := unmarshal(&secs); err == nil {
+		*d = Duration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) proto() *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(time.Duration(d))
+}
+
+// ParseSpec parses a YAML or JSON spec. Unknown fields are errors.
+func ParseSpec(data []byte) (*Spec, error) {
+	var s Spec
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReadSpec reads a YAML or JSON spec file.
+func ReadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Project == "" {
+		return errors.New("project is required")
+	}
+	if s.Name != "" && !strings.HasPrefix(s.Name, "transferJobs/") {
+		return fmt.Errorf("name: got %q, want transferJobs/ID", s.Name)
+	}
+	src := s.Source
+	if n := count(src.GCS != nil, src.AWS != nil, src.Azure != nil, src.S3Compatible != nil, src.HTTP != nil, src.POSIX != nil); n != 1 {
+		return fmt.Errorf("source: got %d sources, want one", n)
+	}
+	if n := count(s.Sink.GCS != nil, s.Sink.POSIX != nil); n != 1 {
+		return fmt.Errorf("sink: got %d sinks, want one", n)
+	}
+	switch {
+	case src.GCS != nil && src.GCS.Bucket == "", s.Sink.GCS != nil && s.Sink.GCS.Bucket == "":
+		return errors.New("gcs: bucket is required")
+	case src.AWS != nil && src.AWS.Bucket == "":
+		return errors.New("source.aws: bucket is required")
+	case src.AWS != nil && src.AWS.RoleARN != "" && src.AWS.CredentialsSecret != "":
+		return errors.New("source.aws: set one of roleArn and credentialsSecret")
+	case src.Azure != nil && (src.Azure.StorageAccount == "" || src.Azure.Container == ""):
+		return errors.New("source.azure: storageAccount and container are required")
+	case src.S3Compatible != nil && (src.S3Compatible.Bucket == "" || src.S3Compatible.Endpoint == "" || src.S3Compatible.AgentPool == ""):
+		return errors.New("source.s3Compatible: bucket, endpoint and agentPool are required")
+	case src.HTTP != nil && src.HTTP.ListURL == "":
+		return errors.New("source.http: listUrl is required")
+	case src.POSIX != nil && (src.POSIX.RootDirectory == "" || src.POSIX.AgentPool == ""),
+		s.Sink.POSIX != nil && (s.Sink.POSIX.RootDirectory == "" || s.Sink.POSIX.AgentPool == ""):
+		return errors.New("posix: rootDirectory and agentPool are required")
+	}
+	if s.Manifest != "" && !strings.HasPrefix(s.Manifest, "gs://") {
+		return fmt.Errorf("manifest: got %q, want a gs:// URL", s.Manifest)
+	}
+	if o := s.Options; o != nil {
+		if _, ok := overwriteWhen[o.Overwrite]; !ok {
+			return fmt.Errorf("options.overwrite: got %q, want different, never or always", o.Overwrite)
+		}
+		if o.DeleteUniqueInSink && o.DeleteFromSource {
+			return errors.New("options: deleteUniqueInSink and deleteFromSource cannot both be set")
+		}
+	}
+	if sc := s.Schedule; sc != nil {
+		if _, err := parseDate(sc.Start); err != nil {
+			return fmt.Errorf("schedule.start: %w", err)
+		}
+		if sc.End != "" {
+			if _, err := parseDate(sc.End); err != nil {
+				return fmt.Errorf("schedule.end: %w", err)
+			}
+		}
+		if sc.At != "" {
+			if _, err := time.Parse("15:04:05", sc.At); err != nil {
+				return fmt.Errorf("schedule.at: %w", err)
+			}
+		}
+		if sc.Every != 0 && time.Duration(sc.Every) < time.Hour {
+			return errors.New("schedule.every: must be at least an hour")
+		}
+	}
+	if l := s.Logging; l != nil {
+		for _, a := range l.Actions {
+			if v, ok := storagetransferpb.LoggingConfig_LoggableAction_value[strings.ToUpper(a)]; !ok || v == 0 {
+				return fmt.Errorf("logging.actions: unknown action %q", a)
+			}
+		}
+		for _, st := range l.States {
+			if v, ok := storagetransferpb.LoggingConfig_LoggableActionState_value[strings.ToUpper(st)]; !ok || v == 0 {
+				return fmt.Errorf("logging.states: unknown state %q", st)
+			}
+		}
+	}
+	return nil
+}
+
+func count(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+var overwriteWhen = map[string]storagetransferpb.TransferOptions_OverwriteWhen{
+	"":          storagetransferpb.TransferOptions_OVERWRITE_WHEN_UNSPECIFIED,
+	"different": storagetransferpb.TransferOptions_DIFFERENT,
+	"never":     storagetransferpb.TransferOptions_NEVER,
+	"always":    storagetransferpb.TransferOptions_ALWAYS,
+}
+
+func parseDate(s string) (*date.Date, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, err
+	}
+	return &date.Date{Year: int32(t.Year()), Month: int32(t.Month()), Day: int32(t.Day())}, nil
+}
+
+// Job returns the transfer job the spec describes. Credentials not named in
+// the spec are read from the environment.
+func (s *Spec) Job() (*storagetransferpb.TransferJob, error) {
+	ts := &storagetransferpb.TransferSpec{}
+	src := s.Source
+	switch {
+	case src.GCS != nil:
+		ts.DataSource = &storagetransferpb.TransferSpec_GcsDataSource{
+			GcsDataSource: &storagetransferpb.GcsData{BucketName: src.GCS.Bucket, Path: src.GCS.Path},
+		}
+	case src.AWS != nil:
+		d := &storagetransferpb.AwsS3Data{
+			BucketName:        src.AWS.Bucket,
+			Path:              src.AWS.Path,
+			RoleArn:           src.AWS.RoleARN,
+			CredentialsSecret: src.AWS.CredentialsSecret,
+		}
+		if d.RoleArn == "" && d.CredentialsSecret == "" {
+			id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+			if id == "" || secret == "" {
+				return nil, errors.New("source.aws: no roleArn or credentialsSecret, and AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY is not set")
+			}
+			d.AwsAccessKey = &storagetransferpb.AwsAccessKey{AccessKeyId: id, SecretAccessKey: secret}
+		}
+		ts.DataSource = &storagetransferpb.TransferSpec_AwsS3DataSource{AwsS3DataSource: d}
+	case src.Azure != nil:
+		d := &storagetransferpb.AzureBlobStorageData{
+			StorageAccount:    src.Azure.StorageAccount,
+			Container:         src.Azure.Container,
+			Path:              src.Azure.Path,
+			CredentialsSecret: src.Azure.CredentialsSecret,
+		}
+		if d.CredentialsSecret == "" {
+			token := os.Getenv("AZURE_SAS_TOKEN")
+			if token == "" {
+				return nil, errors.New("source.azure: no credentialsSecret, and AZURE_SAS_TOKEN is not set")
+			}
+			d.AzureCredentials = &storagetransferpb.AzureCredentials{SasToken: token}
+		}
+		ts.DataSource = &storagetransferpb.TransferSpec_AzureBlobStorageDataSource{AzureBlobStorageDataSource: d}
+	case src.S3Compatible != nil:
+		ts.DataSource = &storagetransferpb.TransferSpec_AwsS3CompatibleDataSource{
+			AwsS3CompatibleDataSource: &storagetransferpb.AwsS3CompatibleData{
+				BucketName: src.S3Compatible.Bucket,
+				Path:       src.S3Compatible.Path,
+				Endpoint:   src.S3Compatible.Endpoint,
+				Region:     src.S3Compatible.Region,
+			},
+		}
+		ts.SourceAgentPoolName = src.S3Compatible.AgentPool
+	case src.HTTP != nil:
+		ts.DataSource = &storagetransferpb.TransferSpec_HttpDataSource{
+			HttpDataSource: &storagetransferpb.HttpData{ListUrl: src.HTTP.ListURL},
+		}
+	case src.POSIX != nil:
+		ts.DataSource = &storagetransferpb.TransferSpec_PosixDataSource{
+			PosixDataSource: &storagetransferpb.PosixFilesystem{RootDirectory: src.POSIX.RootDirectory},
+		}
+		ts.SourceAgentPoolName = src.POSIX.AgentPool
+	}
+	switch {
+	case s.Sink.GCS != nil:
+		ts.DataSink = &storagetransferpb.TransferSpec_GcsDataSink{
+			GcsDataSink: &storagetransferpb.GcsData{BucketName: s.Sink.GCS.Bucket, Path: s.Sink.GCS.Path},
+		}
+	case s.Sink.POSIX != nil:
+		ts.DataSink = &storagetransferpb.TransferSpec_PosixDataSink{
+			PosixDataSink: &storagetransferpb.PosixFilesystem{RootDirectory: s.Sink.POSIX.RootDirectory},
+		}
+		ts.SinkAgentPoolName = s.Sink.POSIX.AgentPool
+	}
+	if s.Manifest != "" {
+		ts.TransferManifest = &storagetransferpb.TransferManifest{Location: s.Manifest}
+	}
+	if c := s.Conditions; c != nil {
+		ts.ObjectConditions = &storagetransferpb.ObjectConditions{
+			IncludePrefixes:                     c.IncludePrefixes,
+			ExcludePrefixes:                     c.ExcludePrefixes,
+			MinTimeElapsedSinceLastModification: c.MinAge.proto(),
+			MaxTimeElapsedSinceLastModification: c.MaxAge.proto(),
+		}
+		if c.LastModifiedSince != nil {
+			ts.ObjectConditions.LastModifiedSince = timestamppb.New(*c.LastModifiedSince)
+		}
+	}
+	if o := s.Options; o != nil {
+		ts.TransferOptions = &storagetransferpb.TransferOptions{
+			OverwriteWhen:                         overwriteWhen[o.Overwrite],
+			OverwriteObjectsAlreadyExistingInSink: o.Overwrite == "always",
+			DeleteObjectsUniqueInSink:             o.DeleteUniqueInSink,
+			DeleteObjectsFromSourceAfterTransfer:  o.DeleteFromSource,
+		}
+	}
+
+	job := &storagetransferpb.TransferJob{
+		Name:         s.Name,
+		ProjectId:    s.Project,
+		Description:  s.Description,
+		TransferSpec: ts,
+		Status:       storagetransferpb.TransferJob_ENABLED,
+	}
+	if sc := s.Schedule; sc != nil {
+		job.Schedule = &storagetransferpb.Schedule{RepeatInterval: sc.Every.proto()}
+		job.Schedule.ScheduleStartDate, _ = parseDate(sc.Start)
+		if sc.End != "" {
+			job.Schedule.ScheduleEndDate, _ = parseDate(sc.End)
+		}
+		if sc.At != "" {
+			t, _ := time.Parse("15:04:05", sc.At)
+			job.Schedule.StartTimeOfDay = &timeofday.TimeOfDay{Hours: int32(t.Hour()), Minutes: int32(t.Minute()), Seconds: int32(t.Second())}
+		}
+	}
+	if l := s.Logging; l != nil {
+		job.LoggingConfig = &storagetransferpb.LoggingConfig{}
+		for _, a := range l.Actions {
+			v := storagetransferpb.LoggingConfig_LoggableAction_value[strings.ToUpper(a)]
+			job.LoggingConfig.LogActions = append(job.LoggingConfig.LogActions, storagetransferpb.LoggingConfig_LoggableAction(v))
+		}
+		for _, st := range l.States {
+			v := storagetransferpb.LoggingConfig_LoggableActionState_value[strings.ToUpper(st)]
+			job.LoggingConfig.LogActionStates = append(job.LoggingConfig.LogActionStates, storagetransferpb.LoggingConfig_LoggableActionState(v))
+		}
+	}
+	return job, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command transferctl manages Storage Transfer Service jobs from spec files;
+// see package transfermanager for the format.
+//
+//	transferctl apply [-run] [-wait] spec.yaml
+//	transferctl run [-wait] -project P transferJobs/ID
+//	transferctl wait -project P (transferJobs/ID | transferOperations/ID)
+//	transferctl pause|resume|cancel transferOperations/ID
+//	transferctl errors -project P (transferJobs/ID | transferOperations/ID)
+//
+// apply creates or updates the job, and optionally runs it and waits for
+// the operation to end, printing its progress. Given a job, wait and errors
+// act on its latest operation. errors prints the objects that failed as
+// lines of JSON.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	storagetransfer "cloud.google.com/go/storagetransfer/apiv1"
+	"github.com/GoogleCloudPlatform/golang-samples/storagetransfer/transfermanager"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  transferctl apply [-run] [-wait] spec.yaml
+  transferctl run [-wait] -project P transferJobs/ID
+  transferctl wait -project P (transferJobs/ID | transferOperations/ID)
+  transferctl pause|resume|cancel transferOperations/ID
+  transferctl errors -project P (transferJobs/ID | transferOperations/ID)`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("transferctl: ")
+	if len(os.Args) < 3 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	project := fs.String("project", "", "Project of the job.")
+	run := fs.Bool("run", false, "Run the job after applying the spec.")
+	wait := fs.Bool("wait", false, "Wait for the operation to end.")
+	interval := fs.Duration("interval", transfermanager.DefaultPollInterval, "How often to check the operation.")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 {
+		usage()
+	}
+	arg := fs.Arg(0)
+
+	// Stop waiting on interrupt; the operation continues.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := storagetransfer.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("storagetransfer.NewClient: %v", err)
+	}
+	defer client.Close()
+	m := &transfermanager.Manager{
+		Client:       transfermanager.NewClient(client),
+		PollInterval: *interval,
+		Progress: func(p *transfermanager.Progress) {
+			fmt.Printf("%s %v\n", time.Now().Format("15:04:05"), p)
+		},
+	}
+
+	switch cmd {
+	case "apply":
+		spec, err := transfermanager.ReadSpec(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		job, created, err := m.Apply(ctx, spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		verb := "Updated"
+		if created {
+			verb = "Created"
+		}
+		fmt.Printf("%s %s\n", verb, job.Name)
+		if *run {
+			err = runJob(ctx, m, spec.Project, job.Name, *wait)
+		}
+	case "run":
+		err = runJob(ctx, m, *project, arg, *wait)
+	case "wait":
+		var op string
+		if op, err = operation(ctx, m, *project, arg); err == nil {
+			err = waitFor(ctx, m, op)
+		}
+	case "pause":
+		err = m.Pause(ctx, arg)
+	case "resume":
+		err = m.Resume(ctx, arg)
+	case "cancel":
+		err = m.Cancel(ctx, arg)
+	case "errors":
+		var op string
+		if op, err = operation(ctx, m, *project, arg); err == nil {
+			err = exportErrors(ctx, m, op)
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runJob(ctx context.Context, m *transfermanager.Manager, project, job string, wait bool) error {
+	op, err := m.Run(ctx, project, job)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Started %s\n", op)
+	if !wait {
+		return nil
+	}
+	return waitFor(ctx, m, op)
+}
+
+// operation returns the operation name, or the latest operation of a job.
+func operation(ctx context.Context, m *transfermanager.Manager, project, name string) (string, error) {
+	if !strings.HasPrefix(name, "transferJobs/") {
+		return name, nil
+	}
+	return m.Latest(ctx, project, name)
+}
+
+func waitFor(ctx context.Context, m *transfermanager.Manager, op string) error {
+	final, err := m.Wait(ctx, op)
+	if errors.Is(err, transfermanager.ErrFailed) {
+		fmt.Fprintf(os.Stderr, "Run \"transferctl errors %s\" for the objects that failed.\n", op)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s finished at %s\n", op, final.GetEndTime().AsTime().Format(time.RFC3339))
+	return nil
+}
+
+func exportErrors(ctx context.Context, m *transfermanager.Manager, name string) error {
+	op, err := m.Client.GetTransferOperation(ctx, name)
+	if err != nil {
+		return err
+	}
+	n, err := transfermanager.ExportErrors(os.Stdout, op)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d failed objects listed\n", n)
+	return nil
+}
@@ -0,0 +1,511 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storagetransfer/apiv1/storagetransferpb"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeClient is an in-memory Client. Each operation steps through a script
+// of states, one per GetTransferOperation call, staying at the last.
+type fakeClient struct {
+	mu      sync.Mutex
+	jobs    map[string]*storagetransferpb.TransferJob
+	scripts map[string][]*storagetransferpb.TransferOperation
+	// script is the script of the next operation run.
+	script []*storagetransferpb.TransferOperation
+	calls  []string
+	nextID int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		jobs:    make(map[string]*storagetransferpb.TransferJob),
+		scripts: make(map[string][]*storagetransferpb.TransferOperation),
+	}
+}
+
+func (f *fakeClient) record(format string, args ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeClient) CreateTransferJob(ctx context.Context, req *storagetransferpb.CreateTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job := proto.Clone(req.TransferJob).(*storagetransferpb.TransferJob)
+	if job.Name == "" {
+		f.nextID++
+		job.Name = fmt.Sprintf("transferJobs/%d", f.nextID)
+	}
+	if _, ok := f.jobs[job.Name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "%s exists", job.Name)
+	}
+	f.record("create %s", job.Name)
+	f.jobs[job.Name] = job
+	return job, nil
+}
+
+func (f *fakeClient) GetTransferJob(ctx context.Context, req *storagetransferpb.GetTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[req.JobName]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.JobName)
+	}
+	return job, nil
+}
+
+func (f *fakeClient) UpdateTransferJob(ctx context.Context, req *storagetransferpb.UpdateTransferJobRequest) (*storagetransferpb.TransferJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.jobs[req.JobName]; !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.JobName)
+	}
+	f.record("update %s %s", req.JobName, strings.Join(req.UpdateTransferJobFieldMask.GetPaths(), ","))
+	job := proto.Clone(req.TransferJob).(*storagetransferpb.TransferJob)
+	f.jobs[req.JobName] = job
+	return job, nil
+}
+
+func (f *fakeClient) RunTransferJob(ctx context.Context, req *storagetransferpb.RunTransferJobRequest) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[req.JobName]
+	if !ok {
+		return "", status.Errorf(codes.NotFound, "%s not found", req.JobName)
+	}
+	f.nextID++
+	name := fmt.Sprintf("transferOperations/op-%d", f.nextID)
+	f.record("run %s", req.JobName)
+	script := f.script
+	if len(script) == 0 {
+		script = []*storagetransferpb.TransferOperation{{Status: storagetransferpb.TransferOperation_IN_PROGRESS}}
+	}
+	for _, op := range script {
+		op.Name = name
+	}
+	f.scripts[name] = script
+	job.LatestOperationName = name
+	return name, nil
+}
+
+func (f *fakeClient) GetTransferOperation(ctx context.Context, name string) (*storagetransferpb.TransferOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	script, ok := f.scripts[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", name)
+	}
+	op := script[0]
+	if len(script) > 1 {
+		f.scripts[name] = script[1:]
+	}
+	return op, nil
+}
+
+// setStatus makes an operation stay in status s.
+func (f *fakeClient) setStatus(name string, s storagetransferpb.TransferOperation_Status) error {
+	script, ok := f.scripts[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "%s not found", name)
+	}
+	op := proto.Clone(script[0]).(*storagetransferpb.TransferOperation)
+	op.Status = s
+	f.scripts[name] = []*storagetransferpb.TransferOperation{op}
+	return nil
+}
+
+func (f *fakeClient) PauseTransferOperation(ctx context.Context, req *storagetransferpb.PauseTransferOperationRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("pause %s", req.Name)
+	return f.setStatus(req.Name, storagetransferpb.TransferOperation_PAUSED)
+}
+
+func (f *fakeClient) ResumeTransferOperation(ctx context.Context, req *storagetransferpb.ResumeTransferOperationRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("resume %s", req.Name)
+	return f.setStatus(req.Name, storagetransferpb.TransferOperation_IN_PROGRESS)
+}
+
+func (f *fakeClient) CancelTransferOperation(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("cancel %s", name)
+	return f.setStatus(name, storagetransferpb.TransferOperation_ABORTED)
+}
+
+const awsSpec = `
+project: p
+name: transferJobs/nightly
+description: nightly
+source:
+  aws:
+    bucket: src
+    path: logs/
+    roleArn: arn:aws:iam::1:role/t
+sink:
+  gcs:
+    bucket: dst
+conditions:
+  includePrefixes: [a, b]
+  minAge: 1d
+options:
+  overwrite: never
+schedule:
+  start: 2024-03-01
+  at: "02:30:00"
+  every: 12h
+logging:
+  actions: [copy]
+  states: [failed]
+`
+
+func TestParseSpec(t *testing.T) {
+	s, err := ParseSpec([]byte(awsSpec))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	job, err := s.Job()
+	if err != nil {
+		t.Fatalf("Job: %v", err)
+	}
+	ts := job.TransferSpec
+	if got := ts.GetAwsS3DataSource(); got.GetBucketName() != "src" || got.GetPath() != "logs/" || got.GetRoleArn() == "" || got.GetAwsAccessKey() != nil {
+		t.Errorf("aws source: got %v", got)
+	}
+	if got := ts.GetGcsDataSink().GetBucketName(); got != "dst" {
+		t.Errorf("sink bucket: got %q, want dst", got)
+	}
+	if got := ts.ObjectConditions.MinTimeElapsedSinceLastModification.AsDuration(); got != 24*time.Hour {
+		t.Errorf("minAge: got %v, want 24h", got)
+	}
+	if got := ts.TransferOptions.OverwriteWhen; got != storagetransferpb.TransferOptions_NEVER {
+		t.Errorf("overwrite: got %v, want NEVER", got)
+	}
+	sc := job.Schedule
+	if sc.ScheduleStartDate.Day != 1 || sc.StartTimeOfDay.Minutes != 30 || sc.RepeatInterval.AsDuration() != 12*time.Hour || sc.ScheduleEndDate != nil {
+		t.Errorf("schedule: got %v", sc)
+	}
+	if got := job.LoggingConfig.LogActions; len(got) != 1 || got[0] != storagetransferpb.LoggingConfig_COPY {
+		t.Errorf("logging: got %v", job.LoggingConfig)
+	}
+
+	invalid := []string{
+		"source: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}",
+		"project: p\nsource: {}\nsink: {gcs: {bucket: b}}",
+		"project: p\nsource: {gcs: {bucket: a}, http: {listUrl: u}}\nsink: {gcs: {bucket: b}}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {}}",
+		"project: p\nsource: {posix: {rootDirectory: /d}}\nsink: {gcs: {bucket: b}}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\noptions: {overwrite: sometimes}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\nschedule: {start: tomorrow}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\nschedule: {start: 2024-01-01, every: 10m}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\nlogging: {actions: [move]}",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\nmanifest: manifest.csv",
+		"project: p\nsource: {gcs: {bucket: a}}\nsink: {gcs: {bucket: b}}\nunknown: 1",
+	}
+	for _, in := range invalid {
+		if _, err := ParseSpec([]byte(in)); err == nil {
+			t.Errorf("ParseSpec(%q): got success, want an error", in)
+		}
+	}
+}
+
+func TestJobSources(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AZURE_SAS_TOKEN", "")
+	sink := "sink: {posix: {rootDirectory: /out, agentPool: projects/p/agentPools/sink}}\n"
+	parse := func(src string) *storagetransferpb.TransferJob {
+		t.Helper()
+		s, err := ParseSpec([]byte("project: p\n" + sink + "source: " + src))
+		if err != nil {
+			t.Fatalf("ParseSpec(%s): %v", src, err)
+		}
+		job, err := s.Job()
+		if err != nil {
+			t.Fatalf("Job(%s): %v", src, err)
+		}
+		return job
+	}
+
+	job := parse("{s3Compatible: {bucket: b, endpoint: s3.example.com, agentPool: projects/p/agentPools/src}}")
+	if job.TransferSpec.GetAwsS3CompatibleDataSource().GetEndpoint() != "s3.example.com" || job.TransferSpec.SourceAgentPoolName != "projects/p/agentPools/src" {
+		t.Errorf("s3Compatible: got %v", job.TransferSpec)
+	}
+	if job.TransferSpec.GetPosixDataSink().GetRootDirectory() != "/out" || job.TransferSpec.SinkAgentPoolName != "projects/p/agentPools/sink" {
+		t.Errorf("posix sink: got %v", job.TransferSpec)
+	}
+	if job := parse("{http: {listUrl: https://example.com/list.tsv}}"); job.TransferSpec.GetHttpDataSource() == nil {
+		t.Errorf("http: got %v", job.TransferSpec)
+	}
+	if job := parse("{azure: {storageAccount: a, container: c, credentialsSecret: projects/p/secrets/s}}"); job.TransferSpec.GetAzureBlobStorageDataSource().GetAzureCredentials() != nil {
+		t.Errorf("azure with a secret: got credentials %v", job.TransferSpec)
+	}
+
+	// Keys not in the spec come from the environment.
+	for _, src := range []string{"{aws: {bucket: b}}", "{azure: {storageAccount: a, container: c}}"} {
+		s, err := ParseSpec([]byte("project: p\n" + sink + "source: " + src))
+		if err != nil {
+			t.Fatalf("ParseSpec: %v", err)
+		}
+		if _, err := s.Job(); err == nil {
+			t.Errorf("Job(%s) without credentials: got success, want an error", src)
+		}
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AZURE_SAS_TOKEN", "token")
+	if k := parse("{aws: {bucket: b}}").TransferSpec.GetAwsS3DataSource().GetAwsAccessKey(); k.GetAccessKeyId() != "id" || k.GetSecretAccessKey() != "secret" {
+		t.Errorf("aws key: got %v", k)
+	}
+	if c := parse("{azure: {storageAccount: a, container: c}}").TransferSpec.GetAzureBlobStorageDataSource().GetAzureCredentials(); c.GetSasToken() != "token" {
+		t.Errorf("azure credentials: got %v", c)
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	fc := newFakeClient()
+	m := &Manager{Client: fc}
+
+	s, err := ParseSpec([]byte(awsSpec))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	if _, created, err := m.Apply(ctx, s); err != nil || !created {
+		t.Fatalf("Apply: got created %v, %v, want created", created, err)
+	}
+	s.Description = "changed"
+	job, created, err := m.Apply(ctx, s)
+	if err != nil || created || job.Description != "changed" {
+		t.Fatalf("Apply again: got %v, created %v, %v, want an update", job, created, err)
+	}
+
+	// Without a name, every apply creates a job.
+	s.Name = ""
+	for i := 0; i < 2; i++ {
+		if _, created, err := m.Apply(ctx, s); err != nil || !created {
+			t.Fatalf("Apply without a name: got created %v, %v", created, err)
+		}
+	}
+	want := []string{
+		"create transferJobs/nightly",
+		"update transferJobs/nightly description,transfer_spec,schedule,logging_config,status",
+		"create transferJobs/1",
+		"create transferJobs/2",
+	}
+	if !reflect.DeepEqual(fc.calls, want) {
+		t.Errorf("calls: got %q, want %q", fc.calls, want)
+	}
+}
+
+func counters(objects, bytes int64) *storagetransferpb.TransferCounters {
+	return &storagetransferpb.TransferCounters{
+		ObjectsFoundFromSource: 10, BytesFoundFromSource: 10 << 20,
+		ObjectsCopiedToSink: objects, BytesCopiedToSink: bytes,
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	op := func(s storagetransferpb.TransferOperation_Status, c *storagetransferpb.TransferCounters) *storagetransferpb.TransferOperation {
+		return &storagetransferpb.TransferOperation{Status: s, Counters: c}
+	}
+	const (
+		queued     = storagetransferpb.TransferOperation_QUEUED
+		inProgress = storagetransferpb.TransferOperation_IN_PROGRESS
+		paused     = storagetransferpb.TransferOperation_PAUSED
+		success    = storagetransferpb.TransferOperation_SUCCESS
+		failed     = storagetransferpb.TransferOperation_FAILED
+	)
+	tests := []struct {
+		name    string
+		script  []*storagetransferpb.TransferOperation
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "success",
+			script: []*storagetransferpb.TransferOperation{
+				op(queued, nil),
+				op(inProgress, counters(1, 1<<20)),
+				op(inProgress, counters(1, 1<<20)),
+				op(paused, counters(1, 1<<20)),
+				op(inProgress, counters(5, 5<<20)),
+				op(success, counters(10, 10<<20)),
+			},
+			want: []string{
+				"QUEUED: 0/0 objects, 0 B/0 B copied",
+				"IN_PROGRESS: 1/10 objects, 1.0 MiB/10.0 MiB copied",
+				"PAUSED: 1/10 objects, 1.0 MiB/10.0 MiB copied",
+				"IN_PROGRESS: 5/10 objects, 5.0 MiB/10.0 MiB copied",
+				"SUCCESS: 10/10 objects, 10.0 MiB/10.0 MiB copied",
+			},
+		},
+		{
+			name: "failure",
+			script: []*storagetransferpb.TransferOperation{
+				op(inProgress, counters(1, 1<<20)),
+				{Status: failed, Counters: &storagetransferpb.TransferCounters{ObjectsFoundFromSource: 10, ObjectsCopiedToSink: 8, ObjectsFromSourceFailed: 2}},
+			},
+			want: []string{
+				"IN_PROGRESS: 1/10 objects, 1.0 MiB/10.0 MiB copied",
+				"FAILED: 8/10 objects, 0 B/0 B copied, 2 failed",
+			},
+			wantErr: ErrFailed,
+		},
+	}
+	for _, tc := range tests {
+		fc := newFakeClient()
+		fc.jobs["transferJobs/j"] = &storagetransferpb.TransferJob{Name: "transferJobs/j"}
+		fc.script = tc.script
+		var got []string
+		m := &Manager{Client: fc, PollInterval: time.Millisecond, Progress: func(p *Progress) { got = append(got, p.String()) }}
+		name, err := m.Run(ctx, "p", "transferJobs/j")
+		if err != nil {
+			t.Fatalf("%s: Run: %v", tc.name, err)
+		}
+		if latest, err := m.Latest(ctx, "p", "transferJobs/j"); err != nil || latest != name {
+			t.Errorf("%s: Latest: got %q, %v, want %q", tc.name, latest, err, name)
+		}
+		final, err := m.Wait(ctx, name)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: Wait: got error %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if final.GetStatus() != tc.script[len(tc.script)-1].Status {
+			t.Errorf("%s: Wait: got status %v", tc.name, final.GetStatus())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: progress:\ngot  %q\nwant %q", tc.name, got, tc.want)
+		}
+	}
+
+	fc := newFakeClient()
+	fc.jobs["transferJobs/j"] = &storagetransferpb.TransferJob{Name: "transferJobs/j"}
+	m := &Manager{Client: fc, PollInterval: time.Millisecond}
+	if _, err := m.Latest(ctx, "p", "transferJobs/j"); err != ErrNoOperation {
+		t.Errorf("Latest before running: got %v, want ErrNoOperation", err)
+	}
+	name, err := m.Run(ctx, "p", "transferJobs/j")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Wait(tctx, name); err != context.DeadlineExceeded {
+		t.Errorf("Wait past the deadline: got %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestPauseResumeCancel(t *testing.T) {
+	ctx := context.Background()
+	fc := newFakeClient()
+	fc.jobs["transferJobs/j"] = &storagetransferpb.TransferJob{Name: "transferJobs/j"}
+	m := &Manager{Client: fc, PollInterval: time.Millisecond}
+	name, err := m.Run(ctx, "p", "transferJobs/j")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	check := func(want storagetransferpb.TransferOperation_Status) {
+		t.Helper()
+		if op, err := fc.GetTransferOperation(ctx, name); err != nil || op.Status != want {
+			t.Errorf("got %v, %v, want %v", op.GetStatus(), err, want)
+		}
+	}
+	if err := m.Pause(ctx, name); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	check(storagetransferpb.TransferOperation_PAUSED)
+	if err := m.Resume(ctx, name); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	check(storagetransferpb.TransferOperation_IN_PROGRESS)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Wait(ctx, name)
+		done <- err
+	}()
+	if err := m.Cancel(ctx, name); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrAborted {
+			t.Errorf("Wait after Cancel: got %v, want ErrAborted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Wait did not return after Cancel")
+	}
+	if err := m.Pause(ctx, "transferOperations/missing"); status.Code(errors.Unwrap(err)) != codes.NotFound {
+		t.Errorf("Pause of a missing operation: got %v, want NotFound", err)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	op := &storagetransferpb.TransferOperation{
+		Name: "transferOperations/op",
+		ErrorBreakdowns: []*storagetransferpb.ErrorSummary{
+			{
+				ErrorCode:  code.Code_NOT_FOUND,
+				ErrorCount: 3,
+				ErrorLogEntries: []*storagetransferpb.ErrorLogEntry{
+					{Url: "s3://src/a", ErrorDetails: []string{"object deleted during transfer"}},
+					{Url: "s3://src/b"},
+				},
+			},
+			{ErrorCode: code.Code_PERMISSION_DENIED, ErrorCount: 1},
+		},
+	}
+	var buf bytes.Buffer
+	n, err := ExportErrors(&buf, op)
+	if err != nil || n != 2 {
+		t.Fatalf("ExportErrors: got %d, %v, want 2", n, err)
+	}
+	var got []ErrorEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e ErrorEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+		got = append(got, e)
+	}
+	want := []ErrorEntry{
+		{Operation: op.Name, Code: "NOT_FOUND", URL: "s3://src/a", Details: []string{"object deleted during transfer"}},
+		{Operation: op.Name, Code: "NOT_FOUND", URL: "s3://src/b"},
+		{Operation: op.Name, Code: "NOT_FOUND", Omitted: 1},
+		{Operation: op.Name, Code: "PERMISSION_DENIED", Omitted: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExportErrors:\ngot  %+v\nwant %+v", got, want)
+	}
+}